@@ -26,10 +26,36 @@ func (t StreamType) String() string {
 
 type HLSConfig struct {
 	Inspectors []HLSInspector
+	// InterstitialTimeout is the timeout to download each asset list and asset playlist of interstitials.
+	// ManifestTimeout is used when this property is zero.
+	InterstitialTimeout time.Duration
 }
 
 type DASHConfig struct {
 	Inspectors []DASHInspector
+	// Keys is a map from KID to content key to decrypt segments protected by Common Encryption.
+	// KID is a hexadecimal string, and hyphens are ignored. (ex: "0123456789abcdef0123456789abcdef")
+	// Segments are not decrypted when this property is empty.
+	Keys map[string][]byte
+	// XLinkTimeout is the timeout to resolve each xlink:href of Period and EventStream.
+	// ManifestTimeout is used when this property is zero.
+	XLinkTimeout time.Duration
+	// XLinkMaxDepth is the maximum depth of nested xlink resolution.
+	// Default value (3) is used when this property is zero.
+	XLinkMaxDepth int
+	// ProbeAllBaseURLs makes the monitor download segments from all BaseURLs.
+	// Otherwise, segments are downloaded from the BaseURL which has the highest priority,
+	// and the next BaseURL is used after segment errors.
+	// Inspectors receive segments of the selected BaseURL in both cases.
+	ProbeAllBaseURLs bool
+	// BaseURLExclusion is the duration for which a BaseURL is skipped after segment errors.
+	// The BaseURL is probed again after the duration.
+	// Default value (1 minute) is used when this property is zero.
+	BaseURLExclusion time.Duration
+	// ClockSyncInterval is the interval to request the wall-clock time from each URL of UTCTiming.
+	// The last clock offset is reused until the interval elapses.
+	// Default value (5 minutes) is used when this property is zero.
+	ClockSyncInterval time.Duration
 }
 
 type Config struct {
@@ -49,6 +75,9 @@ type Config struct {
 	TerminateIfVOD              bool
 	HLS                         *HLSConfig
 	DASH                        *DASHConfig
+	// KeyProvider provides keys to decrypt HLS segments protected by EXT-X-KEY tag.
+	// Segments are not decrypted when this property is nil.
+	KeyProvider KeyProvider
 	// OnDownload will be called when HTTP GET method succeeds.
 	// This function must be thread-safe.
 	OnDownload  OnDownloadHandler
@@ -2,7 +2,11 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +20,43 @@ type Manifest struct {
 	Raw  []byte
 	Time time.Time
 	*mpd.MPD
+	// Timing is the wall-clock time resolved by the first available UTCTiming element.
+	// It is nil when no UTCTiming element is available.
+	Timing *DASHTiming
+	// segmentIndexes holds sidx boxes referred by SegmentBase@indexRange.
+	segmentIndexes map[string]*segmentIndex
+	// elements holds XML elements corresponding to go-dash elements.
+	elements map[interface{}]*xmlElement
+	// xlinkErrors holds errors of xlink resolution.
+	// Elements which failed to be resolved are left in the manifest as is.
+	xlinkErrors []error
+	// patchError holds an error which means MPD patch is inconsistent with the previous manifest.
+	// The manifest is downloaded entirely in that case.
+	patchError error
+	// excludedBaseURLs holds keys of BaseURLs which are skipped because of segment errors.
+	excludedBaseURLs map[string]bool
+	// baseURLSeed is used to select a BaseURL by dvb:weight.
+	baseURLSeed uint32
+	// timingErrors holds errors of UTCTiming elements when none of them is resolved.
+	timingErrors []error
+}
+
+func newManifest(u string, data []byte, tm time.Time) (*Manifest, error) {
+	m, err := mpd.ReadFromString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	elements, err := mapElements(m, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		URL:      u,
+		Raw:      data,
+		Time:     tm,
+		MPD:      m,
+		elements: elements,
+	}, nil
 }
 
 type DASHSegment struct {
@@ -27,38 +68,72 @@ type DASHSegment struct {
 	AdaptationSet   *mpd.AdaptationSet
 	SegmentTemplate *mpd.SegmentTemplate
 	Representation  *mpd.Representation
+	// InitializationURL is URL of the initialization segment which the media segment depends on.
+	// This property is empty when the segment is an initialization segment or has no initialization segment.
+	InitializationURL string
+	// SegmentBase is set instead of SegmentTemplate when the segment is a subsegment indexed by sidx box.
+	SegmentBase *mpd.SegmentBase
+	// SegmentList is set instead of SegmentTemplate when the segment is listed by SegmentList.
+	// It is merged with SegmentList elements in higher levels.
+	SegmentList *mpd.SegmentList
+	// ByteRange and InitializationByteRange are nil when the whole resource is the segment.
+	ByteRange               *ByteRange
+	InitializationByteRange *ByteRange
+	// Timescale and PresentationTimeOffset are properties for Time and Duration.
+	Timescale              uint64
+	PresentationTimeOffset uint64
+	// BaseURL is the BaseURL which URL is resolved with.
+	BaseURL *DASHBaseURL
 }
 
-func (m *Manifest) BaseURL() (string, error) {
-	if m.MPD.BaseURL != "" {
-		return url.ResolveReference(m.URL, m.MPD.BaseURL)
-	}
-	return m.URL, nil
+// EachSegments calls handle for each segment.
+// Segments are resolved with the BaseURL which has the highest priority and has not failed.
+func (m *Manifest) EachSegments(handle func(*DASHSegment) (cont bool)) error {
+	return m.eachSegments(false, handle)
 }
 
-func (m *Manifest) EachSegments(handle func(*DASHSegment) (cont bool)) error {
-	baseURL, err := m.BaseURL()
+// eachSegments calls handle for each segment of the selected BaseURL, or all BaseURLs when all is true.
+func (m *Manifest) eachSegments(all bool, handle func(*DASHSegment) (cont bool)) error {
+	return m.eachRepresentations(all, func(baseURL *DASHBaseURL, period *mpd.Period, as *mpd.AdaptationSet, rep *mpd.Representation) (bool, error) {
+		handle := func(segment *DASHSegment) bool {
+			segment.BaseURL = baseURL
+			return handle(segment)
+		}
+		if as.SegmentTemplate != nil {
+			return m.visitSegmentsBySegmentTemplate(baseURL.URL, as.SegmentTemplate, period, as, rep, handle)
+		} else if rep.SegmentTemplate != nil {
+			return m.visitSegmentsBySegmentTemplate(baseURL.URL, rep.SegmentTemplate, period, as, rep, handle)
+		} else if sl := segmentListOf(period, as, rep); sl != nil {
+			return m.visitSegmentsBySegmentList(baseURL.URL, sl, period, as, rep, handle)
+		} else if sb := segmentBaseOf(period, as, rep); sb != nil {
+			return m.visitSegmentsBySegmentBase(baseURL.URL, sb, period, as, rep, handle)
+		} else if period.SegmentTemplate != nil {
+			return m.visitSegmentsBySegmentTemplate(baseURL.URL, period.SegmentTemplate, period, as, rep, handle)
+		}
+		return true, nil
+	})
+}
+
+// eachRepresentations calls handle with the resolved BaseURL for each representation.
+// handle is called for each BaseURL when all is true.
+func (m *Manifest) eachRepresentations(all bool, handle func(baseURL *DASHBaseURL, period *mpd.Period, as *mpd.AdaptationSet, rep *mpd.Representation) (cont bool, err error)) error {
+	baseURLs, err := m.BaseURLs()
 	if err != nil {
 		return err
 	}
 	for _, period := range m.Periods {
-		baseURL := baseURL
-		if period.BaseURL != "" {
-			baseURL, err = url.ResolveReference(baseURL, period.BaseURL)
+		periodBaseURLs, err := m.resolveBaseURLs(baseURLs, period, period.BaseURL)
+		if err != nil {
+			return err
 		}
 		for _, as := range period.AdaptationSets {
 			for _, rep := range as.Representations {
-				baseURL := baseURL
-				if rep.BaseURL != nil {
-					baseURL, err = url.ResolveReference(baseURL, *rep.BaseURL)
+				repBaseURLs, err := m.representationBaseURLs(periodBaseURLs, as, rep)
+				if err != nil {
+					return err
 				}
-				if as.SegmentTemplate != nil {
-					cont, err := visitSegmentsBySegmentTimeline(baseURL, as.SegmentTemplate, period, as, rep, handle)
-					if err != nil || !cont {
-						return err
-					}
-				} else if rep.SegmentTemplate != nil {
-					cont, err := visitSegmentsBySegmentTimeline(baseURL, rep.SegmentTemplate, period, as, rep, handle)
+				for _, baseURL := range m.selectBaseURLs(repBaseURLs, all) {
+					cont, err := handle(baseURL, period, as, rep)
 					if err != nil || !cont {
 						return err
 					}
@@ -70,15 +145,19 @@ func (m *Manifest) EachSegments(handle func(*DASHSegment) (cont bool)) error {
 }
 
 func (m *Manifest) Segments() ([]*DASHSegment, error) {
+	return m.segments(false)
+}
+
+func (m *Manifest) segments(all bool) ([]*DASHSegment, error) {
 	segments := make([]*DASHSegment, 0)
-	m.EachSegments(func(segment *DASHSegment) bool {
+	m.eachSegments(all, func(segment *DASHSegment) bool {
 		segments = append(segments, segment)
 		return true
 	})
 	return segments, nil
 }
 
-func visitSegmentsBySegmentTimeline(
+func (m *Manifest) visitSegmentsBySegmentTemplate(
 	baseURL string,
 	template *mpd.SegmentTemplate,
 	period *mpd.Period,
@@ -94,11 +173,21 @@ func visitSegmentsBySegmentTimeline(
 	if rep.Bandwidth != nil {
 		bandwidth = *rep.Bandwidth
 	}
-	var number int64
+	// @startNumber is 1 when omitted both for @duration and SegmentTimeline. (ISO/IEC 23009-1 5.3.9.5.3)
+	number := int64(1)
 	if template.StartNumber != nil {
 		number = *template.StartNumber
 	}
+	timescale := uint64(1)
+	if template.Timescale != nil && *template.Timescale != 0 {
+		timescale = uint64(*template.Timescale)
+	}
+	var offset uint64
+	if template.PresentationTimeOffset != nil {
+		offset = *template.PresentationTimeOffset
+	}
 
+	var initURL string
 	if template.Initialization != nil {
 		u, err := url.ResolveReference(baseURL, ResolveTemplate(*template.Initialization, TemplateParams{
 			RepresentationID: repID,
@@ -107,6 +196,7 @@ func visitSegmentsBySegmentTimeline(
 		if err != nil {
 			return false, err
 		}
+		initURL = u
 		if !handle(&DASHSegment{
 			URL:             u,
 			Initialization:  true,
@@ -114,54 +204,476 @@ func visitSegmentsBySegmentTimeline(
 			AdaptationSet:   as,
 			SegmentTemplate: template,
 			Representation:  rep,
+			Timescale:       timescale,
 		}) {
 			return false, nil
 		}
 	}
-	if template.SegmentTimeline != nil && template.Media != nil {
-		var t uint64
-		for _, segment := range template.SegmentTimeline.Segments {
-			n := 1
-			if segment.RepeatCount != nil {
-				n = *segment.RepeatCount + 1
+	if template.Media == nil {
+		return true, nil
+	}
+	visit := func(number int64, t, duration uint64) (bool, error) {
+		u, err := url.ResolveReference(baseURL, ResolveTemplate(*template.Media, TemplateParams{
+			RepresentationID: repID,
+			Number:           number,
+			Bandwidth:        bandwidth,
+			Time:             t,
+		}))
+		if err != nil {
+			return false, err
+		}
+		return handle(&DASHSegment{
+			URL:                    u,
+			InitializationURL:      initURL,
+			Time:                   t,
+			Duration:               duration,
+			Period:                 period,
+			AdaptationSet:          as,
+			SegmentTemplate:        template,
+			Representation:         rep,
+			Timescale:              timescale,
+			PresentationTimeOffset: offset,
+		}), nil
+	}
+	// visitSequence visits k segments which share $Number$ and $Time$ and are distinguished by $SubNumber$.
+	// The duration of the segment sequence is divided equally.
+	visitSequence := func(number int64, t, duration uint64, k int) (bool, error) {
+		for j := 0; j < k; j++ {
+			u, err := url.ResolveReference(baseURL, ResolveTemplate(*template.Media, TemplateParams{
+				RepresentationID: repID,
+				Number:           number,
+				Bandwidth:        bandwidth,
+				Time:             t,
+				SubNumber:        int64(j + 1),
+			}))
+			if err != nil {
+				return false, err
 			}
+			start := duration * uint64(j) / uint64(k)
+			end := duration * uint64(j+1) / uint64(k)
+			if !handle(&DASHSegment{
+				URL:                    u,
+				InitializationURL:      initURL,
+				Time:                   t + start,
+				Duration:               end - start,
+				Period:                 period,
+				AdaptationSet:          as,
+				SegmentTemplate:        template,
+				Representation:         rep,
+				Timescale:              timescale,
+				PresentationTimeOffset: offset,
+			}) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	if template.SegmentTimeline != nil {
+		var t uint64
+		segments := template.SegmentTimeline.Segments
+		for i, segment := range segments {
 			if segment.StartTime != nil {
 				t = *segment.StartTime
 			}
-			for i := 0; i < n; i++ {
-				u, err := url.ResolveReference(baseURL, ResolveTemplate(*template.Media, TemplateParams{
-					RepresentationID: repID,
-					Number:           number,
-					Bandwidth:        bandwidth,
-					Time:             t,
-				}))
-				if err != nil {
+			n := 1
+			if segment.RepeatCount != nil && *segment.RepeatCount >= 0 {
+				n = *segment.RepeatCount + 1
+			} else if segment.RepeatCount != nil {
+				var err error
+				if n, err = m.openEndedRepeatCount(period, template, segments, i, t, timescale, offset); err != nil {
 					return false, err
 				}
-				if !handle(&DASHSegment{
-					URL:             u,
-					Time:            t,
-					Duration:        segment.Duration,
-					Period:          period,
-					AdaptationSet:   as,
-					SegmentTemplate: template,
-					Representation:  rep,
-				}) {
-					return false, nil
+			}
+			k := m.segmentSequenceLength(template, segment)
+			for i := 0; i < n; i++ {
+				if k > 1 {
+					if cont, err := visitSequence(number, t, segment.Duration, k); err != nil || !cont {
+						return cont, err
+					}
+				} else if cont, err := visit(number, t, segment.Duration); err != nil || !cont {
+					return cont, err
 				}
 				t += segment.Duration
 				number++
 			}
 		}
+	} else if template.Duration != nil && *template.Duration > 0 {
+		duration := uint64(*template.Duration)
+		first, last, err := m.availableSegmentIndexes(period, template, float64(duration)/float64(timescale))
+		if err != nil {
+			return false, err
+		}
+		for i := first; i <= last; i++ {
+			if cont, err := visit(number+i, offset+uint64(i)*duration, duration); err != nil || !cont {
+				return cont, err
+			}
+		}
 	}
 	return true, nil
 }
 
+// availableSegmentIndexes returns the range of indexes of segments which are described by SegmentTemplate@duration.
+// For dynamic manifests, the range is limited to the segments which are available at Manifest.Time
+// and are in the time shift buffer.
+// last is less than first when no segments are available.
+func (m *Manifest) availableSegmentIndexes(period *mpd.Period, template *mpd.SegmentTemplate, duration float64) (first, last int64, err error) {
+	last = -1
+	periodDuration, known, err := m.periodDuration(period)
+	if err != nil {
+		return 0, 0, err
+	}
+	if known {
+		last = int64(math.Ceil(periodDuration/duration)) - 1
+	}
+	if m.Type == nil || *m.Type != "dynamic" {
+		return 0, last, nil
+	}
+	elapsed, ok, err := m.elapsedInPeriod(period)
+	if err != nil {
+		return 0, 0, err
+	} else if !ok {
+		return 0, -1, nil
+	}
+	availabilityTimeOffset, err := m.availabilityTimeOffset(template)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// a segment is available when its end time minus availabilityTimeOffset has passed.
+	if !math.IsInf(availabilityTimeOffset, 1) {
+		if l := int64(math.Floor((elapsed+availabilityTimeOffset)/duration)) - 1; !known || l < last {
+			last = l
+		}
+	} else if !known {
+		return 0, -1, nil
+	}
+	if m.TimeShiftBufferDepth != nil {
+		depth, err := mpd.ParseDuration(*m.TimeShiftBufferDepth)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid MPD@timeShiftBufferDepth: %w", err)
+		}
+		// a segment is in the time shift buffer when its end time is after the wall-clock minus timeShiftBufferDepth.
+		if f := int64(math.Ceil((elapsed-depth.Seconds())/duration)) - 1; f > 0 {
+			first = f
+		}
+	}
+	return first, last, nil
+}
+
+// segmentSequenceLength returns S@k when SegmentTemplate@media has $SubNumber$, otherwise returns 1.
+func (m *Manifest) segmentSequenceLength(template *mpd.SegmentTemplate, segment *mpd.SegmentTimelineSegment) int {
+	if !strings.Contains(*template.Media, "$SubNumber") {
+		return 1
+	}
+	value, ok := m.elements[segment].attr("k")
+	if !ok {
+		return 1
+	}
+	k, err := strconv.Atoi(value)
+	if err != nil || k < 1 {
+		return 1
+	}
+	return k
+}
+
+// openEndedRepeatCount returns the number of segments described by the S element whose @r is negative.
+// The segments continue until the next S@t, the end of the period or, for dynamic manifests, the live edge.
+func (m *Manifest) openEndedRepeatCount(
+	period *mpd.Period,
+	template *mpd.SegmentTemplate,
+	segments []*mpd.SegmentTimelineSegment,
+	i int,
+	t uint64,
+	timescale uint64,
+	offset uint64,
+) (int, error) {
+	duration := segments[i].Duration
+	if duration == 0 {
+		return 0, nil
+	}
+	if i+1 < len(segments) && segments[i+1].StartTime != nil {
+		next := *segments[i+1].StartTime
+		if next <= t {
+			return 0, nil
+		}
+		return int((next - t + duration - 1) / duration), nil
+	}
+
+	// position is the start time of the S element from the period start in seconds.
+	position := (float64(t) - float64(offset)) / float64(timescale)
+	seconds := float64(duration) / float64(timescale)
+	n, bounded := 0, false
+	periodDuration, known, err := m.periodDuration(period)
+	if err != nil {
+		return 0, err
+	} else if known {
+		n, bounded = int(math.Ceil((periodDuration-position)/seconds)), true
+	}
+	if m.Type != nil && *m.Type == "dynamic" {
+		elapsed, ok, err := m.elapsedInPeriod(period)
+		if err != nil {
+			return 0, err
+		}
+		availabilityTimeOffset, err := m.availabilityTimeOffset(template)
+		if err != nil {
+			return 0, err
+		}
+		if ok && !math.IsInf(availabilityTimeOffset, 1) {
+			if l := int(math.Floor((elapsed + availabilityTimeOffset - position) / seconds)); !bounded || l < n {
+				n, bounded = l, true
+			}
+		}
+	}
+	if !bounded {
+		// the end is unknown.
+		return 1, nil
+	} else if n < 0 {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// elapsedInPeriod returns the time from the period start to the wall-clock in seconds.
+// ok is false when the manifest doesn't have MPD@availabilityStartTime.
+func (m *Manifest) elapsedInPeriod(period *mpd.Period) (elapsed float64, ok bool, err error) {
+	if m.AvailabilityStartTime == nil {
+		return 0, false, nil
+	}
+	availabilityStartTime, err := time.Parse(time.RFC3339Nano, *m.AvailabilityStartTime)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid MPD@availabilityStartTime: %w", err)
+	}
+	now := m.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return now.Sub(availabilityStartTime).Seconds() - PeriodStart(period), true, nil
+}
+
+// SegmentAvailability returns the availability start time and end time of the media segment in dynamic manifest.
+// start is zero when availabilityTimeOffset is INF, which means the segment is available before its end time.
+// end is zero when MPD@timeShiftBufferDepth is omitted, which means the segment never expires.
+func (m *Manifest) SegmentAvailability(segment *DASHSegment) (start, end time.Time, err error) {
+	if m.AvailabilityStartTime == nil {
+		return time.Time{}, time.Time{}, errors.New("MPD@availabilityStartTime is omitted")
+	}
+	availabilityStartTime, err := time.Parse(time.RFC3339Nano, *m.AvailabilityStartTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid MPD@availabilityStartTime: %w", err)
+	}
+	var availabilityTimeOffset float64
+	if segment.SegmentTemplate != nil {
+		if availabilityTimeOffset, err = m.availabilityTimeOffset(segment.SegmentTemplate); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	} else if segment.SegmentList != nil && segment.SegmentList.AvailabilityTimeOffset != nil {
+		availabilityTimeOffset = float64(*segment.SegmentList.AvailabilityTimeOffset)
+	} else if segment.SegmentBase != nil && segment.SegmentBase.AvailabilityTimeOffset != nil {
+		availabilityTimeOffset = float64(*segment.SegmentBase.AvailabilityTimeOffset)
+	}
+	timescale := float64(segment.Timescale)
+	if timescale == 0 {
+		timescale = 1
+	}
+	segmentEnd := PeriodStart(segment.Period) +
+		(float64(segment.Time)-float64(segment.PresentationTimeOffset)+float64(segment.Duration))/timescale
+	toTime := func(sec float64) time.Time {
+		return availabilityStartTime.Add(time.Duration(sec * float64(time.Second)))
+	}
+	if !math.IsInf(availabilityTimeOffset, 1) {
+		start = toTime(segmentEnd - availabilityTimeOffset)
+	}
+	if m.TimeShiftBufferDepth != nil {
+		depth, err := mpd.ParseDuration(*m.TimeShiftBufferDepth)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid MPD@timeShiftBufferDepth: %w", err)
+		}
+		end = toTime(segmentEnd + depth.Seconds())
+	}
+	return start, end, nil
+}
+
+// availabilityTimeOffset returns SegmentTemplate@availabilityTimeOffset in seconds.
+// It returns +Inf when the value is "INF".
+func (m *Manifest) availabilityTimeOffset(template *mpd.SegmentTemplate) (float64, error) {
+	value, ok := m.elements[template].attr("availabilityTimeOffset")
+	if !ok {
+		return 0, nil
+	} else if value == "INF" {
+		return math.Inf(1), nil
+	}
+	offset, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SegmentTemplate@availabilityTimeOffset: %w", err)
+	}
+	return offset, nil
+}
+
+// PeriodStart returns Period@start in seconds.
+// It returns 0 when Period@start is absent.
+func PeriodStart(period *mpd.Period) float64 {
+	if period.Start == nil {
+		return 0
+	}
+	return time.Duration(*period.Start).Seconds()
+}
+
+// periodDuration returns the duration of the period in seconds.
+// It is derived from Period@duration, the start of the next period or MPD@mediaPresentationDuration.
+// known is false when the period has no end.
+func (m *Manifest) periodDuration(period *mpd.Period) (duration float64, known bool, err error) {
+	if period.Duration != 0 {
+		return time.Duration(period.Duration).Seconds(), true, nil
+	}
+	for i, p := range m.Periods {
+		if p != period {
+			continue
+		}
+		if i+1 < len(m.Periods) && m.Periods[i+1].Start != nil {
+			return PeriodStart(m.Periods[i+1]) - PeriodStart(period), true, nil
+		}
+		break
+	}
+	if m.MediaPresentationDuration != nil {
+		d, err := mpd.ParseDuration(*m.MediaPresentationDuration)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid MPD@mediaPresentationDuration: %w", err)
+		}
+		return d.Seconds() - PeriodStart(period), true, nil
+	}
+	return 0, false, nil
+}
+
 type TemplateParams struct {
 	RepresentationID string
 	Number           int64
 	Bandwidth        int64
 	Time             uint64
+	// SubNumber is the number of the segment in the segment sequence signaled by S@k.
+	SubNumber int64
+}
+
+// TemplateAttribute is an attribute of SegmentTemplate which has a URL template.
+type TemplateAttribute int
+
+const (
+	TemplateAttributeMedia TemplateAttribute = iota
+	TemplateAttributeInitialization
+	TemplateAttributeIndex
+	TemplateAttributeBitstreamSwitching
+)
+
+// TemplateAttributes is the list of all attributes of SegmentTemplate which have a URL template.
+var TemplateAttributes = []TemplateAttribute{
+	TemplateAttributeMedia,
+	TemplateAttributeInitialization,
+	TemplateAttributeIndex,
+	TemplateAttributeBitstreamSwitching,
+}
+
+func (a TemplateAttribute) String() string {
+	switch a {
+	case TemplateAttributeMedia:
+		return "media"
+	case TemplateAttributeInitialization:
+		return "initialization"
+	case TemplateAttributeIndex:
+		return "index"
+	case TemplateAttributeBitstreamSwitching:
+		return "bitstreamSwitching"
+	}
+	return "<Unknown>"
+}
+
+// SegmentTemplateAttribute returns the URL template of the attribute of SegmentTemplate.
+// @index and @bitstreamSwitching are available only when the manifest is parsed by the downloader.
+func (m *Manifest) SegmentTemplateAttribute(template *mpd.SegmentTemplate, attr TemplateAttribute) (string, bool) {
+	switch attr {
+	case TemplateAttributeMedia:
+		if template.Media != nil {
+			return *template.Media, true
+		}
+		return "", false
+	case TemplateAttributeInitialization:
+		if template.Initialization != nil {
+			return *template.Initialization, true
+		}
+		return "", false
+	}
+	return m.elements[template].attr(attr.String())
+}
+
+// templateIdentifiers is a table of identifiers which are allowed for each attribute.
+// The value is true when the identifier accepts a format tag.
+var templateIdentifiers = map[TemplateAttribute]map[string]bool{
+	TemplateAttributeMedia: {
+		"RepresentationID": false,
+		"Number":           true,
+		"Bandwidth":        true,
+		"Time":             true,
+		"SubNumber":        true,
+	},
+	TemplateAttributeInitialization: {
+		"RepresentationID": false,
+		"Bandwidth":        true,
+	},
+	TemplateAttributeIndex: {
+		"RepresentationID": false,
+		"Number":           true,
+		"Bandwidth":        true,
+		"Time":             true,
+	},
+	TemplateAttributeBitstreamSwitching: {
+		"RepresentationID": false,
+		"Bandwidth":        true,
+	},
+}
+
+// parseTemplateIdentifier splits the identifier and the format tag, and returns the width of the format tag.
+func parseTemplateIdentifier(s string) (name string, width int, hasFormat bool, err error) {
+	i := strings.Index(s, "%")
+	if i < 0 {
+		return s, 0, false, nil
+	}
+	name, format := s[:i], s[i:]
+	if !strings.HasPrefix(format, "%0") || !strings.HasSuffix(format, "d") || len(format) < 4 {
+		return name, 0, true, fmt.Errorf("invalid format tag: %s", format)
+	}
+	width, err = strconv.Atoi(format[2 : len(format)-1])
+	if err != nil || width <= 0 {
+		return name, 0, true, fmt.Errorf("invalid format tag: %s", format)
+	}
+	return name, width, true, nil
+}
+
+// ValidateTemplate returns an error when the template contains identifiers which are illegal for the attribute.
+func ValidateTemplate(format string, attr TemplateAttribute) error {
+	ss := strings.Split(format, "$")
+	if len(ss)%2 == 0 {
+		return fmt.Errorf("unclosed identifier: %s", format)
+	}
+	var number, tm bool
+	for i := 1; i < len(ss); i += 2 {
+		if ss[i] == "" {
+			continue
+		}
+		name, _, hasFormat, err := parseTemplateIdentifier(ss[i])
+		if err != nil {
+			return err
+		}
+		formattable, ok := templateIdentifiers[attr][name]
+		if !ok {
+			return fmt.Errorf("illegal identifier for %s: $%s$", attr, ss[i])
+		} else if hasFormat && !formattable {
+			return fmt.Errorf("format tag is not allowed: $%s$", ss[i])
+		}
+		number = number || name == "Number"
+		tm = tm || name == "Time"
+	}
+	if number && tm {
+		return errors.New("$Number$ and $Time$ must not be used together")
+	}
+	return nil
 }
 
 func ResolveTemplate(format string, params TemplateParams) string {
@@ -170,61 +682,161 @@ func ResolveTemplate(format string, params TemplateParams) string {
 	for i, s := range ss {
 		if i%2 == 0 {
 			ret += s
+			continue
 		} else if s == "" {
 			ret += "$"
-		} else if s == "RepresentationID" {
-			ret += params.RepresentationID
-		} else if s == "Number" {
-			ret += strconv.FormatInt(params.Number, 10)
-		} else if s == "Bandwidth" {
-			ret += strconv.FormatInt(params.Bandwidth, 10)
-		} else if s == "Time" {
-			ret += strconv.FormatUint(params.Time, 10)
-		} else {
+			continue
+		}
+		name, width, _, err := parseTemplateIdentifier(s)
+		var value interface{}
+		switch name {
+		case "RepresentationID":
+			value = params.RepresentationID
+		case "Number":
+			value = params.Number
+		case "Bandwidth":
+			value = params.Bandwidth
+		case "Time":
+			value = params.Time
+		case "SubNumber":
+			value = params.SubNumber
+		}
+		if value == nil || err != nil {
 			ret += "$" + s
 			if i == len(ss)-1 {
 				ret += "$"
 			}
+		} else if name == "RepresentationID" {
+			ret += params.RepresentationID
+		} else {
+			ret += fmt.Sprintf("%0*d", width, value)
 		}
 	}
 	return ret
 }
 
 type dashManifestDownloader struct {
-	client   client
-	timeout  time.Duration
-	location string
+	client         client
+	timeout        time.Duration
+	xlinkTimeout   time.Duration
+	xlinkMaxDepth  int
+	location       string
+	segmentIndexes map[string]*segmentIndex
+	// manifest is the last downloaded manifest to which MPD patches are applied.
+	manifest *Manifest
+	// allBaseURLs makes segment indexes loaded from all BaseURLs.
+	allBaseURLs bool
+	// excludedBaseURLs holds keys of failed BaseURLs and the times when they are probed again.
+	excludedBaseURLs map[string]time.Time
+	baseURLExclusion time.Duration
+	// baseURLSeed is kept across downloads so that the same BaseURL is selected from BaseURLs with the same priority.
+	baseURLSeed uint32
+	// clockSyncs holds clock offsets by UTCTiming scheme and URL.
+	clockSyncs        map[string]*clockSync
+	clockSyncInterval time.Duration
 }
 
 func newDASHManifestDownloader(client client, timeout time.Duration) *dashManifestDownloader {
 	return &dashManifestDownloader{
-		client:  client,
-		timeout: timeout,
+		client:            client,
+		timeout:           timeout,
+		xlinkTimeout:      timeout,
+		xlinkMaxDepth:     defaultXLinkMaxDepth,
+		baseURLExclusion:  defaultBaseURLExclusion,
+		baseURLSeed:       rand.New(rand.NewSource(time.Now().UnixNano())).Uint32(),
+		clockSyncInterval: defaultClockSyncInterval,
 	}
 }
 
 func (d *dashManifestDownloader) Download(ctx context.Context, u string) (*Manifest, error) {
+	var manifest *Manifest
+	var patchErr error
+	if d.manifest != nil {
+		patchURL, err := d.manifest.patchLocation(time.Now())
+		if err != nil {
+			log.Printf("WARN: %s: %s", d.manifest.URL, err)
+		} else if patchURL != "" {
+			manifest, patchErr = d.downloadPatch(ctx, patchURL)
+			if patchErr != nil {
+				log.Printf("WARN: fall back to full manifest: %s", patchErr)
+			}
+		}
+	}
+	if manifest == nil {
+		var err error
+		if manifest, err = d.downloadManifest(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	if errors.As(patchErr, &patchPublishTimeError{}) {
+		manifest.patchError = patchErr
+	}
+	if manifest.Location != "" {
+		d.location = manifest.Location
+	}
+	manifest.excludedBaseURLs = d.activeExcludedBaseURLs(time.Now())
+	manifest.baseURLSeed = d.baseURLSeed
 	ctx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
+	if err := d.loadSegmentIndexes(ctx, manifest); err != nil {
+		return nil, err
+	}
+	d.resolveTiming(ctx, manifest)
+	d.manifest = manifest
+	return manifest, nil
+}
+
+// activeExcludedBaseURLs returns keys of BaseURLs which are still excluded at the time.
+// Expired BaseURLs are removed so that they are probed again.
+func (d *dashManifestDownloader) activeExcludedBaseURLs(now time.Time) map[string]bool {
+	excluded := make(map[string]bool, len(d.excludedBaseURLs))
+	for key, expiry := range d.excludedBaseURLs {
+		if now.Before(expiry) {
+			excluded[key] = true
+		} else {
+			delete(d.excludedBaseURLs, key)
+		}
+	}
+	return excluded
+}
+
+// excludeBaseURL makes the BaseURL skipped from the next download until baseURLExclusion elapses.
+// All BaseURLs are enabled again when no BaseURL is available.
+func (d *dashManifestDownloader) excludeBaseURL(manifest *Manifest, baseURL *DASHBaseURL) {
+	if d.excludedBaseURLs == nil {
+		d.excludedBaseURLs = make(map[string]time.Time)
+	}
+	now := time.Now()
+	d.excludedBaseURLs[baseURL.key()] = now.Add(d.baseURLExclusion)
+	var available bool
+	manifest.eachRepresentations(true, func(b *DASHBaseURL, _ *mpd.Period, _ *mpd.AdaptationSet, _ *mpd.Representation) (bool, error) {
+		available = !now.Before(d.excludedBaseURLs[b.key()])
+		return !available, nil
+	})
+	if !available {
+		d.excludedBaseURLs = nil
+	}
+}
 
+func (d *dashManifestDownloader) downloadManifest(ctx context.Context, u string) (*Manifest, error) {
 	if d.location != "" {
 		u = d.location
 	}
-	data, loc, err := d.client.Get(ctx, u)
+	data, loc, err := d.get(ctx, u)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download manifest: %s: %w", u, err)
 	}
-	m, err := mpd.ReadFromString(string(data))
+	data, xlinkErrors := d.resolveXLinks(ctx, loc, data, d.xlinkMaxDepth)
+	manifest, err := newManifest(loc, data, time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode manifest: %s: %w", u, err)
 	}
-	if m.Location != "" {
-		d.location = m.Location
-	}
-	return &Manifest{
-		URL:  loc,
-		Raw:  data,
-		Time: time.Now(),
-		MPD:  m,
-	}, nil
+	manifest.xlinkErrors = xlinkErrors
+	return manifest, nil
+}
+
+func (d *dashManifestDownloader) get(ctx context.Context, u string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	return d.client.Get(ctx, u)
 }
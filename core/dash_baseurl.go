@@ -0,0 +1,156 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/internal/url"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// defaultBaseURLExclusion is used when DASHConfig.BaseURLExclusion is zero.
+const defaultBaseURLExclusion = time.Minute
+
+// DASHBaseURL is a BaseURL element resolved with BaseURL elements in higher levels.
+type DASHBaseURL struct {
+	URL string
+	// ServiceLocation is inherited from BaseURL elements in higher levels when it is omitted.
+	ServiceLocation string
+	// Priority and Weight are dvb:priority and dvb:weight attributes defined in ETSI TS 103 285.
+	// They are 1 when the attributes are omitted.
+	Priority int
+	Weight   int
+}
+
+// key returns the identifier to exclude the BaseURL on failover.
+func (b *DASHBaseURL) key() string {
+	if b.ServiceLocation != "" {
+		return b.ServiceLocation
+	}
+	return b.URL
+}
+
+// BaseURLs returns BaseURLs of MPD element in order of dvb:priority.
+// The URL of the manifest is returned when MPD has no BaseURL elements.
+func (m *Manifest) BaseURLs() ([]*DASHBaseURL, error) {
+	root := []*DASHBaseURL{{URL: m.URL, Priority: 1, Weight: 1}}
+	return m.resolveBaseURLs(root, m.MPD, m.MPD.BaseURL)
+}
+
+// BaseURL returns the BaseURL of MPD element which has the highest priority.
+func (m *Manifest) BaseURL() (string, error) {
+	baseURLs, err := m.BaseURLs()
+	if err != nil {
+		return "", err
+	}
+	return baseURLs[0].URL, nil
+}
+
+// resolveBaseURLs resolves BaseURL elements of the element against each parent BaseURL.
+// parents are returned when the element has no BaseURL elements.
+// fallback is used when the XML element is not mapped.
+func (m *Manifest) resolveBaseURLs(parents []*DASHBaseURL, key interface{}, fallback string) ([]*DASHBaseURL, error) {
+	var children []*xmlElement
+	if element, ok := m.elements[key]; ok {
+		children = element.children("BaseURL")
+	} else if fallback != "" {
+		children = []*xmlElement{{Value: fallback}}
+	}
+	if len(children) == 0 {
+		return parents, nil
+	}
+	baseURLs := make([]*DASHBaseURL, 0, len(parents)*len(children))
+	resolved := make(map[string]bool)
+	for _, parent := range parents {
+		for _, child := range children {
+			u, err := url.ResolveReference(parent.URL, strings.TrimSpace(child.Value))
+			if err != nil {
+				return nil, err
+			}
+			if resolved[u] {
+				continue
+			}
+			resolved[u] = true
+			baseURL := &DASHBaseURL{
+				URL:             u,
+				ServiceLocation: parent.ServiceLocation,
+				Priority:        parent.Priority,
+				Weight:          parent.Weight,
+			}
+			if serviceLocation, ok := child.attr("serviceLocation"); ok {
+				baseURL.ServiceLocation = serviceLocation
+			}
+			if priority, ok := child.attr("priority"); ok {
+				if baseURL.Priority, err = strconv.Atoi(priority); err != nil {
+					return nil, err
+				}
+			}
+			if weight, ok := child.attr("weight"); ok {
+				if baseURL.Weight, err = strconv.Atoi(weight); err != nil {
+					return nil, err
+				}
+			}
+			baseURLs = append(baseURLs, baseURL)
+		}
+	}
+	sort.SliceStable(baseURLs, func(i, j int) bool {
+		return baseURLs[i].Priority < baseURLs[j].Priority
+	})
+	return baseURLs, nil
+}
+
+// representationBaseURLs returns BaseURLs of the representation in order of dvb:priority.
+func (m *Manifest) representationBaseURLs(parents []*DASHBaseURL, as *mpd.AdaptationSet, rep *mpd.Representation) ([]*DASHBaseURL, error) {
+	baseURLs, err := m.resolveBaseURLs(parents, as, "")
+	if err != nil {
+		return nil, err
+	}
+	var fallback string
+	if rep.BaseURL != nil {
+		fallback = *rep.BaseURL
+	}
+	return m.resolveBaseURLs(baseURLs, rep, fallback)
+}
+
+// selectBaseURLs returns BaseURLs to download segments.
+// It returns the BaseURL which has the highest priority and is not excluded unless all is true.
+// BaseURLs with the same priority are selected in proportion to dvb:weight.
+// The BaseURL which has the highest priority is returned when all BaseURLs are excluded.
+func (m *Manifest) selectBaseURLs(baseURLs []*DASHBaseURL, all bool) []*DASHBaseURL {
+	if all {
+		return baseURLs
+	}
+	var candidates []*DASHBaseURL
+	var totalWeight uint32
+	for _, baseURL := range baseURLs {
+		if m.excludedBaseURLs[baseURL.key()] {
+			continue
+		}
+		if len(candidates) != 0 && baseURL.Priority != candidates[0].Priority {
+			break
+		}
+		candidates = append(candidates, baseURL)
+		if baseURL.Weight > 0 {
+			totalWeight += uint32(baseURL.Weight)
+		}
+	}
+	if len(candidates) == 0 {
+		return baseURLs[:1]
+	}
+	if totalWeight == 0 {
+		return candidates[:1]
+	}
+	r := m.baseURLSeed % totalWeight
+	for _, baseURL := range candidates {
+		if baseURL.Weight <= 0 {
+			continue
+		}
+		if r < uint32(baseURL.Weight) {
+			return []*DASHBaseURL{baseURL}
+		}
+		r -= uint32(baseURL.Weight)
+	}
+	return candidates[:1]
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// DASHContentProtection is a ContentProtection element.
+type DASHContentProtection struct {
+	SchemeIDURI string
+	Value       string
+	// DefaultKID is cenc:default_KID attribute.
+	DefaultKID string
+	// PSSH is the base64-encoded content of cenc:pssh element.
+	PSSH string
+}
+
+// ContentProtections returns ContentProtection elements which apply to the representation.
+// Elements of the Representation come first, and take precedence over elements of the AdaptationSet
+// which have the same schemeIdUri.
+func (m *Manifest) ContentProtections(as *mpd.AdaptationSet, rep *mpd.Representation) []*DASHContentProtection {
+	var cps []*DASHContentProtection
+	if rep != nil {
+		cps = append(cps, xmlContentProtections(m.elements[rep])...)
+	}
+	if as == nil {
+		return cps
+	}
+	var asCPs []*DASHContentProtection
+	if element, ok := m.elements[as]; ok {
+		asCPs = xmlContentProtections(element)
+	} else {
+		asCPs = goDASHContentProtections(as.ContentProtection)
+	}
+	overridden := make(map[string]bool, len(cps))
+	for _, cp := range cps {
+		overridden[strings.ToLower(cp.SchemeIDURI)] = true
+	}
+	for _, cp := range asCPs {
+		if !overridden[strings.ToLower(cp.SchemeIDURI)] {
+			cps = append(cps, cp)
+		}
+	}
+	return cps
+}
+
+func xmlContentProtections(element *xmlElement) []*DASHContentProtection {
+	var cps []*DASHContentProtection
+	for _, child := range element.children("ContentProtection") {
+		cp := &DASHContentProtection{}
+		cp.SchemeIDURI, _ = child.attr("schemeIdUri")
+		cp.Value, _ = child.attr("value")
+		cp.DefaultKID, _ = child.attr("default_KID")
+		if pssh := child.child("pssh"); pssh != nil {
+			cp.PSSH = strings.TrimSpace(pssh.Value)
+		}
+		cps = append(cps, cp)
+	}
+	return cps
+}
+
+// goDASHContentProtections converts ContentProtection elements decoded by go-dash.
+// It is used when the XML element is not mapped.
+func goDASHContentProtections(contentProtections []mpd.ContentProtectioner) []*DASHContentProtection {
+	var cps []*DASHContentProtection
+	for _, cp := range contentProtections {
+		var base mpd.ContentProtection
+		c := &DASHContentProtection{}
+		switch cp := cp.(type) {
+		case *mpd.ContentProtection:
+			base = *cp
+		case *mpd.CENCContentProtection:
+			base = cp.ContentProtection
+			if cp.DefaultKID != nil {
+				c.DefaultKID = *cp.DefaultKID
+			}
+			if cp.Value != nil {
+				c.Value = *cp.Value
+			}
+		case *mpd.WidevineContentProtection:
+			base = cp.ContentProtection
+			if cp.PSSH != nil {
+				c.PSSH = *cp.PSSH
+			}
+		case *mpd.PlayreadyContentProtection:
+			base = cp.ContentProtection
+			if cp.PSSH != nil {
+				c.PSSH = *cp.PSSH
+			}
+		default:
+			continue
+		}
+		if base.SchemeIDURI != nil {
+			c.SchemeIDURI = *base.SchemeIDURI
+		}
+		cps = append(cps, c)
+	}
+	return cps
+}
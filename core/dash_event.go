@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// DASHEvent is an Event element in EventStream of Period.
+type DASHEvent struct {
+	Period      *mpd.Period
+	SchemeIDURI string
+	Value       string
+	// Timescale is EventStream@timescale. It is 1 when the attribute is omitted.
+	Timescale              uint64
+	PresentationTimeOffset uint64
+	ID                     string
+	PresentationTime       uint64
+	// Duration is nil when Event@duration is omitted.
+	Duration    *uint64
+	MessageData string
+	// Content is the raw XML content of the Event element.
+	Content []byte
+}
+
+// Time returns the presentation time of the event relative to the start of the period in seconds.
+func (e *DASHEvent) Time() float64 {
+	return (float64(e.PresentationTime) - float64(e.PresentationTimeOffset)) / float64(e.Timescale)
+}
+
+type xmlEventStreams struct {
+	Periods []struct {
+		EventStreams []struct {
+			SchemeIDURI            string  `xml:"schemeIdUri,attr"`
+			Value                  string  `xml:"value,attr"`
+			Timescale              *uint64 `xml:"timescale,attr"`
+			PresentationTimeOffset uint64  `xml:"presentationTimeOffset,attr"`
+			Events                 []struct {
+				ID               string  `xml:"id,attr"`
+				PresentationTime uint64  `xml:"presentationTime,attr"`
+				Duration         *uint64 `xml:"duration,attr"`
+				MessageData      string  `xml:"messageData,attr"`
+				Content          []byte  `xml:",innerxml"`
+			} `xml:"Event"`
+		} `xml:"EventStream"`
+	} `xml:"Period"`
+}
+
+// Events returns Event elements of all periods in order of appearance.
+// Contents of Event elements are available only when the manifest has raw data.
+func (m *Manifest) Events() ([]*DASHEvent, error) {
+	events := make([]*DASHEvent, 0)
+	if len(m.Raw) == 0 {
+		for _, period := range m.Periods {
+			for _, stream := range period.EventStreams {
+				for _, event := range stream.Events {
+					e := &DASHEvent{Period: period, Timescale: 1, Duration: event.Duration}
+					if stream.SchemeIDURI != nil {
+						e.SchemeIDURI = *stream.SchemeIDURI
+					}
+					if stream.Value != nil {
+						e.Value = *stream.Value
+					}
+					if stream.Timescale != nil && *stream.Timescale != 0 {
+						e.Timescale = uint64(*stream.Timescale)
+					}
+					if event.ID != nil {
+						e.ID = *event.ID
+					}
+					if event.PresentationTime != nil {
+						e.PresentationTime = *event.PresentationTime
+					}
+					events = append(events, e)
+				}
+			}
+		}
+		return events, nil
+	}
+
+	var streams xmlEventStreams
+	if err := xml.Unmarshal(m.Raw, &streams); err != nil {
+		return nil, err
+	}
+	for i, period := range streams.Periods {
+		if i >= len(m.Periods) {
+			break
+		}
+		for _, stream := range period.EventStreams {
+			timescale := uint64(1)
+			if stream.Timescale != nil && *stream.Timescale != 0 {
+				timescale = *stream.Timescale
+			}
+			for _, event := range stream.Events {
+				events = append(events, &DASHEvent{
+					Period:                 m.Periods[i],
+					SchemeIDURI:            stream.SchemeIDURI,
+					Value:                  stream.Value,
+					Timescale:              timescale,
+					PresentationTimeOffset: stream.PresentationTimeOffset,
+					ID:                     event.ID,
+					PresentationTime:       event.PresentationTime,
+					Duration:               event.Duration,
+					MessageData:            event.MessageData,
+					Content:                bytes.TrimSpace(event.Content),
+				})
+			}
+		}
+	}
+	return events, nil
+}
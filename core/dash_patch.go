@@ -0,0 +1,548 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/internal/url"
+)
+
+// patchPublishTimeError means that Patch@originalPublishTime doesn't match MPD@publishTime of the held manifest.
+type patchPublishTimeError struct {
+	patchURL            string
+	originalPublishTime string
+	publishTime         string
+}
+
+func (err patchPublishTimeError) Error() string {
+	return fmt.Sprintf("Patch@originalPublishTime mismatches MPD@publishTime: %s: originalPublishTime=%s publishTime=%s",
+		err.patchURL, err.originalPublishTime, err.publishTime)
+}
+
+// patchLocation returns URL of MPD patch.
+// It returns empty string when the manifest has no PatchLocation element or the PatchLocation is expired.
+func (m *Manifest) patchLocation(now time.Time) (string, error) {
+	element := m.elements[m.MPD].child("PatchLocation")
+	if element == nil {
+		return "", nil
+	}
+	if ttl, ok := element.attr("ttl"); ok {
+		sec, err := strconv.ParseFloat(ttl, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid PatchLocation@ttl: %s", ttl)
+		}
+		if now.Sub(m.Time) > time.Duration(sec*float64(time.Second)) {
+			return "", nil
+		}
+	}
+	location := strings.TrimSpace(element.Value)
+	if location == "" {
+		return "", nil
+	}
+	return url.ResolveReference(m.URL, location)
+}
+
+// downloadPatch downloads MPD patch and applies it to the last downloaded manifest.
+func (d *dashManifestDownloader) downloadPatch(ctx context.Context, patchURL string) (*Manifest, error) {
+	data, _, err := d.get(ctx, patchURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download MPD patch: %s: %w", patchURL, err)
+	}
+	patch, err := parseXMLDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MPD patch: %s: %w", patchURL, err)
+	}
+	root := patch.root()
+	if root == nil || root.name.Local != "Patch" {
+		return nil, fmt.Errorf("Patch element is not found: %s", patchURL)
+	}
+	mpdElement := d.manifest.elements[d.manifest.MPD]
+	mpdID, _ := mpdElement.attr("id")
+	if patchID, _ := root.attr("mpdId"); patchID != mpdID {
+		return nil, fmt.Errorf("Patch@mpdId mismatches MPD@id: %s: mpdId=%s id=%s", patchURL, patchID, mpdID)
+	}
+	originalPublishTime, _ := root.attr("originalPublishTime")
+	publishTime, _ := mpdElement.attr("publishTime")
+	if !equalDateTime(originalPublishTime, publishTime) {
+		return nil, patchPublishTimeError{
+			patchURL:            patchURL,
+			originalPublishTime: originalPublishTime,
+			publishTime:         publishTime,
+		}
+	}
+
+	doc, err := parseXMLDocument(d.manifest.Raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range root.elementChildren() {
+		if err := doc.applyPatchOperation(op); err != nil {
+			return nil, fmt.Errorf("failed to apply MPD patch: %s: %w", patchURL, err)
+		}
+	}
+	var buf bytes.Buffer
+	doc.writeTo(&buf)
+	resolved, xlinkErrors := d.resolveXLinks(ctx, d.manifest.URL, buf.Bytes(), d.xlinkMaxDepth)
+	manifest, err := newManifest(d.manifest.URL, resolved, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode patched manifest: %s: %w", patchURL, err)
+	}
+	manifest.xlinkErrors = xlinkErrors
+	return manifest, nil
+}
+
+// equalDateTime compares two xs:dateTime values.
+func equalDateTime(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ta, err := time.Parse(time.RFC3339Nano, a)
+	if err != nil {
+		return false
+	}
+	tb, err := time.Parse(time.RFC3339Nano, b)
+	if err != nil {
+		return false
+	}
+	return ta.Equal(tb)
+}
+
+// xmlNode is a node of mutable XML document to which MPD patches are applied.
+// Namespace prefixes are kept as they are written in the document.
+type xmlNode struct {
+	// name is empty for document nodes and non-element nodes.
+	// name.Space holds the namespace prefix.
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	parent   *xmlNode
+	// token holds character data, comment, processing instruction or directive of non-element node.
+	token xml.Token
+}
+
+func parseXMLDocument(data []byte) (*xmlNode, error) {
+	doc := &xmlNode{}
+	current := doc
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			t = t.Copy()
+			node := &xmlNode{name: t.Name, attrs: t.Attr, parent: current}
+			current.children = append(current.children, node)
+			current = node
+		case xml.EndElement:
+			if current == doc || current.name != t.Name {
+				return nil, fmt.Errorf("unexpected end element: %s", qualifiedName(t.Name))
+			}
+			current = current.parent
+		default:
+			current.children = append(current.children, &xmlNode{token: xml.CopyToken(token), parent: current})
+		}
+	}
+	if current != doc {
+		return nil, fmt.Errorf("element is not closed: %s", qualifiedName(current.name))
+	}
+	return doc, nil
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func (n *xmlNode) isElement() bool {
+	return n.name.Local != ""
+}
+
+func (n *xmlNode) root() *xmlNode {
+	if children := n.elementChildren(); len(children) != 0 {
+		return children[0]
+	}
+	return nil
+}
+
+func (n *xmlNode) elementChildren() []*xmlNode {
+	children := make([]*xmlNode, 0, len(n.children))
+	for _, child := range n.children {
+		if child.isElement() {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (n *xmlNode) attr(name string) (string, bool) {
+	for _, attr := range n.attrs {
+		if qualifiedName(attr.Name) == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *xmlNode) setAttr(name, value string) {
+	for i := range n.attrs {
+		if qualifiedName(n.attrs[i].Name) == name {
+			n.attrs[i].Value = value
+			return
+		}
+	}
+	var attrName xml.Name
+	if i := strings.Index(name, ":"); i >= 0 {
+		attrName = xml.Name{Space: name[:i], Local: name[i+1:]}
+	} else {
+		attrName = xml.Name{Local: name}
+	}
+	n.attrs = append(n.attrs, xml.Attr{Name: attrName, Value: value})
+}
+
+func (n *xmlNode) removeAttr(name string) bool {
+	for i := range n.attrs {
+		if qualifiedName(n.attrs[i].Name) == name {
+			n.attrs = append(n.attrs[:i], n.attrs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (n *xmlNode) text() string {
+	var text string
+	for _, child := range n.children {
+		if data, ok := child.token.(xml.CharData); ok {
+			text += string(data)
+		}
+	}
+	return text
+}
+
+func (n *xmlNode) index() int {
+	for i, child := range n.parent.children {
+		if child == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert inserts nodes into children at i.
+func (n *xmlNode) insert(i int, nodes []*xmlNode) {
+	for _, node := range nodes {
+		node.parent = n
+	}
+	children := make([]*xmlNode, 0, len(n.children)+len(nodes))
+	children = append(children, n.children[:i]...)
+	children = append(children, nodes...)
+	children = append(children, n.children[i:]...)
+	n.children = children
+}
+
+func (n *xmlNode) remove() {
+	i := n.index()
+	n.parent.children = append(n.parent.children[:i], n.parent.children[i+1:]...)
+	n.parent = nil
+}
+
+func (n *xmlNode) writeTo(buf *bytes.Buffer) {
+	switch t := n.token.(type) {
+	case xml.CharData:
+		xml.EscapeText(buf, t)
+		return
+	case xml.Comment:
+		buf.WriteString("<!--")
+		buf.Write(t)
+		buf.WriteString("-->")
+		return
+	case xml.ProcInst:
+		buf.WriteString("<?" + t.Target)
+		if len(t.Inst) != 0 {
+			buf.WriteString(" ")
+			buf.Write(t.Inst)
+		}
+		buf.WriteString("?>")
+		return
+	case xml.Directive:
+		buf.WriteString("<!")
+		buf.Write(t)
+		buf.WriteString(">")
+		return
+	}
+	if !n.isElement() {
+		for _, child := range n.children {
+			child.writeTo(buf)
+		}
+		return
+	}
+	buf.WriteString("<" + qualifiedName(n.name))
+	for _, attr := range n.attrs {
+		buf.WriteString(" " + qualifiedName(attr.Name) + `="`)
+		xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteString(`"`)
+	}
+	if len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, child := range n.children {
+		child.writeTo(buf)
+	}
+	buf.WriteString("</" + qualifiedName(n.name) + ">")
+}
+
+// applyPatchOperation applies add, replace or remove operation defined in RFC 5261.
+func (n *xmlNode) applyPatchOperation(op *xmlNode) error {
+	sel, ok := op.attr("sel")
+	if !ok {
+		return fmt.Errorf("%s@sel is omitted", op.name.Local)
+	}
+	target, attr, err := n.selectNode(sel)
+	if err != nil {
+		return err
+	}
+	switch op.name.Local {
+	case "add":
+		if attr != "" {
+			return fmt.Errorf("add operation cannot select attribute: %s", sel)
+		}
+		if typ, _ := op.attr("type"); strings.HasPrefix(typ, "@") {
+			target.setAttr(typ[1:], op.text())
+			return nil
+		}
+		contents := op.children
+		op.children = nil
+		switch pos, _ := op.attr("pos"); pos {
+		case "":
+			target.insert(len(target.children), contents)
+		case "prepend":
+			target.insert(0, contents)
+		case "before":
+			target.parent.insert(target.index(), contents)
+		case "after":
+			target.parent.insert(target.index()+1, contents)
+		default:
+			return fmt.Errorf("invalid add@pos: %s", pos)
+		}
+	case "replace":
+		if attr != "" {
+			if _, ok := target.attr(attr); !ok {
+				return fmt.Errorf("attribute is not found: %s", sel)
+			}
+			target.setAttr(attr, op.text())
+			return nil
+		}
+		elements := op.elementChildren()
+		if len(elements) != 1 {
+			return fmt.Errorf("replace operation must have exactly one element: %s", sel)
+		}
+		parent, i := target.parent, target.index()
+		target.remove()
+		parent.insert(i, elements)
+	case "remove":
+		if attr != "" {
+			if !target.removeAttr(attr) {
+				return fmt.Errorf("attribute is not found: %s", sel)
+			}
+			return nil
+		}
+		if target.parent == nil || !target.parent.isElement() {
+			return fmt.Errorf("root element cannot be removed: %s", sel)
+		}
+		target.remove()
+	default:
+		return fmt.Errorf("unsupported patch operation: %s", op.name.Local)
+	}
+	return nil
+}
+
+// selectNode selects exactly one element by the subset of XPath which is used in MPD patches.
+// It supports absolute location paths consisting of element names, attribute predicates
+// (ex: [@id='1']), positional predicates (ex: [2]) and trailing attribute step (ex: /@publishTime).
+// The namespace prefixes of element names are ignored.
+func (n *xmlNode) selectNode(sel string) (*xmlNode, string, error) {
+	if !strings.HasPrefix(sel, "/") {
+		return nil, "", fmt.Errorf("unsupported selector: %s", sel)
+	}
+	steps, err := splitOutsideQuotes(sel[1:], '/')
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid selector: %s: %w", sel, err)
+	}
+	nodes := []*xmlNode{n}
+	for i, step := range steps {
+		if strings.HasPrefix(step, "@") {
+			if i != len(steps)-1 {
+				return nil, "", fmt.Errorf("unsupported selector: %s", sel)
+			}
+			if len(nodes) != 1 {
+				return nil, "", fmt.Errorf("selector must match exactly one element: %s", sel)
+			}
+			return nodes[0], step[1:], nil
+		}
+		next := make([]*xmlNode, 0)
+		for _, node := range nodes {
+			matched, err := node.selectChildren(step)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid selector: %s: %w", sel, err)
+			}
+			next = append(next, matched...)
+		}
+		nodes = next
+	}
+	if len(nodes) != 1 {
+		return nil, "", fmt.Errorf("selector must match exactly one element: %s", sel)
+	}
+	return nodes[0], "", nil
+}
+
+func (n *xmlNode) selectChildren(step string) ([]*xmlNode, error) {
+	name := step
+	var predicates []string
+	if i := strings.Index(step, "["); i >= 0 {
+		name = step[:i]
+		rest := step[i:]
+		for rest != "" {
+			if rest[0] != '[' {
+				return nil, fmt.Errorf("invalid step: %s", step)
+			}
+			end, err := closingBracket(rest)
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, rest[1:end])
+			rest = rest[end+1:]
+		}
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+	nodes := make([]*xmlNode, 0)
+	for _, child := range n.elementChildren() {
+		if name == "*" || child.name.Local == name {
+			nodes = append(nodes, child)
+		}
+	}
+	for _, predicate := range predicates {
+		predicate = strings.TrimSpace(predicate)
+		if position, err := strconv.Atoi(predicate); err == nil {
+			if position < 1 || position > len(nodes) {
+				return nil, nil
+			}
+			nodes = nodes[position-1 : position]
+			continue
+		}
+		conditions, err := splitAnd(predicate)
+		if err != nil {
+			return nil, err
+		}
+		filtered := make([]*xmlNode, 0, len(nodes))
+		for _, node := range nodes {
+			ok := true
+			for _, cond := range conditions {
+				if value, exists := node.attr(cond.name); !exists || value != cond.value {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				filtered = append(filtered, node)
+			}
+		}
+		nodes = filtered
+	}
+	return nodes, nil
+}
+
+type attrCondition struct {
+	name  string
+	value string
+}
+
+// splitAnd parses predicates like "@id='1' and @contentType='video'".
+func splitAnd(predicate string) ([]attrCondition, error) {
+	terms, err := splitOutsideQuotes(predicate, ' ')
+	if err != nil {
+		return nil, err
+	}
+	conditions := make([]attrCondition, 0)
+	for i, term := range terms {
+		if term == "" {
+			continue
+		}
+		if term == "and" {
+			if i == 0 || i == len(terms)-1 {
+				return nil, fmt.Errorf("unsupported predicate: %s", predicate)
+			}
+			continue
+		}
+		ss := strings.SplitN(term, "=", 2)
+		if len(ss) != 2 || !strings.HasPrefix(ss[0], "@") || len(ss[1]) < 2 ||
+			(ss[1][0] != '\'' && ss[1][0] != '"') || ss[1][0] != ss[1][len(ss[1])-1] {
+			return nil, fmt.Errorf("unsupported predicate: %s", predicate)
+		}
+		conditions = append(conditions, attrCondition{name: ss[0][1:], value: ss[1][1 : len(ss[1])-1]})
+	}
+	return conditions, nil
+}
+
+// splitOutsideQuotes splits s by sep which is not enclosed in quotes and brackets.
+func splitOutsideQuotes(s string, sep byte) ([]string, error) {
+	var ss []string
+	var quote byte
+	var depth int
+	var start int
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == sep && depth == 0:
+			ss = append(ss, s[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, errors.New("unbalanced quotes or brackets")
+	}
+	return append(ss, s[start:]), nil
+}
+
+func closingBracket(s string) (int, error) {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i, nil
+		}
+	}
+	return 0, errors.New("unbalanced brackets")
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/abema/antares/internal/mp4"
+	"github.com/abema/antares/internal/url"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// segmentIndex is a sidx box and the position of the first referenced byte in the media resource.
+type segmentIndex struct {
+	sidx   *mp4.SegmentIndex
+	anchor int64
+}
+
+// segmentBaseOf returns SegmentBase of the representation.
+// SegmentBase in lower level elements takes precedence.
+func segmentBaseOf(period *mpd.Period, as *mpd.AdaptationSet, rep *mpd.Representation) *mpd.SegmentBase {
+	if rep.SegmentBase != nil {
+		return rep.SegmentBase
+	} else if as.SegmentBase != nil {
+		return as.SegmentBase
+	}
+	return period.SegmentBase
+}
+
+// parseByteRange parses byte range in "first-last" format.
+func parseByteRange(s string) (*ByteRange, error) {
+	ss := strings.SplitN(s, "-", 2)
+	if len(ss) != 2 {
+		return nil, fmt.Errorf("invalid byte range: %s", s)
+	}
+	first, err := strconv.ParseInt(ss[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte range: %s", s)
+	}
+	last, err := strconv.ParseInt(ss[1], 10, 64)
+	if err != nil || last < first {
+		return nil, fmt.Errorf("invalid byte range: %s", s)
+	}
+	return &ByteRange{Offset: first, Length: last - first + 1}, nil
+}
+
+// loadSegmentIndexes downloads sidx boxes referred by SegmentBase@indexRange.
+// Downloaded sidx boxes are reused while the manifest refers to them.
+func (d *dashManifestDownloader) loadSegmentIndexes(ctx context.Context, manifest *Manifest) error {
+	indexes := make(map[string]*segmentIndex)
+	err := manifest.eachRepresentations(d.allBaseURLs, func(b *DASHBaseURL, period *mpd.Period, as *mpd.AdaptationSet, rep *mpd.Representation) (bool, error) {
+		baseURL := b.URL
+		if as.SegmentTemplate != nil || rep.SegmentTemplate != nil || segmentListOf(period, as, rep) != nil {
+			return true, nil
+		}
+		sb := segmentBaseOf(period, as, rep)
+		if sb == nil || sb.IndexRange == nil {
+			return true, nil
+		}
+		byteRange, err := parseByteRange(*sb.IndexRange)
+		if err != nil {
+			return false, err
+		}
+		key := SegmentKey(baseURL, byteRange)
+		if _, ok := indexes[key]; ok {
+			return true, nil
+		} else if index, ok := d.segmentIndexes[key]; ok {
+			indexes[key] = index
+			return true, nil
+		}
+		data, _, err := d.client.GetRange(ctx, baseURL, byteRange)
+		if err != nil {
+			return false, fmt.Errorf("failed to download segment index: %s: %w", baseURL, err)
+		}
+		sidx, end, err := mp4.ReadSegmentIndex(data)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse segment index: %s: %w", baseURL, err)
+		}
+		for _, ref := range sidx.References {
+			if ref.ReferenceType {
+				return false, fmt.Errorf("hierarchical segment index is not supported: %s", baseURL)
+			}
+		}
+		indexes[key] = &segmentIndex{
+			sidx:   sidx,
+			anchor: byteRange.Offset + int64(end) + int64(sidx.FirstOffset),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	d.segmentIndexes = indexes
+	manifest.segmentIndexes = indexes
+	return nil
+}
+
+func (m *Manifest) visitSegmentsBySegmentBase(
+	baseURL string,
+	sb *mpd.SegmentBase,
+	period *mpd.Period,
+	as *mpd.AdaptationSet,
+	rep *mpd.Representation,
+	handle func(*DASHSegment) (cont bool),
+) (bool, error) {
+	var index *segmentIndex
+	if sb.IndexRange != nil {
+		byteRange, err := parseByteRange(*sb.IndexRange)
+		if err != nil {
+			return false, err
+		}
+		index = m.segmentIndexes[SegmentKey(baseURL, byteRange)]
+	}
+	timescale := uint64(1)
+	if index != nil && index.sidx.Timescale != 0 {
+		timescale = uint64(index.sidx.Timescale)
+	} else if sb.Timescale != nil {
+		timescale = uint64(*sb.Timescale)
+	}
+
+	var initURL string
+	var initRange *ByteRange
+	if sb.Initialization != nil {
+		initURL = baseURL
+		if sb.Initialization.SourceURL != nil {
+			u, err := url.ResolveReference(baseURL, *sb.Initialization.SourceURL)
+			if err != nil {
+				return false, err
+			}
+			initURL = u
+		}
+		if sb.Initialization.Range != nil {
+			r, err := parseByteRange(*sb.Initialization.Range)
+			if err != nil {
+				return false, err
+			}
+			initRange = r
+		}
+		if !handle(&DASHSegment{
+			URL:            initURL,
+			Initialization: true,
+			Period:         period,
+			AdaptationSet:  as,
+			Representation: rep,
+			SegmentBase:    sb,
+			ByteRange:      initRange,
+			Timescale:      timescale,
+		}) {
+			return false, nil
+		}
+	}
+	if index == nil {
+		return true, nil
+	}
+
+	// presentationTimeOffset is described in SegmentBase@timescale.
+	var offset uint64
+	if sb.PresentationTimeOffset != nil {
+		offset = *sb.PresentationTimeOffset
+		if sb.Timescale != nil && *sb.Timescale != 0 {
+			offset = offset * timescale / uint64(*sb.Timescale)
+		} else {
+			offset *= timescale
+		}
+	}
+	t := index.sidx.EarliestPresentationTime
+	position := index.anchor
+	for _, ref := range index.sidx.References {
+		if !handle(&DASHSegment{
+			URL:                     baseURL,
+			InitializationURL:       initURL,
+			Time:                    t,
+			Duration:                uint64(ref.SubsegmentDuration),
+			Period:                  period,
+			AdaptationSet:           as,
+			Representation:          rep,
+			SegmentBase:             sb,
+			ByteRange:               &ByteRange{Offset: position, Length: int64(ref.ReferencedSize)},
+			InitializationByteRange: initRange,
+			Timescale:               timescale,
+			PresentationTimeOffset:  offset,
+		}) {
+			return false, nil
+		}
+		t += uint64(ref.SubsegmentDuration)
+		position += int64(ref.ReferencedSize)
+	}
+	return true, nil
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/abema/antares/internal/url"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// segmentListOf returns SegmentList of the representation.
+// Attributes and elements of SegmentList in lower level elements override ones in higher level elements.
+func segmentListOf(period *mpd.Period, as *mpd.AdaptationSet, rep *mpd.Representation) *mpd.SegmentList {
+	var merged *mpd.SegmentList
+	for _, sl := range []*mpd.SegmentList{period.SegmentList, as.SegmentList, rep.SegmentList} {
+		if sl == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &mpd.SegmentList{}
+		}
+		if sl.Initialization != nil {
+			merged.Initialization = sl.Initialization
+		}
+		if sl.RepresentationIndex != nil {
+			merged.RepresentationIndex = sl.RepresentationIndex
+		}
+		if sl.Timescale != nil {
+			merged.Timescale = sl.Timescale
+		}
+		if sl.PresentationTimeOffset != nil {
+			merged.PresentationTimeOffset = sl.PresentationTimeOffset
+		}
+		if sl.IndexRange != nil {
+			merged.IndexRange = sl.IndexRange
+		}
+		if sl.IndexRangeExact != nil {
+			merged.IndexRangeExact = sl.IndexRangeExact
+		}
+		if sl.AvailabilityTimeOffset != nil {
+			merged.AvailabilityTimeOffset = sl.AvailabilityTimeOffset
+		}
+		if sl.AvailabilityTimeComplete != nil {
+			merged.AvailabilityTimeComplete = sl.AvailabilityTimeComplete
+		}
+		if sl.SegmentTimeline != nil {
+			merged.SegmentTimeline = sl.SegmentTimeline
+		}
+		if sl.BitstreamSwitching != nil {
+			merged.BitstreamSwitching = sl.BitstreamSwitching
+		}
+		if sl.Duration != nil {
+			merged.Duration = sl.Duration
+		}
+		if sl.StartNumber != nil {
+			merged.StartNumber = sl.StartNumber
+		}
+		if len(sl.SegmentURLs) != 0 {
+			merged.SegmentURLs = sl.SegmentURLs
+		}
+	}
+	return merged
+}
+
+func (m *Manifest) visitSegmentsBySegmentList(
+	baseURL string,
+	list *mpd.SegmentList,
+	period *mpd.Period,
+	as *mpd.AdaptationSet,
+	rep *mpd.Representation,
+	handle func(*DASHSegment) (cont bool),
+) (bool, error) {
+	timescale := uint64(1)
+	if list.Timescale != nil && *list.Timescale != 0 {
+		timescale = uint64(*list.Timescale)
+	}
+	var offset uint64
+	if list.PresentationTimeOffset != nil {
+		offset = *list.PresentationTimeOffset
+	}
+
+	var initURL string
+	var initRange *ByteRange
+	if list.Initialization != nil {
+		initURL = baseURL
+		if list.Initialization.SourceURL != nil {
+			u, err := url.ResolveReference(baseURL, *list.Initialization.SourceURL)
+			if err != nil {
+				return false, err
+			}
+			initURL = u
+		}
+		if list.Initialization.Range != nil {
+			r, err := parseByteRange(*list.Initialization.Range)
+			if err != nil {
+				return false, err
+			}
+			initRange = r
+		}
+		if !handle(&DASHSegment{
+			URL:            initURL,
+			Initialization: true,
+			Period:         period,
+			AdaptationSet:  as,
+			Representation: rep,
+			SegmentList:    list,
+			ByteRange:      initRange,
+			Timescale:      timescale,
+		}) {
+			return false, nil
+		}
+	}
+
+	// times and durations are given by SegmentTimeline or @duration.
+	times := make([]uint64, 0, len(list.SegmentURLs))
+	durations := make([]uint64, 0, len(list.SegmentURLs))
+	if list.SegmentTimeline != nil {
+		var t uint64
+		segments := list.SegmentTimeline.Segments
+		for j, s := range segments {
+			if s.StartTime != nil {
+				t = *s.StartTime
+			}
+			n := 1
+			if s.RepeatCount != nil {
+				n = *s.RepeatCount + 1
+			}
+			if n <= 0 {
+				// negative @r continues until the next S@t or the end of the SegmentURL list.
+				n = len(list.SegmentURLs) - len(times)
+				if j+1 < len(segments) && segments[j+1].StartTime != nil && s.Duration != 0 {
+					if next := *segments[j+1].StartTime; next > t {
+						n = int((next - t + s.Duration - 1) / s.Duration)
+					} else {
+						n = 0
+					}
+				}
+			}
+			for i := 0; i < n && len(times) < len(list.SegmentURLs); i++ {
+				times = append(times, t)
+				durations = append(durations, s.Duration)
+				t += s.Duration
+			}
+		}
+	} else if list.Duration != nil {
+		for i := range list.SegmentURLs {
+			times = append(times, offset+uint64(i)*uint64(*list.Duration))
+			durations = append(durations, uint64(*list.Duration))
+		}
+	} else if len(list.SegmentURLs) == 1 {
+		// a single segment without @duration spans the whole period. (ISO/IEC 23009-1 5.3.9.2.2)
+		duration, known, err := m.periodDuration(period)
+		if err != nil {
+			return false, err
+		} else if !known {
+			return false, errors.New("duration of the single SegmentURL is unknown")
+		}
+		times = append(times, offset)
+		durations = append(durations, uint64(math.Round(duration*float64(timescale))))
+	} else if len(list.SegmentURLs) != 0 {
+		return false, errors.New("SegmentList has neither SegmentTimeline nor @duration")
+	}
+	if len(times) < len(list.SegmentURLs) {
+		return false, fmt.Errorf("SegmentTimeline describes %d segments but SegmentList has %d SegmentURL elements", len(times), len(list.SegmentURLs))
+	}
+
+	for i, segmentURL := range list.SegmentURLs {
+		u := baseURL
+		if segmentURL.Media != nil {
+			var err error
+			if u, err = url.ResolveReference(baseURL, *segmentURL.Media); err != nil {
+				return false, err
+			}
+		}
+		var byteRange *ByteRange
+		if segmentURL.MediaRange != nil {
+			r, err := parseByteRange(*segmentURL.MediaRange)
+			if err != nil {
+				return false, err
+			}
+			byteRange = r
+		}
+		segment := &DASHSegment{
+			URL:                     u,
+			InitializationURL:       initURL,
+			Period:                  period,
+			AdaptationSet:           as,
+			Representation:          rep,
+			SegmentList:             list,
+			ByteRange:               byteRange,
+			InitializationByteRange: initRange,
+			Timescale:               timescale,
+			PresentationTimeOffset:  offset,
+			Time:                    times[i],
+			Duration:                durations[i],
+		}
+		if !handle(segment) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -1,12 +1,18 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
 	"testing"
 	"time"
 
+	"github.com/abema/antares/internal/mp4/mp4test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/zencoder/go-dash/helpers/ptrs"
@@ -70,6 +76,38 @@ func TestSegments(t *testing.T) {
 		assert.Equal(t, "https://localhost/foo/bar/r1/1400000.mp4", segments[13].URL)
 	})
 
+	t.Run("Period_SegmentTemplate", func(t *testing.T) {
+		m := Manifest{
+			URL: "https://localhost/foo/manifest.mpd",
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{
+					{
+						SegmentTemplate: &mpd.SegmentTemplate{
+							Media: ptrs.Strptr("$RepresentationID$/$Time$.mp4"),
+							SegmentTimeline: &mpd.SegmentTimeline{
+								Segments: []*mpd.SegmentTimelineSegment{
+									{StartTime: ptrs.Uint64ptr(1000000), Duration: 90000, RepeatCount: ptrs.Intptr(1)},
+								},
+							},
+						},
+						AdaptationSets: []*mpd.AdaptationSet{
+							{
+								Representations: []*mpd.Representation{
+									{ID: ptrs.Strptr("r0")},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+		assert.Equal(t, "https://localhost/foo/r0/1000000.mp4", segments[0].URL)
+		assert.Equal(t, "https://localhost/foo/r0/1090000.mp4", segments[1].URL)
+	})
+
 	t.Run("individual_SegmentTemplate", func(t *testing.T) {
 		m := Manifest{
 			URL: "https://localhost/foo/manifest.mpd",
@@ -131,6 +169,418 @@ func TestSegments(t *testing.T) {
 		assert.Equal(t, uint64(1000000), segments[5].Time)
 		assert.Equal(t, uint64(90000), segments[5].Duration)
 	})
+
+	t.Run("SegmentList", func(t *testing.T) {
+		m, err := mpd.ReadFromString(`<MPD type="static" mediaPresentationDuration="PT6S">` +
+			`<Period id="1">` +
+			`<SegmentList timescale="1000" duration="2000" presentationTimeOffset="500">` +
+			`<Initialization sourceURL="init.mp4"/>` +
+			`</SegmentList>` +
+			`<AdaptationSet mimeType="video/mp4">` +
+			`<SegmentList timescale="90000" duration="180000"/>` +
+			`<Representation id="r0" bandwidth="1000000">` +
+			`<SegmentList>` +
+			`<SegmentURL media="r0/1.mp4"/>` +
+			`<SegmentURL media="r0/2.mp4"/>` +
+			`</SegmentList>` +
+			`</Representation>` +
+			`<Representation id="r1" bandwidth="2000000">` +
+			`<BaseURL>r1.mp4</BaseURL>` +
+			`<SegmentList>` +
+			`<Initialization range="0-99"/>` +
+			`<SegmentTimeline><S t="1000" d="90000"/><S d="45000"/></SegmentTimeline>` +
+			`<SegmentURL mediaRange="100-199"/>` +
+			`<SegmentURL mediaRange="200-299"/>` +
+			`</SegmentList>` +
+			`</Representation>` +
+			`</AdaptationSet>` +
+			`</Period>` +
+			`</MPD>`)
+		require.NoError(t, err)
+		manifest := &Manifest{URL: "https://localhost/foo/manifest.mpd", MPD: m}
+		segments, err := manifest.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 6)
+
+		assert.Equal(t, "https://localhost/foo/init.mp4", segments[0].URL)
+		assert.True(t, segments[0].Initialization)
+		assert.Nil(t, segments[0].ByteRange)
+
+		assert.Equal(t, "https://localhost/foo/r0/1.mp4", segments[1].URL)
+		assert.Equal(t, "https://localhost/foo/init.mp4", segments[1].InitializationURL)
+		assert.Equal(t, uint64(500), segments[1].Time)
+		assert.Equal(t, uint64(180000), segments[1].Duration)
+		assert.Equal(t, uint64(90000), segments[1].Timescale)
+		assert.Equal(t, uint64(500), segments[1].PresentationTimeOffset)
+		assert.Equal(t, "https://localhost/foo/r0/2.mp4", segments[2].URL)
+		assert.Equal(t, uint64(180500), segments[2].Time)
+
+		assert.Equal(t, "https://localhost/foo/r1.mp4", segments[3].URL)
+		assert.True(t, segments[3].Initialization)
+		assert.Equal(t, &ByteRange{Offset: 0, Length: 100}, segments[3].ByteRange)
+
+		assert.Equal(t, "https://localhost/foo/r1.mp4", segments[4].URL)
+		assert.Equal(t, &ByteRange{Offset: 100, Length: 100}, segments[4].ByteRange)
+		assert.Equal(t, &ByteRange{Offset: 0, Length: 100}, segments[4].InitializationByteRange)
+		assert.Equal(t, uint64(1000), segments[4].Time)
+		assert.Equal(t, uint64(90000), segments[4].Duration)
+		assert.Equal(t, &ByteRange{Offset: 200, Length: 100}, segments[5].ByteRange)
+		assert.Equal(t, uint64(91000), segments[5].Time)
+		assert.Equal(t, uint64(45000), segments[5].Duration)
+	})
+
+	t.Run("SegmentList_open_ended_repeat", func(t *testing.T) {
+		m, err := mpd.ReadFromString(`<MPD type="dynamic" availabilityStartTime="1970-01-01T00:00:00Z">` +
+			`<Period id="1">` +
+			`<AdaptationSet mimeType="video/mp4">` +
+			`<Representation id="r0" bandwidth="1000000">` +
+			`<SegmentList timescale="1000">` +
+			`<SegmentTimeline><S t="0" d="2000" r="-1"/><S t="6000" d="1000" r="-1"/></SegmentTimeline>` +
+			`<SegmentURL media="1.mp4"/>` +
+			`<SegmentURL media="2.mp4"/>` +
+			`<SegmentURL media="3.mp4"/>` +
+			`<SegmentURL media="4.mp4"/>` +
+			`<SegmentURL media="5.mp4"/>` +
+			`</SegmentList>` +
+			`</Representation>` +
+			`</AdaptationSet>` +
+			`</Period>` +
+			`</MPD>`)
+		require.NoError(t, err)
+		manifest := &Manifest{URL: "https://localhost/foo/manifest.mpd", MPD: m}
+		segments, err := manifest.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 5)
+		for i, expected := range []struct{ time, duration uint64 }{
+			{0, 2000}, {2000, 2000}, {4000, 2000}, {6000, 1000}, {7000, 1000},
+		} {
+			assert.Equal(t, expected.time, segments[i].Time, i)
+			assert.Equal(t, expected.duration, segments[i].Duration, i)
+		}
+	})
+
+	t.Run("SegmentList_without_duration", func(t *testing.T) {
+		build := func(period, list string) *Manifest {
+			m, err := mpd.ReadFromString(`<MPD type="static" mediaPresentationDuration="PT6S">` +
+				`<Period id="1"` + period + `>` +
+				`<AdaptationSet mimeType="video/mp4">` +
+				`<Representation id="r0" bandwidth="1000000">` +
+				`<SegmentList timescale="1000">` + list + `</SegmentList>` +
+				`</Representation>` +
+				`</AdaptationSet>` +
+				`</Period>` +
+				`</MPD>`)
+			require.NoError(t, err)
+			return &Manifest{URL: "https://localhost/foo/manifest.mpd", MPD: m}
+		}
+		each := func(m *Manifest) ([]*DASHSegment, error) {
+			segments := make([]*DASHSegment, 0)
+			err := m.EachSegments(func(segment *DASHSegment) bool {
+				segments = append(segments, segment)
+				return true
+			})
+			return segments, err
+		}
+
+		// a single segment spans the whole period.
+		segments, err := each(build(` duration="PT4S"`, `<SegmentURL media="1.mp4"/>`))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		assert.Equal(t, uint64(0), segments[0].Time)
+		assert.Equal(t, uint64(4000), segments[0].Duration)
+
+		_, err = each(build("", `<SegmentURL media="1.mp4"/><SegmentURL media="2.mp4"/>`))
+		assert.Error(t, err)
+
+		_, err = each(build("", `<SegmentTimeline><S t="0" d="2000"/></SegmentTimeline>`+
+			`<SegmentURL media="1.mp4"/><SegmentURL media="2.mp4"/>`))
+		assert.Error(t, err)
+	})
+
+	t.Run("SegmentTimeline_without_startNumber", func(t *testing.T) {
+		m, err := mpd.ReadFromString(`<MPD type="static" mediaPresentationDuration="PT6S">` +
+			`<Period id="1">` +
+			`<AdaptationSet mimeType="video/mp4">` +
+			`<SegmentTemplate timescale="1000" media="$RepresentationID$/$Number$.mp4" initialization="$RepresentationID$/init.mp4">` +
+			`<SegmentTimeline><S t="0" d="2000" r="2"/></SegmentTimeline>` +
+			`</SegmentTemplate>` +
+			`<Representation id="r0" bandwidth="1000000"/>` +
+			`</AdaptationSet>` +
+			`</Period>` +
+			`</MPD>`)
+		require.NoError(t, err)
+		manifest := &Manifest{URL: "https://localhost/foo/manifest.mpd", MPD: m}
+		segments, err := manifest.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 4)
+		assert.Equal(t, "https://localhost/foo/r0/init.mp4", segments[0].URL)
+		assert.Equal(t, "https://localhost/foo/r0/1.mp4", segments[1].URL)
+		assert.Equal(t, uint64(0), segments[1].Time)
+		assert.Equal(t, "https://localhost/foo/r0/2.mp4", segments[2].URL)
+		assert.Equal(t, "https://localhost/foo/r0/3.mp4", segments[3].URL)
+		assert.Equal(t, uint64(4000), segments[3].Time)
+	})
+
+	t.Run("SegmentTemplate_duration", func(t *testing.T) {
+		manifest := func(typ, attrs, ato string) []byte {
+			return []byte(`<MPD type="` + typ + `" availabilityStartTime="1970-01-01T00:00:00Z" timeShiftBufferDepth="PT10S"` + attrs + `>` +
+				`<Period id="1" start="PT100S">` +
+				`<AdaptationSet mimeType="video/mp4">` +
+				`<SegmentTemplate timescale="1000" duration="2000" startNumber="10" presentationTimeOffset="500"` + ato +
+				` media="$RepresentationID$/$Number$.mp4" initialization="$RepresentationID$/init.mp4"/>` +
+				`<Representation id="r0" bandwidth="1000000"/>` +
+				`</AdaptationSet>` +
+				`</Period>` +
+				`</MPD>`)
+		}
+
+		m, err := newManifest("https://localhost/foo/manifest.mpd", manifest("dynamic", "", ""), time.Unix(130, 500000000))
+		require.NoError(t, err)
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 6)
+		assert.Equal(t, "https://localhost/foo/r0/init.mp4", segments[0].URL)
+		assert.Equal(t, "https://localhost/foo/r0/20.mp4", segments[1].URL)
+		assert.Equal(t, uint64(20500), segments[1].Time)
+		assert.Equal(t, uint64(2000), segments[1].Duration)
+		assert.Equal(t, uint64(1000), segments[1].Timescale)
+		assert.Equal(t, uint64(500), segments[1].PresentationTimeOffset)
+		assert.Equal(t, "https://localhost/foo/r0/24.mp4", segments[5].URL)
+
+		m, err = newManifest("https://localhost/foo/manifest.mpd", manifest("dynamic", "", ` availabilityTimeOffset="1.5"`), time.Unix(130, 500000000))
+		require.NoError(t, err)
+		segments, err = m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 7)
+		assert.Equal(t, "https://localhost/foo/r0/25.mp4", segments[6].URL)
+
+		m, err = newManifest("https://localhost/foo/manifest.mpd", manifest("static", ` mediaPresentationDuration="PT105S"`, ""), time.Unix(130, 500000000))
+		require.NoError(t, err)
+		segments, err = m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 4)
+		assert.Equal(t, "https://localhost/foo/r0/10.mp4", segments[1].URL)
+		assert.Equal(t, "https://localhost/foo/r0/12.mp4", segments[3].URL)
+	})
+
+	t.Run("SegmentTimeline_open_ended", func(t *testing.T) {
+		manifest := func(typ, attrs, timeline string) []byte {
+			return []byte(`<MPD type="` + typ + `" availabilityStartTime="1970-01-01T00:00:00Z"` + attrs + `>` +
+				`<Period id="1" start="PT100S">` +
+				`<AdaptationSet mimeType="video/mp4">` +
+				`<SegmentTemplate timescale="1000" media="$Time$.mp4">` +
+				`<SegmentTimeline>` + timeline + `</SegmentTimeline>` +
+				`</SegmentTemplate>` +
+				`<Representation id="r0" bandwidth="1000000"/>` +
+				`</AdaptationSet>` +
+				`</Period>` +
+				`</MPD>`)
+		}
+		testCases := []struct {
+			name  string
+			data  []byte
+			times []uint64
+		}{
+			{
+				name:  "next_t",
+				data:  manifest("dynamic", "", `<S t="0" d="2000" r="-1"/><S t="9000" d="1000"/>`),
+				times: []uint64{0, 2000, 4000, 6000, 8000, 9000},
+			},
+			{
+				name:  "period_end",
+				data:  manifest("static", ` mediaPresentationDuration="PT109S"`, `<S t="0" d="2000" r="-1"/>`),
+				times: []uint64{0, 2000, 4000, 6000, 8000},
+			},
+			{
+				name:  "live_edge",
+				data:  manifest("dynamic", "", `<S t="20000" d="2000" r="-1"/>`),
+				times: []uint64{20000, 22000, 24000, 26000, 28000},
+			},
+			{
+				name:  "unknown_end",
+				data:  manifest("static", "", `<S t="0" d="2000" r="-1"/>`),
+				times: []uint64{0},
+			},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				m, err := newManifest("https://localhost/foo/manifest.mpd", tc.data, time.Unix(130, 500000000))
+				require.NoError(t, err)
+				segments, err := m.Segments()
+				require.NoError(t, err)
+				times := make([]uint64, 0, len(segments))
+				for _, segment := range segments {
+					times = append(times, segment.Time)
+				}
+				assert.Equal(t, tc.times, times)
+			})
+		}
+	})
+
+	t.Run("SubNumber", func(t *testing.T) {
+		m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD type="static">`+
+			`<Period id="1">`+
+			`<AdaptationSet mimeType="video/mp4">`+
+			`<SegmentTemplate timescale="1000" media="$Time$_$SubNumber%02d$.mp4">`+
+			`<SegmentTimeline><S t="0" d="3000" k="3"/><S d="2000"/></SegmentTimeline>`+
+			`</SegmentTemplate>`+
+			`<Representation id="r0" bandwidth="1000000"/>`+
+			`</AdaptationSet>`+
+			`</Period>`+
+			`</MPD>`), time.Now())
+		require.NoError(t, err)
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 4)
+		assert.Equal(t, "https://localhost/foo/0_01.mp4", segments[0].URL)
+		assert.Equal(t, uint64(0), segments[0].Time)
+		assert.Equal(t, uint64(1000), segments[0].Duration)
+		assert.Equal(t, "https://localhost/foo/0_03.mp4", segments[2].URL)
+		assert.Equal(t, uint64(2000), segments[2].Time)
+		assert.Equal(t, "https://localhost/foo/3000_00.mp4", segments[3].URL)
+		assert.Equal(t, uint64(2000), segments[3].Duration)
+	})
+
+	t.Run("multiple_BaseURLs", func(t *testing.T) {
+		m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD xmlns:dvb="urn:dvb:dash:dash-extensions:2014-1" type="static">`+
+			`<BaseURL serviceLocation="cdn-b" dvb:priority="2" dvb:weight="10">https://cdn-b.example.com/live/</BaseURL>`+
+			`<BaseURL serviceLocation="cdn-a" dvb:priority="1">https://cdn-a.example.com/live/</BaseURL>`+
+			`<Period id="1">`+
+			`<AdaptationSet mimeType="video/mp4">`+
+			`<BaseURL>video/</BaseURL>`+
+			`<SegmentTemplate media="$Number$.mp4" initialization="init.mp4">`+
+			`<SegmentTimeline><S t="0" d="1"/></SegmentTimeline>`+
+			`</SegmentTemplate>`+
+			`<Representation id="r0" bandwidth="1000000"/>`+
+			`</AdaptationSet>`+
+			`</Period>`+
+			`</MPD>`), time.Now())
+		require.NoError(t, err)
+
+		baseURLs, err := m.BaseURLs()
+		require.NoError(t, err)
+		assert.Equal(t, []*DASHBaseURL{
+			{URL: "https://cdn-a.example.com/live/", ServiceLocation: "cdn-a", Priority: 1, Weight: 1},
+			{URL: "https://cdn-b.example.com/live/", ServiceLocation: "cdn-b", Priority: 2, Weight: 10},
+		}, baseURLs)
+		baseURL, err := m.BaseURL()
+		require.NoError(t, err)
+		assert.Equal(t, "https://cdn-a.example.com/live/", baseURL)
+
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+		assert.Equal(t, "https://cdn-a.example.com/live/video/init.mp4", segments[0].URL)
+		assert.Equal(t, "https://cdn-a.example.com/live/video/1.mp4", segments[1].URL)
+		assert.Equal(t, "cdn-a", segments[1].BaseURL.ServiceLocation)
+
+		segments, err = m.segments(true)
+		require.NoError(t, err)
+		require.Len(t, segments, 4)
+		assert.Equal(t, "https://cdn-b.example.com/live/video/1.mp4", segments[3].URL)
+		assert.Equal(t, "cdn-b", segments[3].BaseURL.ServiceLocation)
+
+		// failover
+		d := newDASHManifestDownloader(nil, time.Second)
+		d.excludeBaseURL(m, segments[1].BaseURL)
+		m.excludedBaseURLs = d.activeExcludedBaseURLs(time.Now())
+		assert.Equal(t, map[string]bool{"cdn-a": true}, m.excludedBaseURLs)
+		segments, err = m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+		assert.Equal(t, "https://cdn-b.example.com/live/video/1.mp4", segments[1].URL)
+
+		// all BaseURLs are enabled again when all of them failed.
+		d.excludeBaseURL(m, segments[1].BaseURL)
+		assert.Empty(t, d.excludedBaseURLs)
+
+		// the excluded BaseURL is probed again after the exclusion expires.
+		d.excludeBaseURL(m, segments[1].BaseURL)
+		assert.Equal(t, map[string]bool{"cdn-b": true}, d.activeExcludedBaseURLs(time.Now()))
+		assert.Empty(t, d.activeExcludedBaseURLs(time.Now().Add(defaultBaseURLExclusion)))
+		assert.Empty(t, d.excludedBaseURLs)
+	})
+
+	t.Run("BaseURL_weight", func(t *testing.T) {
+		m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD xmlns:dvb="urn:dvb:dash:dash-extensions:2014-1" type="static">`+
+			`<BaseURL serviceLocation="cdn-a" dvb:priority="1" dvb:weight="1">https://cdn-a.example.com/live/</BaseURL>`+
+			`<BaseURL serviceLocation="cdn-b" dvb:priority="1" dvb:weight="3">https://cdn-b.example.com/live/</BaseURL>`+
+			`<BaseURL serviceLocation="cdn-c" dvb:priority="2" dvb:weight="100">https://cdn-c.example.com/live/</BaseURL>`+
+			`<Period id="1">`+
+			`<AdaptationSet mimeType="video/mp4">`+
+			`<SegmentTemplate media="$Number$.mp4" initialization="init.mp4">`+
+			`<SegmentTimeline><S t="0" d="1"/></SegmentTimeline>`+
+			`</SegmentTemplate>`+
+			`<Representation id="r0" bandwidth="1000000"/>`+
+			`</AdaptationSet>`+
+			`</Period>`+
+			`</MPD>`), time.Now())
+		require.NoError(t, err)
+
+		for seed, expected := range []string{"cdn-a", "cdn-b", "cdn-b", "cdn-b", "cdn-a"} {
+			m.baseURLSeed = uint32(seed)
+			segments, err := m.Segments()
+			require.NoError(t, err)
+			require.Len(t, segments, 2)
+			assert.Equal(t, expected, segments[1].BaseURL.ServiceLocation, seed)
+		}
+
+		// BaseURLs with lower priority are used only when all BaseURLs with higher priority are excluded.
+		m.baseURLSeed = 0
+		m.excludedBaseURLs = map[string]bool{"cdn-a": true}
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		assert.Equal(t, "cdn-b", segments[1].BaseURL.ServiceLocation)
+		m.excludedBaseURLs = map[string]bool{"cdn-a": true, "cdn-b": true}
+		segments, err = m.Segments()
+		require.NoError(t, err)
+		assert.Equal(t, "cdn-c", segments[1].BaseURL.ServiceLocation)
+	})
+}
+
+func TestResolveTemplate(t *testing.T) {
+	params := TemplateParams{
+		RepresentationID: "r0",
+		Number:           12,
+		Bandwidth:        300000,
+		Time:             4567,
+		SubNumber:        2,
+	}
+	assert.Equal(t, "r0/12_4567_300000_2.mp4", ResolveTemplate("$RepresentationID$/$Number$_$Time$_$Bandwidth$_$SubNumber$.mp4", params))
+	assert.Equal(t, "r0/00012_0000004567.mp4", ResolveTemplate("$RepresentationID$/$Number%05d$_$Time%010d$.mp4", params))
+	assert.Equal(t, "a$b/12.mp4", ResolveTemplate("a$$b/$Number%01d$.mp4", params))
+}
+
+func TestValidateTemplate(t *testing.T) {
+	testCases := []struct {
+		format string
+		attr   TemplateAttribute
+		ok     bool
+	}{
+		{format: "$RepresentationID$/$Number%05d$.mp4", attr: TemplateAttributeMedia, ok: true},
+		{format: "$RepresentationID$/$Time$_$SubNumber$.mp4", attr: TemplateAttributeMedia, ok: true},
+		{format: "$RepresentationID$/$Bandwidth$/init.mp4", attr: TemplateAttributeInitialization, ok: true},
+		{format: "$$init.mp4", attr: TemplateAttributeInitialization, ok: true},
+		{format: "$RepresentationID$/$Time$/init.mp4", attr: TemplateAttributeInitialization},
+		{format: "$Number$/init.mp4", attr: TemplateAttributeInitialization},
+		{format: "$Number$_$Time$.mp4", attr: TemplateAttributeMedia},
+		{format: "$Number%5d$.mp4", attr: TemplateAttributeMedia},
+		{format: "$RepresentationID%05d$.mp4", attr: TemplateAttributeMedia},
+		{format: "$Foo$.mp4", attr: TemplateAttributeMedia},
+		{format: "$Number.mp4", attr: TemplateAttributeMedia},
+		{format: "$RepresentationID$/$Number$.sidx", attr: TemplateAttributeIndex, ok: true},
+		{format: "$RepresentationID$/$SubNumber$.sidx", attr: TemplateAttributeIndex},
+		{format: "$RepresentationID$/$Bandwidth$/bs.mp4", attr: TemplateAttributeBitstreamSwitching, ok: true},
+		{format: "$RepresentationID$/$Number$/bs.mp4", attr: TemplateAttributeBitstreamSwitching},
+	}
+	for _, tc := range testCases {
+		err := ValidateTemplate(tc.format, tc.attr)
+		if tc.ok {
+			assert.NoError(t, err, tc.format)
+		} else {
+			assert.Error(t, err, tc.format)
+		}
+	}
 }
 
 func TestDASHManifestDownloader(t *testing.T) {
@@ -163,3 +613,509 @@ func TestDASHManifestDownloader(t *testing.T) {
 	require.Equal(t, "dynamic", *mpd.Type)
 	require.Len(t, mpd.Periods, 1)
 }
+
+func TestDASHManifestDownloader_SegmentBase(t *testing.T) {
+	init := mp4test.Box("moov", make([]byte, 92))
+	sidx := mp4test.Box("sidx", mp4test.U32(0, 1, 90000, 1000, 0, 2, 300, 90000, 0x90000000, 200, 45000, 0x90000000))
+	media := append(append(append([]byte{}, init...), sidx...), make([]byte, 500)...)
+	manifest := []byte(fmt.Sprintf(`<MPD type="static" mediaPresentationDuration="PT1.5S">`+
+		`<Period id="1">`+
+		`<AdaptationSet mimeType="video/mp4">`+
+		`<Representation id="4M" bandwidth="4000000">`+
+		`<BaseURL>media.mp4</BaseURL>`+
+		`<SegmentBase timescale="1000" presentationTimeOffset="10" indexRange="100-%d">`+
+		`<Initialization range="0-99"/>`+
+		`</SegmentBase>`+
+		`</Representation>`+
+		`</AdaptationSet>`+
+		`</Period>`+
+		`</MPD>`, 100+len(sidx)-1))
+	var indexRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.mpd":
+			w.Write(manifest)
+		case "/media.mp4":
+			indexRequests++
+			http.ServeContent(w, r, "media.mp4", time.Time{}, bytes.NewReader(media))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	for i := 0; i < 2; i++ {
+		m, err := d.Download(context.Background(), server.URL+"/manifest.mpd")
+		require.NoError(t, err)
+		segments, err := m.Segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 3)
+
+		assert.Equal(t, server.URL+"/media.mp4", segments[0].URL)
+		assert.True(t, segments[0].Initialization)
+		assert.Equal(t, &ByteRange{Offset: 0, Length: 100}, segments[0].ByteRange)
+
+		assert.Equal(t, server.URL+"/media.mp4", segments[1].URL)
+		assert.False(t, segments[1].Initialization)
+		assert.Equal(t, server.URL+"/media.mp4", segments[1].InitializationURL)
+		assert.Equal(t, &ByteRange{Offset: 0, Length: 100}, segments[1].InitializationByteRange)
+		assert.Equal(t, &ByteRange{Offset: int64(100 + len(sidx)), Length: 300}, segments[1].ByteRange)
+		assert.Equal(t, uint64(1000), segments[1].Time)
+		assert.Equal(t, uint64(90000), segments[1].Duration)
+		assert.Equal(t, uint64(90000), segments[1].Timescale)
+		assert.Equal(t, uint64(900), segments[1].PresentationTimeOffset)
+
+		assert.Equal(t, &ByteRange{Offset: int64(400 + len(sidx)), Length: 200}, segments[2].ByteRange)
+		assert.Equal(t, uint64(91000), segments[2].Time)
+		assert.Equal(t, uint64(45000), segments[2].Duration)
+	}
+	assert.Equal(t, 1, indexRequests)
+}
+
+func TestDASHManifestDownloader_XLink(t *testing.T) {
+	manifest := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:xlink="http://www.w3.org/1999/xlink" type="static" mediaPresentationDuration="PT30S">` +
+		`<Period id="main1" duration="PT10S"><BaseURL>main/</BaseURL></Period>` +
+		`<Period xlink:href="ad.xml" xlink:actuate="onLoad"/>` +
+		`<Period xlink:href="urn:mpeg:dash:resolve-to-zero:2013" xlink:actuate="onLoad"/>` +
+		`<Period id="remote" xlink:href="not_found.xml" xlink:actuate="onLoad"/>` +
+		`<Period id="lazy" xlink:href="lazy.xml"/>` +
+		`<Period id="slow" xlink:href="slow.xml" xlink:actuate="onLoad"/>` +
+		`</MPD>`)
+	ad := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<Period xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:xlink="http://www.w3.org/1999/xlink" id="ad" duration="PT5S">` +
+		`<EventStream xlink:href="events.xml" xlink:actuate="onLoad"/>` +
+		`</Period>`)
+	events := []byte(`<EventStream schemeIdUri="urn:scte:scte35:2014:xml+bin" timescale="1"><Event presentationTime="0" duration="5" id="1"/></EventStream>`)
+	var lazyRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.mpd":
+			w.Write(manifest)
+		case "/ad.xml":
+			w.Write(ad)
+		case "/events.xml":
+			w.Write(events)
+		case "/lazy.xml":
+			lazyRequests++
+			w.Write(ad)
+		case "/slow.xml":
+			time.Sleep(200 * time.Millisecond)
+			w.Write(ad)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	d.xlinkTimeout = 100 * time.Millisecond
+	m, err := d.Download(context.Background(), server.URL+"/manifest.mpd")
+	require.NoError(t, err)
+	require.Len(t, m.Periods, 5)
+	assert.Equal(t, "main1", m.Periods[0].ID)
+	assert.Equal(t, "ad", m.Periods[1].ID)
+	assert.Equal(t, "remote", m.Periods[2].ID)
+	assert.Equal(t, "lazy", m.Periods[3].ID)
+	assert.Equal(t, "slow", m.Periods[4].ID)
+	assert.Contains(t, string(m.Raw), `<EventStream schemeIdUri="urn:scte:scte35:2014:xml+bin"`)
+	assert.NotContains(t, string(m.Raw), "resolve-to-zero")
+	assert.NotContains(t, string(m.Raw), "<?xml version=\"1.0\" encoding=\"UTF-8\"?><Period")
+	assert.Equal(t, 0, lazyRequests)
+	require.Len(t, m.xlinkErrors, 2)
+	assert.Contains(t, m.xlinkErrors[0].Error(), "not_found.xml")
+	assert.False(t, errors.Is(m.xlinkErrors[0], context.DeadlineExceeded))
+	assert.Contains(t, m.xlinkErrors[1].Error(), "slow.xml")
+	assert.True(t, errors.Is(m.xlinkErrors[1], context.DeadlineExceeded))
+
+	t.Run("max depth", func(t *testing.T) {
+		d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		d.xlinkMaxDepth = 1
+		m, err := d.Download(context.Background(), server.URL+"/manifest.mpd")
+		require.NoError(t, err)
+		assert.Equal(t, "ad", m.Periods[1].ID)
+		assert.NotContains(t, string(m.Raw), `schemeIdUri="urn:scte:scte35:2014:xml+bin"`)
+		// events.xml is referred by ad.xml and slow.xml.
+		require.Len(t, m.xlinkErrors, 3)
+		assert.Contains(t, m.xlinkErrors[0].Error(), "too deep xlink nesting")
+	})
+
+	t.Run("nested relative href", func(t *testing.T) {
+		manifest := []byte(`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:xlink="http://www.w3.org/1999/xlink" type="static" mediaPresentationDuration="PT10S">` +
+			`<Period xlink:href="ads/ad.xml" xlink:actuate="onLoad"/>` +
+			`<Period xlink:href="moved.xml" xlink:actuate="onLoad"/>` +
+			`</MPD>`)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/manifest.mpd":
+				w.Write(manifest)
+			case "/ads/ad.xml":
+				w.Write(ad)
+			case "/ads/events.xml":
+				w.Write(events)
+			case "/moved.xml":
+				http.Redirect(w, r, "/ads/ad.xml", http.StatusFound)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		m, err := d.Download(context.Background(), server.URL+"/manifest.mpd")
+		require.NoError(t, err)
+		assert.Empty(t, m.xlinkErrors)
+		require.Len(t, m.Periods, 2)
+		for _, period := range m.Periods {
+			assert.Equal(t, "ad", period.ID)
+			require.Len(t, period.EventStreams, 1)
+			require.NotNil(t, period.EventStreams[0].SchemeIDURI)
+			assert.Equal(t, "urn:scte:scte35:2014:xml+bin", *period.EventStreams[0].SchemeIDURI)
+		}
+	})
+}
+
+func TestDASHManifestDownloader_Patch(t *testing.T) {
+	manifest := []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" id="live" type="dynamic" availabilityStartTime="1970-01-01T00:00:00Z" publishTime="2023-01-01T00:00:00Z">` +
+		`<PatchLocation ttl="60">patch.mpp</PatchLocation>` +
+		`<Period id="1" start="PT0S">` +
+		`<AdaptationSet id="1" mimeType="video/mp4">` +
+		`<SegmentTemplate timescale="90000" media="$Time$.mp4" initialization="init.mp4">` +
+		`<SegmentTimeline><S t="0" d="900000"/></SegmentTimeline>` +
+		`</SegmentTemplate>` +
+		`<Representation id="4M" bandwidth="4000000"/>` +
+		`</AdaptationSet>` +
+		`</Period>` +
+		`</MPD>`)
+	patch := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<Patch xmlns="urn:mpeg:dash:schema:mpd-patch:2020" mpdId="live" originalPublishTime="2023-01-01T00:00:00Z" publishTime="2023-01-01T00:00:10Z">` +
+		`<replace sel="/MPD/@publishTime">2023-01-01T00:00:10Z</replace>` +
+		`<add sel="/MPD/Period[@id='1']/AdaptationSet[@id='1']/SegmentTemplate/SegmentTimeline"><S d="900000"/></add>` +
+		`<add sel="/MPD/Period[1]" pos="after"><Period id="2" start="PT20S"/></add>` +
+		`<remove sel="/MPD/Period[@id='1']/AdaptationSet/Representation[@id='4M']/@bandwidth"/>` +
+		`</Patch>`)
+	var manifestRequests, patchRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.mpd":
+			manifestRequests++
+			w.Write(manifest)
+		case "/patch.mpp":
+			patchRequests++
+			w.Write(patch)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	m, err := d.Download(context.Background(), server.URL+"/manifest.mpd")
+	require.NoError(t, err)
+	require.Len(t, m.Periods, 1)
+	assert.Equal(t, 1, manifestRequests)
+	assert.Equal(t, 0, patchRequests)
+
+	// patch is applied to the previous manifest.
+	m, err = d.Download(context.Background(), server.URL+"/manifest.mpd")
+	require.NoError(t, err)
+	assert.Equal(t, 1, manifestRequests)
+	assert.Equal(t, 1, patchRequests)
+	assert.NoError(t, m.patchError)
+	assert.Equal(t, server.URL+"/manifest.mpd", m.URL)
+	assert.Equal(t, "2023-01-01T00:00:10Z", *m.PublishTime)
+	require.Len(t, m.Periods, 2)
+	assert.Equal(t, "2", m.Periods[1].ID)
+	segments := m.Periods[0].AdaptationSets[0].SegmentTemplate.SegmentTimeline.Segments
+	require.Len(t, segments, 2)
+	assert.Equal(t, uint64(900000), segments[1].Duration)
+	assert.Nil(t, m.Periods[0].AdaptationSets[0].Representations[0].Bandwidth)
+	assert.Contains(t, string(m.Raw), `<PatchLocation ttl="60">patch.mpp</PatchLocation>`)
+
+	// the patch doesn't match the reconstructed manifest, then the full manifest is downloaded.
+	m, err = d.Download(context.Background(), server.URL+"/manifest.mpd")
+	require.NoError(t, err)
+	assert.Equal(t, 2, manifestRequests)
+	assert.Equal(t, 2, patchRequests)
+	var perr patchPublishTimeError
+	require.True(t, errors.As(m.patchError, &perr))
+	assert.Equal(t, "2023-01-01T00:00:00Z", perr.originalPublishTime)
+	assert.Equal(t, "2023-01-01T00:00:10Z", perr.publishTime)
+	assert.Equal(t, manifest, m.Raw)
+	require.Len(t, m.Periods, 1)
+}
+
+func TestApplyPatchOperation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		op       string
+		expected string
+		err      string
+	}{
+		{
+			name:     "add prepend",
+			op:       `<add sel="/MPD/Period[@id='1']" pos="prepend"><BaseURL>a/</BaseURL></add>`,
+			expected: `<MPD id="x"><Period id="1"><BaseURL>a/</BaseURL><AdaptationSet id="1"/></Period><Period id="2"/></MPD>`,
+		},
+		{
+			name:     "add before",
+			op:       `<add sel="/MPD/Period[2]" pos="before"><Period id="1.5"/></add>`,
+			expected: `<MPD id="x"><Period id="1"><AdaptationSet id="1"/></Period><Period id="1.5"/><Period id="2"/></MPD>`,
+		},
+		{
+			name:     "add attribute",
+			op:       `<add sel="/MPD/Period[@id='2']" type="@start">PT10S</add>`,
+			expected: `<MPD id="x"><Period id="1"><AdaptationSet id="1"/></Period><Period id="2" start="PT10S"/></MPD>`,
+		},
+		{
+			name:     "replace element",
+			op:       `<replace sel="/MPD/Period[@id='1']/AdaptationSet[@id='1']"><AdaptationSet id="3"/></replace>`,
+			expected: `<MPD id="x"><Period id="1"><AdaptationSet id="3"/></Period><Period id="2"/></MPD>`,
+		},
+		{
+			name:     "remove element",
+			op:       `<remove sel="/MPD/Period[@id='1' and @id='1']"/>`,
+			expected: `<MPD id="x"><Period id="2"/></MPD>`,
+		},
+		{
+			name: "multiple elements",
+			op:   `<remove sel="/MPD/Period"/>`,
+			err:  "selector must match exactly one element: /MPD/Period",
+		},
+		{
+			name: "attribute not found",
+			op:   `<replace sel="/MPD/@publishTime">2023-01-01T00:00:00Z</replace>`,
+			err:  "attribute is not found: /MPD/@publishTime",
+		},
+		{
+			name: "unsupported operation",
+			op:   `<move sel="/MPD"/>`,
+			err:  "unsupported patch operation: move",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parseXMLDocument([]byte(`<MPD id="x"><Period id="1"><AdaptationSet id="1"/></Period><Period id="2"/></MPD>`))
+			require.NoError(t, err)
+			op, err := parseXMLDocument([]byte(tc.op))
+			require.NoError(t, err)
+			err = doc.applyPatchOperation(op.root())
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			doc.writeTo(&buf)
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+func TestDASHManifestDownloader_UTCTiming(t *testing.T) {
+	serverTime := time.Now().Add(time.Hour).UTC()
+	var xsdateRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.mpd":
+			w.Write([]byte(r.URL.Query().Get("mpd")))
+		case "/xsdate":
+			xsdateRequests++
+			w.Write([]byte(serverTime.Format(time.RFC3339Nano)))
+		case "/iso":
+			w.Write([]byte(serverTime.Format("2006-01-02T15:04:05.000Z") + "\n"))
+		case "/head":
+			w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+		case "/ntp":
+			data := make([]byte, 8)
+			binary.BigEndian.PutUint32(data, uint32(serverTime.Unix()+ntpEpochOffset))
+			binary.BigEndian.PutUint32(data[4:], uint32((int64(serverTime.Nanosecond())<<32)/int64(time.Second)))
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		timings  string
+		scheme   string
+		accuracy time.Duration
+		errors   int
+	}{
+		{
+			name:     "direct",
+			timings:  `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="` + serverTime.Format(time.RFC3339Nano) + `"/>`,
+			scheme:   "urn:mpeg:dash:utc:direct:2014",
+			accuracy: 100 * time.Millisecond,
+		},
+		{
+			name:     "http-xsdate",
+			timings:  `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-xsdate:2014" value="/xsdate"/>`,
+			scheme:   "urn:mpeg:dash:utc:http-xsdate:2014",
+			accuracy: 100 * time.Millisecond,
+		},
+		{
+			name:     "http-iso",
+			timings:  `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-iso:2014" value="/iso"/>`,
+			scheme:   "urn:mpeg:dash:utc:http-iso:2014",
+			accuracy: 100 * time.Millisecond,
+		},
+		{
+			name:     "http-head",
+			timings:  `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-head:2014" value="/head"/>`,
+			scheme:   "urn:mpeg:dash:utc:http-head:2014",
+			accuracy: 1100 * time.Millisecond,
+		},
+		{
+			name:     "http-ntp",
+			timings:  `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-ntp:2014" value="/ntp"/>`,
+			scheme:   "urn:mpeg:dash:utc:http-ntp:2014",
+			accuracy: 100 * time.Millisecond,
+		},
+		{
+			name: "fallback",
+			timings: `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:ntp:2014" value="ntp.example.com"/>` +
+				`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-xsdate:2014" value="/not_found /xsdate"/>` +
+				`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-head:2014" value="/head"/>`,
+			scheme:   "urn:mpeg:dash:utc:http-xsdate:2014",
+			accuracy: 100 * time.Millisecond,
+		},
+		{
+			name: "all failed",
+			timings: `<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-iso:2014" value="/not_found"/>` +
+				`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="invalid"/>`,
+			errors: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mpd := `<MPD type="dynamic" availabilityStartTime="1970-01-01T00:00:00Z">` + tc.timings + `<Period id="1"/></MPD>`
+			d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+			m, err := d.Download(context.Background(), server.URL+"/manifest.mpd?mpd="+neturl.QueryEscape(mpd))
+			require.NoError(t, err)
+			if tc.errors != 0 {
+				assert.Nil(t, m.Timing)
+				assert.Len(t, m.timingErrors, tc.errors)
+				return
+			}
+			require.NotNil(t, m.Timing)
+			assert.Empty(t, m.timingErrors)
+			assert.Equal(t, tc.scheme, m.Timing.SchemeIDURI)
+			assert.InDelta(t, float64(time.Hour), float64(m.Timing.ClockOffset), float64(tc.accuracy))
+			assert.InDelta(t, float64(serverTime.UnixNano()), float64(m.Timing.Time.UnixNano()), float64(tc.accuracy))
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		mpd := `<MPD type="dynamic" availabilityStartTime="1970-01-01T00:00:00Z">` +
+			`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-xsdate:2014" value="/xsdate"/>` +
+			`<Period id="1"/></MPD>`
+		d := newDASHManifestDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		xsdateRequests = 0
+		for i := 0; i < 3; i++ {
+			m, err := d.Download(context.Background(), server.URL+"/manifest.mpd?mpd="+neturl.QueryEscape(mpd))
+			require.NoError(t, err)
+			require.NotNil(t, m.Timing)
+			assert.InDelta(t, float64(time.Hour), float64(m.Timing.ClockOffset), float64(100*time.Millisecond))
+			assert.InDelta(t, float64(serverTime.UnixNano()), float64(m.Timing.Time.UnixNano()), float64(100*time.Millisecond))
+		}
+		assert.Equal(t, 1, xsdateRequests)
+
+		// the clock is synchronized again after the interval.
+		d.clockSyncInterval = 0
+		_, err := d.Download(context.Background(), server.URL+"/manifest.mpd?mpd="+neturl.QueryEscape(mpd))
+		require.NoError(t, err)
+		assert.Equal(t, 2, xsdateRequests)
+	})
+}
+
+func TestManifestEvents(t *testing.T) {
+	m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD type="static" xmlns:scte35="http://www.scte.org/schemas/35/2016">`+
+		`<Period id="1"><EventStream schemeIdUri="urn:example" value="foo">`+
+		`<Event id="1" presentationTime="10" messageData="hello"/>`+
+		`</EventStream></Period>`+
+		`<Period id="2" start="PT10S"><EventStream schemeIdUri="urn:scte:scte35:2013:xml" timescale="90000" presentationTimeOffset="900000">`+
+		`<Event id="2" presentationTime="1080000" duration="2700000">`+
+		`<scte35:SpliceInfoSection><scte35:SpliceInsert spliceEventId="2"/></scte35:SpliceInfoSection>`+
+		`</Event>`+
+		`</EventStream></Period>`+
+		`</MPD>`), time.Unix(0, 0))
+	require.NoError(t, err)
+	events, err := m.Events()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, m.Periods[0], events[0].Period)
+	assert.Equal(t, "urn:example", events[0].SchemeIDURI)
+	assert.Equal(t, "foo", events[0].Value)
+	assert.Equal(t, uint64(1), events[0].Timescale)
+	assert.Equal(t, "1", events[0].ID)
+	assert.Equal(t, 10.0, events[0].Time())
+	assert.Nil(t, events[0].Duration)
+	assert.Equal(t, "hello", events[0].MessageData)
+	assert.Empty(t, events[0].Content)
+	assert.Equal(t, m.Periods[1], events[1].Period)
+	assert.Equal(t, uint64(90000), events[1].Timescale)
+	assert.Equal(t, 2.0, events[1].Time())
+	require.NotNil(t, events[1].Duration)
+	assert.Equal(t, uint64(2700000), *events[1].Duration)
+	assert.Equal(t, `<scte35:SpliceInfoSection><scte35:SpliceInsert spliceEventId="2"/></scte35:SpliceInfoSection>`, string(events[1].Content))
+}
+
+func TestManifestContentProtections(t *testing.T) {
+	m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD type="static" xmlns:cenc="urn:mpeg:cenc:2013">`+
+		`<Period id="1"><AdaptationSet mimeType="video/mp4">`+
+		`<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc" cenc:default_KID="11111111-1111-1111-1111-111111111111"/>`+
+		`<ContentProtection schemeIdUri="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"><cenc:pssh>AAAA</cenc:pssh></ContentProtection>`+
+		`<Representation id="1" bandwidth="100000">`+
+		`<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc" cenc:default_KID="22222222-2222-2222-2222-222222222222"/>`+
+		`</Representation>`+
+		`<Representation id="2" bandwidth="200000"/>`+
+		`</AdaptationSet></Period>`+
+		`</MPD>`), time.Unix(0, 0))
+	require.NoError(t, err)
+	as := m.Periods[0].AdaptationSets[0]
+
+	cps := m.ContentProtections(as, as.Representations[0])
+	require.Len(t, cps, 2)
+	assert.Equal(t, &DASHContentProtection{
+		SchemeIDURI: "urn:mpeg:dash:mp4protection:2011",
+		Value:       "cenc",
+		DefaultKID:  "22222222-2222-2222-2222-222222222222",
+	}, cps[0])
+	assert.Equal(t, &DASHContentProtection{
+		SchemeIDURI: "urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed",
+		PSSH:        "AAAA",
+	}, cps[1])
+
+	cps = m.ContentProtections(as, as.Representations[1])
+	require.Len(t, cps, 2)
+	assert.Equal(t, "11111111-1111-1111-1111-111111111111", cps[0].DefaultKID)
+	assert.Equal(t, "AAAA", cps[1].PSSH)
+}
+
+func TestManifestSegmentTemplateAttribute(t *testing.T) {
+	m, err := newManifest("https://localhost/foo/manifest.mpd", []byte(`<MPD type="static">`+
+		`<Period id="1"><AdaptationSet mimeType="video/mp4">`+
+		`<SegmentTemplate media="$Number$.mp4" initialization="init.mp4" index="$Number$.sidx" bitstreamSwitching="bs.mp4" duration="2"/>`+
+		`<Representation id="1" bandwidth="100000"/>`+
+		`</AdaptationSet></Period>`+
+		`</MPD>`), time.Unix(0, 0))
+	require.NoError(t, err)
+	template := m.Periods[0].AdaptationSets[0].SegmentTemplate
+	for attr, expected := range map[TemplateAttribute]string{
+		TemplateAttributeMedia:              "$Number$.mp4",
+		TemplateAttributeInitialization:     "init.mp4",
+		TemplateAttributeIndex:              "$Number$.sidx",
+		TemplateAttributeBitstreamSwitching: "bs.mp4",
+	} {
+		value, ok := m.SegmentTemplateAttribute(template, attr)
+		assert.True(t, ok, attr.String())
+		assert.Equal(t, expected, value, attr.String())
+	}
+	_, ok := m.SegmentTemplateAttribute(&mpd.SegmentTemplate{}, TemplateAttributeIndex)
+	assert.False(t, ok)
+}
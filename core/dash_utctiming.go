@@ -0,0 +1,200 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/internal/url"
+)
+
+const (
+	utcTimingSchemeDirect2012   = "urn:mpeg:dash:utc:direct:2012"
+	utcTimingSchemeDirect       = "urn:mpeg:dash:utc:direct:2014"
+	utcTimingSchemeHTTPXSDate   = "urn:mpeg:dash:utc:http-xsdate:2014"
+	utcTimingSchemeHTTPISO      = "urn:mpeg:dash:utc:http-iso:2014"
+	utcTimingSchemeHTTPHead     = "urn:mpeg:dash:utc:http-head:2014"
+	utcTimingSchemeHTTPNTP      = "urn:mpeg:dash:utc:http-ntp:2014"
+	utcTimingSchemeHTTPXSDate12 = "urn:mpeg:dash:utc:http-xsdate:2012"
+	utcTimingSchemeHTTPISO12    = "urn:mpeg:dash:utc:http-iso:2012"
+	utcTimingSchemeHTTPHead12   = "urn:mpeg:dash:utc:http-head:2012"
+	utcTimingSchemeHTTPNTP12    = "urn:mpeg:dash:utc:http-ntp:2012"
+)
+
+// ntpEpochOffset is the number of seconds from 1900-01-01 to 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+// defaultClockSyncInterval is used when DASHConfig.ClockSyncInterval is zero.
+const defaultClockSyncInterval = 5 * time.Minute
+
+// DASHTiming is the wall-clock time resolved by UTCTiming element.
+type DASHTiming struct {
+	SchemeIDURI string
+	Value       string
+	// Time is the wall-clock time when the manifest was downloaded.
+	Time time.Time
+	// ClockOffset is the difference of the wall-clock from the local clock.
+	// It is positive when the local clock is behind.
+	ClockOffset time.Duration
+}
+
+type utcTiming struct {
+	schemeIDURI string
+	value       string
+}
+
+// clockSync is the clock offset requested from a UTCTiming URL.
+type clockSync struct {
+	offset time.Duration
+	time   time.Time
+}
+
+// utcTimings returns UTCTiming elements in order of appearance.
+func (m *Manifest) utcTimings() []utcTiming {
+	if element, ok := m.elements[m.MPD]; ok {
+		timings := make([]utcTiming, 0)
+		for _, child := range element.children("UTCTiming") {
+			scheme, _ := child.attr("schemeIdUri")
+			value, _ := child.attr("value")
+			timings = append(timings, utcTiming{schemeIDURI: scheme, value: value})
+		}
+		return timings
+	}
+	if m.UTCTiming != nil && m.UTCTiming.SchemeIDURI != nil && m.UTCTiming.Value != nil {
+		return []utcTiming{{schemeIDURI: *m.UTCTiming.SchemeIDURI, value: *m.UTCTiming.Value}}
+	}
+	return nil
+}
+
+// resolveTiming tries UTCTiming elements in order and sets the first resolved one to the manifest.
+// Errors of UTCTiming elements are held by the manifest when no UTCTiming element is resolved.
+func (d *dashManifestDownloader) resolveTiming(ctx context.Context, manifest *Manifest) {
+	errs := make([]error, 0)
+	for _, timing := range manifest.utcTimings() {
+		offset, err := d.clockOffset(ctx, manifest, timing)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resolve UTCTiming: %s: %s: %w", timing.schemeIDURI, timing.value, err))
+			continue
+		}
+		manifest.Timing = &DASHTiming{
+			SchemeIDURI: timing.schemeIDURI,
+			Value:       timing.value,
+			Time:        manifest.Time.Add(offset),
+			ClockOffset: offset,
+		}
+		return
+	}
+	manifest.timingErrors = errs
+}
+
+func (d *dashManifestDownloader) clockOffset(ctx context.Context, manifest *Manifest, timing utcTiming) (time.Duration, error) {
+	switch timing.schemeIDURI {
+	case utcTimingSchemeDirect, utcTimingSchemeDirect2012:
+		tm, err := parseISOTime(timing.value)
+		if err != nil {
+			return 0, err
+		}
+		return tm.Sub(manifest.Time), nil
+	case utcTimingSchemeHTTPXSDate, utcTimingSchemeHTTPISO, utcTimingSchemeHTTPHead, utcTimingSchemeHTTPNTP,
+		utcTimingSchemeHTTPXSDate12, utcTimingSchemeHTTPISO12, utcTimingSchemeHTTPHead12, utcTimingSchemeHTTPNTP12:
+	default:
+		return 0, errors.New("unsupported scheme")
+	}
+	// value is a white-space separated list of URLs.
+	var lastErr error
+	for _, u := range strings.Fields(timing.value) {
+		u, err := url.ResolveReference(manifest.URL, u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		offset, err := d.cachedClockOffset(ctx, timing.schemeIDURI, u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return offset, nil
+	}
+	if lastErr == nil {
+		return 0, errors.New("no URL")
+	}
+	return 0, lastErr
+}
+
+// cachedClockOffset returns the clock offset of the URL requested within clockSyncInterval,
+// or requests it again.
+// The local clock is assumed not to drift from the wall-clock during the interval.
+func (d *dashManifestDownloader) cachedClockOffset(ctx context.Context, scheme, u string) (time.Duration, error) {
+	key := scheme + "\n" + u
+	now := time.Now()
+	if sync, ok := d.clockSyncs[key]; ok && now.Sub(sync.time) < d.clockSyncInterval {
+		return sync.offset, nil
+	}
+	offset, err := d.requestClockOffset(ctx, scheme, u)
+	if err != nil {
+		return 0, err
+	}
+	if d.clockSyncs == nil {
+		d.clockSyncs = make(map[string]*clockSync)
+	}
+	d.clockSyncs[key] = &clockSync{offset: offset, time: now}
+	return offset, nil
+}
+
+// requestClockOffset requests the wall-clock time and returns the offset from the local clock.
+// The wall-clock time is assumed to be the time at the middle of the request.
+func (d *dashManifestDownloader) requestClockOffset(ctx context.Context, scheme, u string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	start := time.Now()
+	var tm time.Time
+	switch scheme {
+	case utcTimingSchemeHTTPHead, utcTimingSchemeHTTPHead12:
+		header, err := d.client.Head(ctx, u)
+		if err != nil {
+			return 0, err
+		}
+		if tm, err = http.ParseTime(header.Get("Date")); err != nil {
+			return 0, fmt.Errorf("invalid Date header: %w", err)
+		}
+	case utcTimingSchemeHTTPNTP, utcTimingSchemeHTTPNTP12:
+		data, _, err := d.client.Get(ctx, u)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) != 8 {
+			return 0, fmt.Errorf("invalid NTP timestamp: length=%d", len(data))
+		}
+		sec := int64(binary.BigEndian.Uint32(data)) - ntpEpochOffset
+		frac := int64(binary.BigEndian.Uint32(data[4:]))
+		tm = time.Unix(sec, frac*int64(time.Second)>>32)
+	default:
+		data, _, err := d.client.Get(ctx, u)
+		if err != nil {
+			return 0, err
+		}
+		if tm, err = parseISOTime(strings.TrimSpace(string(data))); err != nil {
+			return 0, err
+		}
+	}
+	end := time.Now()
+	return tm.Sub(start.Add(end.Sub(start) / 2)), nil
+}
+
+// parseISOTime parses xs:dateTime and ISO 8601 formats which are used by UTCTiming.
+func parseISOTime(s string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02T15:04:05.999999999",
+	} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time: %s", s)
+}
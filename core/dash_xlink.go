@@ -0,0 +1,138 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/abema/antares/internal/url"
+)
+
+const (
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	// xlinkResolveToZero is the special URL which means the element is removed.
+	xlinkResolveToZero = "urn:mpeg:dash:resolve-to-zero:2013"
+	// defaultXLinkMaxDepth is used when DASHConfig.XLinkMaxDepth is zero.
+	defaultXLinkMaxDepth = 3
+)
+
+// xlinkError is an error of xlink resolution.
+// The element which failed to be resolved is kept as is.
+type xlinkError struct {
+	href   string
+	parent error
+}
+
+func (err xlinkError) Error() string {
+	return fmt.Sprintf("failed to resolve xlink: %s: %s", err.href, err.parent)
+}
+
+func (err xlinkError) Unwrap() error {
+	return err.parent
+}
+
+// xlinkTargets is a set of elements which can be resolved by xlink:href with xlink:actuate="onLoad".
+var xlinkTargets = map[string]bool{
+	"Period":      true,
+	"EventStream": true,
+}
+
+// resolveXLinks replaces Period and EventStream elements which have xlink:href and xlink:actuate="onLoad"
+// with remote elements.
+// Remote elements are resolved recursively until depth reaches zero.
+func (d *dashManifestDownloader) resolveXLinks(ctx context.Context, baseURL string, data []byte, depth int) ([]byte, []error) {
+	var resolved bytes.Buffer
+	var errs []error
+	var last int64
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// the document is returned as is, and the error will be detected by the decoder of MPD.
+			return data, errs
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || !xlinkTargets[start.Name.Local] {
+			continue
+		}
+		href, actuate := xlinkAttrs(start)
+		if href == "" || actuate != "onLoad" {
+			continue
+		}
+		if err := decoder.Skip(); err != nil {
+			return data, errs
+		}
+		end := decoder.InputOffset()
+		remote, loc, err := d.fetchXLink(ctx, baseURL, href, depth)
+		if err != nil {
+			errs = append(errs, xlinkError{href: href, parent: err})
+			continue
+		}
+		// nested xlink:href is resolved against the URL of the remote element.
+		remote, remoteErrs := d.resolveXLinks(ctx, loc, remote, depth-1)
+		errs = append(errs, remoteErrs...)
+		resolved.Write(data[last:offset])
+		resolved.Write(remote)
+		last = end
+	}
+	if last == 0 {
+		return data, errs
+	}
+	resolved.Write(data[last:])
+	return resolved.Bytes(), errs
+}
+
+func xlinkAttrs(start xml.StartElement) (href, actuate string) {
+	actuate = "onRequest"
+	for _, attr := range start.Attr {
+		if attr.Name.Space != xlinkNamespace && attr.Name.Space != "xlink" {
+			continue
+		}
+		switch attr.Name.Local {
+		case "href":
+			href = attr.Value
+		case "actuate":
+			actuate = attr.Value
+		}
+	}
+	return href, actuate
+}
+
+// fetchXLink returns the remote elements and the URL from which they are downloaded after redirects.
+func (d *dashManifestDownloader) fetchXLink(ctx context.Context, baseURL, href string, depth int) ([]byte, string, error) {
+	if href == xlinkResolveToZero {
+		return nil, baseURL, nil
+	}
+	if depth <= 0 {
+		return nil, "", errors.New("too deep xlink nesting")
+	}
+	u, err := url.ResolveReference(baseURL, href)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.xlinkTimeout)
+	defer cancel()
+	data, loc, err := d.client.Get(ctx, u)
+	if err != nil {
+		return nil, "", err
+	}
+	return stripXMLDeclaration(data), loc, nil
+}
+
+// stripXMLDeclaration removes the XML declaration to embed the remote elements into the manifest.
+func stripXMLDeclaration(data []byte) []byte {
+	trimmed := bytes.TrimLeft(data, "\ufeff \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return data
+	}
+	if i := bytes.Index(trimmed, []byte("?>")); i >= 0 {
+		return trimmed[i+2:]
+	}
+	return data
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"encoding/xml"
+
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// xmlElement is a generic XML element to read attributes which go-dash doesn't support.
+type xmlElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr    `xml:",any,attr"`
+	Children []*xmlElement `xml:",any"`
+	Value    string        `xml:",chardata"`
+}
+
+func (e *xmlElement) attr(name string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (e *xmlElement) children(name string) []*xmlElement {
+	if e == nil {
+		return nil
+	}
+	children := make([]*xmlElement, 0)
+	for _, child := range e.Children {
+		if child.XMLName.Local == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (e *xmlElement) child(name string) *xmlElement {
+	if children := e.children(name); len(children) != 0 {
+		return children[0]
+	}
+	return nil
+}
+
+// mapElements returns a table from go-dash elements to corresponding XML elements.
+func mapElements(m *mpd.MPD, data []byte) (map[interface{}]*xmlElement, error) {
+	root := &xmlElement{}
+	if err := xml.Unmarshal(data, root); err != nil {
+		return nil, err
+	}
+	elements := map[interface{}]*xmlElement{m: root}
+	periods := root.children("Period")
+	for i, period := range m.Periods {
+		if i >= len(periods) {
+			break
+		}
+		elements[period] = periods[i]
+		mapSegmentTemplate(elements, period.SegmentTemplate, periods[i])
+		adaptationSets := periods[i].children("AdaptationSet")
+		for j, as := range period.AdaptationSets {
+			if j >= len(adaptationSets) {
+				break
+			}
+			elements[as] = adaptationSets[j]
+			mapSegmentTemplate(elements, as.SegmentTemplate, adaptationSets[j])
+			representations := adaptationSets[j].children("Representation")
+			for k, rep := range as.Representations {
+				if k >= len(representations) {
+					break
+				}
+				elements[rep] = representations[k]
+				mapSegmentTemplate(elements, rep.SegmentTemplate, representations[k])
+			}
+		}
+	}
+	return elements, nil
+}
+
+func mapSegmentTemplate(elements map[interface{}]*xmlElement, template *mpd.SegmentTemplate, parent *xmlElement) {
+	if template == nil {
+		return
+	}
+	element := parent.child("SegmentTemplate")
+	elements[template] = element
+	if template.SegmentTimeline == nil {
+		return
+	}
+	ss := element.child("SegmentTimeline").children("S")
+	for i, s := range template.SegmentTimeline.Segments {
+		if i < len(ss) {
+			elements[s] = ss[i]
+		}
+	}
+}
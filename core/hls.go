@@ -27,6 +27,34 @@ type MediaPlaylist struct {
 	*m3u8.MediaPlaylist
 	VariantParams *m3u8.VariantParams
 	Alternative   *m3u8.Alternative
+	// IFrameOnly is true when the playlist is referred by EXT-X-I-FRAME-STREAM-INF tag
+	// or has EXT-X-I-FRAMES-ONLY tag.
+	IFrameOnly bool
+	// ServerControl is nil when EXT-X-SERVER-CONTROL tag is omitted.
+	ServerControl *ServerControl
+	// PartTarget is PART-TARGET attribute of EXT-X-PART-INF tag.
+	PartTarget      float64
+	PartialSegments []*PartialSegment
+	PreloadHints    []*PreloadHint
+	// BlockingRequest is delivery directives used to download this playlist.
+	// This property is nil when the playlist is not downloaded by blocking playlist reload.
+	BlockingRequest *BlockingRequest
+	// SkippedSegments is SKIPPED-SEGMENTS attribute of EXT-X-SKIP tag.
+	// Skipped segments are complemented from the previous playlist, so Segments always has a complete list.
+	// Raw keeps the downloaded delta update as it is.
+	SkippedSegments uint64
+	// DateRanges holds EXT-X-DATERANGE tags in order of appearance.
+	// Tags skipped by delta update are complemented from the previous playlist.
+	DateRanges []*DateRange
+	// CueTags holds ad-marker tags in order of appearance.
+	CueTags []*CueTag
+	// dateRangesSkipped is true when EXT-X-DATERANGE tags are skipped by delta update.
+	dateRangesSkipped bool
+	// removedDateRanges is RECENTLY-REMOVED-DATERANGES attribute of EXT-X-SKIP tag.
+	removedDateRanges []string
+	// mapKeys holds EXT-X-KEY tags which apply to EXT-X-MAP tags.
+	// EXT-X-MAP tags which are not encrypted are not contained.
+	mapKeys map[m3u8.Map]*m3u8.Key
 }
 
 func (p *MediaPlaylist) SegmentURLs() ([]string, error) {
@@ -45,6 +73,23 @@ func (p *MediaPlaylist) SegmentURLs() ([]string, error) {
 	return urls, nil
 }
 
+// InitializationSegments returns EXT-X-MAP tags applied to the media segments without duplication.
+func (p *MediaPlaylist) InitializationSegments() []*m3u8.Map {
+	maps := make([]*m3u8.Map, 0)
+	keys := make(map[m3u8.Map]bool)
+	appendMap := func(m *m3u8.Map) {
+		if m != nil && !keys[*m] {
+			keys[*m] = true
+			maps = append(maps, m)
+		}
+	}
+	appendMap(p.Map)
+	for _, segment := range p.Segments {
+		appendMap(segment.Map)
+	}
+	return maps
+}
+
 type Playlists struct {
 	MasterPlaylist *MasterPlaylist
 	MediaPlaylists map[string]*MediaPlaylist
@@ -52,6 +97,22 @@ type Playlists struct {
 
 type HLSSegment struct {
 	URL string
+	// ByteRange is a sub-range specified by EXT-X-BYTERANGE tag.
+	// This property is nil when the segment is the entire resource.
+	ByteRange *ByteRange
+	// Partial is true when the segment is a partial segment specified by EXT-X-PART tag.
+	Partial bool
+	// Initialization is true when the segment is a media initialization section specified by EXT-X-MAP tag.
+	Initialization bool
+	// IFrameOnly is true when the segment belongs to an I-frame only playlist.
+	IFrameOnly bool
+	// InitializationURL and InitializationByteRange refer to the media initialization section applied to the segment.
+	// InitializationURL is empty when EXT-X-MAP tag is not applied.
+	InitializationURL       string
+	InitializationByteRange *ByteRange
+	// Encryption is nil when the segment is not encrypted.
+	// Partial segments are never decrypted, so this property is always nil for them.
+	Encryption *Encryption
 	// VariantParams is reference to related VariantParams object in MasterPlaylist.
 	// This property is nullable.
 	VariantParams *m3u8.VariantParams
@@ -63,13 +124,104 @@ type HLSSegment struct {
 func (p *Playlists) Segments() ([]*HLSSegment, error) {
 	segments := make([]*HLSSegment, 0)
 	for _, playlist := range p.MediaPlaylists {
+		base, err := url.Parse(playlist.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range playlist.InitializationSegments() {
+			u, err := base.Parse(m.URI)
+			if err != nil {
+				return nil, err
+			}
+			var byteRange *ByteRange
+			if m.Limit > 0 {
+				byteRange = &ByteRange{Offset: m.Offset, Length: m.Limit}
+			}
+			var encryption *Encryption
+			if key := playlist.mapKeys[*m]; key != nil {
+				ku, err := base.Parse(key.URI)
+				if err != nil {
+					return nil, err
+				}
+				// IV attribute is required for EXT-X-MAP, so the media sequence number is never used.
+				if encryption, err = newEncryption(key, ku.String(), 0); err != nil {
+					return nil, err
+				}
+			}
+			segments = append(segments, &HLSSegment{
+				URL:            u.String(),
+				ByteRange:      byteRange,
+				Initialization: true,
+				Encryption:     encryption,
+				IFrameOnly:     playlist.IFrameOnly,
+				VariantParams:  playlist.VariantParams,
+				Alternative:    playlist.Alternative,
+			})
+		}
 		urls, err := playlist.SegmentURLs()
 		if err != nil {
 			return nil, err
 		}
-		for _, u := range urls {
+		var key *m3u8.Key
+		var keyURL string
+		var initURL string
+		var initByteRange *ByteRange
+		for i, u := range urls {
+			seg := playlist.Segments[i]
+			var byteRange *ByteRange
+			if seg.Limit > 0 {
+				byteRange = &ByteRange{Offset: seg.Offset, Length: seg.Limit}
+			}
+			if seg.Map != nil {
+				mapURL, err := base.Parse(seg.Map.URI)
+				if err != nil {
+					return nil, err
+				}
+				initURL, initByteRange = mapURL.String(), nil
+				if seg.Map.Limit > 0 {
+					initByteRange = &ByteRange{Offset: seg.Map.Offset, Length: seg.Map.Limit}
+				}
+			}
+			var rotated bool
+			if seg.Key != nil {
+				prevKeyURL := keyURL
+				key, keyURL = seg.Key, ""
+				if key.Method != "" && key.Method != "NONE" {
+					ku, err := base.Parse(key.URI)
+					if err != nil {
+						return nil, err
+					}
+					keyURL = ku.String()
+				}
+				rotated = i != 0 && keyURL != prevKeyURL
+			}
+			var encryption *Encryption
+			if keyURL != "" {
+				if encryption, err = newEncryption(key, keyURL, seg.SeqId); err != nil {
+					return nil, err
+				}
+				encryption.Rotated = rotated
+			}
+			segments = append(segments, &HLSSegment{
+				URL:                     u,
+				ByteRange:               byteRange,
+				InitializationURL:       initURL,
+				InitializationByteRange: initByteRange,
+				Encryption:              encryption,
+				IFrameOnly:              playlist.IFrameOnly,
+				VariantParams:           playlist.VariantParams,
+				Alternative:             playlist.Alternative,
+			})
+		}
+		partURLs, err := playlist.PartialSegmentURLs()
+		if err != nil {
+			return nil, err
+		}
+		for i, u := range partURLs {
 			segments = append(segments, &HLSSegment{
 				URL:           u,
+				ByteRange:     playlist.PartialSegments[i].ByteRange,
+				Partial:       true,
 				VariantParams: playlist.VariantParams,
 				Alternative:   playlist.Alternative,
 			})
@@ -87,6 +239,28 @@ func (p *Playlists) IsVOD() bool {
 	return true
 }
 
+// CanBlockReload returns whether all media playlists support blocking playlist reload.
+func (p *Playlists) CanBlockReload() bool {
+	for _, playlist := range p.MediaPlaylists {
+		if playlist.ServerControl == nil || !playlist.ServerControl.CanBlockReload {
+			return false
+		}
+	}
+	return len(p.MediaPlaylists) != 0
+}
+
+// minPartTarget returns the smallest PART-TARGET of media playlists.
+// It returns 0 when no media playlist has EXT-X-PART-INF tag.
+func (p *Playlists) minPartTarget() float64 {
+	var target float64
+	for _, playlist := range p.MediaPlaylists {
+		if playlist.PartTarget > 0 && (target == 0 || playlist.PartTarget < target) {
+			target = playlist.PartTarget
+		}
+	}
+	return target
+}
+
 func (p *Playlists) MaxTargetDuration() float64 {
 	var dur float64
 	for _, playlist := range p.MediaPlaylists {
@@ -98,23 +272,48 @@ func (p *Playlists) MaxTargetDuration() float64 {
 }
 
 type hlsPlaylistDownloader struct {
-	client         client
-	timeout        time.Duration
-	masterPlaylist *MasterPlaylist
+	client              client
+	timeout             time.Duration
+	interstitialTimeout time.Duration
+	masterPlaylist      *MasterPlaylist
+	mediaPlaylists      map[string]*MediaPlaylist
+	// interstitials holds the interstitials resolved on the previous download by the asset URLs.
+	interstitials map[string]*Interstitial
+	mutex         sync.Mutex
 }
 
 func newHLSPlaylistDownloader(client client, timeout time.Duration) *hlsPlaylistDownloader {
 	return &hlsPlaylistDownloader{
-		client:  client,
-		timeout: timeout,
+		client:              client,
+		timeout:             timeout,
+		interstitialTimeout: timeout,
+		mediaPlaylists:      make(map[string]*MediaPlaylist),
 	}
 }
 
 func (d *hlsPlaylistDownloader) Download(ctx context.Context, u string) (*Playlists, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	playlists, err := d.download(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	d.resolveInterstitials(ctx, playlists)
+	return playlists, nil
+}
+
+func (d *hlsPlaylistDownloader) download(ctx context.Context, u string) (*Playlists, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout+d.blockingTimeout())
 	defer cancel()
 
 	if d.masterPlaylist == nil {
+		if prev := d.loadMediaPlaylist("_"); prev != nil {
+			mediaPlaylist, err := d.downloadMediaPlaylist(ctx, nil, "_", u, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &Playlists{
+				MediaPlaylists: map[string]*MediaPlaylist{"_": mediaPlaylist},
+			}, nil
+		}
 		data, loc, err := d.client.Get(ctx, u)
 		if err != nil {
 			return nil, fmt.Errorf("failed to download playlist: %s: %w", u, err)
@@ -124,17 +323,16 @@ func (d *hlsPlaylistDownloader) Download(ctx context.Context, u string) (*Playli
 			return nil, fmt.Errorf("failed to decode playlist: %s: %w", u, err)
 		}
 		if ptype == m3u8.MEDIA {
-			media := dec.(*m3u8.MediaPlaylist)
-			removeNilSegments(media)
+			mediaPlaylist, err := newMediaPlaylist(loc, data, dec.(*m3u8.MediaPlaylist), nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode playlist: %s: %w", u, err)
+			}
+			if err := mediaPlaylist.mergeDeltaUpdate(nil); err != nil {
+				return nil, newPermanentError(fmt.Errorf("inconsistent delta update: %s: %w", u, err))
+			}
+			d.storeMediaPlaylist("_", mediaPlaylist)
 			return &Playlists{
-				MediaPlaylists: map[string]*MediaPlaylist{
-					"_": {
-						URL:           loc,
-						Raw:           data,
-						Time:          time.Now(),
-						MediaPlaylist: media,
-					},
-				},
+				MediaPlaylists: map[string]*MediaPlaylist{"_": mediaPlaylist},
 			}, nil
 		}
 		master := dec.(*m3u8.MasterPlaylist)
@@ -159,7 +357,7 @@ func (d *hlsPlaylistDownloader) Download(ctx context.Context, u string) (*Playli
 	for vi := range d.masterPlaylist.Variants {
 		variant := d.masterPlaylist.Variants[vi]
 		eg.Go(thread.NoPanic(func() error {
-			mediaPlaylist, err := d.downloadMediaPlaylist(ctx, base, variant.URI, &variant.VariantParams, nil)
+			mediaPlaylist, err := d.downloadMediaPlaylist(ctx, base, variant.URI, variant.URI, &variant.VariantParams, nil)
 			if err != nil {
 				return err
 			}
@@ -174,7 +372,7 @@ func (d *hlsPlaylistDownloader) Download(ctx context.Context, u string) (*Playli
 				continue
 			}
 			eg.Go(thread.NoPanic(func() error {
-				mediaPlaylist, err := d.downloadMediaPlaylist(ctx, base, alt.URI, nil, alt)
+				mediaPlaylist, err := d.downloadMediaPlaylist(ctx, base, alt.URI, alt.URI, nil, alt)
 				if err != nil {
 					return err
 				}
@@ -191,18 +389,80 @@ func (d *hlsPlaylistDownloader) Download(ctx context.Context, u string) (*Playli
 	return playlists, nil
 }
 
+// blockingTimeout returns additional timeout for blocking playlist reload.
+// Server can hold a request up to three times of the part target duration.
+func (d *hlsPlaylistDownloader) blockingTimeout() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var timeout time.Duration
+	for _, playlist := range d.mediaPlaylists {
+		if playlist.nextBlockingRequest() == nil {
+			continue
+		}
+		target := playlist.PartTarget
+		if target == 0 {
+			target = playlist.TargetDuration
+		}
+		if t := time.Duration(target*3*1e9) * time.Nanosecond; t > timeout {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+func (d *hlsPlaylistDownloader) loadMediaPlaylist(key string) *MediaPlaylist {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.mediaPlaylists[key]
+}
+
+func (d *hlsPlaylistDownloader) storeMediaPlaylist(key string, playlist *MediaPlaylist) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if playlist == nil {
+		delete(d.mediaPlaylists, key)
+		return
+	}
+	d.mediaPlaylists[key] = playlist
+}
+
 func (d *hlsPlaylistDownloader) downloadMediaPlaylist(
 	ctx context.Context,
 	base *url.URL,
+	key string,
 	u string,
 	variantParams *m3u8.VariantParams,
 	alt *m3u8.Alternative,
 ) (*MediaPlaylist, error) {
-	absolute, err := base.Parse(u)
-	if err != nil {
-		return nil, fmt.Errorf("invalid URL format: %s: %w", u, err)
+	reqURL := u
+	if base != nil {
+		absolute, err := base.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL format: %s: %w", u, err)
+		}
+		reqURL = absolute.String()
+	}
+	prev := d.loadMediaPlaylist(key)
+	var blockingRequest *BlockingRequest
+	directives := make(url.Values)
+	if prev != nil {
+		if blockingRequest = prev.nextBlockingRequest(); blockingRequest != nil {
+			for key, values := range blockingRequest.query() {
+				directives[key] = values
+			}
+		}
+		if prev.canRequestDeltaUpdate(time.Now()) {
+			directives.Set("_HLS_skip", "YES")
+		}
+	}
+	if len(directives) != 0 {
+		var err error
+		reqURL, err = addDeliveryDirectives(reqURL, directives)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL format: %s: %w", u, err)
+		}
 	}
-	data, loc, err := d.client.Get(ctx, absolute.String())
+	data, loc, err := d.client.Get(ctx, reqURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download media playlist: %s: %w", u, err)
 	}
@@ -210,16 +470,48 @@ func (d *hlsPlaylistDownloader) downloadMediaPlaylist(
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode media playlist: %s: %w", u, err)
 	}
-	media := dec.(*m3u8.MediaPlaylist)
+	media, ok := dec.(*m3u8.MediaPlaylist)
+	if !ok {
+		return nil, fmt.Errorf("unexpected master playlist: %s", u)
+	}
+	mediaPlaylist, err := newMediaPlaylist(loc, data, media, variantParams, alt)
+	if err == nil {
+		err = mediaPlaylist.mergeDeltaUpdate(prev)
+	}
+	if err != nil {
+		// next request fetches the full playlist.
+		d.storeMediaPlaylist(key, nil)
+		return nil, newPermanentError(fmt.Errorf("inconsistent delta update: %s: %w", u, err))
+	}
+	mediaPlaylist.BlockingRequest = blockingRequest
+	d.storeMediaPlaylist(key, mediaPlaylist)
+	return mediaPlaylist, nil
+}
+
+func newMediaPlaylist(
+	loc string,
+	data []byte,
+	media *m3u8.MediaPlaylist,
+	variantParams *m3u8.VariantParams,
+	alt *m3u8.Alternative,
+) (*MediaPlaylist, error) {
 	removeNilSegments(media)
-	return &MediaPlaylist{
-		URL:           loc,
+	fillByteRangeOffsets(media)
+	mediaPlaylist := &MediaPlaylist{
+		URL:           removeDeliveryDirectives(loc),
 		Raw:           data,
 		Time:          time.Now(),
 		MediaPlaylist: media,
 		VariantParams: variantParams,
 		Alternative:   alt,
-	}, nil
+		IFrameOnly:    media.Iframe || (variantParams != nil && variantParams.Iframe),
+	}
+	if err := decodeLowLatencyTags(mediaPlaylist); err != nil {
+		return nil, err
+	}
+	decodeAdTags(mediaPlaylist)
+	decodeMapKeys(mediaPlaylist)
+	return mediaPlaylist, nil
 }
 
 // removeNilSegments removes nil elements, because grafov/m3u8 returns nil-filled large slice.
@@ -232,3 +524,15 @@ func removeNilSegments(media *m3u8.MediaPlaylist) {
 		}
 	}
 }
+
+// fillByteRangeOffsets complements omitted offsets of EXT-X-BYTERANGE tags,
+// because grafov/m3u8 sets zero instead of the end of the previous sub-range of the same resource.
+func fillByteRangeOffsets(media *m3u8.MediaPlaylist) {
+	var prev *m3u8.MediaSegment
+	for _, seg := range media.Segments {
+		if seg.Limit > 0 && seg.Offset == 0 && prev != nil && prev.Limit > 0 && prev.URI == seg.URI {
+			seg.Offset = prev.Offset + prev.Limit
+		}
+		prev = seg
+	}
+}
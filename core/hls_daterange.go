@@ -0,0 +1,146 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// DateRange is EXT-X-DATERANGE tag.
+type DateRange struct {
+	ID    string
+	Class string
+	// StartDate is zero when START-DATE attribute is omitted or invalid.
+	StartDate time.Time
+	// EndDate is nil when END-DATE attribute is omitted or invalid.
+	EndDate *time.Time
+	// Duration and PlannedDuration are nil when the attributes are omitted.
+	Duration        *float64
+	PlannedDuration *float64
+	// SCTE35Cmd, SCTE35Out and SCTE35In are hexadecimal strings of splice_info_section.
+	SCTE35Cmd string
+	SCTE35Out string
+	SCTE35In  string
+	EndOnNext bool
+	// Attributes holds all attributes including client-defined attributes (X-<client-attribute>).
+	Attributes map[string]string
+	// SeqID is media sequence number of the segment which follows the tag.
+	SeqID uint64
+	// Interstitial holds the assets resolved from X-ASSET-URI or X-ASSET-LIST attribute.
+	// This property is nil unless CLASS is "com.apple.hls.interstitial" and either attribute is specified.
+	Interstitial *Interstitial
+}
+
+// End returns the end date of the date range.
+// ok is false when neither END-DATE nor DURATION is specified.
+func (r *DateRange) End() (end time.Time, ok bool) {
+	if r.EndDate != nil {
+		return *r.EndDate, true
+	}
+	if r.Duration != nil && !r.StartDate.IsZero() {
+		return r.StartDate.Add(time.Duration(*r.Duration * float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+// CueTag is an ad-marker tag which is not defined by RFC 8216,
+// such as EXT-X-CUE-OUT, EXT-X-CUE-OUT-CONT, EXT-X-CUE-IN, EXT-X-SCTE35 and EXT-OATCLS-SCTE35.
+type CueTag struct {
+	// Name is the tag name without "#".
+	Name  string
+	Value string
+	// SeqID is media sequence number of the segment which follows the tag.
+	SeqID uint64
+}
+
+var cueTagNames = map[string]bool{
+	"#EXT-X-CUE-OUT":      true,
+	"#EXT-X-CUE-OUT-CONT": true,
+	"#EXT-X-CUE-IN":       true,
+	"#EXT-X-SCTE35":       true,
+	"#EXT-OATCLS-SCTE35":  true,
+}
+
+// decodeAdTags decodes EXT-X-DATERANGE tags and ad-marker tags which grafov/m3u8 doesn't support.
+func decodeAdTags(playlist *MediaPlaylist) {
+	scanMediaPlaylistTags(playlist.Raw, playlist.SeqNo, func(name, value string, seqID uint64) {
+		if name == "#EXT-X-SKIP" {
+			// RECENTLY-REMOVED-DATERANGES attribute is present only when EXT-X-DATERANGE tags are skipped.
+			// grafov/m3u8 drops empty quoted-string attributes.
+			if strings.Contains(value, "RECENTLY-REMOVED-DATERANGES=") {
+				playlist.dateRangesSkipped = true
+				if removed := m3u8.DecodeAttributeList(value)["RECENTLY-REMOVED-DATERANGES"]; removed != "" {
+					playlist.removedDateRanges = strings.Split(removed, "\t")
+				}
+			}
+			return
+		}
+		if cueTagNames[name] {
+			playlist.CueTags = append(playlist.CueTags, &CueTag{
+				Name:  strings.TrimPrefix(name, "#"),
+				Value: value,
+				SeqID: seqID,
+			})
+			return
+		}
+		if name != "#EXT-X-DATERANGE" {
+			return
+		}
+		attrs := m3u8.DecodeAttributeList(value)
+		dateRange := &DateRange{
+			ID:         attrs["ID"],
+			Class:      attrs["CLASS"],
+			SCTE35Cmd:  attrs["SCTE35-CMD"],
+			SCTE35Out:  attrs["SCTE35-OUT"],
+			SCTE35In:   attrs["SCTE35-IN"],
+			EndOnNext:  attrs["END-ON-NEXT"] == "YES",
+			Attributes: attrs,
+			SeqID:      seqID,
+		}
+		if tm, err := time.Parse(time.RFC3339Nano, attrs["START-DATE"]); err == nil {
+			dateRange.StartDate = tm
+		}
+		if tm, err := time.Parse(time.RFC3339Nano, attrs["END-DATE"]); err == nil {
+			dateRange.EndDate = &tm
+		}
+		if d, err := strconv.ParseFloat(attrs["DURATION"], 64); err == nil {
+			dateRange.Duration = &d
+		}
+		if d, err := strconv.ParseFloat(attrs["PLANNED-DURATION"], 64); err == nil {
+			dateRange.PlannedDuration = &d
+		}
+		playlist.DateRanges = append(playlist.DateRanges, dateRange)
+	})
+}
+
+// mergeDeltaUpdateAdTags complements ad-marker tags of skipped segments
+// and EXT-X-DATERANGE tags which are omitted by delta update from the previous playlist.
+func (p *MediaPlaylist) mergeDeltaUpdateAdTags(prev *MediaPlaylist) {
+	cueTags := make([]*CueTag, 0, len(p.CueTags))
+	for _, tag := range prev.CueTags {
+		if tag.SeqID >= p.SeqNo && tag.SeqID < p.SeqNo+p.SkippedSegments {
+			cueTags = append(cueTags, tag)
+		}
+	}
+	p.CueTags = append(cueTags, p.CueTags...)
+
+	if !p.dateRangesSkipped {
+		return
+	}
+	ids := make(map[string]bool, len(p.DateRanges)+len(p.removedDateRanges))
+	for _, dateRange := range p.DateRanges {
+		ids[dateRange.ID] = true
+	}
+	for _, id := range p.removedDateRanges {
+		ids[id] = true
+	}
+	dateRanges := make([]*DateRange, 0, len(p.DateRanges))
+	for _, dateRange := range prev.DateRanges {
+		if !ids[dateRange.ID] {
+			dateRanges = append(dateRanges, dateRange)
+		}
+	}
+	p.DateRanges = append(dateRanges, p.DateRanges...)
+}
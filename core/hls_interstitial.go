@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/abema/antares/internal/url"
+	"github.com/grafov/m3u8"
+)
+
+// InterstitialClass is CLASS attribute of EXT-X-DATERANGE tag which schedules an interstitial.
+const InterstitialClass = "com.apple.hls.interstitial"
+
+// Interstitial is the assets of EXT-X-DATERANGE tag whose CLASS is "com.apple.hls.interstitial".
+type Interstitial struct {
+	// AssetURI and AssetList are absolute URLs of X-ASSET-URI and X-ASSET-LIST attributes.
+	// They are empty when the attributes are omitted.
+	AssetURI  string
+	AssetList string
+	// Assets holds the asset of X-ASSET-URI or ASSETS of the asset list in order.
+	Assets []*InterstitialAsset
+	// Error is set when the asset list can't be downloaded or decoded.
+	Error error
+}
+
+// InterstitialAsset is an asset playlist of an interstitial.
+type InterstitialAsset struct {
+	URI string
+	// Duration is DURATION of the asset list entry.
+	// This property is nil when the asset is referred by X-ASSET-URI.
+	Duration *float64
+	// PlaylistDuration is the total duration of the segments in the asset playlist.
+	// The first variant is used when the asset is a multivariant playlist.
+	PlaylistDuration float64
+	// Closed is true when the asset playlist has EXT-X-ENDLIST tag.
+	Closed bool
+	// Error is set when the asset playlist can't be downloaded or decoded.
+	Error error
+}
+
+// hasError returns whether the asset list or any asset failed to be resolved.
+func (i *Interstitial) hasError() bool {
+	if i.Error != nil {
+		return true
+	}
+	for _, asset := range i.Assets {
+		if asset.Error != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type interstitialAssetList struct {
+	Assets []struct {
+		URI      string   `json:"URI"`
+		Duration *float64 `json:"DURATION"`
+	} `json:"ASSETS"`
+}
+
+// resolveInterstitials downloads assets of interstitial EXT-X-DATERANGE tags and sets them to the tags.
+// Resolved interstitials are cached by the asset URLs, and failed ones are retried on the next call.
+func (d *hlsPlaylistDownloader) resolveInterstitials(ctx context.Context, playlists *Playlists) {
+	cache := make(map[string]*Interstitial)
+	for _, media := range playlists.MediaPlaylists {
+		for _, dateRange := range media.DateRanges {
+			if dateRange.Class != InterstitialClass {
+				continue
+			}
+			assetURI, assetList := dateRange.Attributes["X-ASSET-URI"], dateRange.Attributes["X-ASSET-LIST"]
+			if assetURI == "" && assetList == "" {
+				continue
+			}
+			if assetURI != "" {
+				if u, err := url.ResolveReference(media.URL, assetURI); err == nil {
+					assetURI = u
+				}
+			}
+			if assetList != "" {
+				if u, err := url.ResolveReference(media.URL, assetList); err == nil {
+					assetList = u
+				}
+			}
+			key := assetURI + "\n" + assetList
+			interstitial, ok := cache[key]
+			if !ok {
+				interstitial = d.interstitials[key]
+				if interstitial == nil || interstitial.hasError() {
+					interstitial = d.fetchInterstitial(ctx, assetURI, assetList)
+				}
+				cache[key] = interstitial
+			}
+			dateRange.Interstitial = interstitial
+		}
+	}
+	d.interstitials = cache
+}
+
+func (d *hlsPlaylistDownloader) fetchInterstitial(ctx context.Context, assetURI, assetList string) *Interstitial {
+	interstitial := &Interstitial{
+		AssetURI:  assetURI,
+		AssetList: assetList,
+	}
+	if assetURI != "" {
+		// X-ASSET-LIST is ignored when both attributes are specified.
+		interstitial.Assets = []*InterstitialAsset{d.fetchInterstitialAsset(ctx, assetURI, nil)}
+		return interstitial
+	}
+	data, loc, err := d.getInterstitial(ctx, assetList)
+	if err != nil {
+		interstitial.Error = fmt.Errorf("failed to download asset list: %s: %w", assetList, err)
+		return interstitial
+	}
+	var list interstitialAssetList
+	if err := json.Unmarshal(data, &list); err != nil {
+		interstitial.Error = fmt.Errorf("failed to decode asset list: %s: %w", assetList, err)
+		return interstitial
+	}
+	if list.Assets == nil {
+		interstitial.Error = fmt.Errorf("asset list has no ASSETS: %s", assetList)
+		return interstitial
+	}
+	interstitial.Assets = make([]*InterstitialAsset, 0, len(list.Assets))
+	for _, entry := range list.Assets {
+		u, err := url.ResolveReference(loc, entry.URI)
+		if err != nil || entry.URI == "" {
+			interstitial.Assets = append(interstitial.Assets, &InterstitialAsset{
+				URI:      entry.URI,
+				Duration: entry.Duration,
+				Error:    fmt.Errorf("invalid asset URI: %q", entry.URI),
+			})
+			continue
+		}
+		interstitial.Assets = append(interstitial.Assets, d.fetchInterstitialAsset(ctx, u, entry.Duration))
+	}
+	return interstitial
+}
+
+func (d *hlsPlaylistDownloader) fetchInterstitialAsset(ctx context.Context, u string, duration *float64) *InterstitialAsset {
+	asset := &InterstitialAsset{
+		URI:      u,
+		Duration: duration,
+	}
+	data, loc, err := d.getInterstitial(ctx, u)
+	if err != nil {
+		asset.Error = fmt.Errorf("failed to download asset playlist: %s: %w", u, err)
+		return asset
+	}
+	dec, ptype, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+	if err != nil {
+		asset.Error = fmt.Errorf("failed to decode asset playlist: %s: %w", u, err)
+		return asset
+	}
+	if ptype == m3u8.MASTER {
+		master := dec.(*m3u8.MasterPlaylist)
+		if len(master.Variants) == 0 {
+			asset.Error = fmt.Errorf("asset playlist has no variant: %s", u)
+			return asset
+		}
+		variantURL, err := url.ResolveReference(loc, master.Variants[0].URI)
+		if err != nil {
+			asset.Error = fmt.Errorf("invalid URL format: %s: %w", master.Variants[0].URI, err)
+			return asset
+		}
+		data, _, err = d.getInterstitial(ctx, variantURL)
+		if err != nil {
+			asset.Error = fmt.Errorf("failed to download asset playlist: %s: %w", variantURL, err)
+			return asset
+		}
+		dec, ptype, err = m3u8.DecodeFrom(bytes.NewReader(data), true)
+		if err != nil {
+			asset.Error = fmt.Errorf("failed to decode asset playlist: %s: %w", variantURL, err)
+			return asset
+		}
+		if ptype != m3u8.MEDIA {
+			asset.Error = errors.New("unexpected multivariant playlist: " + variantURL)
+			return asset
+		}
+	}
+	media := dec.(*m3u8.MediaPlaylist)
+	removeNilSegments(media)
+	for _, segment := range media.Segments {
+		asset.PlaylistDuration += segment.Duration
+	}
+	asset.Closed = media.Closed
+	return asset
+}
+
+func (d *hlsPlaylistDownloader) getInterstitial(ctx context.Context, u string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.interstitialTimeout)
+	defer cancel()
+	return d.client.Get(ctx, u)
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// DurationEpsilon absorbs rounding errors of decimal floating-point attributes in seconds.
+const DurationEpsilon = 0.001
+
+// minBlockingReloadInterval is the minimum interval of blocking playlist reloads.
+const minBlockingReloadInterval = 100 * time.Millisecond
+
+// ServerControl represents EXT-X-SERVER-CONTROL tag.
+type ServerControl struct {
+	CanBlockReload    bool
+	CanSkipUntil      float64
+	CanSkipDateRanges bool
+	HoldBack          float64
+	PartHoldBack      float64
+}
+
+// PartialSegment represents EXT-X-PART tag.
+type PartialSegment struct {
+	// SeqID is media sequence number of the parent segment.
+	SeqID uint64
+	// Index is position in the parent segment.
+	Index       int
+	URI         string
+	Duration    float64
+	Independent bool
+	Gap         bool
+	// ByteRange is nil when the partial segment is the entire resource.
+	ByteRange *ByteRange
+}
+
+// PreloadHint represents EXT-X-PRELOAD-HINT tag.
+type PreloadHint struct {
+	Type           string
+	URI            string
+	ByteRangeStart int64
+	// ByteRangeLength is zero when the length is unknown.
+	ByteRangeLength int64
+}
+
+// BlockingRequest represents delivery directives of blocking playlist reload.
+type BlockingRequest struct {
+	MSN uint64
+	// Part is -1 when _HLS_part is not specified.
+	Part int
+}
+
+func (r *BlockingRequest) query() url.Values {
+	query := url.Values{"_HLS_msn": []string{strconv.FormatUint(r.MSN, 10)}}
+	if r.Part >= 0 {
+		query.Set("_HLS_part", strconv.Itoa(r.Part))
+	}
+	return query
+}
+
+var deliveryDirectives = []string{"_HLS_msn", "_HLS_part", "_HLS_skip"}
+
+func addDeliveryDirectives(u string, directives url.Values) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for key, values := range directives {
+		query[key] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+func removeDeliveryDirectives(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.RawQuery == "" {
+		return u
+	}
+	query := parsed.Query()
+	var found bool
+	for _, key := range deliveryDirectives {
+		if _, ok := query[key]; ok {
+			query.Del(key)
+			found = true
+		}
+	}
+	if !found {
+		return u
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// scanMediaPlaylistTags calls handle for each tag line of the media playlist.
+// seqID is media sequence number of the segment which follows the tag.
+// EXT-X-SKIP tag advances seqID by SKIPPED-SEGMENTS attribute.
+func scanMediaPlaylistTags(raw []byte, seqNo uint64, handle func(name, value string, seqID uint64)) {
+	seqID := seqNo
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			seqID++
+			continue
+		}
+		name, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+		handle(name, value, seqID)
+		if name == "#EXT-X-SKIP" {
+			skipped, _ := strconv.ParseUint(m3u8.DecodeAttributeList(value)["SKIPPED-SEGMENTS"], 10, 64)
+			seqID += skipped
+		}
+	}
+}
+
+// decodeLowLatencyTags decodes tags of Low-Latency HLS which grafov/m3u8 doesn't support.
+func decodeLowLatencyTags(playlist *MediaPlaylist) error {
+	var prevPart *PartialSegment
+	var skip bool
+	var err error
+	scanMediaPlaylistTags(playlist.Raw, playlist.SeqNo, func(name, value string, seqID uint64) {
+		switch name {
+		case "#EXT-X-SKIP":
+			if skip || seqID != playlist.SeqNo {
+				err = errors.New("EXT-X-SKIP must be placed before the first media segment")
+				return
+			}
+			skip = true
+			playlist.SkippedSegments, _ = strconv.ParseUint(m3u8.DecodeAttributeList(value)["SKIPPED-SEGMENTS"], 10, 64)
+		case "#EXT-X-SERVER-CONTROL":
+			attrs := m3u8.DecodeAttributeList(value)
+			playlist.ServerControl = &ServerControl{
+				CanBlockReload:    attrs["CAN-BLOCK-RELOAD"] == "YES",
+				CanSkipUntil:      parseFloatAttr(attrs["CAN-SKIP-UNTIL"]),
+				CanSkipDateRanges: attrs["CAN-SKIP-DATERANGES"] == "YES",
+				HoldBack:          parseFloatAttr(attrs["HOLD-BACK"]),
+				PartHoldBack:      parseFloatAttr(attrs["PART-HOLD-BACK"]),
+			}
+		case "#EXT-X-PART-INF":
+			attrs := m3u8.DecodeAttributeList(value)
+			playlist.PartTarget = parseFloatAttr(attrs["PART-TARGET"])
+		case "#EXT-X-PART":
+			attrs := m3u8.DecodeAttributeList(value)
+			part := &PartialSegment{
+				SeqID:       seqID,
+				URI:         attrs["URI"],
+				Duration:    parseFloatAttr(attrs["DURATION"]),
+				Independent: attrs["INDEPENDENT"] == "YES",
+				Gap:         attrs["GAP"] == "YES",
+			}
+			if prevPart != nil && prevPart.SeqID == seqID {
+				part.Index = prevPart.Index + 1
+			}
+			if byteRange, ok := attrs["BYTERANGE"]; ok {
+				part.ByteRange = parseByteRangeAttr(byteRange)
+				if part.ByteRange != nil && !strings.Contains(byteRange, "@") &&
+					prevPart != nil && prevPart.URI == part.URI && prevPart.ByteRange != nil {
+					part.ByteRange.Offset = prevPart.ByteRange.Offset + prevPart.ByteRange.Length
+				}
+			}
+			playlist.PartialSegments = append(playlist.PartialSegments, part)
+			prevPart = part
+		case "#EXT-X-PRELOAD-HINT":
+			attrs := m3u8.DecodeAttributeList(value)
+			start, _ := strconv.ParseInt(attrs["BYTERANGE-START"], 10, 64)
+			length, _ := strconv.ParseInt(attrs["BYTERANGE-LENGTH"], 10, 64)
+			playlist.PreloadHints = append(playlist.PreloadHints, &PreloadHint{
+				Type:            attrs["TYPE"],
+				URI:             attrs["URI"],
+				ByteRangeStart:  start,
+				ByteRangeLength: length,
+			})
+		}
+	})
+	return err
+}
+
+func parseFloatAttr(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+// parseByteRangeAttr parses "<n>[@<o>]" format.
+func parseByteRangeAttr(value string) *ByteRange {
+	s := strings.SplitN(value, "@", 2)
+	length, err := strconv.ParseInt(s[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	var offset int64
+	if len(s) == 2 {
+		if offset, err = strconv.ParseInt(s[1], 10, 64); err != nil {
+			return nil
+		}
+	}
+	return &ByteRange{Offset: offset, Length: length}
+}
+
+// LastPartialSegment returns the latest partial segment.
+// It returns nil when the playlist has no partial segments.
+func (p *MediaPlaylist) LastPartialSegment() *PartialSegment {
+	if len(p.PartialSegments) == 0 {
+		return nil
+	}
+	return p.PartialSegments[len(p.PartialSegments)-1]
+}
+
+// PartialSegmentURLs returns absolute URLs of partial segments.
+func (p *MediaPlaylist) PartialSegmentURLs() ([]string, error) {
+	base, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(p.PartialSegments))
+	for _, part := range p.PartialSegments {
+		u, err := base.Parse(part.URI)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
+
+// SatisfiesBlockingRequest returns whether the playlist contains the segment or the partial segment requested by req.
+func (p *MediaPlaylist) SatisfiesBlockingRequest(req *BlockingRequest) bool {
+	if len(p.Segments) != 0 && p.Segments[len(p.Segments)-1].SeqId >= req.MSN {
+		return true
+	}
+	if req.Part < 0 {
+		return false
+	}
+	last := p.LastPartialSegment()
+	return last != nil && (last.SeqID > req.MSN || (last.SeqID == req.MSN && last.Index >= req.Part))
+}
+
+// nextBlockingRequest returns delivery directives to request the next update of the playlist.
+// It returns nil when the server doesn't support blocking playlist reload.
+func (p *MediaPlaylist) nextBlockingRequest() *BlockingRequest {
+	if p.ServerControl == nil || !p.ServerControl.CanBlockReload {
+		return nil
+	}
+	next := p.SeqNo
+	if len(p.Segments) != 0 {
+		next = p.Segments[len(p.Segments)-1].SeqId + 1
+	}
+	if len(p.PartialSegments) == 0 {
+		return &BlockingRequest{MSN: next, Part: -1}
+	}
+	last := p.LastPartialSegment()
+	if last.SeqID >= next {
+		return &BlockingRequest{MSN: last.SeqID, Part: last.Index + 1}
+	}
+	return &BlockingRequest{MSN: next, Part: 0}
+}
+
+// canRequestDeltaUpdate returns whether the client can request a delta update based on this playlist.
+// The playlist must be no older than one-half of the skip boundary.
+func (p *MediaPlaylist) canRequestDeltaUpdate(now time.Time) bool {
+	if p.ServerControl == nil || p.ServerControl.CanSkipUntil == 0 || p.Closed {
+		return false
+	}
+	return now.Sub(p.Time).Seconds() < p.ServerControl.CanSkipUntil/2
+}
+
+// mergeDeltaUpdate complements skipped segments from the previous playlist.
+func (p *MediaPlaylist) mergeDeltaUpdate(prev *MediaPlaylist) error {
+	if p.SkippedSegments == 0 {
+		return nil
+	}
+	if prev == nil {
+		return errors.New("unexpected EXT-X-SKIP without previous playlist")
+	}
+	var listed float64
+	for _, seg := range p.Segments {
+		seg.SeqId += p.SkippedSegments
+		listed += seg.Duration
+	}
+	skipped := make([]*m3u8.MediaSegment, 0, p.SkippedSegments)
+	for _, seg := range prev.Segments {
+		if seg.SeqId >= p.SeqNo && seg.SeqId < p.SeqNo+p.SkippedSegments {
+			skipped = append(skipped, seg)
+		}
+	}
+	if uint64(len(skipped)) != p.SkippedSegments {
+		return fmt.Errorf("skipped segments are not in the previous playlist: media-sequence=%d skipped=%d found=%d",
+			p.SeqNo, p.SkippedSegments, len(skipped))
+	}
+	if p.ServerControl != nil && listed+DurationEpsilon < p.ServerControl.CanSkipUntil {
+		return fmt.Errorf("skipped segments are within skip boundary: listed=%f can-skip-until=%f",
+			listed, p.ServerControl.CanSkipUntil)
+	}
+	p.Segments = append(skipped, p.Segments...)
+	p.mergeDeltaUpdateAdTags(prev)
+	for m, key := range prev.mapKeys {
+		if _, ok := p.mapKeys[m]; !ok {
+			if p.mapKeys == nil {
+				p.mapKeys = make(map[m3u8.Map]*m3u8.Key)
+			}
+			p.mapKeys[m] = key
+		}
+	}
+	return nil
+}
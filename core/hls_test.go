@@ -2,13 +2,17 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +55,62 @@ func TestSegmentURLs(t *testing.T) {
 	require.Equal(t, "audio_1", segments[2].Alternative.Name)
 }
 
+func TestSegmentByteRanges(t *testing.T) {
+	p := &Playlists{
+		MediaPlaylists: map[string]*MediaPlaylist{
+			"media_0.m3u8": {
+				URL: "https://localhost/foo/media_0.m3u8",
+				MediaPlaylist: &m3u8.MediaPlaylist{Segments: []*m3u8.MediaSegment{
+					{URI: "main.mp4", Limit: 1000, Offset: 0},
+					{URI: "main.mp4", Limit: 1200, Offset: 1000},
+					{URI: "other.ts"},
+				}},
+			},
+		},
+	}
+	segments, err := p.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	require.Equal(t, "https://localhost/foo/main.mp4", segments[0].URL)
+	require.Equal(t, &ByteRange{Offset: 0, Length: 1000}, segments[0].ByteRange)
+	require.Equal(t, "https://localhost/foo/main.mp4", segments[1].URL)
+	require.Equal(t, &ByteRange{Offset: 1000, Length: 1200}, segments[1].ByteRange)
+	require.Equal(t, "https://localhost/foo/other.ts", segments[2].URL)
+	require.Nil(t, segments[2].ByteRange)
+}
+
+func TestSegmentInitialization(t *testing.T) {
+	init0 := &m3u8.Map{URI: "init_0.mp4"}
+	init1 := &m3u8.Map{URI: "main.mp4", Limit: 720, Offset: 0}
+	p := &Playlists{
+		MediaPlaylists: map[string]*MediaPlaylist{
+			"media_0.m3u8": {
+				URL: "https://localhost/foo/media_0.m3u8",
+				MediaPlaylist: &m3u8.MediaPlaylist{
+					Map: init0,
+					Segments: []*m3u8.MediaSegment{
+						{URI: "0.mp4", Map: init0},
+						{URI: "1.mp4"},
+						{URI: "main.mp4", Limit: 1000, Offset: 720, Map: init1},
+					},
+				},
+			},
+		},
+	}
+	segments, err := p.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 5)
+	assert.Equal(t, "https://localhost/foo/init_0.mp4", segments[0].URL)
+	assert.Nil(t, segments[0].ByteRange)
+	assert.True(t, segments[0].Initialization)
+	assert.Equal(t, "https://localhost/foo/main.mp4", segments[1].URL)
+	assert.Equal(t, &ByteRange{Offset: 0, Length: 720}, segments[1].ByteRange)
+	assert.True(t, segments[1].Initialization)
+	for _, seg := range segments[2:] {
+		assert.False(t, seg.Initialization)
+	}
+}
+
 func TestIsVOD(t *testing.T) {
 	t.Run("all_live", func(t *testing.T) {
 		p := &Playlists{
@@ -140,8 +200,22 @@ func TestHLSPlaylistDownloader(t *testing.T) {
 		`media_2_100.ts` + "\n" +
 		`#EXTINF:7.941,` + "\n" +
 		`media_2_101.ts` + "\n")
+	byterange := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:4` + "\n" +
+		`#EXT-X-TARGETDURATION:8` + "\n" +
+		`#EXTINF:7.975,` + "\n" +
+		`#EXT-X-BYTERANGE:1000@500` + "\n" +
+		`main.mp4` + "\n" +
+		`#EXTINF:7.941,` + "\n" +
+		`#EXT-X-BYTERANGE:1200` + "\n" +
+		`main.mp4` + "\n" +
+		`#EXTINF:7.941,` + "\n" +
+		`#EXT-X-BYTERANGE:800` + "\n" +
+		`main.mp4` + "\n")
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
+		case "/byterange.m3u8":
+			w.Write(byterange)
 		case "/master.m3u8":
 			w.Write(master)
 		case "/media_0.m3u8":
@@ -212,4 +286,395 @@ func TestHLSPlaylistDownloader(t *testing.T) {
 		require.Nil(t, mp0.VariantParams)
 		require.Nil(t, mp0.Alternative)
 	})
+
+	t.Run("byte range", func(t *testing.T) {
+		d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		playlists, err := d.Download(context.Background(), server.URL+"/byterange.m3u8")
+		require.NoError(t, err)
+		segments := playlists.MediaPlaylists["_"].Segments
+		require.Len(t, segments, 3)
+		assert.Equal(t, int64(1000), segments[0].Limit)
+		assert.Equal(t, int64(500), segments[0].Offset)
+		assert.Equal(t, int64(1200), segments[1].Limit)
+		assert.Equal(t, int64(1500), segments[1].Offset)
+		assert.Equal(t, int64(800), segments[2].Limit)
+		assert.Equal(t, int64(2700), segments[2].Offset)
+	})
+}
+
+func TestHLSPlaylistDownloader_BlockingReload(t *testing.T) {
+	media := func(msn int, parts int) []byte {
+		data := `#EXTM3U` + "\n" +
+			`#EXT-X-VERSION:6` + "\n" +
+			`#EXT-X-TARGETDURATION:4` + "\n" +
+			`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.0` + "\n" +
+			`#EXT-X-PART-INF:PART-TARGET=1.0` + "\n" +
+			fmt.Sprintf(`#EXT-X-MEDIA-SEQUENCE:%d`, msn) + "\n" +
+			`#EXT-X-PART:DURATION=1.0,URI="part_a.mp4",INDEPENDENT=YES` + "\n" +
+			`#EXT-X-PART:DURATION=1.0,URI="part_b.mp4"` + "\n" +
+			`#EXTINF:2.0,` + "\n" +
+			fmt.Sprintf(`segment_%d.mp4`, msn) + "\n"
+		for i := 0; i < parts; i++ {
+			data += fmt.Sprintf(`#EXT-X-PART:DURATION=1.0,URI="segment_%d.mp4",BYTERANGE=100`, msn+1) + "\n"
+		}
+		data += `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="next.mp4",BYTERANGE-START=200` + "\n"
+		return []byte(data)
+	}
+	queries := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		switch r.URL.RawQuery {
+		case "":
+			w.Write(media(100, 2))
+		case "_HLS_msn=101&_HLS_part=2":
+			w.Write(media(100, 3))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	playlists, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp := playlists.MediaPlaylists["_"]
+	require.True(t, mp.ServerControl.CanBlockReload)
+	assert.Equal(t, 3.0, mp.ServerControl.PartHoldBack)
+	assert.Equal(t, 1.0, mp.PartTarget)
+	require.Len(t, mp.PartialSegments, 4)
+	assert.Equal(t, uint64(100), mp.PartialSegments[0].SeqID)
+	assert.Equal(t, 0, mp.PartialSegments[0].Index)
+	assert.True(t, mp.PartialSegments[0].Independent)
+	assert.Equal(t, uint64(100), mp.PartialSegments[1].SeqID)
+	assert.Equal(t, 1, mp.PartialSegments[1].Index)
+	assert.Equal(t, uint64(101), mp.PartialSegments[2].SeqID)
+	assert.Equal(t, 0, mp.PartialSegments[2].Index)
+	assert.Equal(t, &ByteRange{Offset: 0, Length: 100}, mp.PartialSegments[2].ByteRange)
+	assert.Equal(t, uint64(101), mp.PartialSegments[3].SeqID)
+	assert.Equal(t, 1, mp.PartialSegments[3].Index)
+	assert.Equal(t, &ByteRange{Offset: 100, Length: 100}, mp.PartialSegments[3].ByteRange)
+	require.Len(t, mp.PreloadHints, 1)
+	assert.Equal(t, "PART", mp.PreloadHints[0].Type)
+	assert.Equal(t, int64(200), mp.PreloadHints[0].ByteRangeStart)
+	assert.Nil(t, mp.BlockingRequest)
+	assert.True(t, playlists.CanBlockReload())
+
+	playlists, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp = playlists.MediaPlaylists["_"]
+	assert.Equal(t, server.URL+"/media.m3u8", mp.URL)
+	assert.Equal(t, &BlockingRequest{MSN: 101, Part: 2}, mp.BlockingRequest)
+	assert.True(t, mp.SatisfiesBlockingRequest(mp.BlockingRequest))
+	assert.Equal(t, []string{"", "_HLS_msn=101&_HLS_part=2"}, queries)
+
+	segments, err := playlists.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 6)
+	assert.False(t, segments[0].Partial)
+	assert.True(t, segments[1].Partial)
+}
+
+func TestHLSPlaylistDownloader_DeltaUpdate(t *testing.T) {
+	header := `#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:9` + "\n" +
+		`#EXT-X-TARGETDURATION:2` + "\n" +
+		`#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=12.0` + "\n"
+	full := func(msn int) []byte {
+		data := header + fmt.Sprintf(`#EXT-X-MEDIA-SEQUENCE:%d`, msn) + "\n"
+		for i := 0; i < 10; i++ {
+			data += "#EXTINF:2.0,\n" + fmt.Sprintf("segment_%d.ts\n", msn+i)
+		}
+		return []byte(data)
+	}
+	delta := func(msn, skipped, listed int) []byte {
+		data := header + fmt.Sprintf(`#EXT-X-MEDIA-SEQUENCE:%d`, msn) + "\n" +
+			fmt.Sprintf(`#EXT-X-SKIP:SKIPPED-SEGMENTS=%d`, skipped) + "\n"
+		for i := 0; i < listed; i++ {
+			data += "#EXTINF:2.0,\n" + fmt.Sprintf("segment_%d.ts\n", msn+skipped+i)
+		}
+		return []byte(data)
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		queries := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.URL.RawQuery)
+			switch r.URL.RawQuery {
+			case "":
+				w.Write(full(100))
+			case "_HLS_skip=YES":
+				w.Write(delta(101, 3, 8))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer server.Close()
+
+		d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		playlists, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.NoError(t, err)
+		assert.Equal(t, 12.0, playlists.MediaPlaylists["_"].ServerControl.CanSkipUntil)
+
+		playlists, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.NoError(t, err)
+		mp := playlists.MediaPlaylists["_"]
+		assert.Equal(t, []string{"", "_HLS_skip=YES"}, queries)
+		assert.Equal(t, server.URL+"/media.m3u8", mp.URL)
+		assert.Equal(t, uint64(3), mp.SkippedSegments)
+		assert.Equal(t, delta(101, 3, 8), mp.Raw)
+		require.Len(t, mp.Segments, 11)
+		for i, seg := range mp.Segments {
+			assert.Equal(t, uint64(101+i), seg.SeqId)
+			assert.Equal(t, fmt.Sprintf("segment_%d.ts", 101+i), seg.URI)
+		}
+	})
+
+	t.Run("inconsistent", func(t *testing.T) {
+		queries := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.URL.RawQuery)
+			switch r.URL.RawQuery {
+			case "":
+				w.Write(full(100))
+			case "_HLS_skip=YES":
+				// segment_110 is not in the previous playlist
+				w.Write(delta(105, 6, 6))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer server.Close()
+
+		d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		_, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.NoError(t, err)
+		_, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.Error(t, err)
+		assert.True(t, errors.As(err, &permanentError{}))
+
+		// falls back to the full playlist
+		playlists, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.NoError(t, err)
+		assert.Len(t, playlists.MediaPlaylists["_"].Segments, 10)
+		assert.Equal(t, []string{"", "_HLS_skip=YES", ""}, queries)
+	})
+
+	t.Run("skip_boundary", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.RawQuery {
+			case "":
+				w.Write(full(100))
+			default:
+				w.Write(delta(100, 5, 5))
+			}
+		}))
+		defer server.Close()
+
+		d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+		_, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.NoError(t, err)
+		_, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "skip boundary")
+	})
+}
+
+func TestHLSPlaylistDownloader_AdTags(t *testing.T) {
+	header := `#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:9` + "\n" +
+		`#EXT-X-TARGETDURATION:2` + "\n" +
+		`#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=12.0,CAN-SKIP-DATERANGES=YES` + "\n"
+	full := header + `#EXT-X-MEDIA-SEQUENCE:100` + "\n" +
+		`#EXT-X-DATERANGE:ID="ad1",CLASS="com.example.ad",START-DATE="2021-01-01T00:00:00Z",DURATION=4.0,SCTE35-OUT=0xFC30` + "\n" +
+		`#EXT-X-DATERANGE:ID="ad2",START-DATE="2021-01-01T00:00:10Z",PLANNED-DURATION=6.5,END-ON-NEXT=YES,CLASS="com.example.ad",X-COM-EXAMPLE="foo"` + "\n"
+	for i := 0; i < 10; i++ {
+		switch i {
+		case 1:
+			full += "#EXT-X-CUE-OUT:4.0\n"
+		case 2:
+			full += "#EXT-X-CUE-OUT-CONT:ElapsedTime=2.0,Duration=4.0\n"
+		case 3:
+			full += "#EXT-X-CUE-IN\n"
+		}
+		full += "#EXTINF:2.0,\n" + fmt.Sprintf("segment_%d.ts\n", 100+i)
+	}
+	delta := header + `#EXT-X-MEDIA-SEQUENCE:101` + "\n" +
+		`#EXT-X-SKIP:SKIPPED-SEGMENTS=3,RECENTLY-REMOVED-DATERANGES="ad1"` + "\n" +
+		`#EXT-X-DATERANGE:ID="ad3",START-DATE="2021-01-01T00:00:20Z"` + "\n"
+	for i := 0; i < 8; i++ {
+		delta += "#EXTINF:2.0,\n" + fmt.Sprintf("segment_%d.ts\n", 104+i)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "" {
+			w.Write([]byte(full))
+		} else {
+			w.Write([]byte(delta))
+		}
+	}))
+	defer server.Close()
+
+	d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	playlists, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp := playlists.MediaPlaylists["_"]
+	require.Len(t, mp.DateRanges, 2)
+	assert.Equal(t, "ad1", mp.DateRanges[0].ID)
+	assert.Equal(t, "com.example.ad", mp.DateRanges[0].Class)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), mp.DateRanges[0].StartDate)
+	require.NotNil(t, mp.DateRanges[0].Duration)
+	assert.Equal(t, 4.0, *mp.DateRanges[0].Duration)
+	end, ok := mp.DateRanges[0].End()
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 4, 0, time.UTC), end)
+	assert.Equal(t, "0xFC30", mp.DateRanges[0].SCTE35Out)
+	assert.Equal(t, uint64(100), mp.DateRanges[0].SeqID)
+	require.NotNil(t, mp.DateRanges[1].PlannedDuration)
+	assert.Equal(t, 6.5, *mp.DateRanges[1].PlannedDuration)
+	assert.True(t, mp.DateRanges[1].EndOnNext)
+	assert.Equal(t, "foo", mp.DateRanges[1].Attributes["X-COM-EXAMPLE"])
+	assert.Equal(t, []*CueTag{
+		{Name: "EXT-X-CUE-OUT", Value: "4.0", SeqID: 101},
+		{Name: "EXT-X-CUE-OUT-CONT", Value: "ElapsedTime=2.0,Duration=4.0", SeqID: 102},
+		{Name: "EXT-X-CUE-IN", SeqID: 103},
+	}, mp.CueTags)
+
+	// tags of skipped segments and skipped EXT-X-DATERANGE tags are complemented.
+	playlists, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp = playlists.MediaPlaylists["_"]
+	require.Len(t, mp.Segments, 11)
+	require.Len(t, mp.DateRanges, 2)
+	assert.Equal(t, "ad2", mp.DateRanges[0].ID)
+	assert.Equal(t, "ad3", mp.DateRanges[1].ID)
+	assert.Equal(t, uint64(104), mp.DateRanges[1].SeqID)
+	require.Len(t, mp.CueTags, 3)
+	assert.Equal(t, "EXT-X-CUE-OUT", mp.CueTags[0].Name)
+}
+
+func TestHLSPlaylistDownloader_Interstitials(t *testing.T) {
+	media := `#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:7` + "\n" +
+		`#EXT-X-TARGETDURATION:2` + "\n" +
+		`#EXT-X-PROGRAM-DATE-TIME:2021-01-01T00:00:00Z` + "\n" +
+		`#EXT-X-DATERANGE:ID="ad1",CLASS="com.apple.hls.interstitial",START-DATE="2021-01-01T00:00:02Z",X-ASSET-URI="ad/asset.m3u8"` + "\n" +
+		`#EXT-X-DATERANGE:ID="ad2",CLASS="com.apple.hls.interstitial",START-DATE="2021-01-01T00:00:04Z",X-ASSET-LIST="list.json"` + "\n" +
+		`#EXTINF:2.0,` + "\n" +
+		`segment_0.ts` + "\n"
+	asset := `#EXTM3U` + "\n" +
+		`#EXT-X-TARGETDURATION:6` + "\n" +
+		`#EXTINF:6.0,` + "\n" +
+		`a.ts` + "\n" +
+		`#EXTINF:4.0,` + "\n" +
+		`b.ts` + "\n" +
+		`#EXT-X-ENDLIST` + "\n"
+	var requests int
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/media.m3u8":
+			w.Write([]byte(media))
+		case "/ad/asset.m3u8":
+			w.Write([]byte(asset))
+		case "/list.json":
+			w.Write([]byte(`{"ASSETS":[{"URI":"ad/asset.m3u8","DURATION":10.0},{"URI":"missing.m3u8","DURATION":5.0}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	playlists, err := d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp := playlists.MediaPlaylists["_"]
+	require.Len(t, mp.DateRanges, 2)
+
+	ad1 := mp.DateRanges[0].Interstitial
+	require.NotNil(t, ad1)
+	assert.Equal(t, server.URL+"/ad/asset.m3u8", ad1.AssetURI)
+	assert.NoError(t, ad1.Error)
+	require.Len(t, ad1.Assets, 1)
+	assert.Nil(t, ad1.Assets[0].Duration)
+	assert.Equal(t, 10.0, ad1.Assets[0].PlaylistDuration)
+	assert.True(t, ad1.Assets[0].Closed)
+	assert.NoError(t, ad1.Assets[0].Error)
+
+	ad2 := mp.DateRanges[1].Interstitial
+	require.NotNil(t, ad2)
+	assert.Equal(t, server.URL+"/list.json", ad2.AssetList)
+	assert.NoError(t, ad2.Error)
+	require.Len(t, ad2.Assets, 2)
+	assert.Equal(t, server.URL+"/ad/asset.m3u8", ad2.Assets[0].URI)
+	require.NotNil(t, ad2.Assets[0].Duration)
+	assert.Equal(t, 10.0, *ad2.Assets[0].Duration)
+	assert.Equal(t, 10.0, ad2.Assets[0].PlaylistDuration)
+	assert.NoError(t, ad2.Assets[0].Error)
+	assert.Error(t, ad2.Assets[1].Error)
+	assert.Equal(t, 5, requests)
+
+	// resolved interstitials are cached, and failed ones are retried.
+	playlists, err = d.Download(context.Background(), server.URL+"/media.m3u8")
+	require.NoError(t, err)
+	mp = playlists.MediaPlaylists["_"]
+	assert.Same(t, ad1, mp.DateRanges[0].Interstitial)
+	assert.NotSame(t, ad2, mp.DateRanges[1].Interstitial)
+	assert.Equal(t, 9, requests)
+}
+
+func TestHLSPlaylistDownloader_IFrame(t *testing.T) {
+	master := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-STREAM-INF:BANDWIDTH=1280000` + "\n" +
+		`media.m3u8` + "\n" +
+		`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=128000,URI="iframe.m3u8"` + "\n")
+	media := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:4` + "\n" +
+		`#EXT-X-TARGETDURATION:8` + "\n" +
+		`#EXTINF:8.0,` + "\n" +
+		`#EXT-X-BYTERANGE:10000@0` + "\n" +
+		`main.ts` + "\n")
+	iframe := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:4` + "\n" +
+		`#EXT-X-TARGETDURATION:8` + "\n" +
+		`#EXT-X-I-FRAMES-ONLY` + "\n" +
+		`#EXTINF:4.0,` + "\n" +
+		`#EXT-X-BYTERANGE:376@0` + "\n" +
+		`main.ts` + "\n" +
+		`#EXTINF:4.0,` + "\n" +
+		`#EXT-X-BYTERANGE:564@5000` + "\n" +
+		`main.ts` + "\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Write(master)
+		case "/media.m3u8":
+			w.Write(media)
+		case "/iframe.m3u8":
+			w.Write(iframe)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := newHLSPlaylistDownloader(newClient(http.DefaultClient, nil, nil), time.Second)
+	playlists, err := d.Download(context.Background(), server.URL+"/master.m3u8")
+	require.NoError(t, err)
+	require.Len(t, playlists.MediaPlaylists, 2)
+	assert.False(t, playlists.MediaPlaylists["media.m3u8"].IFrameOnly)
+	assert.True(t, playlists.MediaPlaylists["iframe.m3u8"].IFrameOnly)
+
+	segments, err := playlists.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].ByteRange.Offset < segments[j].ByteRange.Offset ||
+			(segments[i].ByteRange.Offset == segments[j].ByteRange.Offset && segments[i].IFrameOnly)
+	})
+	assert.True(t, segments[0].IFrameOnly)
+	assert.Equal(t, &ByteRange{Offset: 0, Length: 376}, segments[0].ByteRange)
+	assert.False(t, segments[1].IFrameOnly)
+	assert.True(t, segments[2].IFrameOnly)
+	assert.Equal(t, &ByteRange{Offset: 5000, Length: 564}, segments[2].ByteRange)
 }
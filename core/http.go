@@ -28,8 +28,27 @@ func (err permanentError) Unwrap() error {
 	return err.parent
 }
 
+// ByteRange represents a sub-range of a resource.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Last returns the position of the last byte of the range.
+func (r *ByteRange) Last() int64 {
+	return r.Offset + r.Length - 1
+}
+
+// String returns a value of Range request header.
+func (r *ByteRange) String() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Last())
+}
+
 type client interface {
 	Get(ctx context.Context, url string) ([]byte, string, error)
+	GetRange(ctx context.Context, url string, byteRange *ByteRange) ([]byte, string, error)
+	// Head sends HEAD request and returns the response header.
+	Head(ctx context.Context, url string) (http.Header, error)
 }
 
 type simpleClient struct {
@@ -47,12 +66,23 @@ func newClient(bareClient *http.Client, header http.Header, handler func(file *F
 }
 
 func (c *simpleClient) Get(ctx context.Context, url string) ([]byte, string, error) {
+	return c.GetRange(ctx, url, nil)
+}
+
+func (c *simpleClient) GetRange(ctx context.Context, url string, byteRange *ByteRange) ([]byte, string, error) {
 	via := url
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, "", newPermanentError(err)
 	}
 	req.Header = c.header
+	if byteRange != nil {
+		req.Header = c.header.Clone()
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Header.Set("Range", byteRange.String())
+	}
 
 	bare := *c.bare
 	bare.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -97,6 +127,12 @@ func (c *simpleClient) Get(ctx context.Context, url string) ([]byte, string, err
 			Body: data,
 		})
 	}
+	if byteRange != nil {
+		if err := validatePartialContent(resp, data, byteRange); err != nil {
+			return nil, "", err
+		}
+		return data, url, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
@@ -107,6 +143,51 @@ func (c *simpleClient) Get(ctx context.Context, url string) ([]byte, string, err
 	return data, url, nil
 }
 
+func (c *simpleClient) Head(ctx context.Context, url string) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return nil, newPermanentError(err)
+	}
+	req.Header = c.header
+	resp, err := c.bare.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			err = newPermanentError(err)
+		}
+		return nil, err
+	}
+	return resp.Header, nil
+}
+
+func validatePartialContent(resp *http.Response, data []byte, byteRange *ByteRange) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusOK || (resp.StatusCode >= 400 && resp.StatusCode < 500) {
+			err = newPermanentError(err)
+		}
+		return err
+	}
+	var first, last int64
+	contentRange := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &first, &last); err != nil {
+		return newPermanentError(fmt.Errorf("invalid Content-Range: %s", contentRange))
+	}
+	if first != byteRange.Offset || last != byteRange.Last() {
+		return newPermanentError(fmt.Errorf("unexpected Content-Range: expected=%d-%d actual=%s",
+			byteRange.Offset, byteRange.Last(), contentRange))
+	}
+	if int64(len(data)) != byteRange.Length {
+		return newPermanentError(fmt.Errorf("unexpected body length: expected=%d actual=%d",
+			byteRange.Length, len(data)))
+	}
+	return nil
+}
+
 type redirectKeeper struct {
 	client
 	redirectMap map[string]string
@@ -1,11 +1,13 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,6 +33,8 @@ func TestClient(t *testing.T) {
 		case "/not_found":
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte("not found"))
+		case "/range":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello antares")))
 		}
 	}))
 	header := http.Header{
@@ -76,6 +80,33 @@ func TestClient(t *testing.T) {
 		require.Error(t, err)
 		assert.True(t, handle)
 	})
+
+	t.Run("206_partial_content", func(t *testing.T) {
+		var handle bool
+		client := newClient(&http.Client{}, header, func(file *File) {
+			require.Equal(t, 206, file.StatusCode)
+			require.Equal(t, "bytes=6-12", file.RequestHeader.Get("Range"))
+			handle = true
+		})
+		data, _, err := client.GetRange(context.Background(), server.URL+"/range", &ByteRange{Offset: 6, Length: 7})
+		require.NoError(t, err)
+		assert.Equal(t, "antares", string(data))
+		assert.True(t, handle)
+		assert.Empty(t, header.Get("Range"))
+	})
+
+	t.Run("range_not_supported", func(t *testing.T) {
+		client := newClient(&http.Client{}, header, nil)
+		_, _, err := client.GetRange(context.Background(), server.URL+"/hello", &ByteRange{Offset: 6, Length: 7})
+		require.Error(t, err)
+		assert.True(t, errors.As(err, &permanentError{}))
+	})
+
+	t.Run("unsatisfiable_range", func(t *testing.T) {
+		client := newClient(&http.Client{}, header, nil)
+		_, _, err := client.GetRange(context.Background(), server.URL+"/range", &ByteRange{Offset: 6, Length: 100})
+		require.Error(t, err)
+	})
 }
 
 func TestRedirectKeeper(t *testing.T) {
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/abema/antares/internal/mp4"
+	"github.com/abema/antares/internal/ts"
+	"github.com/grafov/m3u8"
+)
+
+// KeyRequest is a request for the decryption key specified by EXT-X-KEY tag.
+type KeyRequest struct {
+	// URL is the absolute URL of URI attribute.
+	URL       string
+	Method    string
+	KeyFormat string
+	client    client
+}
+
+// Download downloads the key from URL via HTTP client of Monitor.
+func (r *KeyRequest) Download(ctx context.Context) ([]byte, error) {
+	data, _, err := r.client.Get(ctx, r.URL)
+	return data, err
+}
+
+// KeyProvider provides decryption keys of HLS segments.
+// This interface must be thread-safe.
+type KeyProvider interface {
+	Key(ctx context.Context, req *KeyRequest) ([]byte, error)
+}
+
+type httpKeyProvider struct{}
+
+// HTTPKeyProvider returns KeyProvider which downloads keys from URI attribute of EXT-X-KEY tag.
+func HTTPKeyProvider() KeyProvider {
+	return &httpKeyProvider{}
+}
+
+func (p *httpKeyProvider) Key(ctx context.Context, req *KeyRequest) ([]byte, error) {
+	if req.KeyFormat != "" && req.KeyFormat != "identity" {
+		return nil, fmt.Errorf("unsupported KEYFORMAT: %s", req.KeyFormat)
+	}
+	return req.Download(ctx)
+}
+
+type staticKeyProvider struct {
+	keys map[string][]byte
+}
+
+// StaticKeyProvider returns KeyProvider which returns keys from the table.
+// The table is keyed by absolute URL of the key.
+func StaticKeyProvider(keys map[string][]byte) KeyProvider {
+	return &staticKeyProvider{keys: keys}
+}
+
+func (p *staticKeyProvider) Key(_ context.Context, req *KeyRequest) ([]byte, error) {
+	key, ok := p.keys[req.URL]
+	if !ok {
+		return nil, errors.New("key is not found")
+	}
+	return key, nil
+}
+
+// Encryption is decryption parameters of the segment specified by EXT-X-KEY tag.
+type Encryption struct {
+	// Method is AES-128 or SAMPLE-AES.
+	Method string
+	// KeyURL is the absolute URL of URI attribute.
+	KeyURL    string
+	KeyFormat string
+	// IV is derived from the media sequence number when IV attribute is omitted.
+	IV []byte
+	// Rotated is true when the key differs from the one of the previous segment.
+	Rotated bool
+}
+
+func newEncryption(key *m3u8.Key, keyURL string, seqID uint64) (*Encryption, error) {
+	iv := make([]byte, aes.BlockSize)
+	if key.IV != "" {
+		s := strings.TrimPrefix(strings.TrimPrefix(key.IV, "0x"), "0X")
+		decoded, err := hex.DecodeString(s)
+		if err != nil || len(decoded) != aes.BlockSize {
+			return nil, fmt.Errorf("invalid IV: %s", key.IV)
+		}
+		iv = decoded
+	} else {
+		binary.BigEndian.PutUint64(iv[8:], seqID)
+	}
+	return &Encryption{
+		Method:    key.Method,
+		KeyURL:    keyURL,
+		KeyFormat: key.Keyformat,
+		IV:        iv,
+	}, nil
+}
+
+// decodeMapKeys decodes EXT-X-KEY tags which apply to EXT-X-MAP tags,
+// because grafov/m3u8 doesn't keep the order of EXT-X-KEY and EXT-X-MAP tags.
+// Media initialization sections are encrypted only by AES-128, and SAMPLE-AES leaves them clear.
+func decodeMapKeys(playlist *MediaPlaylist) {
+	var key *m3u8.Key
+	scanMediaPlaylistTags(playlist.Raw, playlist.SeqNo, func(name, value string, _ uint64) {
+		switch name {
+		case "#EXT-X-KEY":
+			attrs := m3u8.DecodeAttributeList(value)
+			key = &m3u8.Key{
+				Method:            attrs["METHOD"],
+				URI:               attrs["URI"],
+				IV:                attrs["IV"],
+				Keyformat:         attrs["KEYFORMAT"],
+				Keyformatversions: attrs["KEYFORMATVERSIONS"],
+			}
+		case "#EXT-X-MAP":
+			if key == nil || key.Method != "AES-128" {
+				return
+			}
+			attrs := m3u8.DecodeAttributeList(value)
+			m := m3u8.Map{URI: attrs["URI"]}
+			if byteRange, ok := attrs["BYTERANGE"]; ok {
+				fmt.Sscanf(byteRange, "%d@%d", &m.Limit, &m.Offset)
+			}
+			if playlist.mapKeys == nil {
+				playlist.mapKeys = make(map[m3u8.Map]*m3u8.Key)
+			}
+			playlist.mapKeys[m] = key
+		}
+	})
+}
+
+// errUnsupportedEncryption is returned when the segment is encrypted in the way which is not supported.
+var errUnsupportedEncryption = errors.New("unsupported encryption")
+
+// decryptionError is returned when the segment can't be decrypted.
+type decryptionError struct {
+	message string
+	parent  error
+}
+
+func (err decryptionError) Error() string {
+	return err.parent.Error()
+}
+
+func (err decryptionError) Unwrap() error {
+	return err.parent
+}
+
+// keyStore caches keys provided by KeyProvider.
+type keyStore struct {
+	provider KeyProvider
+	client   client
+	keys     map[string][]byte
+	mutex    sync.Mutex
+}
+
+func newKeyStore(provider KeyProvider, client client) *keyStore {
+	return &keyStore{
+		provider: provider,
+		client:   client,
+		keys:     make(map[string][]byte),
+	}
+}
+
+func (s *keyStore) key(ctx context.Context, enc *Encryption) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if key, ok := s.keys[enc.KeyURL]; ok {
+		return key, nil
+	}
+	key, err := s.provider.Key(ctx, &KeyRequest{
+		URL:       enc.KeyURL,
+		Method:    enc.Method,
+		KeyFormat: enc.KeyFormat,
+		client:    s.client,
+	})
+	if err != nil {
+		return nil, decryptionError{
+			message: "failed to get decryption key",
+			parent:  fmt.Errorf("failed to get decryption key: %s: %w", enc.KeyURL, err),
+		}
+	}
+	if len(key) != aes.BlockSize {
+		return nil, decryptionError{
+			message: "failed to get decryption key",
+			parent:  fmt.Errorf("invalid key length: %s: %d", enc.KeyURL, len(key)),
+		}
+	}
+	s.keys[enc.KeyURL] = key
+	return key, nil
+}
+
+// retain removes keys which are not contained in urls.
+func (s *keyStore) retain(urls map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for u := range s.keys {
+		if !urls[u] {
+			delete(s.keys, u)
+		}
+	}
+}
+
+// decryptor returns a function to decrypt the segment.
+func (s *keyStore) decryptor(seg *HLSSegment) decryptFunc {
+	enc := seg.Encryption
+	return func(ctx context.Context, data []byte, load loadFunc) ([]byte, error) {
+		key, err := s.key(ctx, enc)
+		if err != nil {
+			return nil, err
+		}
+		var decrypted []byte
+		switch enc.Method {
+		case "AES-128":
+			decrypted, err = decryptAES128(data, key, enc.IV)
+		case "SAMPLE-AES":
+			decrypted, err = decryptSampleAES(data, key, seg, load)
+		default:
+			err = fmt.Errorf("%w: METHOD=%s", errUnsupportedEncryption, enc.Method)
+		}
+		if err != nil {
+			message := "failed to decrypt segment"
+			if errors.Is(err, errUnsupportedEncryption) {
+				message = "unsupported encryption"
+			} else if enc.Rotated {
+				message = "key rotation is broken"
+			}
+			return nil, decryptionError{
+				message: message,
+				parent:  fmt.Errorf("%s: %s: %w", message, seg.URL, err),
+			}
+		}
+		return decrypted, nil
+	}
+}
+
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid data length: %d", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding")
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+func decryptSampleAES(data, key []byte, seg *HLSSegment, load loadFunc) ([]byte, error) {
+	if len(data) != 0 && data[0] == ts.SyncByte {
+		return nil, fmt.Errorf("%w: SAMPLE-AES of MPEG-2 TS", errUnsupportedEncryption)
+	}
+	if seg.InitializationURL == "" {
+		return nil, errors.New("SAMPLE-AES requires EXT-X-MAP")
+	}
+	init, ok := load(seg.InitializationURL, seg.InitializationByteRange)
+	if !ok {
+		return nil, fmt.Errorf("initialization segment is not available: %s", seg.InitializationURL)
+	}
+	tracks, err := mp4.ReadTrackEncryptions(init)
+	if err != nil {
+		return nil, err
+	}
+	return mp4.DecryptFragments(data, tracks, func(_ []byte) ([]byte, error) {
+		return key, nil
+	})
+}
+
+// cencKeys is a table of content keys keyed by KID in lowercase hexadecimal without hyphens.
+type cencKeys map[string][]byte
+
+func newCENCKeys(keys map[string][]byte) cencKeys {
+	normalized := make(cencKeys, len(keys))
+	for kid, key := range keys {
+		normalized[strings.ToLower(strings.ReplaceAll(kid, "-", ""))] = key
+	}
+	return normalized
+}
+
+// decryptor returns a function to decrypt the segment protected by Common Encryption.
+func (keys cencKeys) decryptor(seg *DASHSegment) decryptFunc {
+	return func(_ context.Context, data []byte, load loadFunc) ([]byte, error) {
+		decrypted, err := keys.decrypt(data, seg, load)
+		if err != nil {
+			return nil, decryptionError{
+				message: "failed to decrypt segment",
+				parent:  fmt.Errorf("failed to decrypt segment: %s: %w", seg.URL, err),
+			}
+		}
+		return decrypted, nil
+	}
+}
+
+func (keys cencKeys) decrypt(data []byte, seg *DASHSegment, load loadFunc) ([]byte, error) {
+	init, ok := load(seg.InitializationURL, seg.InitializationByteRange)
+	if !ok {
+		return nil, fmt.Errorf("initialization segment is not available: %s", seg.InitializationURL)
+	}
+	tracks, err := mp4.ReadTrackEncryptions(init)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return data, nil
+	}
+	return mp4.DecryptFragments(data, tracks, func(kid []byte) ([]byte, error) {
+		key, ok := keys[hex.EncodeToString(kid)]
+		if !ok {
+			return nil, fmt.Errorf("key is not found: KID=%x", kid)
+		}
+		return key, nil
+	})
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptAES128(t *testing.T, data, key, iv []byte) []byte {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	encrypted := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted
+}
+
+func TestNewEncryption(t *testing.T) {
+	enc, err := newEncryption(&m3u8.Key{Method: "AES-128", URI: "key"}, "https://foo/key", 0x1234)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x12, 0x34}, enc.IV)
+
+	enc, err = newEncryption(&m3u8.Key{Method: "AES-128", URI: "key", IV: "0x000102030405060708090A0B0C0D0E0F"}, "https://foo/key", 0x1234)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, enc.IV)
+
+	_, err = newEncryption(&m3u8.Key{Method: "AES-128", URI: "key", IV: "0x0001"}, "https://foo/key", 0x1234)
+	assert.Error(t, err)
+}
+
+func TestSegmentsEncryption(t *testing.T) {
+	p := &Playlists{
+		MediaPlaylists: map[string]*MediaPlaylist{
+			"media.m3u8": {
+				URL: "https://localhost/foo/media.m3u8",
+				MediaPlaylist: &m3u8.MediaPlaylist{Segments: []*m3u8.MediaSegment{
+					{SeqId: 10, URI: "10.ts", Key: &m3u8.Key{Method: "AES-128", URI: "key1"}},
+					{SeqId: 11, URI: "11.ts"},
+					{SeqId: 12, URI: "12.ts", Key: &m3u8.Key{Method: "AES-128", URI: "key2"}},
+					{SeqId: 13, URI: "13.ts", Key: &m3u8.Key{Method: "NONE"}},
+				}},
+			},
+		},
+	}
+	segments, err := p.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 4)
+	assert.Equal(t, "https://localhost/foo/key1", segments[0].Encryption.KeyURL)
+	assert.False(t, segments[0].Encryption.Rotated)
+	assert.Equal(t, "https://localhost/foo/key1", segments[1].Encryption.KeyURL)
+	assert.Equal(t, byte(11), segments[1].Encryption.IV[15])
+	assert.False(t, segments[1].Encryption.Rotated)
+	assert.Equal(t, "https://localhost/foo/key2", segments[2].Encryption.KeyURL)
+	assert.True(t, segments[2].Encryption.Rotated)
+	assert.Nil(t, segments[3].Encryption)
+}
+
+func TestSegmentsEncryption_Map(t *testing.T) {
+	raw := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:6` + "\n" +
+		`#EXT-X-TARGETDURATION:2` + "\n" +
+		`#EXT-X-MAP:URI="clear.mp4"` + "\n" +
+		`#EXT-X-KEY:METHOD=AES-128,URI="key",IV=0x000102030405060708090A0B0C0D0E0F` + "\n" +
+		`#EXTINF:2.0,` + "\n" +
+		`1.m4s` + "\n" +
+		`#EXT-X-MAP:URI="encrypted.mp4",BYTERANGE="1000@0"` + "\n" +
+		`#EXTINF:2.0,` + "\n" +
+		`2.m4s` + "\n")
+	dec, _, err := m3u8.DecodeFrom(bytes.NewReader(raw), true)
+	require.NoError(t, err)
+	media, err := newMediaPlaylist("https://localhost/foo/media.m3u8", raw, dec.(*m3u8.MediaPlaylist), nil, nil)
+	require.NoError(t, err)
+	p := &Playlists{MediaPlaylists: map[string]*MediaPlaylist{"media.m3u8": media}}
+	segments, err := p.Segments()
+	require.NoError(t, err)
+	inits := make(map[string]*HLSSegment)
+	for _, seg := range segments {
+		if seg.Initialization {
+			inits[seg.URL] = seg
+		}
+	}
+	require.Len(t, inits, 2)
+	assert.Nil(t, inits["https://localhost/foo/clear.mp4"].Encryption)
+	enc := inits["https://localhost/foo/encrypted.mp4"].Encryption
+	require.NotNil(t, enc)
+	assert.Equal(t, "https://localhost/foo/key", enc.KeyURL)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, enc.IV)
+}
+
+func TestDecryptSampleAES_MPEG2TS(t *testing.T) {
+	s := newKeyStore(StaticKeyProvider(map[string][]byte{
+		"https://foo/key": []byte("0123456789abcdef"),
+	}), nil)
+	decrypt := s.decryptor(&HLSSegment{
+		URL:        "https://foo/1.ts",
+		Encryption: &Encryption{Method: "SAMPLE-AES", KeyURL: "https://foo/key", IV: make([]byte, 16), Rotated: true},
+	})
+	_, err := decrypt(context.Background(), []byte{0x47, 0x40, 0x00, 0x10}, nil)
+	var derr decryptionError
+	require.True(t, errors.As(err, &derr))
+	assert.Equal(t, "unsupported encryption", derr.message)
+}
+
+func TestDecryptAES128(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, 16)
+	encrypted := encryptAES128(t, []byte("plain text"), key, iv)
+	data, err := decryptAES128(encrypted, key, iv)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain text"), data)
+
+	_, err = decryptAES128(encrypted, []byte("fedcba9876543210"), iv)
+	assert.Error(t, err)
+	_, err = decryptAES128(encrypted[:15], key, iv)
+	assert.Error(t, err)
+}
+
+func TestKeyStore(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/key":
+			w.Write([]byte("0123456789abcdef"))
+		case "/short":
+			w.Write([]byte("0123"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := newKeyStore(HTTPKeyProvider(), newClient(http.DefaultClient, nil, nil))
+	for i := 0; i < 2; i++ {
+		key, err := s.key(context.Background(), &Encryption{Method: "AES-128", KeyURL: server.URL + "/key"})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("0123456789abcdef"), key)
+	}
+	assert.Equal(t, 1, requests)
+	s.retain(map[string]bool{})
+	_, err := s.key(context.Background(), &Encryption{Method: "AES-128", KeyURL: server.URL + "/key"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests)
+
+	for _, path := range []string{"/short", "/not_found"} {
+		_, err = s.key(context.Background(), &Encryption{Method: "AES-128", KeyURL: server.URL + path})
+		var derr decryptionError
+		require.True(t, errors.As(err, &derr))
+		assert.Equal(t, "failed to get decryption key", derr.message)
+	}
+
+	s = newKeyStore(StaticKeyProvider(map[string][]byte{
+		"https://foo/key": []byte("0123456789abcdef"),
+	}), nil)
+	key, err := s.key(context.Background(), &Encryption{Method: "AES-128", KeyURL: "https://foo/key"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef"), key)
+	_, err = s.key(context.Background(), &Encryption{Method: "AES-128", KeyURL: "https://foo/unknown"})
+	assert.Error(t, err)
+}
+
+func TestMonitor_HLSDecryption(t *testing.T) {
+	key1 := []byte("0123456789abcdef")
+	key2 := []byte("fedcba9876543210")
+	iv := func(seqID byte) []byte {
+		iv := make([]byte, 16)
+		iv[15] = seqID
+		return iv
+	}
+	media := func(key2URI string) []byte {
+		return []byte(`#EXTM3U` + "\n" +
+			`#EXT-X-VERSION:3` + "\n" +
+			`#EXT-X-TARGETDURATION:8` + "\n" +
+			`#EXT-X-MEDIA-SEQUENCE:1` + "\n" +
+			`#EXT-X-KEY:METHOD=AES-128,URI="key1"` + "\n" +
+			`#EXTINF:8.0,` + "\n" +
+			`1.ts` + "\n" +
+			fmt.Sprintf(`#EXT-X-KEY:METHOD=AES-128,URI="%s"`, key2URI) + "\n" +
+			`#EXTINF:8.0,` + "\n" +
+			`2.ts` + "\n" +
+			`#EXT-X-ENDLIST` + "\n")
+	}
+	testCases := []struct {
+		name    string
+		key2URI string
+		message string
+	}{
+		{name: "ok", key2URI: "key2"},
+		{name: "key_not_found", key2URI: "key3", message: "failed to get decryption key"},
+		{name: "broken_rotation", key2URI: "key4", message: "key rotation is broken"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/media.m3u8":
+					w.Write(media(tc.key2URI))
+				case "/key1":
+					w.Write(key1)
+				case "/key2":
+					w.Write(key2)
+				case "/key4":
+					w.Write(key1)
+				case "/1.ts":
+					w.Write(encryptAES128(t, []byte("segment1"), key1, iv(1)))
+				case "/2.ts":
+					w.Write(encryptAES128(t, []byte("segment2"), key2, iv(2)))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			config := NewConfig(server.URL+"/media.m3u8", StreamTypeHLS)
+			config.KeyProvider = HTTPKeyProvider()
+			reportCh := make(chan Reports, 10)
+			config.HLS.Inspectors = []HLSInspector{
+				&mockHLSInspector{inspect: func(playlists *Playlists, segments SegmentStore) *Report {
+					data, ok := segments.Load(server.URL + "/1.ts")
+					require.True(t, ok)
+					assert.Equal(t, "segment1", string(data))
+					data, ok = segments.Load(server.URL + "/2.ts")
+					if tc.message == "" {
+						require.True(t, ok)
+						assert.Equal(t, "segment2", string(data))
+					} else {
+						// the segment which can't be decrypted is not passed to inspectors.
+						assert.False(t, ok)
+					}
+					return &Report{Name: "i1", Severity: Info}
+				}},
+			}
+			config.OnReport = func(reports Reports) {
+				reportCh <- reports
+			}
+
+			m := NewMonitor(config)
+			reports := <-reportCh
+			if tc.message != "" {
+				require.Len(t, reports, 1)
+				assert.Equal(t, "Monitor", reports[0].Name)
+				assert.Equal(t, tc.message, reports[0].Message)
+				// the other segments are still inspected.
+				reports = <-reportCh
+			}
+			m.Terminate()
+			require.Len(t, reports, 1)
+			assert.Equal(t, "i1", reports[0].Name)
+		})
+	}
+}
+
+func TestMonitor_HLSDecryptionMap(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	media := []byte(`#EXTM3U` + "\n" +
+		`#EXT-X-VERSION:6` + "\n" +
+		`#EXT-X-TARGETDURATION:8` + "\n" +
+		`#EXT-X-KEY:METHOD=AES-128,URI="key",IV=0x66656463626139383736353433323130` + "\n" +
+		`#EXT-X-MAP:URI="init.mp4"` + "\n" +
+		`#EXTINF:8.0,` + "\n" +
+		`1.m4s` + "\n" +
+		`#EXT-X-ENDLIST` + "\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/media.m3u8":
+			w.Write(media)
+		case "/key":
+			w.Write(key)
+		case "/init.mp4":
+			w.Write(encryptAES128(t, []byte("init"), key, iv))
+		case "/1.m4s":
+			w.Write(encryptAES128(t, []byte("segment1"), key, iv))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := NewConfig(server.URL+"/media.m3u8", StreamTypeHLS)
+	config.KeyProvider = HTTPKeyProvider()
+	reportCh := make(chan Reports, 10)
+	config.HLS.Inspectors = []HLSInspector{
+		&mockHLSInspector{inspect: func(playlists *Playlists, segments SegmentStore) *Report {
+			data, ok := segments.Load(server.URL + "/init.mp4")
+			require.True(t, ok)
+			assert.Equal(t, "init", string(data))
+			data, ok = segments.Load(server.URL + "/1.m4s")
+			require.True(t, ok)
+			assert.Equal(t, "segment1", string(data))
+			return &Report{Name: "i1", Severity: Info}
+		}},
+	}
+	config.OnReport = func(reports Reports) {
+		reportCh <- reports
+	}
+
+	m := NewMonitor(config)
+	reports := <-reportCh
+	m.Terminate()
+	require.Len(t, reports, 1)
+	assert.Equal(t, "i1", reports[0].Name)
+}
+
+func TestCENCKeys(t *testing.T) {
+	kid := []byte("fedcba9876543210")
+	key := []byte("0123456789abcdef")
+	init := mp4test.Box("moov", mp4test.Box("trak",
+		mp4test.Box("tkhd", mp4test.U32(0, 0, 0, 1, 0, 0)),
+		mp4test.Box("mdia", mp4test.Box("minf", mp4test.Box("stbl", mp4test.Box("stsd", mp4test.U32(0, 1), mp4test.Box("encv",
+			make([]byte, 78),
+			mp4test.Box("sinf",
+				mp4test.Box("frma", []byte("avc1")),
+				mp4test.Box("schm", mp4test.U32(0), []byte("cenc"), mp4test.U32(0x10000)),
+				mp4test.Box("schi", mp4test.Box("tenc", mp4test.U32(0), []byte{0, 0, 1, 8}, kid)),
+			),
+		))))),
+	))
+	plain := []byte("plain sample data")
+	iv := []byte("iv000001")
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCTR(block, append(append([]byte{}, iv...), make([]byte, 8)...)).XORKeyStream(encrypted, plain)
+	fragment := func(sample []byte) []byte {
+		build := func(dataOffset uint32) []byte {
+			return mp4test.Box("moof",
+				mp4test.Box("mfhd", mp4test.U32(0, 1)),
+				mp4test.Box("traf",
+					mp4test.Box("tfhd", mp4test.U32(0x020000, 1)),
+					mp4test.Box("trun", mp4test.U32(0x000201, 1, dataOffset, uint32(len(sample)))),
+					mp4test.Box("senc", mp4test.U32(0, 1), iv),
+				),
+			)
+		}
+		moof := build(0)
+		moof = build(uint32(len(moof) + 8))
+		return append(moof, mp4test.Box("mdat", sample)...)
+	}
+	seg := &DASHSegment{URL: "https://foo/1.mp4", InitializationURL: "https://foo/init.mp4"}
+	load := func(url string, _ *ByteRange) ([]byte, bool) {
+		if url == "https://foo/init.mp4" {
+			return init, true
+		}
+		return nil, false
+	}
+
+	keys := newCENCKeys(map[string][]byte{"66656463-6261-3938-3736-353433323130": key})
+	decrypted, err := keys.decryptor(seg)(context.Background(), fragment(encrypted), load)
+	require.NoError(t, err)
+	assert.Equal(t, fragment(plain), decrypted)
+
+	keys = newCENCKeys(map[string][]byte{"00000000000000000000000000000000": key})
+	_, err = keys.decryptor(seg)(context.Background(), fragment(encrypted), load)
+	require.Error(t, err)
+	var derr decryptionError
+	require.True(t, errors.As(err, &derr))
+	assert.Equal(t, "failed to decrypt segment", derr.message)
+}
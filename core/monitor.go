@@ -22,8 +22,12 @@ type monitor struct {
 	hlsDownloader  *hlsPlaylistDownloader
 	dashDownloader *dashManifestDownloader
 	segmentStore   mutableSegmentStore
-	context        context.Context
-	terminate      func()
+	keyStore       *keyStore
+	cencKeys       cencKeys
+	// blockingRequests holds the next blocking requests of media playlists to detect updates.
+	blockingRequests map[string]BlockingRequest
+	context          context.Context
+	terminate        func()
 }
 
 func NewMonitor(config *Config) Monitor {
@@ -40,8 +44,32 @@ func NewMonitor(config *Config) Monitor {
 	switch config.StreamType {
 	case StreamTypeHLS:
 		m.hlsDownloader = newHLSPlaylistDownloader(manifestClient, config.ManifestTimeout)
+		if config.HLS != nil && config.HLS.InterstitialTimeout != 0 {
+			m.hlsDownloader.interstitialTimeout = config.HLS.InterstitialTimeout
+		}
+		if config.KeyProvider != nil {
+			m.keyStore = newKeyStore(config.KeyProvider, httpClient)
+		}
 	case StreamTypeDASH:
 		m.dashDownloader = newDASHManifestDownloader(manifestClient, config.ManifestTimeout)
+		if config.DASH != nil && len(config.DASH.Keys) != 0 {
+			m.cencKeys = newCENCKeys(config.DASH.Keys)
+		}
+		if config.DASH != nil && config.DASH.XLinkTimeout != 0 {
+			m.dashDownloader.xlinkTimeout = config.DASH.XLinkTimeout
+		}
+		if config.DASH != nil && config.DASH.XLinkMaxDepth != 0 {
+			m.dashDownloader.xlinkMaxDepth = config.DASH.XLinkMaxDepth
+		}
+		if config.DASH != nil && config.DASH.ClockSyncInterval != 0 {
+			m.dashDownloader.clockSyncInterval = config.DASH.ClockSyncInterval
+		}
+		if config.DASH != nil && config.DASH.BaseURLExclusion != 0 {
+			m.dashDownloader.baseURLExclusion = config.DASH.BaseURLExclusion
+		}
+		if config.DASH != nil {
+			m.dashDownloader.allBaseURLs = config.DASH.ProbeAllBaseURLs
+		}
 	}
 	m.context, m.terminate = context.WithCancel(context.Background())
 	go m.run()
@@ -101,13 +129,48 @@ func (m *monitor) proc() (cont bool, waitDur time.Duration) {
 	switch m.config.StreamType {
 	case StreamTypeHLS:
 		if err := m.updateSegmentStoreHLS(playlists); err != nil {
-			m.onError("failed to download segment", err)
-			return true, m.config.DefaultInterval
+			m.onSegmentError(err)
+			// segments which can't be decrypted are not stored, and the others are still inspected.
+			if !errors.As(err, &decryptionError{}) {
+				return true, m.config.DefaultInterval
+			}
 		}
 	default:
+		var perr patchPublishTimeError
+		if errors.As(manifest.patchError, &perr) {
+			m.onReport([]*Report{
+				{
+					Name:     "Monitor",
+					Severity: Warn,
+					Message:  "MPD patch mismatches publishTime",
+					Values: Values{
+						"patch":               perr.patchURL,
+						"originalPublishTime": perr.originalPublishTime,
+						"publishTime":         perr.publishTime,
+					},
+				},
+			})
+		}
+		for _, err := range manifest.timingErrors {
+			m.onError("failed to resolve UTCTiming", err)
+		}
+		for _, err := range manifest.xlinkErrors {
+			if errors.Is(err, context.DeadlineExceeded) {
+				m.onError("xlink resolution timed out", err)
+			} else {
+				m.onError("failed to resolve xlink", err)
+			}
+		}
 		if err := m.updateSegmentStoreDASH(manifest); err != nil {
-			m.onError("failed to download segment", err)
-			return true, m.config.DefaultInterval
+			var berr baseURLError
+			if errors.As(err, &berr) {
+				log.Printf("WARN: fail over from BaseURL: %s", berr.baseURL.URL)
+				m.dashDownloader.excludeBaseURL(manifest, berr.baseURL)
+			}
+			m.onSegmentError(err)
+			if !errors.As(err, &decryptionError{}) {
+				return true, m.config.DefaultInterval
+			}
 		}
 	}
 
@@ -158,6 +221,15 @@ func (m *monitor) hlsWaitDuration(playlists *Playlists) (bool, time.Duration) {
 	if !m.config.PrioritizeSuggestedInterval {
 		return true, m.config.DefaultInterval
 	}
+	// server holds the next request until the playlist is updated.
+	// The monitor backs off when the server responds without updates to avoid a busy loop.
+	if m.updateBlockingRequests(playlists) && playlists.CanBlockReload() {
+		dur := time.Duration(playlists.minPartTarget() * float64(time.Second) / 2)
+		if dur < minBlockingReloadInterval {
+			return true, minBlockingReloadInterval
+		}
+		return true, dur
+	}
 	dur := time.Duration(playlists.MaxTargetDuration()) * time.Second / 2
 	if dur == 0 {
 		return true, m.config.DefaultInterval
@@ -167,6 +239,25 @@ func (m *monitor) hlsWaitDuration(playlists *Playlists) (bool, time.Duration) {
 	return true, dur
 }
 
+// updateBlockingRequests records the next blocking requests of media playlists,
+// and returns whether any of them is changed from the last poll.
+func (m *monitor) updateBlockingRequests(playlists *Playlists) bool {
+	requests := make(map[string]BlockingRequest, len(playlists.MediaPlaylists))
+	var updated bool
+	for key, playlist := range playlists.MediaPlaylists {
+		req := playlist.nextBlockingRequest()
+		if req == nil {
+			continue
+		}
+		requests[key] = *req
+		if prev, ok := m.blockingRequests[key]; !ok || prev != *req {
+			updated = true
+		}
+	}
+	m.blockingRequests = requests
+	return updated
+}
+
 func (m *monitor) dashWaitDuration(manifest *Manifest) (bool, time.Duration) {
 	if manifest.Type == nil || *manifest.Type != "dynamic" {
 		if m.config.TerminateIfVOD {
@@ -201,27 +292,89 @@ func (m *monitor) updateSegmentStoreHLS(playlists *Playlists) error {
 	if err != nil {
 		return err
 	}
-	urls := make([]string, 0, len(segments))
+	requests := make([]*segmentRequest, 0, len(segments))
+	requested := make(map[string]bool, len(segments))
+	keyURLs := make(map[string]bool)
 	for _, seg := range segments {
 		if m.config.SegmentFilter == nil || m.config.SegmentFilter.CheckHLS(seg) == Pass {
-			urls = append(urls, seg.URL)
+			req := &segmentRequest{
+				url:            seg.URL,
+				byteRange:      seg.ByteRange,
+				initialization: seg.Initialization,
+			}
+			if m.keyStore != nil && seg.Encryption != nil {
+				req.decrypt = m.keyStore.decryptor(seg)
+				keyURLs[seg.Encryption.KeyURL] = true
+			}
+			requests = append(requests, req)
+			requested[req.key()] = true
+		}
+	}
+	// SAMPLE-AES decryption of fragmented MP4 requires the initialization segment.
+	initSegments := make(map[string]*HLSSegment)
+	for _, seg := range segments {
+		if seg.Initialization {
+			initSegments[SegmentKey(seg.URL, seg.ByteRange)] = seg
+		}
+	}
+	for _, seg := range segments {
+		if m.keyStore == nil || seg.Encryption == nil || seg.InitializationURL == "" {
+			continue
 		}
+		init := &segmentRequest{
+			url:            seg.InitializationURL,
+			byteRange:      seg.InitializationByteRange,
+			initialization: true,
+		}
+		if requested[SegmentKey(seg.URL, seg.ByteRange)] && !requested[init.key()] {
+			if initSeg := initSegments[init.key()]; initSeg != nil && initSeg.Encryption != nil {
+				init.decrypt = m.keyStore.decryptor(initSeg)
+				keyURLs[initSeg.Encryption.KeyURL] = true
+			}
+			requests = append(requests, init)
+			requested[init.key()] = true
+		}
+	}
+	if m.keyStore != nil {
+		m.keyStore.retain(keyURLs)
 	}
-	return m.segmentStore.Sync(m.context, urls)
+	return m.segmentStore.Sync(m.context, requests)
 }
 
 func (m *monitor) updateSegmentStoreDASH(manifest *Manifest) error {
-	segments, err := manifest.Segments()
+	segments, err := manifest.segments(m.config.DASH.ProbeAllBaseURLs)
 	if err != nil {
 		return err
 	}
-	urls := make([]string, 0, len(segments))
+	requests := make([]*segmentRequest, 0, len(segments))
+	requested := make(map[string]bool, len(segments))
 	for _, seg := range segments {
 		if m.config.SegmentFilter == nil || m.config.SegmentFilter.CheckDASH(seg) == Pass {
-			urls = append(urls, seg.URL)
+			req := &segmentRequest{url: seg.URL, byteRange: seg.ByteRange, initialization: seg.Initialization, baseURL: seg.BaseURL}
+			if m.cencKeys != nil && seg.InitializationURL != "" {
+				req.decrypt = m.cencKeys.decryptor(seg)
+			}
+			requests = append(requests, req)
+			requested[req.key()] = true
 		}
 	}
-	return m.segmentStore.Sync(m.context, urls)
+	// decryption requires the initialization segment.
+	for _, seg := range segments {
+		if m.cencKeys == nil || seg.InitializationURL == "" {
+			continue
+		}
+		init := &segmentRequest{
+			url:            seg.InitializationURL,
+			byteRange:      seg.InitializationByteRange,
+			initialization: true,
+			baseURL:        seg.BaseURL,
+		}
+		if requested[SegmentKey(seg.URL, seg.ByteRange)] && !requested[init.key()] {
+			requests = append(requests, init)
+			requested[init.key()] = true
+		}
+	}
+	return m.segmentStore.Sync(m.context, requests)
 }
 
 func (m *monitor) onPanic(r interface{}) {
@@ -229,18 +382,37 @@ func (m *monitor) onPanic(r interface{}) {
 }
 
 func (m *monitor) onError(msg string, err error) {
+	m.onErrorWithValues(msg, Values{"error": err})
+}
+
+func (m *monitor) onErrorWithValues(msg string, values Values) {
 	m.onReport([]*Report{
 		{
 			Name:     "Monitor",
 			Severity: Error,
 			Message:  msg,
-			Values: Values{
-				"error": err,
-			},
+			Values:   values,
 		},
 	})
 }
 
+func (m *monitor) onSegmentError(err error) {
+	values := Values{"error": err}
+	var berr baseURLError
+	if errors.As(err, &berr) {
+		values["baseURL"] = berr.baseURL.URL
+		values["serviceLocation"] = berr.baseURL.ServiceLocation
+	}
+	var derr decryptionError
+	if errors.As(err, &derr) {
+		m.onErrorWithValues(derr.message, values)
+	} else if errors.As(err, &initializationSegmentError{}) {
+		m.onErrorWithValues("failed to download initialization segment", values)
+	} else {
+		m.onErrorWithValues("failed to download segment", values)
+	}
+}
+
 func (m *monitor) onReport(reports Reports) {
 	if m.config.OnReport != nil {
 		m.config.OnReport(reports)
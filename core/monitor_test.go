@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -132,6 +133,89 @@ func TestMonitor(t *testing.T) {
 		assert.Equal(t, "OnTerminate", calls[8])
 	})
 
+	t.Run("HLS ByteRange", func(t *testing.T) {
+		media := []byte(`#EXTM3U` + "\n" +
+			`#EXT-X-VERSION:4` + "\n" +
+			`#EXT-X-TARGETDURATION:8` + "\n" +
+			`#EXT-X-MEDIA-SEQUENCE:2680` + "\n" +
+			`#EXTINF:7.975,` + "\n" +
+			`#EXT-X-BYTERANGE:5@0` + "\n" +
+			`main.ts` + "\n" +
+			`#EXTINF:7.941,` + "\n" +
+			`#EXT-X-BYTERANGE:6` + "\n" +
+			`main.ts` + "\n")
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/media.m3u8":
+				w.Write(media)
+			case "/main.ts":
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("dummyTSfile")))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		config := NewConfig(server.URL+"/media.m3u8", StreamTypeHLS)
+		reportCh := make(chan Reports, 10)
+		config.HLS.Inspectors = []HLSInspector{
+			&mockHLSInspector{inspect: func(playlists *Playlists, segments SegmentStore) *Report {
+				data, ok := segments.LoadRange(server.URL+"/main.ts", &ByteRange{Offset: 0, Length: 5})
+				require.True(t, ok)
+				assert.Equal(t, "dummy", string(data))
+				data, ok = segments.LoadRange(server.URL+"/main.ts", &ByteRange{Offset: 5, Length: 6})
+				require.True(t, ok)
+				assert.Equal(t, "TSfile", string(data))
+				assert.False(t, segments.Exists(server.URL+"/main.ts"))
+				return &Report{Name: "i1", Severity: Info}
+			}},
+		}
+		config.OnReport = func(reports Reports) {
+			reportCh <- reports
+		}
+
+		m := NewMonitor(config)
+		reports := <-reportCh
+		m.Terminate()
+		require.Len(t, reports, 1)
+		assert.Equal(t, "i1", reports[0].Name)
+	})
+
+	t.Run("HLS Initialization Not Found", func(t *testing.T) {
+		media := []byte(`#EXTM3U` + "\n" +
+			`#EXT-X-VERSION:7` + "\n" +
+			`#EXT-X-TARGETDURATION:8` + "\n" +
+			`#EXT-X-MEDIA-SEQUENCE:2680` + "\n" +
+			`#EXT-X-MAP:URI="init.mp4"` + "\n" +
+			`#EXTINF:7.975,` + "\n" +
+			`2680.mp4` + "\n" +
+			`#EXT-X-ENDLIST` + "\n")
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/media.m3u8":
+				w.Write(media)
+			case "/2680.mp4":
+				w.Write([]byte("dummy"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		config := NewConfig(server.URL+"/media.m3u8", StreamTypeHLS)
+		reportCh := make(chan Reports, 10)
+		config.OnReport = func(reports Reports) {
+			reportCh <- reports
+		}
+
+		m := NewMonitor(config)
+		reports := <-reportCh
+		m.Terminate()
+		require.Len(t, reports, 1)
+		assert.Equal(t, "Monitor", reports[0].Name)
+		assert.Equal(t, Error, reports[0].Severity)
+		assert.Equal(t, "failed to download initialization segment", reports[0].Message)
+		assert.Contains(t, reports[0].Values["error"].(error).Error(), server.URL+"/init.mp4")
+	})
+
 	t.Run("DASH Live", func(t *testing.T) {
 		manifest := []byte(`<MPD type="dynamic" minimumUpdatePeriod="PT5.000000S" availabilityStartTime="1970-01-01T00:00:00Z">` +
 			`<Period id="1" start="PT1600000000.000S">` +
@@ -231,6 +315,19 @@ func TestMonitor_HLSWaitDuration(t *testing.T) {
 			Closed:         false,
 		}},
 	}}
+	blockingPlaylists := &Playlists{MediaPlaylists: map[string]*MediaPlaylist{
+		"1.m3u8": {
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 8.0},
+			ServerControl: &ServerControl{CanBlockReload: true},
+		},
+	}}
+	lowLatencyPlaylists := &Playlists{MediaPlaylists: map[string]*MediaPlaylist{
+		"1.m3u8": {
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 8.0},
+			ServerControl: &ServerControl{CanBlockReload: true},
+			PartTarget:    1.0,
+		},
+	}}
 	vodPlaylists := &Playlists{MediaPlaylists: map[string]*MediaPlaylist{
 		"1.m3u8": {MediaPlaylist: &m3u8.MediaPlaylist{
 			TargetDuration: 8.0,
@@ -265,6 +362,26 @@ func TestMonitor_HLSWaitDuration(t *testing.T) {
 			cont: true,
 			dur:  4 * time.Second,
 		},
+		{
+			name:      "live_blocking_reload",
+			playlists: blockingPlaylists,
+			config: &Config{
+				DefaultInterval:             5 * time.Second,
+				PrioritizeSuggestedInterval: true,
+			},
+			cont: true,
+			dur:  100 * time.Millisecond,
+		},
+		{
+			name:      "live_blocking_reload_parts",
+			playlists: lowLatencyPlaylists,
+			config: &Config{
+				DefaultInterval:             5 * time.Second,
+				PrioritizeSuggestedInterval: true,
+			},
+			cont: true,
+			dur:  500 * time.Millisecond,
+		},
 		{
 			name:      "vod_not_terminate",
 			playlists: vodPlaylists,
@@ -294,6 +411,18 @@ func TestMonitor_HLSWaitDuration(t *testing.T) {
 			assert.Equal(t, tc.dur, dur)
 		})
 	}
+
+	t.Run("live_blocking_reload_without_update", func(t *testing.T) {
+		m := NewMonitor(&Config{
+			DefaultInterval:             5 * time.Second,
+			PrioritizeSuggestedInterval: true,
+		}).(*monitor)
+		_, dur := m.hlsWaitDuration(lowLatencyPlaylists)
+		assert.Equal(t, 500*time.Millisecond, dur)
+		// the server responds without any update.
+		_, dur = m.hlsWaitDuration(lowLatencyPlaylists)
+		assert.Equal(t, 4*time.Second, dur)
+	})
 }
 
 func TestMonitor_DASHWaitDuration(t *testing.T) {
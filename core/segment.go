@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/abema/antares/internal/thread"
@@ -20,11 +21,71 @@ type cache struct {
 type SegmentStore interface {
 	Exists(url string) bool
 	Load(url string) ([]byte, bool)
+	// ExistsRange and LoadRange access a sub-range of the resource.
+	// When byteRange is nil, they are equivalent to Exists and Load.
+	ExistsRange(url string, byteRange *ByteRange) bool
+	LoadRange(url string, byteRange *ByteRange) ([]byte, bool)
+}
+
+type segmentRequest struct {
+	url            string
+	byteRange      *ByteRange
+	initialization bool
+	// decrypt is nil when the segment is not encrypted.
+	decrypt decryptFunc
+	// baseURL is the DASH BaseURL which the segment URL is resolved with.
+	baseURL *DASHBaseURL
+}
+
+// loadFunc loads the segment which is downloaded in the same synchronization or cached.
+type loadFunc func(url string, byteRange *ByteRange) ([]byte, bool)
+
+// decryptFunc decrypts the downloaded segment.
+type decryptFunc func(ctx context.Context, data []byte, load loadFunc) ([]byte, error)
+
+// initializationSegmentError is returned when the initialization segment can't be downloaded.
+type initializationSegmentError struct {
+	parent error
+}
+
+func (err initializationSegmentError) Error() string {
+	return err.parent.Error()
+}
+
+func (err initializationSegmentError) Unwrap() error {
+	return err.parent
+}
+
+// baseURLError is returned when the segment resolved with the DASH BaseURL can't be downloaded.
+type baseURLError struct {
+	baseURL *DASHBaseURL
+	parent  error
+}
+
+func (err baseURLError) Error() string {
+	return err.parent.Error()
+}
+
+func (err baseURLError) Unwrap() error {
+	return err.parent
+}
+
+func (r *segmentRequest) key() string {
+	return SegmentKey(r.url, r.byteRange)
+}
+
+// SegmentKey returns the identifier of the segment specified by the URL and the byte range.
+// Segments which share the URL are distinguished by the byte range.
+func SegmentKey(url string, byteRange *ByteRange) string {
+	if byteRange == nil {
+		return url
+	}
+	return url + "#" + byteRange.String()
 }
 
 type mutableSegmentStore interface {
 	SegmentStore
-	Sync(ctx context.Context, urls []string) error
+	Sync(ctx context.Context, requests []*segmentRequest) error
 }
 
 type segmentStore struct {
@@ -51,34 +112,46 @@ func newSegmentStore(
 }
 
 func (s *segmentStore) Exists(url string) bool {
-	_, ok := s.cacheMap[url]
-	return ok
+	return s.ExistsRange(url, nil)
 }
 
 func (s *segmentStore) Load(url string) ([]byte, bool) {
-	seg, ok := s.cacheMap[url]
-	return seg.data, ok
+	return s.LoadRange(url, nil)
+}
+
+func (s *segmentStore) ExistsRange(url string, byteRange *ByteRange) bool {
+	_, ok := s.cacheMap[SegmentKey(url, byteRange)]
+	return ok
+}
+
+func (s *segmentStore) LoadRange(url string, byteRange *ByteRange) ([]byte, bool) {
+	seg, ok := s.cacheMap[SegmentKey(url, byteRange)]
+	if !ok {
+		return nil, false
+	}
+	return seg.data, true
 }
 
-func (s *segmentStore) Sync(ctx context.Context, urls []string) error {
-	for url := range s.cacheMap {
-		s.cacheMap[url].del = true
+func (s *segmentStore) Sync(ctx context.Context, requests []*segmentRequest) error {
+	for key := range s.cacheMap {
+		s.cacheMap[key].del = true
 	}
 
 	type result struct {
-		url  string
+		req  *segmentRequest
 		data []byte
 	}
-	results := make(chan result, len(urls))
+	results := make(chan result, len(requests))
 	eg := new(errgroup.Group)
 	maxConc := s.maxConc
 	if maxConc == 0 {
 		maxConc = 1
 	}
 	limiter := make(chan struct{}, maxConc)
-	for i := range urls {
-		url := urls[i]
-		if seg, ok := s.cacheMap[url]; ok {
+	for i := range requests {
+		req := requests[i]
+		key := req.key()
+		if seg, ok := s.cacheMap[key]; ok {
 			seg.del = false
 			continue
 		}
@@ -90,18 +163,27 @@ func (s *segmentStore) Sync(ctx context.Context, urls []string) error {
 			return backoff.RetryNotify(func() error {
 				ctx, cancel := context.WithTimeout(ctx, s.timeout)
 				defer cancel()
-				data, _, err := s.httpClient.Get(ctx, url)
+				data, _, err := s.httpClient.GetRange(ctx, req.url, req.byteRange)
 				if err != nil {
-					err = fmt.Errorf("failed to download segment: %s: %w", url, err)
+					if req.initialization {
+						err = initializationSegmentError{
+							parent: fmt.Errorf("failed to download initialization segment: %s: %w", key, err),
+						}
+					} else {
+						err = fmt.Errorf("failed to download segment: %s: %w", key, err)
+					}
+					if req.baseURL != nil {
+						err = baseURLError{baseURL: req.baseURL, parent: err}
+					}
 					if ctx.Err() != nil || errors.As(err, &permanentError{}) {
 						return backoff.Permanent(err)
 					}
 					return err
 				}
-				results <- result{url: url, data: data}
+				results <- result{req: req, data: data}
 				return nil
 			}, s.backoff, func(err error, _ time.Duration) {
-				log.Printf("WARN: failed to download segment: %s: %s", url, err)
+				log.Printf("WARN: failed to download segment: %s: %s", key, err)
 			})
 		}))
 	}
@@ -109,13 +191,47 @@ func (s *segmentStore) Sync(ctx context.Context, urls []string) error {
 		return err
 	}
 	close(results)
+	downloaded := make(map[string][]byte, len(results))
+	encrypted := make([]*segmentRequest, 0)
 	for res := range results {
-		s.cacheMap[res.url] = &cache{data: res.data}
+		downloaded[res.req.key()] = res.data
+		if res.req.decrypt != nil {
+			encrypted = append(encrypted, res.req)
+		}
+	}
+	// initialization segments are decrypted first, because media segments refer to the decrypted ones.
+	sort.SliceStable(encrypted, func(i, j int) bool {
+		return encrypted[i].initialization && !encrypted[j].initialization
+	})
+	load := func(url string, byteRange *ByteRange) ([]byte, bool) {
+		if data, ok := downloaded[SegmentKey(url, byteRange)]; ok {
+			return data, true
+		}
+		return s.LoadRange(url, byteRange)
+	}
+	// segments which can't be decrypted are not cached, so that inspectors never receive encrypted data.
+	// The first error is returned after the other segments are cached.
+	var decryptErr error
+	for _, req := range encrypted {
+		data, err := req.decrypt(ctx, downloaded[req.key()], load)
+		if err != nil {
+			if decryptErr == nil {
+				decryptErr = err
+			} else {
+				log.Printf("WARN: %s", err)
+			}
+			delete(downloaded, req.key())
+			continue
+		}
+		downloaded[req.key()] = data
+	}
+	for key, data := range downloaded {
+		s.cacheMap[key] = &cache{data: data}
 	}
-	for url := range s.cacheMap {
-		if s.cacheMap[url].del {
-			delete(s.cacheMap, url)
+	for key := range s.cacheMap {
+		if s.cacheMap[key].del {
+			delete(s.cacheMap, key)
 		}
 	}
-	return nil
+	return decryptErr
 }
@@ -0,0 +1,211 @@
+package dash
+
+import (
+	"sort"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+type AvailabilityWindowInspectorConfig struct {
+	// Tolerance is the allowable difference between the wall-clock and segment availability times.
+	Tolerance time.Duration
+	// DVRWindowTolerance is the allowable shortage of the DVR window from MPD@timeShiftBufferDepth
+	// in addition to the segment duration.
+	DVRWindowTolerance time.Duration
+}
+
+func DefaultAvailabilityWindowInspectorConfig() *AvailabilityWindowInspectorConfig {
+	return &AvailabilityWindowInspectorConfig{
+		Tolerance:          500 * time.Millisecond,
+		DVRWindowTolerance: time.Second,
+	}
+}
+
+// NewAvailabilityWindowInspector returns AvailabilityWindowInspector.
+// It inspects that segments in dynamic manifest are in the availability window,
+// and that the DVR window covers MPD@timeShiftBufferDepth.
+func NewAvailabilityWindowInspector() core.DASHInspector {
+	return NewAvailabilityWindowInspectorWithConfig(DefaultAvailabilityWindowInspectorConfig())
+}
+
+func NewAvailabilityWindowInspectorWithConfig(config *AvailabilityWindowInspectorConfig) core.DASHInspector {
+	return &availabilityWindowInspector{
+		config: config,
+	}
+}
+
+type availabilityWindowInspector struct {
+	config *AvailabilityWindowInspectorConfig
+}
+
+type representationWindow struct {
+	period      *mpd.Period
+	earliest    time.Time
+	maxDuration time.Duration
+}
+
+func (ins *availabilityWindowInspector) Inspect(manifest *core.Manifest, segments core.SegmentStore) *core.Report {
+	if manifest.Type == nil || *manifest.Type != "dynamic" {
+		return &core.Report{
+			Name:     "AvailabilityWindowInspector",
+			Severity: core.Info,
+			Message:  "skip VOD manifest",
+		}
+	}
+	if manifest.AvailabilityStartTime == nil {
+		return &core.Report{
+			Name:     "AvailabilityWindowInspector",
+			Severity: core.Error,
+			Message:  "MPD@availabilityStartTime is omitted",
+		}
+	}
+	availabilityStartTime, err := time.Parse(time.RFC3339Nano, *manifest.AvailabilityStartTime)
+	if err != nil {
+		return &core.Report{
+			Name:     "AvailabilityWindowInspector",
+			Severity: core.Error,
+			Message:  "invalid MPD@availabilityStartTime",
+			Values:   core.Values{"error": err},
+		}
+	}
+
+	wallClock := manifest.Time
+	if manifest.Timing != nil {
+		wallClock = manifest.Timing.Time
+	} else if wallClock.IsZero() {
+		wallClock = time.Now()
+	}
+
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+	windows := make(map[string]*representationWindow)
+	var inspectErr error
+	err = manifest.EachSegments(func(segment *core.DASHSegment) bool {
+		if segment.Initialization {
+			return true
+		}
+		start, end, err := manifest.SegmentAvailability(segment)
+		if err != nil {
+			inspectErr = err
+			return false
+		}
+		values := core.Values{
+			"period":    segment.Period.ID,
+			"time":      segment.Time,
+			"wallClock": wallClock.UTC().Format(time.RFC3339Nano),
+		}
+		var repID string
+		if segment.Representation.ID != nil {
+			repID = *segment.Representation.ID
+			values["representation"] = repID
+		}
+		if !start.IsZero() && start.After(wallClock.Add(ins.config.Tolerance)) {
+			values["availabilityStartTime"] = start.UTC().Format(time.RFC3339Nano)
+			setReport(&core.Report{
+				Name:     "AvailabilityWindowInspector",
+				Severity: core.Error,
+				Message:  "segment is listed before its availability start time",
+				Values:   values,
+			})
+		} else if !end.IsZero() && end.Before(wallClock.Add(-ins.config.Tolerance)) {
+			values["availabilityEndTime"] = end.UTC().Format(time.RFC3339Nano)
+			setReport(&core.Report{
+				Name:     "AvailabilityWindowInspector",
+				Severity: core.Warn,
+				Message:  "segment is listed after its availability end time",
+				Values:   values,
+			})
+		}
+
+		timescale := float64(segment.Timescale)
+		if timescale == 0 {
+			timescale = 1
+		}
+		sec := core.PeriodStart(segment.Period) + (float64(segment.Time)-float64(segment.PresentationTimeOffset))/timescale
+		segmentStart := availabilityStartTime.Add(time.Duration(sec * float64(time.Second)))
+		duration := time.Duration(float64(segment.Duration) / timescale * float64(time.Second))
+		window, ok := windows[repID]
+		if !ok {
+			window = &representationWindow{period: segment.Period}
+			windows[repID] = window
+		}
+		if window.earliest.IsZero() || segmentStart.Before(window.earliest) {
+			window.earliest = segmentStart
+		}
+		if duration > window.maxDuration {
+			window.maxDuration = duration
+		}
+		return true
+	})
+	if err == nil {
+		err = inspectErr
+	}
+	if err != nil {
+		return &core.Report{
+			Name:     "AvailabilityWindowInspector",
+			Severity: core.Error,
+			Message:  "failed to compute segment availability",
+			Values:   core.Values{"error": err},
+		}
+	}
+
+	if manifest.TimeShiftBufferDepth != nil && len(manifest.Periods) != 0 {
+		depth, err := mpd.ParseDuration(*manifest.TimeShiftBufferDepth)
+		if err != nil {
+			return &core.Report{
+				Name:     "AvailabilityWindowInspector",
+				Severity: core.Error,
+				Message:  "invalid MPD@timeShiftBufferDepth",
+				Values:   core.Values{"error": err},
+			}
+		}
+		// the DVR window can't be longer than the stream.
+		expectedStart := wallClock.Add(-depth)
+		streamStart := availabilityStartTime.Add(time.Duration(core.PeriodStart(manifest.Periods[0]) * float64(time.Second)))
+		if expectedStart.Before(streamStart) {
+			expectedStart = streamStart
+		}
+		repIDs := make([]string, 0, len(windows))
+		for repID := range windows {
+			repIDs = append(repIDs, repID)
+		}
+		sort.Strings(repIDs)
+		for _, repID := range repIDs {
+			window := windows[repID]
+			shortage := window.earliest.Sub(expectedStart)
+			if shortage <= window.maxDuration+ins.config.DVRWindowTolerance {
+				continue
+			}
+			values := core.Values{
+				"period":               window.period.ID,
+				"dvrWindow":            wallClock.Sub(window.earliest),
+				"timeShiftBufferDepth": depth,
+			}
+			if repID != "" {
+				values["representation"] = repID
+			}
+			setReport(&core.Report{
+				Name:     "AvailabilityWindowInspector",
+				Severity: core.Warn,
+				Message:  "DVR window is shorter than timeShiftBufferDepth",
+				Values:   values,
+			})
+		}
+	}
+
+	if report != nil {
+		return report
+	}
+	return &core.Report{
+		Name:     "AvailabilityWindowInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   core.Values{"wallClock": wallClock.UTC().Format(time.RFC3339Nano)},
+	}
+}
@@ -0,0 +1,111 @@
+package dash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestAvailabilityWindowInspector(t *testing.T) {
+	ins := NewAvailabilityWindowInspector()
+	availabilityStartTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	buildManifest := func(start uint64, repeat int) *core.Manifest {
+		return &core.Manifest{
+			Time: availabilityStartTime.Add(100 * time.Second),
+			MPD: &mpd.MPD{
+				Type:                  ptrs.Strptr("dynamic"),
+				AvailabilityStartTime: ptrs.Strptr("2023-01-01T00:00:00Z"),
+				TimeShiftBufferDepth:  ptrs.Strptr("PT30S"),
+				Periods: []*mpd.Period{{
+					ID: "1",
+					AdaptationSets: []*mpd.AdaptationSet{{
+						SegmentTemplate: &mpd.SegmentTemplate{
+							Timescale: ptrs.Int64ptr(1),
+							Media:     ptrs.Strptr("$Time$.mp4"),
+							SegmentTimeline: &mpd.SegmentTimeline{
+								Segments: []*mpd.SegmentTimelineSegment{
+									{StartTime: ptrs.Uint64ptr(start), Duration: 2, RepeatCount: ptrs.Intptr(repeat)},
+								},
+							},
+						},
+						Representations: []*mpd.Representation{{ID: ptrs.Strptr("video")}},
+					}},
+				}},
+			},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(70, 14), nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+	})
+
+	t.Run("before_availability_start_time", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(70, 16), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "segment is listed before its availability start time", report.Message)
+		assert.Equal(t, "video", report.Values["representation"])
+		assert.Equal(t, uint64(100), report.Values["time"])
+		assert.Equal(t, "2023-01-01T00:01:42Z", report.Values["availabilityStartTime"])
+	})
+
+	t.Run("after_availability_end_time", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(60, 19), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "segment is listed after its availability end time", report.Message)
+		assert.Equal(t, uint64(60), report.Values["time"])
+		assert.Equal(t, "2023-01-01T00:01:32Z", report.Values["availabilityEndTime"])
+	})
+
+	t.Run("short_dvr_window", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(80, 9), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "DVR window is shorter than timeShiftBufferDepth", report.Message)
+		assert.Equal(t, 20*time.Second, report.Values["dvrWindow"])
+		assert.Equal(t, 30*time.Second, report.Values["timeShiftBufferDepth"])
+	})
+
+	t.Run("beginning_of_stream", func(t *testing.T) {
+		manifest := buildManifest(0, 9)
+		manifest.Time = availabilityStartTime.Add(20 * time.Second)
+		report := ins.Inspect(manifest, nil)
+		require.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("availability_time_offset", func(t *testing.T) {
+		// the last segment ends at 102s and is available 4 seconds earlier.
+		availabilityTimeOffset := float32(4)
+		periodStart := 70 * time.Second
+		manifest := buildManifest(70, 0)
+		period := manifest.Periods[0]
+		period.Start = (*mpd.Duration)(&periodStart)
+		period.AdaptationSets[0].SegmentTemplate = nil
+		period.AdaptationSets[0].SegmentList = &mpd.SegmentList{
+			MultipleSegmentBase: mpd.MultipleSegmentBase{
+				SegmentBase: mpd.SegmentBase{
+					Timescale:              ptrs.Uint32ptr(1),
+					AvailabilityTimeOffset: &availabilityTimeOffset,
+				},
+				Duration: ptrs.Uint32ptr(2),
+			},
+		}
+		for i := 0; i < 16; i++ {
+			period.AdaptationSets[0].SegmentList.SegmentURLs = append(period.AdaptationSets[0].SegmentList.SegmentURLs,
+				&mpd.SegmentURL{Media: ptrs.Strptr("media.mp4")})
+		}
+		report := ins.Inspect(manifest, nil)
+		require.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("skip_vod_manifest", func(t *testing.T) {
+		report := ins.Inspect(&core.Manifest{MPD: &mpd.MPD{Type: ptrs.Strptr("static")}}, nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "skip VOD manifest", report.Message)
+	})
+}
@@ -0,0 +1,286 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// NewContentProtectionInspector returns ContentProtectionInspector.
+// It inspects that ContentProtection@cenc:default_KID in MPD is consistent with
+// tenc boxes in initialization segments and pssh contents.
+func NewContentProtectionInspector() core.DASHInspector {
+	return &contentProtectionInspector{}
+}
+
+type contentProtectionInspector struct{}
+
+func (ins *contentProtectionInspector) Inspect(manifest *core.Manifest, segments core.SegmentStore) *core.Report {
+	var report *core.Report
+	inspected := make(map[string]bool)
+	err := manifest.EachSegments(func(segment *core.DASHSegment) (cont bool) {
+		if !segment.Initialization {
+			return true
+		}
+		key := core.SegmentKey(segment.URL, segment.ByteRange)
+		if inspected[key] {
+			return true
+		}
+		data, ok := segments.LoadRange(segment.URL, segment.ByteRange)
+		if !ok {
+			return true
+		}
+		inspected[key] = true
+		if rep := inspectContentProtection(manifest, segment, data); rep != nil && (report == nil || rep.Severity.WorseThan(report.Severity)) {
+			report = rep
+		}
+		return true
+	})
+	if err != nil {
+		return &core.Report{
+			Name:     "ContentProtectionInspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	if report != nil {
+		return report
+	}
+	if len(inspected) == 0 {
+		return &core.Report{
+			Name:     "ContentProtectionInspector",
+			Severity: core.Info,
+			Message:  "no initialization segments",
+		}
+	}
+	return &core.Report{
+		Name:     "ContentProtectionInspector",
+		Severity: core.Info,
+		Message:  "good",
+	}
+}
+
+// mpdPSSH is cenc:pssh element in ContentProtection.
+type mpdPSSH struct {
+	schemeIDURI string
+	value       string
+}
+
+// inspectContentProtection inspects ContentProtection elements of the AdaptationSet and the Representation.
+// default_KID of the Representation takes precedence over the AdaptationSet.
+func inspectContentProtection(manifest *core.Manifest, segment *core.DASHSegment, data []byte) *core.Report {
+	values := core.Values{"url": segment.URL}
+	var defaultKID string
+	var mpdPSSHs []mpdPSSH
+	for _, cp := range manifest.ContentProtections(segment.AdaptationSet, segment.Representation) {
+		if defaultKID == "" && cp.DefaultKID != "" {
+			defaultKID = normalizeKID(cp.DefaultKID)
+		}
+		if cp.PSSH != "" && cp.SchemeIDURI != "" {
+			mpdPSSHs = append(mpdPSSHs, mpdPSSH{schemeIDURI: cp.SchemeIDURI, value: cp.PSSH})
+		}
+	}
+	if defaultKID != "" {
+		values["defaultKID"] = defaultKID
+		if _, err := hex.DecodeString(defaultKID); err != nil || len(defaultKID) != 32 {
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Error,
+				Message:  "invalid default_KID",
+				Values:   values,
+			}
+		}
+	}
+
+	tracks, err := mp4.ReadTrackEncryptions(data)
+	if err != nil {
+		values["error"] = err
+		return &core.Report{
+			Name:     "ContentProtectionInspector",
+			Severity: core.Error,
+			Message:  "failed to parse initialization segment",
+			Values:   values,
+		}
+	}
+	psshs, err := mp4.ReadPSSHs(data)
+	if err != nil {
+		values["error"] = err
+		return &core.Report{
+			Name:     "ContentProtectionInspector",
+			Severity: core.Error,
+			Message:  "failed to parse initialization segment",
+			Values:   values,
+		}
+	}
+
+	trackIDs := make([]uint32, 0, len(tracks))
+	for trackID := range tracks {
+		trackIDs = append(trackIDs, trackID)
+	}
+	sort.Slice(trackIDs, func(i, j int) bool { return trackIDs[i] < trackIDs[j] })
+	for _, trackID := range trackIDs {
+		tenc := tracks[trackID]
+		if !tenc.IsProtected {
+			continue
+		}
+		kid := hex.EncodeToString(tenc.KID)
+		if defaultKID == "" {
+			values["tenc"] = kid
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Warn,
+				Message:  "default_KID is omitted",
+				Values:   values,
+			}
+		} else if kid != defaultKID {
+			values["tenc"] = kid
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Error,
+				Message:  "default_KID mismatches tenc",
+				Values:   values,
+			}
+		}
+	}
+	if defaultKID == "" {
+		return nil
+	}
+	if len(tracks) == 0 {
+		return &core.Report{
+			Name:     "ContentProtectionInspector",
+			Severity: core.Error,
+			Message:  "tenc box is not found",
+			Values:   values,
+		}
+	}
+
+	for _, p := range mpdPSSHs {
+		pssh, err := decodeMPDPSSH(p.value)
+		if err != nil {
+			values["schemeIdUri"] = p.schemeIDURI
+			values["error"] = err
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Error,
+				Message:  "invalid cenc:pssh",
+				Values:   values,
+			}
+		}
+		systemID := hex.EncodeToString(pssh.SystemID)
+		if systemID != normalizeKID(strings.TrimPrefix(strings.ToLower(p.schemeIDURI), "urn:uuid:")) {
+			values["schemeIdUri"] = p.schemeIDURI
+			values["systemID"] = systemID
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Error,
+				Message:  "cenc:pssh mismatches schemeIdUri",
+				Values:   values,
+			}
+		}
+		psshs = append(psshs, pssh)
+	}
+	for _, pssh := range psshs {
+		if kids := psshKIDs(pssh); len(kids) != 0 && !containsKID(kids, defaultKID) {
+			values["systemID"] = hex.EncodeToString(pssh.SystemID)
+			return &core.Report{
+				Name:     "ContentProtectionInspector",
+				Severity: core.Error,
+				Message:  "pssh doesn't contain default_KID",
+				Values:   values,
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeKID(kid string) string {
+	return strings.ToLower(strings.ReplaceAll(kid, "-", ""))
+}
+
+func decodeMPDPSSH(value string) (*mp4.PSSH, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := mp4.ReadBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) != 1 || boxes[0].Type != "pssh" {
+		return nil, errors.New("not a pssh box")
+	}
+	return mp4.ParsePSSH(boxes[0])
+}
+
+// psshKIDs returns KIDs in the pssh box.
+// KIDs of version 0 box are read from the Widevine PSSH data.
+func psshKIDs(pssh *mp4.PSSH) [][]byte {
+	if len(pssh.KIDs) != 0 {
+		return pssh.KIDs
+	}
+	if hex.EncodeToString(pssh.SystemID) == mpd.CONTENT_PROTECTION_WIDEVINE_SCHEME_HEX {
+		return widevineKeyIDs(pssh.Data)
+	}
+	return nil
+}
+
+// widevineKeyIDs reads key_id fields (field number 2) of WidevinePsshData protobuf message.
+func widevineKeyIDs(data []byte) [][]byte {
+	var kids [][]byte
+	for len(data) != 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil
+		}
+		data = data[n:]
+		switch tag & 0x7 {
+		case 0:
+			_, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return nil
+			}
+			data = data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil
+			}
+			if tag>>3 == 2 {
+				kids = append(kids, data[n:n+int(size)])
+			}
+			data = data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return nil
+			}
+			data = data[4:]
+		default:
+			return nil
+		}
+	}
+	return kids
+}
+
+func containsKID(kids [][]byte, kid string) bool {
+	decoded, _ := hex.DecodeString(kid)
+	for _, k := range kids {
+		if bytes.Equal(k, decoded) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package dash
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/abema/antares/internal/segmenttest"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func protectedInit(kid []byte, psshs ...[]byte) []byte {
+	tenc := append([]byte{0, 0, 1, 8}, kid...)
+	trak := mp4test.Box("trak",
+		mp4test.FullBox("tkhd", 0, 0, mp4test.U32(0, 0, 1, 0, 0)),
+		mp4test.Box("mdia", mp4test.Box("minf", mp4test.Box("stbl", mp4test.FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, mp4test.Box("encv",
+			make([]byte, 78),
+			mp4test.Box("sinf",
+				mp4test.Box("frma", []byte("avc1")),
+				mp4test.FullBox("schm", 0, 0, []byte("cenc"), []byte{0, 1, 0, 0}),
+				mp4test.Box("schi", mp4test.FullBox("tenc", 0, 0, tenc)),
+			),
+		))))),
+	)
+	return mp4test.Box("moov", append([][]byte{trak}, psshs...)...)
+}
+
+func pssh(systemID string, kids [][]byte, data []byte) []byte {
+	var version uint8
+	var payload []byte
+	payload = append(payload, mp4test.MustDecodeHex(systemID)...)
+	if len(kids) != 0 {
+		version = 1
+		payload = append(payload, mp4test.U32(uint32(len(kids)))...)
+		for _, kid := range kids {
+			payload = append(payload, kid...)
+		}
+	}
+	payload = append(payload, mp4test.U32(uint32(len(data)))...)
+	payload = append(payload, data...)
+	return mp4test.FullBox("pssh", version, 0, payload)
+}
+
+func TestContentProtectionInspector(t *testing.T) {
+	kid := mp4test.MustDecodeHex("0123456789abcdef0123456789abcdef")
+	otherKID := mp4test.MustDecodeHex("fedcba9876543210fedcba9876543210")
+	manifest := func(defaultKID string, widevinePSSH []byte) *core.Manifest {
+		cps := []mpd.ContentProtectioner{}
+		if defaultKID != "" {
+			cps = append(cps, &mpd.CENCContentProtection{
+				ContentProtection: mpd.ContentProtection{
+					SchemeIDURI: ptrs.Strptr(mpd.CONTENT_PROTECTION_ROOT_SCHEME_ID_URI),
+				},
+				DefaultKID: ptrs.Strptr(defaultKID),
+			})
+		}
+		if widevinePSSH != nil {
+			cps = append(cps, &mpd.WidevineContentProtection{
+				ContentProtection: mpd.ContentProtection{
+					SchemeIDURI: ptrs.Strptr(mpd.CONTENT_PROTECTION_WIDEVINE_SCHEME_ID),
+				},
+				PSSH: ptrs.Strptr(base64.StdEncoding.EncodeToString(widevinePSSH)),
+			})
+		}
+		return &core.Manifest{
+			URL: "http://example.com/manifest.mpd",
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{{
+					AdaptationSets: []*mpd.AdaptationSet{{
+						CommonAttributesAndElements: mpd.CommonAttributesAndElements{
+							ContentProtection: cps,
+						},
+						SegmentTemplate: &mpd.SegmentTemplate{
+							Initialization: ptrs.Strptr("init.mp4"),
+							Media:          ptrs.Strptr("$Time$.mp4"),
+							SegmentTimeline: &mpd.SegmentTimeline{
+								Segments: []*mpd.SegmentTimelineSegment{{Duration: 90000}},
+							},
+						},
+						Representations: []*mpd.Representation{{}},
+					}},
+				}},
+			},
+		}
+	}
+	// WidevinePsshData with key_id field
+	widevineData := append([]byte{0x12, 0x10}, kid...)
+	const initURL = "http://example.com/init.mp4"
+
+	testCases := []struct {
+		name     string
+		manifest *core.Manifest
+		init     []byte
+		severity core.Severity
+		message  string
+	}{
+		{
+			name:     "ok",
+			manifest: manifest("01234567-89AB-CDEF-0123-456789ABCDEF", pssh(mpd.CONTENT_PROTECTION_WIDEVINE_SCHEME_HEX, nil, widevineData)),
+			init:     protectedInit(kid, pssh(mpd.CONTENT_PROTECTION_PLAYREADY_SCHEME_HEX, [][]byte{kid}, nil)),
+			severity: core.Info,
+			message:  "good",
+		},
+		{
+			name:     "no_init",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", nil),
+			severity: core.Info,
+			message:  "no initialization segments",
+		},
+		{
+			name:     "omitted",
+			manifest: manifest("", nil),
+			init:     protectedInit(kid),
+			severity: core.Warn,
+			message:  "default_KID is omitted",
+		},
+		{
+			name:     "invalid_default_kid",
+			manifest: manifest("0123", nil),
+			init:     protectedInit(kid),
+			severity: core.Error,
+			message:  "invalid default_KID",
+		},
+		{
+			name:     "tenc_mismatch",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", nil),
+			init:     protectedInit(otherKID),
+			severity: core.Error,
+			message:  "default_KID mismatches tenc",
+		},
+		{
+			name:     "no_tenc",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", nil),
+			init:     mp4test.Box("moov"),
+			severity: core.Error,
+			message:  "tenc box is not found",
+		},
+		{
+			name:     "init_pssh_mismatch",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", nil),
+			init:     protectedInit(kid, pssh(mpd.CONTENT_PROTECTION_PLAYREADY_SCHEME_HEX, [][]byte{otherKID}, nil)),
+			severity: core.Error,
+			message:  "pssh doesn't contain default_KID",
+		},
+		{
+			name:     "mpd_pssh_mismatch",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", pssh(mpd.CONTENT_PROTECTION_WIDEVINE_SCHEME_HEX, nil, append([]byte{0x12, 0x10}, otherKID...))),
+			init:     protectedInit(kid),
+			severity: core.Error,
+			message:  "pssh doesn't contain default_KID",
+		},
+		{
+			name:     "mpd_pssh_system_id",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", pssh(mpd.CONTENT_PROTECTION_PLAYREADY_SCHEME_HEX, nil, nil)),
+			init:     protectedInit(kid),
+			severity: core.Error,
+			message:  "cenc:pssh mismatches schemeIdUri",
+		},
+		{
+			name:     "mpd_pssh_invalid",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", []byte("foo")),
+			init:     protectedInit(kid),
+			severity: core.Error,
+			message:  "invalid cenc:pssh",
+		},
+		{
+			name:     "parse_error",
+			manifest: manifest("01234567-89ab-cdef-0123-456789abcdef", nil),
+			init:     []byte{0, 0, 0, 100, 'm', 'o', 'o', 'v'},
+			severity: core.Error,
+			message:  "failed to parse initialization segment",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := segmenttest.SegmentStore{}
+			if tc.init != nil {
+				store[initURL] = tc.init
+			}
+			report := NewContentProtectionInspector().Inspect(tc.manifest, store)
+			require.Equal(t, tc.severity, report.Severity)
+			require.Equal(t, tc.message, report.Message)
+		})
+	}
+}
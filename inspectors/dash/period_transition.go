@@ -0,0 +1,373 @@
+package dash
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+const (
+	periodContinuityScheme   = "urn:mpeg:dash:period-continuity:2015"
+	periodConnectivityScheme = "urn:mpeg:dash:period-connectivity:2015"
+)
+
+type PeriodTransitionInspectorConfig struct {
+	// Tolerance is the allowable difference between period boundaries and segment boundaries.
+	Tolerance time.Duration
+}
+
+func DefaultPeriodTransitionInspectorConfig() *PeriodTransitionInspectorConfig {
+	return &PeriodTransitionInspectorConfig{
+		Tolerance: 100 * time.Millisecond,
+	}
+}
+
+// NewPeriodTransitionInspector returns PeriodTransitionInspector.
+// It inspects that periods are contiguous and filled with segments,
+// that Period@id and Period@start are stable between manifest updates,
+// that period-continuity and period-connectivity descriptors refer to the consistent AdaptationSet,
+// and that presentationTimeOffset matches the first segment time.
+func NewPeriodTransitionInspector() core.DASHInspector {
+	return NewPeriodTransitionInspectorWithConfig(DefaultPeriodTransitionInspectorConfig())
+}
+
+func NewPeriodTransitionInspectorWithConfig(config *PeriodTransitionInspectorConfig) core.DASHInspector {
+	return &periodTransitionInspector{
+		config: config,
+	}
+}
+
+type periodTransitionInspector struct {
+	config *PeriodTransitionInspectorConfig
+	// periodStarts holds Period@start of the last manifest by Period@id.
+	periodStarts map[string]float64
+}
+
+// periodSegments is the first and the last media segments of the representation in the period.
+type periodSegments struct {
+	period         *mpd.Period
+	adaptationSet  *mpd.AdaptationSet
+	representation *mpd.Representation
+	first          *core.DASHSegment
+	last           *core.DASHSegment
+}
+
+func (s *periodSegments) values() core.Values {
+	values := core.Values{"period": s.period.ID}
+	if s.representation.ID != nil {
+		values["representation"] = *s.representation.ID
+	}
+	return values
+}
+
+// segmentSeconds returns the time of the segment in seconds relative to the start of the period.
+func segmentSeconds(segment *core.DASHSegment, t uint64) float64 {
+	timescale := float64(segment.Timescale)
+	if timescale == 0 {
+		timescale = 1
+	}
+	return (float64(t) - float64(segment.PresentationTimeOffset)) / timescale
+}
+
+func (ins *periodTransitionInspector) Inspect(manifest *core.Manifest, _ core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if rep != nil && (report == nil || rep.Severity.WorseThan(report.Severity)) {
+			report = rep
+		}
+	}
+	tolerance := ins.config.Tolerance.Seconds()
+	dynamic := manifest.Type != nil && *manifest.Type == "dynamic"
+
+	// period boundaries
+	for i, period := range manifest.Periods {
+		if dynamic && period.ID == "" {
+			setReport(&core.Report{
+				Name:     "PeriodTransitionInspector",
+				Severity: core.Error,
+				Message:  "Period@id is omitted in dynamic manifest",
+				Values:   core.Values{"index": i},
+			})
+		}
+		if i+1 >= len(manifest.Periods) {
+			break
+		}
+		next := manifest.Periods[i+1]
+		if period.Start == nil || period.Duration == 0 || next.Start == nil {
+			continue
+		}
+		end := core.PeriodStart(period) + time.Duration(period.Duration).Seconds()
+		if math.Abs(end-core.PeriodStart(next)) > tolerance {
+			setReport(&core.Report{
+				Name:     "PeriodTransitionInspector",
+				Severity: core.Error,
+				Message:  "Period@start + Period@duration mismatches next Period@start",
+				Values: core.Values{
+					"period":    period.ID,
+					"end":       end,
+					"nextStart": core.PeriodStart(next),
+				},
+			})
+		}
+	}
+
+	// stability of Period@id and Period@start
+	periodStarts := make(map[string]float64, len(manifest.Periods))
+	for _, period := range manifest.Periods {
+		if period.ID == "" || period.Start == nil {
+			continue
+		}
+		start := core.PeriodStart(period)
+		periodStarts[period.ID] = start
+		if prev, ok := ins.periodStarts[period.ID]; ok {
+			if math.Abs(prev-start) > tolerance {
+				setReport(&core.Report{
+					Name:     "PeriodTransitionInspector",
+					Severity: core.Error,
+					Message:  "Period@start is changed from previous manifest",
+					Values:   core.Values{"period": period.ID, "previous": prev, "start": start},
+				})
+			}
+			continue
+		}
+		for id, prev := range ins.periodStarts {
+			if math.Abs(prev-start) <= tolerance && manifestHasNoPeriod(manifest, id) {
+				setReport(&core.Report{
+					Name:     "PeriodTransitionInspector",
+					Severity: core.Error,
+					Message:  "Period@id is changed from previous manifest",
+					Values:   core.Values{"period": period.ID, "previous": id, "start": start},
+				})
+				break
+			}
+		}
+	}
+	ins.periodStarts = periodStarts
+
+	// segments in each period
+	segmentsList := make([]*periodSegments, 0)
+	err := manifest.EachSegments(func(segment *core.DASHSegment) bool {
+		if segment.Initialization {
+			return true
+		}
+		var last *periodSegments
+		if len(segmentsList) != 0 {
+			last = segmentsList[len(segmentsList)-1]
+		}
+		if last == nil || last.period != segment.Period || last.representation != segment.Representation {
+			last = &periodSegments{
+				period:         segment.Period,
+				adaptationSet:  segment.AdaptationSet,
+				representation: segment.Representation,
+				first:          segment,
+			}
+			segmentsList = append(segmentsList, last)
+		}
+		last.last = segment
+		return true
+	})
+	if err != nil {
+		return &core.Report{
+			Name:     "PeriodTransitionInspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	for _, segments := range segmentsList {
+		index := periodIndex(manifest, segments.period)
+		// the first segment of the period is listed unless the beginning of the period is out of the DVR window.
+		if !dynamic || index > 0 {
+			if start := segmentSeconds(segments.first, segments.first.Time); math.Abs(start) > tolerance {
+				values := segments.values()
+				values["presentationTimeOffset"] = segments.first.PresentationTimeOffset
+				values["time"] = segments.first.Time
+				setReport(&core.Report{
+					Name:     "PeriodTransitionInspector",
+					Severity: core.Error,
+					Message:  "presentationTimeOffset mismatches the first segment time",
+					Values:   values,
+				})
+			}
+		}
+		// the period which is followed by another period must be filled with segments.
+		if index+1 < len(manifest.Periods) {
+			duration, ok := knownPeriodDuration(manifest, index)
+			end := segmentSeconds(segments.last, segments.last.Time+segments.last.Duration)
+			if ok && math.Abs(end-duration) > tolerance {
+				values := segments.values()
+				values["duration"] = duration
+				values["end"] = end
+				setReport(&core.Report{
+					Name:     "PeriodTransitionInspector",
+					Severity: core.Warn,
+					Message:  "segments don't fill Period",
+					Values:   values,
+				})
+			}
+		}
+	}
+
+	// period-continuity and period-connectivity
+	for index, period := range manifest.Periods {
+		for _, as := range period.AdaptationSets {
+			setReport(ins.inspectContinuity(manifest, index, as, segmentsList))
+		}
+	}
+
+	if report != nil {
+		return report
+	}
+	return &core.Report{
+		Name:     "PeriodTransitionInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   core.Values{"periods": len(manifest.Periods)},
+	}
+}
+
+func (ins *periodTransitionInspector) inspectContinuity(manifest *core.Manifest, index int, as *mpd.AdaptationSet, segmentsList []*periodSegments) *core.Report {
+	period := manifest.Periods[index]
+	for _, prop := range as.SupplementalProperty {
+		if prop.SchemeIDURI == nil || (*prop.SchemeIDURI != periodContinuityScheme && *prop.SchemeIDURI != periodConnectivityScheme) {
+			continue
+		}
+		scheme := *prop.SchemeIDURI
+		var value string
+		if prop.Value != nil {
+			value = *prop.Value
+		}
+		values := core.Values{"period": period.ID, "schemeIdUri": scheme, "value": value}
+		if as.ID != nil {
+			values["adaptationSet"] = *as.ID
+		}
+		if index == 0 || manifest.Periods[index-1].ID != value {
+			return &core.Report{
+				Name:     "PeriodTransitionInspector",
+				Severity: core.Error,
+				Message:  "descriptor doesn't refer to the previous Period",
+				Values:   values,
+			}
+		}
+		prevAS := findAdaptationSet(manifest.Periods[index-1], as.ID)
+		if prevAS == nil {
+			return &core.Report{
+				Name:     "PeriodTransitionInspector",
+				Severity: core.Error,
+				Message:  "AdaptationSet of previous Period is not found",
+				Values:   values,
+			}
+		}
+		if mimeTypeOf(as) != mimeTypeOf(prevAS) || codecsOf(as) != codecsOf(prevAS) {
+			values["codecs"] = codecsOf(as)
+			values["previousCodecs"] = codecsOf(prevAS)
+			return &core.Report{
+				Name:     "PeriodTransitionInspector",
+				Severity: core.Warn,
+				Message:  "AdaptationSet mismatches AdaptationSet of previous Period",
+				Values:   values,
+			}
+		}
+		if scheme != periodContinuityScheme {
+			continue
+		}
+		// media time continues across the period boundary.
+		for _, segments := range segmentsList {
+			if segments.adaptationSet != as || segments.representation.ID == nil {
+				continue
+			}
+			for _, prev := range segmentsList {
+				if prev.adaptationSet != prevAS || prev.representation.ID == nil || *prev.representation.ID != *segments.representation.ID {
+					continue
+				}
+				if prev.last.Timescale != segments.first.Timescale ||
+					prev.last.Time+prev.last.Duration != segments.first.Time {
+					values := segments.values()
+					values["adaptationSet"] = *as.ID
+					values["previousEnd"] = prev.last.Time + prev.last.Duration
+					values["time"] = segments.first.Time
+					return &core.Report{
+						Name:     "PeriodTransitionInspector",
+						Severity: core.Error,
+						Message:  "media time is not continuous across periods",
+						Values:   values,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func manifestHasNoPeriod(manifest *core.Manifest, id string) bool {
+	for _, period := range manifest.Periods {
+		if period.ID == id {
+			return false
+		}
+	}
+	return true
+}
+
+func periodIndex(manifest *core.Manifest, period *mpd.Period) int {
+	for i, p := range manifest.Periods {
+		if p == period {
+			return i
+		}
+	}
+	return -1
+}
+
+// knownPeriodDuration returns the duration of the period from Period@duration or the start of the next period.
+func knownPeriodDuration(manifest *core.Manifest, index int) (float64, bool) {
+	period := manifest.Periods[index]
+	if period.Duration != 0 {
+		return time.Duration(period.Duration).Seconds(), true
+	}
+	if index+1 < len(manifest.Periods) && manifest.Periods[index+1].Start != nil {
+		return core.PeriodStart(manifest.Periods[index+1]) - core.PeriodStart(period), true
+	}
+	return 0, false
+}
+
+func findAdaptationSet(period *mpd.Period, id *string) *mpd.AdaptationSet {
+	if id == nil {
+		return nil
+	}
+	for _, as := range period.AdaptationSets {
+		if as.ID != nil && *as.ID == *id {
+			return as
+		}
+	}
+	return nil
+}
+
+func mimeTypeOf(as *mpd.AdaptationSet) string {
+	if as.MimeType != nil {
+		return *as.MimeType
+	}
+	for _, rep := range as.Representations {
+		if rep.MimeType != nil {
+			return *rep.MimeType
+		}
+	}
+	return ""
+}
+
+// codecsOf returns AdaptationSet@codecs or sorted codecs of representations.
+func codecsOf(as *mpd.AdaptationSet) string {
+	if as.Codecs != nil {
+		return *as.Codecs
+	}
+	codecs := make([]string, 0, len(as.Representations))
+	for _, rep := range as.Representations {
+		if rep.Codecs != nil {
+			codecs = append(codecs, *rep.Codecs)
+		}
+	}
+	sort.Strings(codecs)
+	return strings.Join(codecs, ",")
+}
@@ -0,0 +1,155 @@
+package dash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestPeriodTransitionInspector(t *testing.T) {
+	duration := func(d time.Duration) *mpd.Duration {
+		md := mpd.Duration(d)
+		return &md
+	}
+	period := func(id string, start time.Duration, pto uint64, segments ...*mpd.SegmentTimelineSegment) *mpd.Period {
+		return &mpd.Period{
+			ID:    id,
+			Start: duration(start),
+			AdaptationSets: []*mpd.AdaptationSet{{
+				ID: ptrs.Strptr("video"),
+				CommonAttributesAndElements: mpd.CommonAttributesAndElements{
+					MimeType: ptrs.Strptr("video/mp4"),
+					Codecs:   ptrs.Strptr("avc1.64001f"),
+				},
+				Representations: []*mpd.Representation{{
+					ID: ptrs.Strptr("video1"),
+					SegmentTemplate: &mpd.SegmentTemplate{
+						Timescale:              ptrs.Int64ptr(90000),
+						PresentationTimeOffset: ptrs.Uint64ptr(pto),
+						Media:                  ptrs.Strptr("$Time$.mp4"),
+						SegmentTimeline:        &mpd.SegmentTimeline{Segments: segments},
+					},
+				}},
+			}},
+		}
+	}
+	buildManifest := func(periods ...*mpd.Period) *core.Manifest {
+		return &core.Manifest{
+			MPD: &mpd.MPD{
+				Type:    ptrs.Strptr("static"),
+				Periods: periods,
+			},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		report := ins.Inspect(buildManifest(
+			period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+			period("2", 10*time.Second, 900000, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(900000), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+	})
+
+	t.Run("error/period duration", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		p1 := period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)})
+		p1.Duration = mpd.Duration(8 * time.Second)
+		report := ins.Inspect(buildManifest(
+			p1,
+			period("2", 10*time.Second, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "Period@start + Period@duration mismatches next Period@start", report.Message)
+		assert.Equal(t, 8.0, report.Values["end"])
+		assert.Equal(t, 10.0, report.Values["nextStart"])
+	})
+
+	t.Run("warn/segments don't fill period", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		report := ins.Inspect(buildManifest(
+			period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(3)}),
+			period("2", 10*time.Second, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "segments don't fill Period", report.Message)
+		assert.Equal(t, 8.0, report.Values["end"])
+		assert.Equal(t, 10.0, report.Values["duration"])
+	})
+
+	t.Run("error/presentationTimeOffset", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		report := ins.Inspect(buildManifest(
+			period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+			period("2", 10*time.Second, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(900000), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "presentationTimeOffset mismatches the first segment time", report.Message)
+		assert.Equal(t, "2", report.Values["period"])
+		assert.Equal(t, uint64(900000), report.Values["time"])
+	})
+
+	t.Run("error/period id changed", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		report := ins.Inspect(buildManifest(
+			period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+		report = ins.Inspect(buildManifest(
+			period("a", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "Period@id is changed from previous manifest", report.Message)
+		assert.Equal(t, "1", report.Values["previous"])
+	})
+
+	t.Run("error/period start changed", func(t *testing.T) {
+		ins := NewPeriodTransitionInspector()
+		report := ins.Inspect(buildManifest(
+			period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+		report = ins.Inspect(buildManifest(
+			period("1", 2*time.Second, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "Period@start is changed from previous manifest", report.Message)
+	})
+
+	t.Run("period-continuity", func(t *testing.T) {
+		build := func(value string, codecs string, start uint64) *core.Manifest {
+			p2 := period("2", 10*time.Second, start, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(start), Duration: 180000, RepeatCount: ptrs.Intptr(4)})
+			p2.AdaptationSets[0].Codecs = ptrs.Strptr(codecs)
+			p2.AdaptationSets[0].SupplementalProperty = []mpd.DescriptorType{{
+				SchemeIDURI: ptrs.Strptr("urn:mpeg:dash:period-continuity:2015"),
+				Value:       ptrs.Strptr(value),
+			}}
+			return buildManifest(
+				period("1", 0, 0, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+				p2,
+			)
+		}
+
+		report := NewPeriodTransitionInspector().Inspect(build("1", "avc1.64001f", 900000), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		report = NewPeriodTransitionInspector().Inspect(build("0", "avc1.64001f", 900000), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "descriptor doesn't refer to the previous Period", report.Message)
+
+		report = NewPeriodTransitionInspector().Inspect(build("1", "avc1.640028", 900000), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "AdaptationSet mismatches AdaptationSet of previous Period", report.Message)
+
+		report = NewPeriodTransitionInspector().Inspect(build("1", "avc1.64001f", 0), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "media time is not continuous across periods", report.Message)
+		assert.Equal(t, uint64(900000), report.Values["previousEnd"])
+	})
+}
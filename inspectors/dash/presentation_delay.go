@@ -45,7 +45,9 @@ func (ins *PresentationDelayInspector) Inspect(manifest *core.Manifest, segments
 
 	// get wall-clock
 	wallClock := time.Now()
-	if manifest.UTCTiming != nil && manifest.UTCTiming.SchemeIDURI != nil && *manifest.UTCTiming.SchemeIDURI == "urn:mpeg:dash:utc:direct:2014" {
+	if manifest.Timing != nil {
+		wallClock = manifest.Timing.Time
+	} else if manifest.UTCTiming != nil && manifest.UTCTiming.SchemeIDURI != nil && *manifest.UTCTiming.SchemeIDURI == "urn:mpeg:dash:utc:direct:2014" {
 		tm, err := time.Parse(time.RFC3339Nano, *manifest.UTCTiming.Value)
 		if err != nil {
 			return &core.Report{
@@ -101,14 +103,8 @@ func (ins *PresentationDelayInspector) Inspect(manifest *core.Manifest, segments
 		if segment.Period.Start != nil {
 			periodStart = time.Duration(*segment.Period.Start).Seconds()
 		}
-		var offset uint64
-		if segment.SegmentTemplate.PresentationTimeOffset != nil {
-			offset = *segment.SegmentTemplate.PresentationTimeOffset
-		}
-		timescale := float64(1)
-		if segment.SegmentTemplate.Timescale != nil {
-			timescale = float64(*segment.SegmentTemplate.Timescale)
-		}
+		offset := segment.PresentationTimeOffset
+		timescale := float64(segment.Timescale)
 		s := int64(segment.Time) - int64(offset)
 		fs := periodStart + float64(s)/timescale
 		ts := availabilityStartTime.Add(time.Duration(fs*1e9) * time.Nanosecond)
@@ -138,6 +134,10 @@ func (ins *PresentationDelayInspector) Inspect(manifest *core.Manifest, segments
 		"wallClock":                  wallClock.UTC().Format(time.RFC3339Nano),
 		"suggestedPresentationDelay": suggestedPresentationDelay,
 	}
+	if manifest.Timing != nil {
+		values["clockOffset"] = manifest.Timing.ClockOffset
+		values["utcTimingScheme"] = manifest.Timing.SchemeIDURI
+	}
 	earliestRenderTime := earliestVideoTime.Add(suggestedPresentationDelay)
 	latestRenderTime := latestVideoTime.Add(suggestedPresentationDelay)
 	if !ins.config.IgnoreEarliestSegment {
@@ -64,6 +64,24 @@ func TestPresentationDelayInspector(t *testing.T) {
 		assert.Equal(t, "good", report.Message)
 	})
 
+	t.Run("ok/resolved_timing", func(t *testing.T) {
+		manifest := buildManifest(7 * time.Second)
+		manifest.UTCTiming = nil
+		manifest.PublishTime = nil
+		manifest.Timing = &core.DASHTiming{
+			SchemeIDURI: "urn:mpeg:dash:utc:http-xsdate:2014",
+			Value:       "https://localhost/time",
+			Time:        time.Date(2023, 1, 1, 6, 0, 36, 0, time.UTC),
+			ClockOffset: 1500 * time.Millisecond,
+		}
+		report := ins.Inspect(manifest, nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, "2023-01-01T06:00:36Z", report.Values["wallClock"])
+		assert.Equal(t, 1500*time.Millisecond, report.Values["clockOffset"])
+		assert.Equal(t, "urn:mpeg:dash:utc:http-xsdate:2014", report.Values["utcTimingScheme"])
+	})
+
 	t.Run("warn/presentation_time_is_new", func(t *testing.T) {
 		report := ins.Inspect(buildManifest(5*time.Second), nil)
 		require.Equal(t, core.Warn, report.Severity)
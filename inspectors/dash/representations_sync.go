@@ -0,0 +1,163 @@
+package dash
+
+import (
+	"math"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+type RepresentationsSyncInspectorConfig struct {
+	WarnSegmentDurationDiff  time.Duration
+	ErrorSegmentDurationDiff time.Duration
+	WarnLatestTimeDiff       time.Duration
+	ErrorLatestTimeDiff      time.Duration
+}
+
+func DefaultRepresentationsSyncInspectorConfig() *RepresentationsSyncInspectorConfig {
+	return &RepresentationsSyncInspectorConfig{
+		WarnSegmentDurationDiff:  500 * time.Millisecond,
+		ErrorSegmentDurationDiff: 1000 * time.Millisecond,
+		WarnLatestTimeDiff:       4 * time.Second,
+		ErrorLatestTimeDiff:      8 * time.Second,
+	}
+}
+
+// NewRepresentationsSyncInspector returns RepresentationsSyncInspector.
+// It inspects synchronization of representations.
+// Segment durations are compared in each AdaptationSet,
+// and the latest segment end times are compared across all AdaptationSets.
+func NewRepresentationsSyncInspector() core.DASHInspector {
+	return NewRepresentationsSyncInspectorWithConfig(DefaultRepresentationsSyncInspectorConfig())
+}
+
+func NewRepresentationsSyncInspectorWithConfig(config *RepresentationsSyncInspectorConfig) core.DASHInspector {
+	return &representationsSyncInspector{
+		config: config,
+	}
+}
+
+type representationsSyncInspector struct {
+	config *RepresentationsSyncInspectorConfig
+}
+
+func (ins *representationsSyncInspector) Inspect(manifest *core.Manifest, _ core.SegmentStore) *core.Report {
+	type SegmentKey struct {
+		AdaptationSet *mpd.AdaptationSet
+		// Time is the start time of the segment in milliseconds.
+		Time int64
+	}
+	type SegmentValue struct {
+		MaxDuration float64
+		MinDuration float64
+	}
+	segmentMap := make(map[SegmentKey]SegmentValue)
+	type LatestValue struct {
+		Period  *mpd.Period
+		EndTime float64
+	}
+	latestMap := make(map[*mpd.Representation]LatestValue)
+	err := manifest.EachSegments(func(segment *core.DASHSegment) bool {
+		if segment.Initialization {
+			return true
+		}
+		timescale := float64(segment.Timescale)
+		if timescale == 0 {
+			timescale = 1
+		}
+		start := core.PeriodStart(segment.Period) + (float64(segment.Time)-float64(segment.PresentationTimeOffset))/timescale
+		duration := float64(segment.Duration) / timescale
+		skey := SegmentKey{
+			AdaptationSet: segment.AdaptationSet,
+			Time:          int64(math.Round(start * 1000)),
+		}
+		sval, ok := segmentMap[skey]
+		if !ok || duration > sval.MaxDuration {
+			sval.MaxDuration = duration
+		}
+		if !ok || duration < sval.MinDuration {
+			sval.MinDuration = duration
+		}
+		segmentMap[skey] = sval
+		if end := start + duration; end > latestMap[segment.Representation].EndTime {
+			latestMap[segment.Representation] = LatestValue{Period: segment.Period, EndTime: end}
+		}
+		return true
+	})
+	if err != nil {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	if len(latestMap) == 0 {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Info,
+			Message:  "no segments",
+		}
+	}
+
+	var maxDurDiff float64
+	for _, sval := range segmentMap {
+		durDiff := sval.MaxDuration - sval.MinDuration
+		if durDiff > maxDurDiff {
+			maxDurDiff = durDiff
+		}
+	}
+	// representations in the period which has the latest segment are compared.
+	var latest LatestValue
+	for _, lval := range latestMap {
+		if lval.EndTime > latest.EndTime {
+			latest = lval
+		}
+	}
+	var timeDiff float64
+	for _, lval := range latestMap {
+		if lval.Period == latest.Period && latest.EndTime-lval.EndTime > timeDiff {
+			timeDiff = latest.EndTime - lval.EndTime
+		}
+	}
+	values := core.Values{"durDiff": maxDurDiff, "timeDiff": timeDiff}
+	if ins.config.ErrorSegmentDurationDiff != 0 && maxDurDiff >= ins.config.ErrorSegmentDurationDiff.Seconds() {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Error,
+			Message:  "large duration difference",
+			Values:   values,
+		}
+	}
+	if ins.config.ErrorLatestTimeDiff != 0 && timeDiff >= ins.config.ErrorLatestTimeDiff.Seconds() {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Error,
+			Message:  "large latest time difference",
+			Values:   values,
+		}
+	}
+	if ins.config.WarnSegmentDurationDiff != 0 && maxDurDiff >= ins.config.WarnSegmentDurationDiff.Seconds() {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Warn,
+			Message:  "large duration difference",
+			Values:   values,
+		}
+	}
+	if ins.config.WarnLatestTimeDiff != 0 && timeDiff >= ins.config.WarnLatestTimeDiff.Seconds() {
+		return &core.Report{
+			Name:     "RepresentationsSyncInspector",
+			Severity: core.Warn,
+			Message:  "large latest time difference",
+			Values:   values,
+		}
+	}
+	return &core.Report{
+		Name:     "RepresentationsSyncInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   values,
+	}
+}
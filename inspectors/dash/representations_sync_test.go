@@ -0,0 +1,110 @@
+package dash
+
+import (
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestRepresentationsSyncInspector(t *testing.T) {
+	representation := func(id string, timescale int64, segments ...*mpd.SegmentTimelineSegment) *mpd.Representation {
+		return &mpd.Representation{
+			ID: ptrs.Strptr(id),
+			SegmentTemplate: &mpd.SegmentTemplate{
+				Timescale:       ptrs.Int64ptr(timescale),
+				Media:           ptrs.Strptr(id + "/$Time$.mp4"),
+				SegmentTimeline: &mpd.SegmentTimeline{Segments: segments},
+			},
+		}
+	}
+	buildManifest := func(video2, audio []*mpd.SegmentTimelineSegment) *core.Manifest {
+		return &core.Manifest{
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{{
+					ID: "1",
+					AdaptationSets: []*mpd.AdaptationSet{
+						{
+							Representations: []*mpd.Representation{
+								representation("video1", 90000, &mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}),
+								representation("video2", 90000, video2...),
+							},
+						},
+						{
+							Representations: []*mpd.Representation{
+								representation("audio", 48000, audio...),
+							},
+						},
+					},
+				}},
+			},
+		}
+	}
+	ins := NewRepresentationsSyncInspector()
+
+	t.Run("ok", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}},
+			// audio segments are shorter than video segments.
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 92160, RepeatCount: ptrs.Intptr(4)}},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.InDelta(t, 0.4, report.Values["timeDiff"], 1e-9)
+	})
+
+	t.Run("warn/duration", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			[]*mpd.SegmentTimelineSegment{
+				{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(2)},
+				{Duration: 135000},
+				{Duration: 225000},
+			},
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 96000, RepeatCount: ptrs.Intptr(4)}},
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "large duration difference", report.Message)
+		assert.InDelta(t, 0.5, report.Values["durDiff"], 1e-9)
+	})
+
+	t.Run("error/duration", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			[]*mpd.SegmentTimelineSegment{
+				{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(2)},
+				{Duration: 90000},
+				{Duration: 270000},
+			},
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 96000, RepeatCount: ptrs.Intptr(4)}},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "large duration difference", report.Message)
+	})
+
+	t.Run("warn/latest_time", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(4)}},
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 96000, RepeatCount: ptrs.Intptr(2)}},
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "large latest time difference", report.Message)
+		assert.InDelta(t, 4.0, report.Values["timeDiff"], 1e-9)
+	})
+
+	t.Run("error/latest_time", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 180000}},
+			[]*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 96000, RepeatCount: ptrs.Intptr(4)}},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "large latest time difference", report.Message)
+	})
+
+	t.Run("no_segments", func(t *testing.T) {
+		report := ins.Inspect(&core.Manifest{MPD: &mpd.MPD{}}, nil)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "no segments", report.Message)
+	})
+}
@@ -0,0 +1,492 @@
+package dash
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4"
+	"github.com/abema/antares/internal/scte35"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+const (
+	scte35SchemeXML    = "urn:scte:scte35:2013:xml"
+	scte35SchemeBin    = "urn:scte:scte35:2013:bin"
+	scte35SchemeXMLBin = "urn:scte:scte35:2014:xml+bin"
+
+	// emsgUnknownDuration is emsg event_duration which means the duration is unknown.
+	emsgUnknownDuration = 0xffffffff
+)
+
+type SCTE35InspectorConfig struct {
+	// Tolerance is the allowable difference between durations of events and breaks.
+	Tolerance time.Duration
+}
+
+func DefaultSCTE35InspectorConfig() *SCTE35InspectorConfig {
+	return &SCTE35InspectorConfig{
+		Tolerance: 500 * time.Millisecond,
+	}
+}
+
+// NewSCTE35Inspector returns SCTE35Inspector.
+// It decodes SCTE-35 messages in EventStream elements and in-band emsg boxes,
+// and inspects pairing of splice out and splice return, durations of breaks and uniqueness of event IDs.
+// The next scheduled break is reported in values.
+func NewSCTE35Inspector() core.DASHInspector {
+	return NewSCTE35InspectorWithConfig(DefaultSCTE35InspectorConfig())
+}
+
+func NewSCTE35InspectorWithConfig(config *SCTE35InspectorConfig) core.DASHInspector {
+	return &scte35Inspector{
+		config: config,
+	}
+}
+
+type scte35Inspector struct {
+	config *SCTE35InspectorConfig
+}
+
+// scte35Cue is a SCTE-35 message carried by an Event element or an emsg box.
+type scte35Cue struct {
+	// source is "EventStream" or "emsg".
+	source      string
+	period      *mpd.Period
+	schemeIDURI string
+	value       string
+	id          string
+	// time is the presentation time of the event in seconds.
+	time float64
+	// duration is the duration of the event in seconds. It is negative when the duration is unknown.
+	duration float64
+	message  []byte
+	info     *scte35.SpliceInfo
+}
+
+func (c *scte35Cue) values() core.Values {
+	return core.Values{
+		"source":      c.source,
+		"period":      c.period.ID,
+		"schemeIdUri": c.schemeIDURI,
+		"id":          c.id,
+		"time":        c.time,
+	}
+}
+
+// spliceInsertType is the type of scte35Signal for splice_insert.
+const spliceInsertType = -1
+
+// scte35Signal is a splice out or a splice return signaled by splice_insert or segmentation_descriptor.
+// Splice outs and splice returns are paired by the type and the event ID.
+type scte35Signal struct {
+	// typeID is segmentation_type_id of the start, or spliceInsertType.
+	typeID int
+	// eventID is splice_event_id or segmentation_event_id.
+	eventID uint32
+	// duration is the duration of the break in seconds. It is negative when the duration is not signaled.
+	duration float64
+}
+
+// outs returns signals which start breaks.
+func (c *scte35Cue) outs() []scte35Signal {
+	signals := make([]scte35Signal, 0, 1)
+	if insert := c.info.SpliceInsert; insert != nil && !insert.Cancel && insert.OutOfNetwork {
+		signal := scte35Signal{typeID: spliceInsertType, eventID: insert.EventID, duration: -1}
+		if insert.BreakDuration != nil {
+			signal.duration = float64(*insert.BreakDuration) / scte35.Timescale
+		}
+		signals = append(signals, signal)
+	}
+	for _, desc := range c.info.SegmentationDescriptors {
+		if !desc.Cancel && desc.IsStart() {
+			signal := scte35Signal{typeID: int(desc.StartTypeID()), eventID: desc.EventID, duration: -1}
+			if desc.Duration != nil {
+				signal.duration = float64(*desc.Duration) / scte35.Timescale
+			}
+			signals = append(signals, signal)
+		}
+	}
+	return signals
+}
+
+// ins returns signals which end breaks.
+func (c *scte35Cue) ins() []scte35Signal {
+	signals := make([]scte35Signal, 0, 1)
+	if insert := c.info.SpliceInsert; insert != nil && !insert.Cancel && !insert.OutOfNetwork {
+		signals = append(signals, scte35Signal{typeID: spliceInsertType, eventID: insert.EventID, duration: -1})
+	}
+	for _, desc := range c.info.SegmentationDescriptors {
+		if !desc.Cancel && desc.IsEnd() {
+			signals = append(signals, scte35Signal{typeID: int(desc.StartTypeID()), eventID: desc.EventID, duration: -1})
+		}
+	}
+	return signals
+}
+
+func (s scte35Signal) values(values core.Values) core.Values {
+	if s.typeID != spliceInsertType {
+		values["segmentationTypeId"] = s.typeID
+	}
+	return values
+}
+
+func isSCTE35Scheme(scheme string) bool {
+	return scheme == scte35SchemeXML || scheme == scte35SchemeBin || scheme == scte35SchemeXMLBin
+}
+
+func (ins *scte35Inspector) Inspect(manifest *core.Manifest, segments core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+	tolerance := ins.config.Tolerance.Seconds()
+
+	cues := make([]*scte35Cue, 0)
+	events, err := manifest.Events()
+	if err != nil {
+		return &core.Report{
+			Name:     "SCTE35Inspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	for _, event := range events {
+		if !isSCTE35Scheme(event.SchemeIDURI) {
+			continue
+		}
+		cue := &scte35Cue{
+			source:      "EventStream",
+			period:      event.Period,
+			schemeIDURI: event.SchemeIDURI,
+			value:       event.Value,
+			id:          event.ID,
+			time:        core.PeriodStart(event.Period) + event.Time(),
+			duration:    -1,
+		}
+		if event.Duration != nil {
+			cue.duration = float64(*event.Duration) / float64(event.Timescale)
+		}
+		var err error
+		switch {
+		case len(event.Content) != 0 && event.Content[0] == '<':
+			cue.message = event.Content
+			cue.info, err = scte35.ParseXML(event.Content)
+		case len(event.Content) != 0:
+			cue.message = event.Content
+			cue.info, err = scte35.ParseBase64(string(event.Content))
+		default:
+			cue.message = []byte(event.MessageData)
+			cue.info, err = scte35.ParseBase64(event.MessageData)
+		}
+		if err != nil {
+			values := cue.values()
+			values["error"] = err
+			setReport(&core.Report{
+				Name:     "SCTE35Inspector",
+				Severity: core.Error,
+				Message:  "failed to decode SCTE-35 message",
+				Values:   values,
+			})
+			continue
+		}
+		cues = append(cues, cue)
+	}
+
+	inspected := make(map[string]bool)
+	err = manifest.EachSegments(func(segment *core.DASHSegment) bool {
+		if segment.Initialization {
+			return true
+		}
+		key := core.SegmentKey(segment.URL, segment.ByteRange)
+		if inspected[key] {
+			return true
+		}
+		data, ok := segments.LoadRange(segment.URL, segment.ByteRange)
+		if !ok {
+			return true
+		}
+		inspected[key] = true
+		emsgs, err := mp4.ReadEventMessages(data)
+		if err != nil {
+			// malformed segments are reported by other inspectors.
+			return true
+		}
+		timescale := float64(segment.Timescale)
+		if timescale == 0 {
+			timescale = 1
+		}
+		for _, emsg := range emsgs {
+			if !isSCTE35Scheme(emsg.SchemeIDURI) || emsg.Timescale == 0 {
+				continue
+			}
+			cue := &scte35Cue{
+				source:      "emsg",
+				period:      segment.Period,
+				schemeIDURI: emsg.SchemeIDURI,
+				value:       emsg.Value,
+				id:          strconv.FormatUint(uint64(emsg.ID), 10),
+				duration:    -1,
+				message:     emsg.MessageData,
+			}
+			if emsg.Version == 0 {
+				cue.time = core.PeriodStart(segment.Period) +
+					(float64(segment.Time)-float64(segment.PresentationTimeOffset))/timescale +
+					float64(emsg.PresentationTimeDelta)/float64(emsg.Timescale)
+			} else {
+				cue.time = core.PeriodStart(segment.Period) +
+					float64(emsg.PresentationTime)/float64(emsg.Timescale) -
+					float64(segment.PresentationTimeOffset)/timescale
+			}
+			if emsg.EventDuration != emsgUnknownDuration {
+				cue.duration = float64(emsg.EventDuration) / float64(emsg.Timescale)
+			}
+			var err error
+			if emsg.SchemeIDURI == scte35SchemeBin {
+				cue.info, err = scte35.Parse(emsg.MessageData)
+			} else {
+				cue.info, err = scte35.ParseXML(emsg.MessageData)
+			}
+			if err != nil {
+				values := cue.values()
+				values["url"] = segment.URL
+				values["error"] = err
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "failed to decode SCTE-35 message",
+					Values:   values,
+				})
+				continue
+			}
+			cues = append(cues, cue)
+		}
+		return true
+	})
+	if err != nil {
+		return &core.Report{
+			Name:     "SCTE35Inspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	if len(cues) == 0 && report == nil {
+		return &core.Report{
+			Name:     "SCTE35Inspector",
+			Severity: core.Info,
+			Message:  "no SCTE-35 events",
+		}
+	}
+
+	// the same event is repeated in emsg boxes of representations and segments.
+	type cueKey struct {
+		source string
+		period *mpd.Period
+		scheme string
+		value  string
+		id     string
+	}
+	uniqueCues := make([]*scte35Cue, 0, len(cues))
+	cueMap := make(map[cueKey]*scte35Cue)
+	for _, cue := range cues {
+		key := cueKey{source: cue.source, scheme: cue.schemeIDURI, value: cue.value, id: cue.id}
+		if cue.source == "EventStream" {
+			key.period = cue.period
+		}
+		if prev, ok := cueMap[key]; ok {
+			if math.Abs(prev.time-cue.time) > tolerance || !bytes.Equal(prev.message, cue.message) {
+				values := cue.values()
+				values["previousTime"] = prev.time
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "duplicate event id",
+					Values:   values,
+				})
+			}
+			continue
+		}
+		cueMap[key] = cue
+		uniqueCues = append(uniqueCues, cue)
+	}
+	sort.SliceStable(uniqueCues, func(i, j int) bool {
+		return uniqueCues[i].time < uniqueCues[j].time
+	})
+
+	// durations and pairing of breaks
+	for _, source := range []string{"EventStream", "emsg"} {
+		sourceCues := make([]*scte35Cue, 0, len(uniqueCues))
+		for _, cue := range uniqueCues {
+			if cue.source == source {
+				sourceCues = append(sourceCues, cue)
+			}
+		}
+		ins.inspectBreaks(sourceCues, setReport)
+	}
+
+	values := ins.nextBreak(manifest, uniqueCues)
+	values["cues"] = len(uniqueCues)
+	if report != nil {
+		for k, v := range values {
+			if _, ok := report.Values[k]; !ok {
+				report.Values[k] = v
+			}
+		}
+		return report
+	}
+	return &core.Report{
+		Name:     "SCTE35Inspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   values,
+	}
+}
+
+// inspectBreaks inspects durations and pairing of breaks signaled by cues in order of time.
+// Breaks of different types can overlap, for example, an advertisement in a placement opportunity.
+func (ins *scte35Inspector) inspectBreaks(cues []*scte35Cue, setReport func(*core.Report)) {
+	tolerance := ins.config.Tolerance.Seconds()
+	type openBreak struct {
+		cue    *scte35Cue
+		signal scte35Signal
+	}
+	open := make(map[int]*openBreak)
+	// seen holds types which have appeared in the previous cues.
+	seen := make(map[int]bool)
+	for _, cue := range cues {
+		outs := cue.outs()
+		ins.inspectEventDuration(cue, outs, setReport)
+		// splice returns are handled first because the next break can start at the same time.
+		for _, in := range cue.ins() {
+			prev, ok := open[in.typeID]
+			if !ok {
+				// the splice out of the first break can be out of the DVR window.
+				if seen[in.typeID] {
+					setReport(&core.Report{
+						Name:     "SCTE35Inspector",
+						Severity: core.Warn,
+						Message:  "splice return without splice out",
+						Values:   in.values(cue.values()),
+					})
+				}
+				seen[in.typeID] = true
+				continue
+			}
+			if prev.signal.eventID != in.eventID {
+				values := in.values(cue.values())
+				values["outEventId"] = prev.signal.eventID
+				values["inEventId"] = in.eventID
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Warn,
+					Message:  "splice return mismatches splice out event id",
+					Values:   values,
+				})
+			} else if prev.signal.duration >= 0 && math.Abs(cue.time-prev.cue.time-prev.signal.duration) > tolerance {
+				values := prev.signal.values(prev.cue.values())
+				values["breakLength"] = cue.time - prev.cue.time
+				values["breakDuration"] = prev.signal.duration
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Warn,
+					Message:  "break length mismatches break duration",
+					Values:   values,
+				})
+			}
+			delete(open, in.typeID)
+		}
+		for _, out := range outs {
+			// the break without splice return is ended by auto-return.
+			if prev, ok := open[out.typeID]; ok && (prev.signal.duration < 0 || prev.cue.time+prev.signal.duration > cue.time+tolerance) {
+				values := prev.signal.values(prev.cue.values())
+				values["nextOutTime"] = cue.time
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Warn,
+					Message:  "splice out without splice return",
+					Values:   values,
+				})
+			}
+			open[out.typeID] = &openBreak{cue: cue, signal: out}
+			seen[out.typeID] = true
+		}
+	}
+}
+
+// inspectEventDuration inspects whether the duration of the event matches any break duration of the cue.
+func (ins *scte35Inspector) inspectEventDuration(cue *scte35Cue, outs []scte35Signal, setReport func(*core.Report)) {
+	if cue.duration < 0 {
+		return
+	}
+	tolerance := ins.config.Tolerance.Seconds()
+	var mismatched *scte35Signal
+	for i := range outs {
+		if outs[i].duration < 0 {
+			continue
+		}
+		if math.Abs(cue.duration-outs[i].duration) <= tolerance {
+			return
+		}
+		if mismatched == nil {
+			mismatched = &outs[i]
+		}
+	}
+	if mismatched == nil {
+		return
+	}
+	values := mismatched.values(cue.values())
+	values["duration"] = cue.duration
+	values["breakDuration"] = mismatched.duration
+	setReport(&core.Report{
+		Name:     "SCTE35Inspector",
+		Severity: core.Error,
+		Message:  "event duration mismatches break duration",
+		Values:   values,
+	})
+}
+
+// nextBreak returns values of the first splice out after the wall-clock time.
+// The first splice out in the manifest is returned for static manifests.
+func (ins *scte35Inspector) nextBreak(manifest *core.Manifest, cues []*scte35Cue) core.Values {
+	values := core.Values{}
+	now := math.Inf(-1)
+	var availabilityStartTime time.Time
+	if manifest.Type != nil && *manifest.Type == "dynamic" && manifest.AvailabilityStartTime != nil {
+		ast, err := time.Parse(time.RFC3339Nano, *manifest.AvailabilityStartTime)
+		if err != nil {
+			return values
+		}
+		availabilityStartTime = ast
+		wallClock := manifest.Time
+		if manifest.Timing != nil {
+			wallClock = manifest.Timing.Time
+		} else if wallClock.IsZero() {
+			wallClock = time.Now()
+		}
+		now = wallClock.Sub(ast).Seconds()
+	}
+	for _, cue := range cues {
+		outs := cue.outs()
+		if len(outs) == 0 || cue.time < now {
+			continue
+		}
+		if availabilityStartTime.IsZero() {
+			values["nextBreak"] = cue.time
+		} else {
+			values["nextBreak"] = availabilityStartTime.Add(time.Duration(cue.time * float64(time.Second))).UTC().Format(time.RFC3339Nano)
+		}
+		if outs[0].duration >= 0 {
+			values["nextBreakDuration"] = outs[0].duration
+		}
+		values["nextBreakEventId"] = outs[0].eventID
+		break
+	}
+	return values
+}
@@ -0,0 +1,191 @@
+package dash
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/abema/antares/internal/segmenttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestSCTE35Inspector(t *testing.T) {
+	spliceInsert := func(id int, presentationTime, duration, eventID uint64, out bool) string {
+		var breakDuration string
+		if out {
+			breakDuration = fmt.Sprintf(`<scte35:BreakDuration autoReturn="true" duration="%d"/>`, duration)
+		}
+		return fmt.Sprintf(`<Event id="%d" presentationTime="%d" duration="%d">`+
+			`<scte35:SpliceInfoSection><scte35:SpliceInsert spliceEventId="%d" outOfNetworkIndicator="%t">`+
+			`<scte35:Program><scte35:SpliceTime ptsTime="%d"/></scte35:Program>%s`+
+			`</scte35:SpliceInsert></scte35:SpliceInfoSection></Event>`,
+			id, presentationTime, duration, eventID, out, presentationTime, breakDuration)
+	}
+	timeSignal := func(id int, presentationTime, duration uint64, descriptors ...string) string {
+		return fmt.Sprintf(`<Event id="%d" presentationTime="%d" duration="%d">`+
+			`<scte35:SpliceInfoSection><scte35:TimeSignal><scte35:SpliceTime ptsTime="%d"/></scte35:TimeSignal>%s`+
+			`</scte35:SpliceInfoSection></Event>`,
+			id, presentationTime, duration, presentationTime, strings.Join(descriptors, ""))
+	}
+	segmentation := func(eventID uint64, typeID uint8, duration uint64) string {
+		var durationAttr string
+		if duration != 0 {
+			durationAttr = fmt.Sprintf(` segmentationDuration="%d"`, duration)
+		}
+		return fmt.Sprintf(`<scte35:SegmentationDescriptor segmentationEventId="%d" segmentationTypeId="%d"%s/>`, eventID, typeID, durationAttr)
+	}
+	buildManifest := func(events ...string) *core.Manifest {
+		data := `<MPD type="static" xmlns:scte35="http://www.scte.org/schemas/35/2016">` +
+			`<Period id="1" start="PT0S">` +
+			`<EventStream schemeIdUri="urn:scte:scte35:2013:xml" timescale="90000">` +
+			strings.Join(events, "") +
+			`</EventStream>` +
+			`<AdaptationSet><Representation id="video"><SegmentTemplate timescale="90000" media="$Time$.mp4">` +
+			`<SegmentTimeline><S t="0" d="180000" r="29"/></SegmentTimeline>` +
+			`</SegmentTemplate></Representation></AdaptationSet>` +
+			`</Period></MPD>`
+		m, err := mpd.ReadFromString(data)
+		require.NoError(t, err)
+		return &core.Manifest{URL: "https://localhost/manifest.mpd", Raw: []byte(data), MPD: m}
+	}
+	ins := NewSCTE35Inspector()
+
+	t.Run("ok", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			spliceInsert(1, 1800000, 2700000, 100, true),
+			spliceInsert(2, 4500000, 0, 100, false),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 20.0, report.Values["nextBreak"])
+		assert.Equal(t, 30.0, report.Values["nextBreakDuration"])
+		assert.Equal(t, uint32(100), report.Values["nextBreakEventId"])
+		assert.Equal(t, 2, report.Values["cues"])
+	})
+
+	t.Run("no events", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(), segmenttest.SegmentStore{})
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "no SCTE-35 events", report.Message)
+	})
+
+	t.Run("error/event duration", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			strings.Replace(spliceInsert(1, 1800000, 2700000, 100, true), `duration="2700000">`, `duration="1800000">`, 1),
+			spliceInsert(2, 4500000, 0, 100, false),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "event duration mismatches break duration", report.Message)
+		assert.Equal(t, 20.0, report.Values["duration"])
+		assert.Equal(t, 30.0, report.Values["breakDuration"])
+		assert.Equal(t, 20.0, report.Values["nextBreak"])
+	})
+
+	t.Run("warn/break length", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			spliceInsert(1, 1800000, 2700000, 100, true),
+			spliceInsert(2, 3600000, 0, 100, false),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "break length mismatches break duration", report.Message)
+		assert.Equal(t, 20.0, report.Values["breakLength"])
+	})
+
+	t.Run("warn/splice return without splice out", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			spliceInsert(1, 1800000, 2700000, 100, true),
+			spliceInsert(2, 4500000, 0, 100, false),
+			spliceInsert(3, 4680000, 0, 100, false),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "splice return without splice out", report.Message)
+		assert.Equal(t, "3", report.Values["id"])
+	})
+
+	t.Run("warn/splice out without splice return", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			spliceInsert(1, 900000, 2700000, 100, true),
+			spliceInsert(2, 1800000, 2700000, 101, true),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "splice out without splice return", report.Message)
+		assert.Equal(t, "1", report.Values["id"])
+	})
+
+	t.Run("segmentation/nested breaks", func(t *testing.T) {
+		// advertisements in a placement opportunity are paired by the type and the event ID.
+		report := ins.Inspect(buildManifest(
+			timeSignal(1, 1800000, 2700000, segmentation(10, 0x34, 2700000), segmentation(11, 0x30, 1350000)),
+			timeSignal(2, 3150000, 1350000, segmentation(11, 0x31, 0), segmentation(12, 0x30, 1350000)),
+			timeSignal(3, 4500000, 0, segmentation(12, 0x31, 0), segmentation(10, 0x35, 0)),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Info, report.Severity, report.Message)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, uint32(10), report.Values["nextBreakEventId"])
+		assert.Equal(t, 30.0, report.Values["nextBreakDuration"])
+	})
+
+	t.Run("segmentation/promos and unscheduled events are ignored", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			timeSignal(1, 1800000, 0, segmentation(10, 0x3C, 0)),
+			timeSignal(2, 2700000, 0, segmentation(11, 0x40, 0)),
+			timeSignal(3, 3600000, 0, segmentation(11, 0x41, 0)),
+			timeSignal(4, 4500000, 0, segmentation(12, 0x3D, 0)),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Info, report.Severity, report.Message)
+		assert.Nil(t, report.Values["nextBreak"])
+	})
+
+	t.Run("segmentation/splice return mismatches splice out event id", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			timeSignal(1, 1800000, 2700000, segmentation(10, 0x30, 2700000)),
+			timeSignal(2, 4500000, 0, segmentation(11, 0x31, 0)),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "splice return mismatches splice out event id", report.Message)
+		assert.Equal(t, uint32(10), report.Values["outEventId"])
+		assert.Equal(t, uint32(11), report.Values["inEventId"])
+		assert.Equal(t, 0x30, report.Values["segmentationTypeId"])
+	})
+
+	t.Run("error/duplicate event id", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			spliceInsert(1, 1800000, 2700000, 100, true),
+			spliceInsert(1, 4500000, 0, 100, false),
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "duplicate event id", report.Message)
+	})
+
+	t.Run("error/decode", func(t *testing.T) {
+		report := ins.Inspect(buildManifest(
+			`<Event id="1" presentationTime="0"><scte35:Foo/></Event>`,
+		), segmenttest.SegmentStore{})
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "failed to decode SCTE-35 message", report.Message)
+	})
+
+	t.Run("emsg", func(t *testing.T) {
+		message, err := base64.StdEncoding.DecodeString("/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=")
+		require.NoError(t, err)
+		segment := mp4test.FullBox("emsg", 0,
+			0,
+			[]byte("urn:scte:scte35:2013:bin\x00\x00"),
+			mp4test.U32(90000), mp4test.U32(90000), mp4test.U32(0x52ccf5), mp4test.U32(1),
+			message,
+		)
+		segment = append(segment, mp4test.Box("moof")...)
+		report := ins.Inspect(buildManifest(), segmenttest.SegmentStore{
+			"https://localhost/180000.mp4": segment,
+		})
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 3.0, report.Values["nextBreak"])
+		assert.Equal(t, uint32(0x4800008f), report.Values["nextBreakEventId"])
+	})
+}
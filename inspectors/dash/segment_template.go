@@ -0,0 +1,124 @@
+package dash
+
+import (
+	"strings"
+
+	"github.com/abema/antares/core"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// NewSegmentTemplateInspector returns SegmentTemplateInspector.
+// It inspects that URL templates of SegmentTemplate contain only legal identifiers,
+// and that URLs of SegmentList contain no identifiers because they are not substituted.
+func NewSegmentTemplateInspector() core.DASHInspector {
+	return &segmentTemplateInspector{}
+}
+
+type segmentTemplateInspector struct{}
+
+func (ins *segmentTemplateInspector) Inspect(manifest *core.Manifest, segments core.SegmentStore) *core.Report {
+	for _, period := range manifest.Periods {
+		templates := []*mpd.SegmentTemplate{period.SegmentTemplate}
+		lists := []*mpd.SegmentList{period.SegmentList}
+		for _, as := range period.AdaptationSets {
+			templates = append(templates, as.SegmentTemplate)
+			lists = append(lists, as.SegmentList)
+			for _, rep := range as.Representations {
+				templates = append(templates, rep.SegmentTemplate)
+				lists = append(lists, rep.SegmentList)
+			}
+		}
+		for _, template := range templates {
+			if template == nil {
+				continue
+			}
+			for _, attr := range core.TemplateAttributes {
+				format, ok := manifest.SegmentTemplateAttribute(template, attr)
+				if !ok {
+					continue
+				}
+				if report := inspectTemplate(period, format, attr); report != nil {
+					return report
+				}
+			}
+		}
+		for _, list := range lists {
+			if list == nil {
+				continue
+			}
+			if report := inspectSegmentList(period, list); report != nil {
+				return report
+			}
+		}
+	}
+	return &core.Report{
+		Name:     "SegmentTemplateInspector",
+		Severity: core.Info,
+		Message:  "good",
+	}
+}
+
+func inspectTemplate(period *mpd.Period, format string, attr core.TemplateAttribute) *core.Report {
+	if err := core.ValidateTemplate(format, attr); err != nil {
+		return &core.Report{
+			Name:     "SegmentTemplateInspector",
+			Severity: core.Error,
+			Message:  "invalid SegmentTemplate@" + attr.String(),
+			Values: core.Values{
+				"period":   period.ID,
+				"template": format,
+				"error":    err,
+			},
+		}
+	}
+	return nil
+}
+
+// templateIdentifiers are identifiers of URL templates with the leading "$".
+var templateIdentifiers = []string{"$RepresentationID", "$Number", "$Bandwidth", "$Time", "$SubNumber"}
+
+// inspectSegmentList reports URLs of SegmentList which contain identifiers of URL templates.
+func inspectSegmentList(period *mpd.Period, list *mpd.SegmentList) *core.Report {
+	check := func(attr string, url *string, values core.Values) *core.Report {
+		if url == nil {
+			return nil
+		}
+		for _, identifier := range templateIdentifiers {
+			if strings.Contains(*url, identifier+"$") || strings.Contains(*url, identifier+"%") {
+				values["period"] = period.ID
+				values["url"] = *url
+				return &core.Report{
+					Name:     "SegmentTemplateInspector",
+					Severity: core.Error,
+					Message:  "template identifier in " + attr,
+					Values:   values,
+				}
+			}
+		}
+		return nil
+	}
+	for _, url := range []struct {
+		attr string
+		url  *mpd.URL
+	}{
+		{attr: "Initialization@sourceURL", url: list.Initialization},
+		{attr: "RepresentationIndex@sourceURL", url: list.RepresentationIndex},
+		{attr: "BitstreamSwitching@sourceURL", url: list.BitstreamSwitching},
+	} {
+		if url.url == nil {
+			continue
+		}
+		if report := check(url.attr, url.url.SourceURL, core.Values{}); report != nil {
+			return report
+		}
+	}
+	for i, segmentURL := range list.SegmentURLs {
+		if report := check("SegmentURL@media", segmentURL.Media, core.Values{"index": i}); report != nil {
+			return report
+		}
+		if report := check("SegmentURL@index", segmentURL.Index, core.Values{"index": i}); report != nil {
+			return report
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package dash
+
+import (
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestSegmentTemplateInspector(t *testing.T) {
+	manifest := func(init, media string) *core.Manifest {
+		return &core.Manifest{
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{{
+					AdaptationSets: []*mpd.AdaptationSet{{
+						Representations: []*mpd.Representation{{
+							SegmentTemplate: &mpd.SegmentTemplate{
+								Initialization: ptrs.Strptr(init),
+								Media:          ptrs.Strptr(media),
+							},
+						}},
+					}},
+				}},
+			},
+		}
+	}
+	ins := NewSegmentTemplateInspector()
+
+	report := ins.Inspect(manifest("$RepresentationID$/init.mp4", "$RepresentationID$/$Number%05d$.mp4"), nil)
+	require.Equal(t, core.Info, report.Severity)
+
+	report = ins.Inspect(manifest("$RepresentationID$/$Time$.mp4", "$RepresentationID$/$Time$.mp4"), nil)
+	require.Equal(t, core.Error, report.Severity)
+	require.Equal(t, "invalid SegmentTemplate@initialization", report.Message)
+
+	report = ins.Inspect(manifest("init.mp4", "$Number%5d$.mp4"), nil)
+	require.Equal(t, core.Error, report.Severity)
+	require.Equal(t, "invalid SegmentTemplate@media", report.Message)
+}
+
+func TestSegmentTemplateInspector_SegmentList(t *testing.T) {
+	manifest := func(list *mpd.SegmentList) *core.Manifest {
+		return &core.Manifest{
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{{
+					ID: "1",
+					AdaptationSets: []*mpd.AdaptationSet{{
+						Representations: []*mpd.Representation{{SegmentList: list}},
+					}},
+				}},
+			},
+		}
+	}
+	ins := NewSegmentTemplateInspector()
+
+	report := ins.Inspect(manifest(&mpd.SegmentList{
+		MultipleSegmentBase: mpd.MultipleSegmentBase{SegmentBase: mpd.SegmentBase{
+			Initialization: &mpd.URL{SourceURL: ptrs.Strptr("init.mp4")},
+		}},
+		SegmentURLs: []*mpd.SegmentURL{{Media: ptrs.Strptr("1.mp4")}, {Media: ptrs.Strptr("2.mp4")}},
+	}), nil)
+	require.Equal(t, core.Info, report.Severity)
+
+	report = ins.Inspect(manifest(&mpd.SegmentList{
+		MultipleSegmentBase: mpd.MultipleSegmentBase{SegmentBase: mpd.SegmentBase{
+			Initialization: &mpd.URL{SourceURL: ptrs.Strptr("$RepresentationID$/init.mp4")},
+		}},
+	}), nil)
+	require.Equal(t, core.Error, report.Severity)
+	require.Equal(t, "template identifier in Initialization@sourceURL", report.Message)
+
+	report = ins.Inspect(manifest(&mpd.SegmentList{
+		SegmentURLs: []*mpd.SegmentURL{{Media: ptrs.Strptr("1.mp4")}, {Media: ptrs.Strptr("$Number%05d$.mp4")}},
+	}), nil)
+	require.Equal(t, core.Error, report.Severity)
+	require.Equal(t, "template identifier in SegmentURL@media", report.Message)
+	require.Equal(t, 1, report.Values["index"])
+}
@@ -0,0 +1,351 @@
+package dash
+
+import (
+	"math"
+
+	"github.com/abema/antares/core"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+// NewSegmentTimelineInspector returns SegmentTimelineInspector.
+// It inspects that SegmentTimeline elements are well-formed and continuous,
+// that timelines don't go back or lose segments between manifest updates,
+// and that segment boundaries are aligned across representations when AdaptationSet@segmentAlignment is true.
+func NewSegmentTimelineInspector() core.DASHInspector {
+	return &segmentTimelineInspector{}
+}
+
+type segmentTimelineInspector struct {
+	// previous holds timelines of the last manifest by representationTimelineKey.
+	previous map[string]*representationTimeline
+}
+
+type timelineSegment struct {
+	time     uint64
+	duration uint64
+}
+
+// representationTimeline is a list of media segments of the representation described by SegmentTimeline.
+type representationTimeline struct {
+	period         *mpd.Period
+	adaptationSet  *mpd.AdaptationSet
+	representation *mpd.Representation
+	timescale      uint64
+	offset         uint64
+	segments       []timelineSegment
+}
+
+func (t *representationTimeline) key() string {
+	key := t.period.ID + "/"
+	if t.representation.ID != nil {
+		key += *t.representation.ID
+	}
+	return key
+}
+
+func (t *representationTimeline) values() core.Values {
+	values := core.Values{"period": t.period.ID}
+	if t.representation.ID != nil {
+		values["representation"] = *t.representation.ID
+	}
+	return values
+}
+
+// seconds returns the presentation time of the segment time in seconds.
+func (t *representationTimeline) seconds(time uint64) float64 {
+	return (float64(time) - float64(t.offset)) / float64(t.timescale)
+}
+
+func (ins *segmentTimelineInspector) Inspect(manifest *core.Manifest, segments core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if rep != nil && (report == nil || rep.Severity.WorseThan(report.Severity)) {
+			report = rep
+		}
+	}
+	eachSegmentTimelines(manifest, func(period *mpd.Period, rep *mpd.Representation, timeline *mpd.SegmentTimeline) {
+		setReport(inspectOpenEndedRepeat(period, rep, timeline))
+	})
+
+	timelines, err := representationTimelines(manifest)
+	if err != nil {
+		return &core.Report{
+			Name:     "SegmentTimelineInspector",
+			Severity: core.Error,
+			Message:  "unexpected error",
+			Values:   core.Values{"error": err},
+		}
+	}
+	current := make(map[string]*representationTimeline, len(timelines))
+	for _, timeline := range timelines {
+		setReport(inspectContinuity(timeline))
+		if prev, ok := ins.previous[timeline.key()]; ok {
+			setReport(inspectUpdate(prev, timeline))
+		}
+		current[timeline.key()] = timeline
+	}
+	setReport(inspectAlignment(timelines))
+	ins.previous = current
+
+	if report != nil {
+		return report
+	}
+	return &core.Report{
+		Name:     "SegmentTimelineInspector",
+		Severity: core.Info,
+		Message:  "good",
+	}
+}
+
+// eachSegmentTimelines calls handle for each SegmentTimeline of SegmentTemplate.
+// SegmentTemplate is resolved in the same order as core: AdaptationSet, Representation and Period.
+// rep is nil when SegmentTemplate is in AdaptationSet or Period.
+func eachSegmentTimelines(manifest *core.Manifest, handle func(period *mpd.Period, rep *mpd.Representation, timeline *mpd.SegmentTimeline)) {
+	for _, period := range manifest.Periods {
+		// inherited is true when a representation inherits SegmentTemplate of the Period.
+		var inherited bool
+		for _, as := range period.AdaptationSets {
+			if as.SegmentTemplate != nil {
+				if as.SegmentTemplate.SegmentTimeline != nil {
+					handle(period, nil, as.SegmentTemplate.SegmentTimeline)
+				}
+				continue
+			}
+			for _, rep := range as.Representations {
+				if rep.SegmentTemplate != nil {
+					if rep.SegmentTemplate.SegmentTimeline != nil {
+						handle(period, rep, rep.SegmentTemplate.SegmentTimeline)
+					}
+				} else {
+					inherited = true
+				}
+			}
+		}
+		if inherited && period.SegmentTemplate != nil && period.SegmentTemplate.SegmentTimeline != nil {
+			handle(period, nil, period.SegmentTemplate.SegmentTimeline)
+		}
+	}
+}
+
+// inspectOpenEndedRepeat reports S elements with negative @r which are not the last.
+// The open-ended S element in the middle is ambiguous unless the next S element has @t.
+func inspectOpenEndedRepeat(period *mpd.Period, rep *mpd.Representation, timeline *mpd.SegmentTimeline) *core.Report {
+	for i, s := range timeline.Segments {
+		if s.RepeatCount == nil || *s.RepeatCount >= 0 || i == len(timeline.Segments)-1 {
+			continue
+		}
+		values := core.Values{"period": period.ID, "index": i}
+		if rep != nil && rep.ID != nil {
+			values["representation"] = *rep.ID
+		}
+		if timeline.Segments[i+1].StartTime == nil {
+			return &core.Report{
+				Name:     "SegmentTimelineInspector",
+				Severity: core.Error,
+				Message:  "open-ended S element is followed by S element without @t",
+				Values:   values,
+			}
+		}
+		return &core.Report{
+			Name:     "SegmentTimelineInspector",
+			Severity: core.Warn,
+			Message:  "open-ended S element is not last",
+			Values:   values,
+		}
+	}
+	return nil
+}
+
+// representationTimelines returns media segments described by SegmentTimeline for each representation.
+func representationTimelines(manifest *core.Manifest) ([]*representationTimeline, error) {
+	timelines := make([]*representationTimeline, 0)
+	var current *representationTimeline
+	err := manifest.EachSegments(func(segment *core.DASHSegment) bool {
+		if segment.Initialization {
+			return true
+		}
+		if segment.SegmentTemplate != nil && segment.SegmentTemplate.SegmentTimeline == nil ||
+			segment.SegmentList != nil && segment.SegmentList.SegmentTimeline == nil ||
+			segment.SegmentTemplate == nil && segment.SegmentList == nil {
+			return true
+		}
+		if current == nil || current.representation != segment.Representation || current.period != segment.Period {
+			current = &representationTimeline{
+				period:         segment.Period,
+				adaptationSet:  segment.AdaptationSet,
+				representation: segment.Representation,
+				timescale:      segment.Timescale,
+				offset:         segment.PresentationTimeOffset,
+			}
+			if current.timescale == 0 {
+				current.timescale = 1
+			}
+			timelines = append(timelines, current)
+		}
+		current.segments = append(current.segments, timelineSegment{time: segment.Time, duration: segment.Duration})
+		return true
+	})
+	return timelines, err
+}
+
+// inspectContinuity reports gaps and overlaps between consecutive segments.
+func inspectContinuity(timeline *representationTimeline) *core.Report {
+	var report *core.Report
+	for i := 1; i < len(timeline.segments); i++ {
+		prev := timeline.segments[i-1]
+		seg := timeline.segments[i]
+		expected := prev.time + prev.duration
+		if seg.time == expected {
+			continue
+		}
+		values := timeline.values()
+		values["expected"] = expected
+		values["time"] = seg.time
+		if seg.time < expected {
+			return &core.Report{
+				Name:     "SegmentTimelineInspector",
+				Severity: core.Error,
+				Message:  "segments overlap in SegmentTimeline",
+				Values:   values,
+			}
+		} else if report == nil {
+			report = &core.Report{
+				Name:     "SegmentTimelineInspector",
+				Severity: core.Warn,
+				Message:  "gap in SegmentTimeline",
+				Values:   values,
+			}
+		}
+	}
+	return report
+}
+
+// inspectUpdate reports regression of the timeline, segments removed from the middle of the timeline
+// and segments whose duration changed compared with the previous manifest.
+// Segments are compared in the time range covered by both timelines.
+func inspectUpdate(prev, timeline *representationTimeline) *core.Report {
+	if len(prev.segments) == 0 || len(timeline.segments) == 0 || prev.timescale != timeline.timescale {
+		return nil
+	}
+	first := timeline.segments[0].time
+	if first < prev.segments[0].time {
+		values := timeline.values()
+		values["previous"] = prev.segments[0].time
+		values["time"] = first
+		return &core.Report{
+			Name:     "SegmentTimelineInspector",
+			Severity: core.Error,
+			Message:  "S@t goes back from previous manifest",
+			Values:   values,
+		}
+	}
+	lastPrev := prev.segments[len(prev.segments)-1]
+	last := timeline.segments[len(timeline.segments)-1]
+	end := last.time + last.duration
+	if prevEnd := lastPrev.time + lastPrev.duration; end < prevEnd {
+		values := timeline.values()
+		values["previous"] = prevEnd
+		values["time"] = end
+		return &core.Report{
+			Name:     "SegmentTimelineInspector",
+			Severity: core.Error,
+			Message:  "end of SegmentTimeline goes back from previous manifest",
+			Values:   values,
+		}
+	}
+	durations := make(map[uint64]uint64, len(timeline.segments))
+	for _, seg := range timeline.segments {
+		durations[seg.time] = seg.duration
+	}
+	for _, seg := range prev.segments {
+		if _, ok := durations[seg.time]; seg.time >= first && !ok {
+			values := timeline.values()
+			values["time"] = seg.time
+			return &core.Report{
+				Name:     "SegmentTimelineInspector",
+				Severity: core.Error,
+				Message:  "segment disappeared from the middle of SegmentTimeline",
+				Values:   values,
+			}
+		}
+	}
+	for _, seg := range prev.segments {
+		if d, ok := durations[seg.time]; ok && d != seg.duration {
+			values := timeline.values()
+			values["time"] = seg.time
+			values["previous"] = seg.duration
+			values["duration"] = d
+			return &core.Report{
+				Name:     "SegmentTimelineInspector",
+				Severity: core.Error,
+				Message:  "S@d changed from previous manifest",
+				Values:   values,
+			}
+		}
+	}
+	return nil
+}
+
+// alignmentTolerance is the tolerance to compare segment boundaries of representations in seconds.
+const alignmentTolerance = 1e-4
+
+// inspectAlignment reports segment boundaries which are not aligned across representations
+// in AdaptationSet which has segmentAlignment="true".
+func inspectAlignment(timelines []*representationTimeline) *core.Report {
+	references := make(map[*mpd.AdaptationSet]*representationTimeline)
+	for _, timeline := range timelines {
+		as := timeline.adaptationSet
+		if as.SegmentAlignment == nil || !*as.SegmentAlignment || len(timeline.segments) == 0 {
+			continue
+		}
+		ref, ok := references[as]
+		if !ok {
+			references[as] = timeline
+			continue
+		}
+		// boundaries of each timeline must be found in the other.
+		for _, pair := range [][2]*representationTimeline{{ref, timeline}, {timeline, ref}} {
+			if t, ok := misalignedBoundary(pair[0], pair[1]); ok {
+				values := pair[1].values()
+				values["time"] = t
+				if pair[0].representation.ID != nil {
+					values["reference"] = *pair[0].representation.ID
+				}
+				return &core.Report{
+					Name:     "SegmentTimelineInspector",
+					Severity: core.Error,
+					Message:  "segment boundaries are not aligned across representations",
+					Values:   values,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// misalignedBoundary returns the time of the first segment of timeline whose start doesn't match
+// any segment start of ref in the time range covered by both timelines.
+func misalignedBoundary(ref, timeline *representationTimeline) (uint64, bool) {
+	start := math.Max(ref.seconds(ref.segments[0].time), timeline.seconds(timeline.segments[0].time))
+	lastRef := ref.segments[len(ref.segments)-1]
+	lastSeg := timeline.segments[len(timeline.segments)-1]
+	end := math.Min(ref.seconds(lastRef.time+lastRef.duration), timeline.seconds(lastSeg.time+lastSeg.duration))
+	boundaries := make([]float64, 0, len(ref.segments))
+	for _, seg := range ref.segments {
+		boundaries = append(boundaries, ref.seconds(seg.time))
+	}
+	var i int
+	for _, seg := range timeline.segments {
+		t := timeline.seconds(seg.time)
+		if t < start-alignmentTolerance || t > end-alignmentTolerance {
+			continue
+		}
+		for i < len(boundaries) && boundaries[i] < t-alignmentTolerance {
+			i++
+		}
+		if i == len(boundaries) || boundaries[i] > t+alignmentTolerance {
+			return seg.time, true
+		}
+	}
+	return 0, false
+}
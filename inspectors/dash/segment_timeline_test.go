@@ -0,0 +1,228 @@
+package dash
+
+import (
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/stretchr/testify/require"
+	"github.com/zencoder/go-dash/helpers/ptrs"
+	"github.com/zencoder/go-dash/mpd"
+)
+
+func TestSegmentTimelineInspector(t *testing.T) {
+	manifest := func(segments ...*mpd.SegmentTimelineSegment) *core.Manifest {
+		return &core.Manifest{
+			MPD: &mpd.MPD{
+				Periods: []*mpd.Period{{
+					ID: "1",
+					AdaptationSets: []*mpd.AdaptationSet{{
+						SegmentTemplate: &mpd.SegmentTemplate{
+							Media:           ptrs.Strptr("$Time$.mp4"),
+							SegmentTimeline: &mpd.SegmentTimeline{Segments: segments},
+						},
+						Representations: []*mpd.Representation{{}},
+					}},
+				}},
+			},
+		}
+	}
+	ins := NewSegmentTimelineInspector()
+
+	t.Run("ok", func(t *testing.T) {
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(2)},
+			&mpd.SegmentTimelineSegment{Duration: 90000, RepeatCount: ptrs.Intptr(-1)},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("not_last", func(t *testing.T) {
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(-1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(900000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		require.Equal(t, "open-ended S element is not last", report.Message)
+		require.Equal(t, 0, report.Values["index"])
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(-1)},
+			&mpd.SegmentTimelineSegment{Duration: 90000},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "open-ended S element is followed by S element without @t", report.Message)
+	})
+
+	t.Run("period", func(t *testing.T) {
+		manifest := func(segments ...*mpd.SegmentTimelineSegment) *core.Manifest {
+			return &core.Manifest{
+				MPD: &mpd.MPD{
+					Periods: []*mpd.Period{{
+						ID: "1",
+						SegmentTemplate: &mpd.SegmentTemplate{
+							Media:           ptrs.Strptr("$Time$.mp4"),
+							SegmentTimeline: &mpd.SegmentTimeline{Segments: segments},
+						},
+						AdaptationSets: []*mpd.AdaptationSet{{
+							Representations: []*mpd.Representation{{}},
+						}},
+					}},
+				},
+			}
+		}
+		report := NewSegmentTimelineInspector().Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(-1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(900000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		require.Equal(t, "open-ended S element is not last", report.Message)
+
+		report = NewSegmentTimelineInspector().Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(170000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "segments overlap in SegmentTimeline", report.Message)
+	})
+
+	t.Run("gap", func(t *testing.T) {
+		report := NewSegmentTimelineInspector().Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(270000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Warn, report.Severity)
+		require.Equal(t, "gap in SegmentTimeline", report.Message)
+		require.Equal(t, uint64(180000), report.Values["expected"])
+		require.Equal(t, uint64(270000), report.Values["time"])
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		report := NewSegmentTimelineInspector().Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(0), Duration: 90000, RepeatCount: ptrs.Intptr(1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(170000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "segments overlap in SegmentTimeline", report.Message)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		ins := NewSegmentTimelineInspector()
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(90000), Duration: 90000, RepeatCount: ptrs.Intptr(4)},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		// sliding window
+		report = ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(180000), Duration: 90000, RepeatCount: ptrs.Intptr(4)},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		// the segment at 360000 disappears.
+		report = ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(180000), Duration: 90000, RepeatCount: ptrs.Intptr(1)},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(360000), Duration: 180000},
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(540000), Duration: 90000},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "segment disappeared from the middle of SegmentTimeline", report.Message)
+		require.Equal(t, uint64(450000), report.Values["time"])
+
+		report = ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(90000), Duration: 90000, RepeatCount: ptrs.Intptr(5)},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "S@t goes back from previous manifest", report.Message)
+		require.Equal(t, uint64(180000), report.Values["previous"])
+		require.Equal(t, uint64(90000), report.Values["time"])
+	})
+
+	t.Run("update_end", func(t *testing.T) {
+		ins := NewSegmentTimelineInspector()
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(90000), Duration: 90000, RepeatCount: ptrs.Intptr(4)},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		// the live edge moves backwards.
+		report = ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(90000), Duration: 90000, RepeatCount: ptrs.Intptr(3)},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "end of SegmentTimeline goes back from previous manifest", report.Message)
+		require.Equal(t, uint64(540000), report.Values["previous"])
+		require.Equal(t, uint64(450000), report.Values["time"])
+	})
+
+	t.Run("update_duration", func(t *testing.T) {
+		ins := NewSegmentTimelineInspector()
+		report := ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(90000), Duration: 90000, RepeatCount: ptrs.Intptr(4)},
+		), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		// the last segment is shortened and the following segments are shifted.
+		report = ins.Inspect(manifest(
+			&mpd.SegmentTimelineSegment{StartTime: ptrs.Uint64ptr(180000), Duration: 90000, RepeatCount: ptrs.Intptr(2)},
+			&mpd.SegmentTimelineSegment{Duration: 45000},
+			&mpd.SegmentTimelineSegment{Duration: 90000},
+		), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "S@d changed from previous manifest", report.Message)
+		require.Equal(t, uint64(450000), report.Values["time"])
+		require.Equal(t, uint64(90000), report.Values["previous"])
+		require.Equal(t, uint64(45000), report.Values["duration"])
+	})
+
+	t.Run("alignment", func(t *testing.T) {
+		aligned := func(video, audio []*mpd.SegmentTimelineSegment) *core.Manifest {
+			return &core.Manifest{
+				MPD: &mpd.MPD{
+					Periods: []*mpd.Period{{
+						ID: "1",
+						AdaptationSets: []*mpd.AdaptationSet{{
+							SegmentAlignment: ptrs.Boolptr(true),
+							Representations: []*mpd.Representation{
+								{
+									ID: ptrs.Strptr("video"),
+									SegmentTemplate: &mpd.SegmentTemplate{
+										Timescale:       ptrs.Int64ptr(90000),
+										Media:           ptrs.Strptr("video/$Time$.mp4"),
+										SegmentTimeline: &mpd.SegmentTimeline{Segments: video},
+									},
+								},
+								{
+									ID: ptrs.Strptr("audio"),
+									SegmentTemplate: &mpd.SegmentTemplate{
+										Timescale:       ptrs.Int64ptr(48000),
+										Media:           ptrs.Strptr("audio/$Time$.mp4"),
+										SegmentTimeline: &mpd.SegmentTimeline{Segments: audio},
+									},
+								},
+							},
+						}},
+					}},
+				},
+			}
+		}
+		video := []*mpd.SegmentTimelineSegment{{StartTime: ptrs.Uint64ptr(0), Duration: 180000, RepeatCount: ptrs.Intptr(2)}}
+
+		report := NewSegmentTimelineInspector().Inspect(aligned(video, []*mpd.SegmentTimelineSegment{
+			{StartTime: ptrs.Uint64ptr(96000), Duration: 96000, RepeatCount: ptrs.Intptr(2)},
+		}), nil)
+		require.Equal(t, core.Info, report.Severity)
+
+		report = NewSegmentTimelineInspector().Inspect(aligned(video, []*mpd.SegmentTimelineSegment{
+			{StartTime: ptrs.Uint64ptr(0), Duration: 96000},
+			{Duration: 95000},
+			{Duration: 97000},
+		}), nil)
+		require.Equal(t, core.Error, report.Severity)
+		require.Equal(t, "segment boundaries are not aligned across representations", report.Message)
+		require.Equal(t, "audio", report.Values["representation"])
+		require.Equal(t, "video", report.Values["reference"])
+		require.Equal(t, uint64(191000), report.Values["time"])
+	})
+}
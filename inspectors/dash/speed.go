@@ -57,14 +57,8 @@ func (ins *speedInspector) Inspect(manifest *core.Manifest, segments core.Segmen
 		if segment.Period.Start != nil {
 			periodStart = time.Duration(*segment.Period.Start).Seconds()
 		}
-		var offset uint64
-		if segment.SegmentTemplate.PresentationTimeOffset != nil {
-			offset = *segment.SegmentTemplate.PresentationTimeOffset
-		}
-		timescale := float64(1)
-		if segment.SegmentTemplate.Timescale != nil {
-			timescale = float64(*segment.SegmentTemplate.Timescale)
-		}
+		offset := segment.PresentationTimeOffset
+		timescale := float64(segment.Timescale)
 		t := int64(segment.Time) - int64(offset) + int64(segment.Duration)
 		vt := periodStart + float64(t)/timescale
 		if vt > videoTime {
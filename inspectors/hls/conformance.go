@@ -0,0 +1,314 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+)
+
+// Rule IDs of ConformanceInspector.
+// They are set to "rule" of the report values, and never changed so that they can be used to filter reports.
+const (
+	ConformanceRuleTargetDuration        = "target-duration"
+	ConformanceRuleVersion               = "version-compatibility"
+	ConformanceRuleMediaSequence         = "media-sequence"
+	ConformanceRuleDiscontinuitySequence = "discontinuity-sequence"
+	ConformanceRuleRemovedSegment        = "removed-segment"
+	ConformanceRuleChangedSegment        = "changed-segment"
+	ConformanceRuleLivePlaylistDuration  = "live-playlist-duration"
+)
+
+type ConformanceInspectorConfig struct {
+	// IgnoreRules is a list of rule IDs which are not inspected.
+	// For example, ConformanceRuleRemovedSegment should be ignored when the stream reuses the URI of a slate segment.
+	IgnoreRules []string
+}
+
+func DefaultConformanceInspectorConfig() *ConformanceInspectorConfig {
+	return &ConformanceInspectorConfig{}
+}
+
+// NewConformanceInspector returns ConformanceInspector.
+// It inspects media playlists against the MUST rules of RFC 8216,
+// including the rules about playlist updates which are tracked across polls.
+func NewConformanceInspector() core.HLSInspector {
+	return NewConformanceInspectorWithConfig(DefaultConformanceInspectorConfig())
+}
+
+func NewConformanceInspectorWithConfig(config *ConformanceInspectorConfig) core.HLSInspector {
+	ignored := make(map[string]bool, len(config.IgnoreRules))
+	for _, rule := range config.IgnoreRules {
+		ignored[rule] = true
+	}
+	return &conformanceInspector{
+		config:  config,
+		ignored: ignored,
+		states:  make(map[string]*conformanceState),
+	}
+}
+
+type conformanceInspector struct {
+	config  *ConformanceInspectorConfig
+	ignored map[string]bool
+	// states holds the previous playlist by playlist URL.
+	states map[string]*conformanceState
+}
+
+type conformanceState struct {
+	seqNo            uint64
+	discontinuitySeq uint64
+	segments         map[uint64]*m3u8.MediaSegment
+	// removed holds media sequence numbers of removed segments by URI and byte range.
+	removed map[segmentResource]uint64
+}
+
+// segmentResource identifies the resource of a media segment.
+type segmentResource struct {
+	uri    string
+	offset int64
+	limit  int64
+}
+
+func newSegmentResource(segment *m3u8.MediaSegment) segmentResource {
+	return segmentResource{uri: segment.URI, offset: segment.Offset, limit: segment.Limit}
+}
+
+// versionRequirement is the minimum protocol version required by a tag or an attribute.
+type versionRequirement struct {
+	tag     string
+	version int
+}
+
+func (ins *conformanceInspector) Inspect(playlists *core.Playlists, _ core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rule, section, message string, values core.Values) {
+		if ins.ignored[rule] {
+			return
+		}
+		values["rule"] = rule
+		values["section"] = section
+		if report == nil {
+			report = &core.Report{
+				Name:     "ConformanceInspector",
+				Severity: core.Error,
+				Message:  message,
+				Values:   values,
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(playlists.MediaPlaylists))
+	for key := range playlists.MediaPlaylists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		media := playlists.MediaPlaylists[key]
+		ins.inspectTargetDuration(media, setReport)
+		ins.inspectVersion(media, setReport)
+		ins.inspectUpdate(media, setReport)
+	}
+
+	if report != nil {
+		return report
+	}
+	return &core.Report{
+		Name:     "ConformanceInspector",
+		Severity: core.Info,
+		Message:  "good",
+	}
+}
+
+func (ins *conformanceInspector) inspectTargetDuration(media *core.MediaPlaylist, setReport func(rule, section, message string, values core.Values)) {
+	for _, segment := range media.Segments {
+		if math.Round(segment.Duration) > media.TargetDuration {
+			setReport(ConformanceRuleTargetDuration, "4.3.3.1", "EXTINF duration rounded to the nearest integer exceeds EXT-X-TARGETDURATION", core.Values{
+				"playlist":       media.URL,
+				"seqId":          segment.SeqId,
+				"duration":       segment.Duration,
+				"targetDuration": media.TargetDuration,
+			})
+			return
+		}
+	}
+}
+
+func (ins *conformanceInspector) inspectVersion(media *core.MediaPlaylist, setReport func(rule, section, message string, values core.Values)) {
+	version, requirements := scanVersionRequirements(media.Raw)
+	for _, req := range requirements {
+		if req.version > version {
+			setReport(ConformanceRuleVersion, "7", "EXT-X-VERSION is incompatible with used tags", core.Values{
+				"playlist":        media.URL,
+				"tag":             req.tag,
+				"version":         version,
+				"requiredVersion": req.version,
+			})
+			return
+		}
+	}
+}
+
+// scanVersionRequirements returns the value of EXT-X-VERSION tag, and the tags and attributes which require higher versions.
+// The version is 1 when EXT-X-VERSION tag is omitted.
+func scanVersionRequirements(raw []byte) (int, []versionRequirement) {
+	version := 1
+	var requirements []versionRequirement
+	var iframeOnly bool
+	var hasMap bool
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#EXT") {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+		switch name {
+		case "#EXT-X-VERSION":
+			if v, err := strconv.Atoi(value); err == nil {
+				version = v
+			}
+		case "#EXTINF":
+			if d := strings.SplitN(value, ",", 2)[0]; strings.Contains(d, ".") {
+				requirements = append(requirements, versionRequirement{tag: "EXTINF with decimal-floating-point duration", version: 3})
+			}
+		case "#EXT-X-KEY":
+			attrs := m3u8.DecodeAttributeList(value)
+			if _, ok := attrs["IV"]; ok {
+				requirements = append(requirements, versionRequirement{tag: "EXT-X-KEY with IV", version: 2})
+			}
+			_, hasKeyFormat := attrs["KEYFORMAT"]
+			_, hasKeyFormatVersions := attrs["KEYFORMATVERSIONS"]
+			if hasKeyFormat || hasKeyFormatVersions {
+				requirements = append(requirements, versionRequirement{tag: "EXT-X-KEY with KEYFORMAT or KEYFORMATVERSIONS", version: 5})
+			}
+		case "#EXT-X-BYTERANGE":
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-BYTERANGE", version: 4})
+		case "#EXT-X-I-FRAMES-ONLY":
+			iframeOnly = true
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-I-FRAMES-ONLY", version: 4})
+		case "#EXT-X-MAP":
+			hasMap = true
+		case "#EXT-X-DEFINE":
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-DEFINE", version: 8})
+		case "#EXT-X-SKIP":
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-SKIP", version: 9})
+			if strings.Contains(value, "RECENTLY-REMOVED-DATERANGES=") {
+				requirements = append(requirements, versionRequirement{tag: "EXT-X-SKIP with RECENTLY-REMOVED-DATERANGES", version: 10})
+			}
+		}
+	}
+	if hasMap {
+		if iframeOnly {
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-MAP in I-frame playlist", version: 5})
+		} else {
+			requirements = append(requirements, versionRequirement{tag: "EXT-X-MAP", version: 6})
+		}
+	}
+	return version, requirements
+}
+
+// inspectUpdate inspects the rules about the update of the playlist compared with the previous playlist.
+func (ins *conformanceInspector) inspectUpdate(media *core.MediaPlaylist, setReport func(rule, section, message string, values core.Values)) {
+	state := &conformanceState{
+		seqNo:            media.SeqNo,
+		discontinuitySeq: media.DiscontinuitySeq,
+		segments:         make(map[uint64]*m3u8.MediaSegment, len(media.Segments)),
+		removed:          make(map[segmentResource]uint64),
+	}
+	for _, segment := range media.Segments {
+		state.segments[segment.SeqId] = segment
+	}
+	prev := ins.states[media.URL]
+	ins.states[media.URL] = state
+	if prev == nil {
+		return
+	}
+	values := func() core.Values {
+		return core.Values{
+			"playlist":         media.URL,
+			"seqNo":            media.SeqNo,
+			"prevSeqNo":        prev.seqNo,
+			"discontinuitySeq": media.DiscontinuitySeq,
+		}
+	}
+	if media.SeqNo < prev.seqNo {
+		setReport(ConformanceRuleMediaSequence, "6.2.1", "media sequence number decreases", values())
+		return
+	}
+
+	// removed segments
+	var discontinuities uint64
+	var removed uint64
+	for seqID, segment := range prev.segments {
+		if seqID >= media.SeqNo {
+			continue
+		}
+		removed++
+		if segment.Discontinuity {
+			discontinuities++
+		}
+		state.removed[newSegmentResource(segment)] = seqID
+	}
+	// some segments are added and removed between polls when the previous playlist doesn't cover all removed segments.
+	complete := removed == media.SeqNo-prev.seqNo
+	// removed segments are remembered while they are within the length of the playlist from the head.
+	for resource, seqID := range prev.removed {
+		if seqID+uint64(len(media.Segments)) >= media.SeqNo {
+			if _, ok := state.removed[resource]; !ok {
+				state.removed[resource] = seqID
+			}
+		}
+	}
+
+	if complete && media.DiscontinuitySeq != prev.discontinuitySeq+discontinuities ||
+		!complete && media.DiscontinuitySeq < prev.discontinuitySeq {
+		v := values()
+		v["prevDiscontinuitySeq"] = prev.discontinuitySeq
+		v["removedDiscontinuities"] = discontinuities
+		setReport(ConformanceRuleDiscontinuitySequence, "6.2.2", "EXT-X-DISCONTINUITY-SEQUENCE mismatches removed discontinuities", v)
+	}
+
+	for _, segment := range media.Segments {
+		if seqID, ok := state.removed[newSegmentResource(segment)]; ok {
+			v := values()
+			v["uri"] = segment.URI
+			v["seqId"] = segment.SeqId
+			v["removedSeqId"] = seqID
+			setReport(ConformanceRuleRemovedSegment, "6.2.1", "removed media segment reappears", v)
+			break
+		}
+	}
+	for _, segment := range media.Segments {
+		p, ok := prev.segments[segment.SeqId]
+		if ok && newSegmentResource(p) != newSegmentResource(segment) {
+			v := values()
+			v["seqId"] = segment.SeqId
+			v["uri"] = segment.URI
+			v["prevUri"] = p.URI
+			setReport(ConformanceRuleChangedSegment, "6.2.1", "media segment is changed from previous playlist", v)
+			break
+		}
+	}
+
+	if !media.Closed && media.SeqNo > prev.seqNo {
+		var duration float64
+		for _, segment := range media.Segments {
+			duration += segment.Duration
+		}
+		if duration < media.TargetDuration*3 {
+			v := values()
+			v["duration"] = duration
+			v["targetDuration"] = media.TargetDuration
+			setReport(ConformanceRuleLivePlaylistDuration, "6.2.2", "live playlist is shorter than three times the target duration", v)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformanceInspector(t *testing.T) {
+	build := func(seqNo, discontinuitySeq uint64, count int, discontinuities ...uint64) *core.MediaPlaylist {
+		media := &core.MediaPlaylist{
+			URL: "media.m3u8",
+			Raw: []byte("#EXTM3U\n#EXT-X-VERSION:3\n"),
+			MediaPlaylist: &m3u8.MediaPlaylist{
+				TargetDuration:   2,
+				SeqNo:            seqNo,
+				DiscontinuitySeq: discontinuitySeq,
+			},
+		}
+		for i := 0; i < count; i++ {
+			seqID := seqNo + uint64(i)
+			media.Segments = append(media.Segments, &m3u8.MediaSegment{
+				SeqId:    seqID,
+				URI:      fmt.Sprintf("segment_%d.ts", seqID),
+				Duration: 2.0,
+			})
+			for _, d := range discontinuities {
+				if d == seqID {
+					media.Segments[i].Discontinuity = true
+				}
+			}
+		}
+		return media
+	}
+	inspect := func(ins core.HLSInspector, media *core.MediaPlaylist) *core.Report {
+		return ins.Inspect(&core.Playlists{MediaPlaylists: map[string]*core.MediaPlaylist{"_": media}}, nil)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		for _, media := range []*core.MediaPlaylist{
+			build(100, 0, 5, 101, 103),
+			build(100, 0, 6, 101, 103),
+			build(102, 1, 5, 103),
+			build(104, 2, 5),
+		} {
+			report := inspect(ins, media)
+			require.NotNil(t, report)
+			assert.Equal(t, core.Info, report.Severity)
+			assert.Equal(t, "good", report.Message)
+		}
+	})
+
+	t.Run("target duration", func(t *testing.T) {
+		media := build(100, 0, 5)
+		media.Segments[2].Duration = 2.49
+		report := inspect(NewConformanceInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+
+		media.Segments[2].Duration = 2.5
+		report = inspect(NewConformanceInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, ConformanceRuleTargetDuration, report.Values["rule"])
+		assert.Equal(t, uint64(102), report.Values["seqId"])
+	})
+
+	t.Run("version", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			raw      string
+			tag      string
+			required int
+		}{
+			{name: "float EXTINF", raw: "#EXTM3U\n#EXTINF:2.0,\nsegment.ts\n", tag: "EXTINF with decimal-floating-point duration", required: 3},
+			{name: "IV", raw: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\",IV=0x00000000000000000000000000000001\n#EXTINF:2,\nsegment.ts\n", tag: "EXT-X-KEY with IV", required: 2},
+			{name: "BYTERANGE", raw: "#EXTM3U\n#EXT-X-VERSION:3\n#EXTINF:2.0,\n#EXT-X-BYTERANGE:1000@0\nsegment.ts\n", tag: "EXT-X-BYTERANGE", required: 4},
+			{name: "MAP", raw: "#EXTM3U\n#EXT-X-VERSION:5\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.0,\nsegment.m4s\n", tag: "EXT-X-MAP", required: 6},
+			{name: "SKIP", raw: "#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-SKIP:SKIPPED-SEGMENTS=3,RECENTLY-REMOVED-DATERANGES=\"\"\n#EXTINF:2.0,\nsegment.ts\n", tag: "EXT-X-SKIP with RECENTLY-REMOVED-DATERANGES", required: 10},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				media := build(100, 0, 5)
+				media.Raw = []byte(tc.raw)
+				report := inspect(NewConformanceInspector(), media)
+				require.NotNil(t, report)
+				assert.Equal(t, core.Error, report.Severity)
+				assert.Equal(t, "EXT-X-VERSION is incompatible with used tags", report.Message)
+				assert.Equal(t, ConformanceRuleVersion, report.Values["rule"])
+				assert.Equal(t, tc.tag, report.Values["tag"])
+				assert.Equal(t, tc.required, report.Values["requiredVersion"])
+			})
+		}
+
+		media := build(100, 0, 5)
+		media.Raw = []byte("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.0,\n#EXT-X-BYTERANGE:1000@0\nsegment.m4s\n")
+		report := inspect(NewConformanceInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("media sequence", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		inspect(ins, build(100, 0, 5))
+		report := inspect(ins, build(99, 0, 5))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "media sequence number decreases", report.Message)
+		assert.Equal(t, ConformanceRuleMediaSequence, report.Values["rule"])
+		assert.Equal(t, "6.2.1", report.Values["section"])
+	})
+
+	t.Run("discontinuity sequence", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		inspect(ins, build(100, 0, 5, 101))
+		report := inspect(ins, build(102, 0, 5))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, ConformanceRuleDiscontinuitySequence, report.Values["rule"])
+		assert.Equal(t, uint64(1), report.Values["removedDiscontinuities"])
+
+		// only decrease is detected when some removed segments are unknown.
+		ins = NewConformanceInspector()
+		inspect(ins, build(100, 3, 5))
+		report = inspect(ins, build(110, 5, 5))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build(120, 4, 5))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, ConformanceRuleDiscontinuitySequence, report.Values["rule"])
+
+		// a huge jump of the media sequence number doesn't matter.
+		ins = NewConformanceInspector()
+		inspect(ins, build(100, 3, 5))
+		report = inspect(ins, build(math.MaxUint64-10, 3, 5))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("removed segment", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		inspect(ins, build(100, 0, 5))
+		media := build(101, 0, 5)
+		media.Segments[4].URI = "segment_100.ts"
+		report := inspect(ins, media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "removed media segment reappears", report.Message)
+		assert.Equal(t, ConformanceRuleRemovedSegment, report.Values["rule"])
+		assert.Equal(t, uint64(100), report.Values["removedSeqId"])
+
+		// the rule can be ignored.
+		ins = NewConformanceInspectorWithConfig(&ConformanceInspectorConfig{
+			IgnoreRules: []string{ConformanceRuleRemovedSegment},
+		})
+		inspect(ins, build(100, 0, 5))
+		report = inspect(ins, media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("changed segment", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		inspect(ins, build(100, 0, 5))
+		media := build(101, 0, 5)
+		media.Segments[0].URI = "other.ts"
+		report := inspect(ins, media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, ConformanceRuleChangedSegment, report.Values["rule"])
+		assert.Equal(t, "segment_101.ts", report.Values["prevUri"])
+	})
+
+	t.Run("live playlist duration", func(t *testing.T) {
+		ins := NewConformanceInspector()
+		// short playlists are allowed until segments are removed.
+		report := inspect(ins, build(100, 0, 2))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build(101, 0, 2))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "live playlist is shorter than three times the target duration", report.Message)
+		assert.Equal(t, ConformanceRuleLivePlaylistDuration, report.Values["rule"])
+	})
+}
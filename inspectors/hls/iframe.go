@@ -0,0 +1,268 @@
+package hls
+
+import (
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4"
+	"github.com/abema/antares/internal/ts"
+	"github.com/grafov/m3u8"
+)
+
+type IFrameInspectorConfig struct {
+	// WarnLagRatio and ErrorLagRatio are thresholds of the time difference between
+	// the I-frame only playlist and the main media playlists, as multiples of EXT-X-TARGETDURATION.
+	WarnLagRatio  float64
+	ErrorLagRatio float64
+}
+
+func DefaultIFrameInspectorConfig() *IFrameInspectorConfig {
+	return &IFrameInspectorConfig{
+		WarnLagRatio:  1.5,
+		ErrorLagRatio: 3,
+	}
+}
+
+// NewIFrameInspector returns IFrameInspector.
+// It inspects that I-frame only playlists advance in lockstep with the main media playlists
+// and that each I-frame starts with a keyframe.
+func NewIFrameInspector() core.HLSInspector {
+	return NewIFrameInspectorWithConfig(DefaultIFrameInspectorConfig())
+}
+
+func NewIFrameInspectorWithConfig(config *IFrameInspectorConfig) core.HLSInspector {
+	return &iframeInspector{
+		config:   config,
+		keyframe: make(map[string]bool),
+	}
+}
+
+type iframeInspector struct {
+	config *IFrameInspectorConfig
+	// keyframe holds results of the keyframe check for each I-frame.
+	keyframe map[string]bool
+}
+
+func (ins *iframeInspector) Inspect(playlists *core.Playlists, segments core.SegmentStore) *core.Report {
+	mains := make([]*core.MediaPlaylist, 0)
+	iframes := make([]*core.MediaPlaylist, 0)
+	for _, media := range playlists.MediaPlaylists {
+		if media.IFrameOnly {
+			iframes = append(iframes, media)
+		} else if media.Alternative == nil {
+			mains = append(mains, media)
+		}
+	}
+	if len(iframes) == 0 {
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Info,
+			Message:  "skip no I-frame only playlists",
+		}
+	}
+	keyframe := make(map[string]bool)
+	var report *core.Report
+	for _, media := range iframes {
+		if rep := ins.inspectMediaPlaylist(media, mains, segments, keyframe); report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+	ins.keyframe = keyframe
+	return report
+}
+
+func (ins *iframeInspector) inspectMediaPlaylist(
+	media *core.MediaPlaylist,
+	mains []*core.MediaPlaylist,
+	segments core.SegmentStore,
+	keyframe map[string]bool,
+) *core.Report {
+	values := core.Values{"url": media.URL}
+	if len(media.Segments) == 0 {
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Error,
+			Message:  "no segments",
+			Values:   values,
+		}
+	}
+	urls, err := media.SegmentURLs()
+	if err != nil {
+		values["error"] = err
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Error,
+			Message:  "invalid segment URL",
+			Values:   values,
+		}
+	}
+	var codecs string
+	if media.VariantParams != nil {
+		codecs = media.VariantParams.Codecs
+	}
+	for i, segment := range media.Segments {
+		byteRange := segmentByteRange(segment)
+		key := core.SegmentKey(urls[i], byteRange)
+		ok, checked := ins.keyframe[key]
+		if !checked {
+			data, exists := segments.LoadRange(urls[i], byteRange)
+			if !exists {
+				continue
+			}
+			var known bool
+			if ok, known, err = startsWithKeyframe(data, codecs); err != nil {
+				values["segment"] = key
+				values["error"] = err
+				return &core.Report{
+					Name:     "IFrameInspector",
+					Severity: core.Error,
+					Message:  "failed to parse I-frame",
+					Values:   values,
+				}
+			} else if !known {
+				continue
+			}
+		}
+		keyframe[key] = ok
+		if !ok {
+			values["segment"] = key
+			values["seqID"] = segment.SeqId
+			return &core.Report{
+				Name:     "IFrameInspector",
+				Severity: core.Error,
+				Message:  "I-frame doesn't start with keyframe",
+				Values:   values,
+			}
+		}
+	}
+	lag, ok := iframeLag(media, urls[len(urls)-1], mains)
+	if !ok {
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Info,
+			Message:  "good",
+			Values:   values,
+		}
+	}
+	values["lag"] = lag
+	values["targetDuration"] = media.TargetDuration
+	if ins.config.ErrorLagRatio != 0 && lag > media.TargetDuration*ins.config.ErrorLagRatio {
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Error,
+			Message:  "I-frame only playlist is out of sync",
+			Values:   values,
+		}
+	} else if ins.config.WarnLagRatio != 0 && lag > media.TargetDuration*ins.config.WarnLagRatio {
+		return &core.Report{
+			Name:     "IFrameInspector",
+			Severity: core.Warn,
+			Message:  "I-frame only playlist is out of sync",
+			Values:   values,
+		}
+	}
+	return &core.Report{
+		Name:     "IFrameInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   values,
+	}
+}
+
+func segmentByteRange(segment *m3u8.MediaSegment) *core.ByteRange {
+	if segment.Limit == 0 {
+		return nil
+	}
+	return &core.ByteRange{Offset: segment.Offset, Length: segment.Limit}
+}
+
+// startsWithKeyframe returns whether the I-frame data starts with a keyframe.
+// known is false when the data doesn't have enough information to decide it.
+func startsWithKeyframe(data []byte, codecs string) (ok bool, known bool, err error) {
+	if len(data) != 0 && data[0] == ts.SyncByte {
+		packets, err := ts.ReadPackets(data)
+		if err != nil {
+			return false, false, err
+		}
+		pes, randomAccess, found := ts.FirstVideoPES(packets)
+		if !found {
+			return false, true, nil
+		} else if randomAccess {
+			return true, true, nil
+		}
+		hevc := strings.Contains(codecs, "hvc1") || strings.Contains(codecs, "hev1")
+		avc := strings.Contains(codecs, "avc1") || strings.Contains(codecs, "avc3")
+		if !hevc && !avc {
+			return false, false, nil
+		}
+		es, err := ts.PESPayload(pes)
+		if err != nil {
+			return false, false, err
+		}
+		return ts.ContainsIDR(es, hevc), true, nil
+	}
+	return mp4.FirstSampleIsSync(data)
+}
+
+// iframeLag returns the time difference between the latest I-frame and the most advanced main media playlist.
+// It compares EXT-X-PROGRAM-DATE-TIME if possible, otherwise it finds the main segment which contains the latest I-frame.
+func iframeLag(media *core.MediaPlaylist, latestURL string, mains []*core.MediaPlaylist) (float64, bool) {
+	if end, ok := endTime(media); ok {
+		var mainEnd time.Time
+		for _, main := range mains {
+			if e, ok := endTime(main); ok && e.After(mainEnd) {
+				mainEnd = e
+			}
+		}
+		if !mainEnd.IsZero() {
+			return math.Abs(mainEnd.Sub(end).Seconds()), true
+		}
+	}
+	latest := media.Segments[len(media.Segments)-1]
+	var lag float64
+	var found bool
+	for _, main := range mains {
+		base, err := url.Parse(main.URL)
+		if err != nil {
+			continue
+		}
+		var after float64
+		for i := len(main.Segments) - 1; i >= 0; i-- {
+			segment := main.Segments[i]
+			u, err := base.Parse(segment.URI)
+			if err != nil {
+				break
+			}
+			if u.String() == latestURL && containsOffset(segment, latest.Offset) {
+				if !found || after > lag {
+					lag = after
+				}
+				found = true
+				break
+			}
+			after += segment.Duration
+		}
+	}
+	return lag, found
+}
+
+func containsOffset(segment *m3u8.MediaSegment, offset int64) bool {
+	return segment.Limit == 0 || (segment.Offset <= offset && offset < segment.Offset+segment.Limit)
+}
+
+// endTime returns the end time of the playlist based on EXT-X-PROGRAM-DATE-TIME tag.
+func endTime(media *core.MediaPlaylist) (time.Time, bool) {
+	var end time.Time
+	for _, segment := range media.Segments {
+		if !segment.ProgramDateTime.IsZero() {
+			end = segment.ProgramDateTime
+		} else if end.IsZero() {
+			continue
+		}
+		end = end.Add(time.Duration(segment.Duration * float64(time.Second)))
+	}
+	return end, !end.IsZero()
+}
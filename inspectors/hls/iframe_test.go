@@ -0,0 +1,99 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/abema/antares/internal/segmenttest"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// moof returns a movie fragment which has first_sample_flags.
+func moof(sync bool) []byte {
+	flags := uint32(0x01010000)
+	if sync {
+		flags = 0x02000000
+	}
+	return mp4test.Box("moof", mp4test.Box("traf", mp4test.FullBox("trun", 0, 0x000004, mp4test.U32(1, flags))))
+}
+
+func TestIFrameInspector(t *testing.T) {
+	build := func(mainSeqs int, iframeSeqs int) *core.Playlists {
+		main := &core.MediaPlaylist{
+			URL:           "https://foo/main.m3u8",
+			VariantParams: &m3u8.VariantParams{Bandwidth: 1000000},
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 2},
+		}
+		iframe := &core.MediaPlaylist{
+			URL:           "https://foo/iframe.m3u8",
+			VariantParams: &m3u8.VariantParams{Bandwidth: 100000, Iframe: true},
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 2},
+			IFrameOnly:    true,
+		}
+		for i := 0; i < mainSeqs; i++ {
+			main.Segments = append(main.Segments, &m3u8.MediaSegment{
+				SeqId: uint64(i), URI: "main.mp4", Duration: 2, Offset: int64(i * 1000), Limit: 1000,
+			})
+		}
+		for i := 0; i < iframeSeqs; i++ {
+			iframe.Segments = append(iframe.Segments, &m3u8.MediaSegment{
+				SeqId: uint64(i), URI: "main.mp4", Duration: 2, Offset: int64(i * 1000), Limit: 40,
+			})
+		}
+		return &core.Playlists{
+			MediaPlaylists: map[string]*core.MediaPlaylist{
+				"main.m3u8":   main,
+				"iframe.m3u8": iframe,
+			},
+		}
+	}
+	store := segmenttest.SegmentStore{}
+	for i := 0; i < 10; i++ {
+		store["https://foo/main.mp4#"+(&core.ByteRange{Offset: int64(i * 1000), Length: 40}).String()] = moof(i != 7)
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		report := NewIFrameInspector().Inspect(build(5, 5), store)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 0.0, report.Values["lag"])
+	})
+
+	t.Run("lag", func(t *testing.T) {
+		report := NewIFrameInspector().Inspect(build(6, 4), store)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "I-frame only playlist is out of sync", report.Message)
+		assert.Equal(t, 4.0, report.Values["lag"])
+		report = NewIFrameInspector().Inspect(build(7, 3), store)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "I-frame only playlist is out of sync", report.Message)
+	})
+
+	t.Run("program_date_time", func(t *testing.T) {
+		playlists := build(5, 5)
+		playlists.MediaPlaylists["main.m3u8"].Segments[0].ProgramDateTime = time.Unix(1000, 0)
+		playlists.MediaPlaylists["iframe.m3u8"].Segments[0].ProgramDateTime = time.Unix(996, 0)
+		report := NewIFrameInspector().Inspect(playlists, store)
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, 4.0, report.Values["lag"])
+	})
+
+	t.Run("not_keyframe", func(t *testing.T) {
+		report := NewIFrameInspector().Inspect(build(8, 8), store)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "I-frame doesn't start with keyframe", report.Message)
+		assert.Equal(t, uint64(7), report.Values["seqID"])
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		playlists := build(5, 5)
+		delete(playlists.MediaPlaylists, "iframe.m3u8")
+		report := NewIFrameInspector().Inspect(playlists, store)
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "skip no I-frame only playlists", report.Message)
+	})
+}
@@ -0,0 +1,308 @@
+package hls
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/core"
+)
+
+type InterstitialInspectorConfig struct {
+	// Tolerance is the allowable difference between durations and dates of interstitials.
+	Tolerance time.Duration
+}
+
+func DefaultInterstitialInspectorConfig() *InterstitialInspectorConfig {
+	return &InterstitialInspectorConfig{
+		Tolerance: 500 * time.Millisecond,
+	}
+}
+
+// NewInterstitialInspector returns InterstitialInspector.
+// It inspects EXT-X-DATERANGE tags whose CLASS is "com.apple.hls.interstitial",
+// and the asset lists and asset playlists downloaded by the monitor.
+func NewInterstitialInspector() core.HLSInspector {
+	return NewInterstitialInspectorWithConfig(DefaultInterstitialInspectorConfig())
+}
+
+func NewInterstitialInspectorWithConfig(config *InterstitialInspectorConfig) core.HLSInspector {
+	return &interstitialInspector{
+		config: config,
+	}
+}
+
+type interstitialInspector struct {
+	config *InterstitialInspectorConfig
+}
+
+// interstitialEnums is the allowed values of enumerated-string-list attributes.
+var interstitialEnums = []struct {
+	name   string
+	values map[string]bool
+}{
+	{name: "CUE", values: map[string]bool{"PRE": true, "POST": true, "ONCE": true}},
+	{name: "X-SNAP", values: map[string]bool{"OUT": true, "IN": true}},
+	{name: "X-RESTRICT", values: map[string]bool{"SKIP": true, "JUMP": true}},
+}
+
+func (ins *interstitialInspector) Inspect(playlists *core.Playlists, _ core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+
+	keys := make([]string, 0, len(playlists.MediaPlaylists))
+	for key, media := range playlists.MediaPlaylists {
+		if !media.IFrameOnly {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	ids := make(map[string]bool)
+	for _, key := range keys {
+		media := playlists.MediaPlaylists[key]
+		var interstitials int
+		for _, dateRange := range media.DateRanges {
+			if dateRange.Class != core.InterstitialClass {
+				continue
+			}
+			interstitials++
+			ids[dateRange.ID] = true
+			ins.inspectDateRange(media, dateRange, setReport)
+		}
+		if interstitials != 0 {
+			ins.inspectWindow(media, setReport)
+		}
+	}
+
+	if report != nil {
+		return report
+	}
+	if len(ids) == 0 {
+		return &core.Report{
+			Name:     "InterstitialInspector",
+			Severity: core.Info,
+			Message:  "no interstitials",
+		}
+	}
+	return &core.Report{
+		Name:     "InterstitialInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   core.Values{"interstitials": len(ids)},
+	}
+}
+
+func (ins *interstitialInspector) inspectDateRange(media *core.MediaPlaylist, dateRange *core.DateRange, setReport func(*core.Report)) {
+	tolerance := ins.config.Tolerance.Seconds()
+	// each report has its own values so that later checks don't modify reports which are already set.
+	report := func(severity core.Severity, message string, extra core.Values) {
+		values := core.Values{"playlist": media.URL, "id": dateRange.ID}
+		for k, v := range extra {
+			values[k] = v
+		}
+		setReport(&core.Report{
+			Name:     "InterstitialInspector",
+			Severity: severity,
+			Message:  message,
+			Values:   values,
+		})
+	}
+	attrs := dateRange.Attributes
+
+	assetURI, assetList := attrs["X-ASSET-URI"], attrs["X-ASSET-LIST"]
+	if assetURI == "" && assetList == "" {
+		report(core.Error, "interstitial has neither X-ASSET-URI nor X-ASSET-LIST", nil)
+	} else if assetURI != "" && assetList != "" {
+		report(core.Error, "interstitial has both X-ASSET-URI and X-ASSET-LIST", nil)
+	}
+	if dateRange.StartDate.IsZero() {
+		report(core.Error, "START-DATE of interstitial is invalid", core.Values{
+			"startDate": attrs["START-DATE"],
+		})
+	}
+	for _, enum := range interstitialEnums {
+		value, ok := attrs[enum.name]
+		if !ok {
+			continue
+		}
+		for _, e := range strings.Split(value, ",") {
+			if !enum.values[strings.TrimSpace(e)] {
+				report(core.Error, "interstitial attribute has unknown value", core.Values{
+					"attribute": enum.name,
+					"value":     value,
+				})
+				break
+			}
+		}
+	}
+	cue := strings.Split(attrs["CUE"], ",")
+	if containsString(cue, "PRE") && containsString(cue, "POST") {
+		report(core.Error, "CUE has both PRE and POST", core.Values{
+			"cue": attrs["CUE"],
+		})
+	}
+	resumeOffset, hasResumeOffset, ok := parseNonNegativeFloat(attrs, "X-RESUME-OFFSET")
+	if !ok {
+		report(core.Error, "X-RESUME-OFFSET is invalid", core.Values{
+			"resumeOffset": attrs["X-RESUME-OFFSET"],
+		})
+	}
+	playoutLimit, hasPlayoutLimit, ok := parseNonNegativeFloat(attrs, "X-PLAYOUT-LIMIT")
+	if !ok || (hasPlayoutLimit && playoutLimit == 0) {
+		report(core.Error, "X-PLAYOUT-LIMIT is invalid", core.Values{
+			"playoutLimit": attrs["X-PLAYOUT-LIMIT"],
+		})
+	}
+
+	interstitial := dateRange.Interstitial
+	if interstitial == nil {
+		return
+	}
+	if interstitial.Error != nil {
+		report(core.Error, "interstitial asset list is unreachable", core.Values{
+			"assetList": interstitial.AssetList,
+			"error":     interstitial.Error,
+		})
+		return
+	}
+	var total float64
+	resolved := true
+	for _, asset := range interstitial.Assets {
+		if asset.Error != nil {
+			report(core.Error, "interstitial asset is unreachable", core.Values{
+				"asset": asset.URI,
+				"error": asset.Error,
+			})
+			resolved = false
+			continue
+		}
+		if asset.Duration != nil {
+			total += *asset.Duration
+			if asset.Closed && math.Abs(*asset.Duration-asset.PlaylistDuration) > tolerance {
+				report(core.Warn, "DURATION of asset list mismatches asset playlist", core.Values{
+					"asset":            asset.URI,
+					"duration":         *asset.Duration,
+					"playlistDuration": asset.PlaylistDuration,
+				})
+			}
+		} else if asset.Closed {
+			total += asset.PlaylistDuration
+		} else {
+			// the duration of a live asset is unknown.
+			resolved = false
+		}
+	}
+	if !resolved || len(interstitial.Assets) == 0 {
+		return
+	}
+	if hasPlayoutLimit && total > playoutLimit {
+		total = playoutLimit
+	}
+	if hasResumeOffset {
+		// X-RESUME-OFFSET=0 means the interstitial is inserted without replacing the primary content.
+		if resumeOffset != 0 && math.Abs(total-resumeOffset) > tolerance {
+			report(core.Warn, "duration of interstitial assets mismatches X-RESUME-OFFSET", core.Values{
+				"assetsDuration": total,
+				"resumeOffset":   resumeOffset,
+			})
+		}
+	} else if dateRange.Duration != nil && math.Abs(total-*dateRange.Duration) > tolerance {
+		report(core.Warn, "duration of interstitial assets mismatches DURATION", core.Values{
+			"assetsDuration": total,
+			"duration":       *dateRange.Duration,
+		})
+	}
+}
+
+// inspectWindow inspects whether interstitials are scheduled within the playlist window.
+func (ins *interstitialInspector) inspectWindow(media *core.MediaPlaylist, setReport func(*core.Report)) {
+	windowStart, ok := playlistStartDate(media)
+	if !ok {
+		setReport(&core.Report{
+			Name:     "InterstitialInspector",
+			Severity: core.Error,
+			Message:  "playlist with interstitials has no EXT-X-PROGRAM-DATE-TIME",
+			Values:   core.Values{"playlist": media.URL},
+		})
+		return
+	}
+	windowEnd, _ := playlistEndDate(media)
+	for _, dateRange := range media.DateRanges {
+		if dateRange.Class != core.InterstitialClass || dateRange.StartDate.IsZero() {
+			continue
+		}
+		cue := strings.Split(dateRange.Attributes["CUE"], ",")
+		if containsString(cue, "PRE") || containsString(cue, "POST") {
+			// pre-roll and post-roll interstitials are played regardless of START-DATE.
+			continue
+		}
+		end, ok := dateRange.End()
+		if !ok {
+			end = dateRange.StartDate
+		}
+		values := core.Values{
+			"playlist":    media.URL,
+			"id":          dateRange.ID,
+			"startDate":   dateRange.StartDate.Format(time.RFC3339Nano),
+			"windowStart": windowStart.Format(time.RFC3339Nano),
+			"windowEnd":   windowEnd.Format(time.RFC3339Nano),
+		}
+		if end.Before(windowStart.Add(-ins.config.Tolerance)) {
+			setReport(&core.Report{
+				Name:     "InterstitialInspector",
+				Severity: core.Warn,
+				Message:  "interstitial is scheduled outside the playlist window",
+				Values:   values,
+			})
+		} else if media.Closed && dateRange.StartDate.After(windowEnd.Add(ins.config.Tolerance)) {
+			// the interstitial is never played because no segment will be appended.
+			setReport(&core.Report{
+				Name:     "InterstitialInspector",
+				Severity: core.Error,
+				Message:  "interstitial is scheduled outside the playlist window",
+				Values:   values,
+			})
+		}
+	}
+}
+
+// playlistStartDate returns the date of the first segment estimated by EXT-X-PROGRAM-DATE-TIME tags.
+func playlistStartDate(media *core.MediaPlaylist) (time.Time, bool) {
+	var elapsed float64
+	for _, segment := range media.Segments {
+		if !segment.ProgramDateTime.IsZero() {
+			return segment.ProgramDateTime.Add(-time.Duration(elapsed * float64(time.Second))), true
+		}
+		elapsed += segment.Duration
+	}
+	return time.Time{}, false
+}
+
+// parseNonNegativeFloat parses the attribute as decimal-floating-point.
+// ok is false when the attribute is present and invalid.
+func parseNonNegativeFloat(attrs map[string]string, name string) (value float64, present bool, ok bool) {
+	s, present := attrs[name]
+	if !present {
+		return 0, false, true
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, true, false
+	}
+	return value, true, true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == s {
+			return true
+		}
+	}
+	return false
+}
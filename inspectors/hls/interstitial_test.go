@@ -0,0 +1,180 @@
+package hls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterstitialInspector(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	float := func(f float64) *float64 { return &f }
+	build := func(closed bool, dateRanges ...*core.DateRange) *core.Playlists {
+		media := &core.MediaPlaylist{
+			URL:           "media.m3u8",
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 2, Closed: closed},
+			DateRanges:    dateRanges,
+		}
+		for seq := uint64(0); seq < 10; seq++ {
+			media.Segments = append(media.Segments, &m3u8.MediaSegment{SeqId: seq, Duration: 2.0})
+		}
+		media.Segments[1].ProgramDateTime = start.Add(2 * time.Second)
+		return &core.Playlists{MediaPlaylists: map[string]*core.MediaPlaylist{"media.m3u8": media}}
+	}
+	interstitial := func(id string, offset float64, attrs map[string]string, assets ...*core.InterstitialAsset) *core.DateRange {
+		r := &core.DateRange{
+			ID:         id,
+			Class:      core.InterstitialClass,
+			StartDate:  start.Add(time.Duration(offset * float64(time.Second))),
+			Attributes: map[string]string{"ID": id, "CLASS": core.InterstitialClass, "X-ASSET-URI": "ad.m3u8"},
+		}
+		for k, v := range attrs {
+			if v == "" {
+				delete(r.Attributes, k)
+			} else {
+				r.Attributes[k] = v
+			}
+		}
+		if len(assets) != 0 {
+			r.Interstitial = &core.Interstitial{Assets: assets}
+		}
+		return r
+	}
+	asset := func(duration *float64, playlistDuration float64) *core.InterstitialAsset {
+		return &core.InterstitialAsset{URI: "ad.m3u8", Duration: duration, PlaylistDuration: playlistDuration, Closed: true}
+	}
+
+	t.Run("no interstitials", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "no interstitials", report.Message)
+	})
+
+	t.Run("good", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false,
+			interstitial("ad1", 4, map[string]string{"X-RESUME-OFFSET": "10", "X-SNAP": "OUT,IN", "X-RESTRICT": "SKIP"}, asset(nil, 10)),
+			interstitial("ad2", 10, map[string]string{"X-ASSET-URI": "", "X-ASSET-LIST": "list.json", "X-PLAYOUT-LIMIT": "15"},
+				asset(float(10), 10), asset(float(10), 10)),
+			interstitial("pre", -100, map[string]string{"CUE": "PRE,ONCE"}),
+		), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 3, report.Values["interstitials"])
+	})
+
+	t.Run("invalid attributes", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			attrs   map[string]string
+			message string
+		}{
+			{name: "no asset", attrs: map[string]string{"X-ASSET-URI": ""}, message: "interstitial has neither X-ASSET-URI nor X-ASSET-LIST"},
+			{name: "both assets", attrs: map[string]string{"X-ASSET-LIST": "list.json"}, message: "interstitial has both X-ASSET-URI and X-ASSET-LIST"},
+			{name: "unknown X-SNAP", attrs: map[string]string{"X-SNAP": "OUT,FOO"}, message: "interstitial attribute has unknown value"},
+			{name: "unknown X-RESTRICT", attrs: map[string]string{"X-RESTRICT": "SEEK"}, message: "interstitial attribute has unknown value"},
+			{name: "PRE and POST", attrs: map[string]string{"CUE": "PRE,POST"}, message: "CUE has both PRE and POST"},
+			{name: "negative X-RESUME-OFFSET", attrs: map[string]string{"X-RESUME-OFFSET": "-1"}, message: "X-RESUME-OFFSET is invalid"},
+			{name: "zero X-PLAYOUT-LIMIT", attrs: map[string]string{"X-PLAYOUT-LIMIT": "0"}, message: "X-PLAYOUT-LIMIT is invalid"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				report := NewInterstitialInspector().Inspect(build(false, interstitial("ad1", 4, tc.attrs)), nil)
+				require.NotNil(t, report)
+				assert.Equal(t, core.Error, report.Severity)
+				assert.Equal(t, tc.message, report.Message)
+				assert.Equal(t, "ad1", report.Values["id"])
+			})
+		}
+	})
+
+	t.Run("values of reports are independent", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false, interstitial("ad1", 4, map[string]string{
+			"X-ASSET-URI":     "",
+			"X-SNAP":          "FOO",
+			"X-RESUME-OFFSET": "-1",
+		})), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "interstitial has neither X-ASSET-URI nor X-ASSET-LIST", report.Message)
+		assert.Equal(t, core.Values{"playlist": "media.m3u8", "id": "ad1"}, report.Values)
+	})
+
+	t.Run("unreachable asset list", func(t *testing.T) {
+		r := interstitial("ad1", 4, map[string]string{"X-ASSET-URI": "", "X-ASSET-LIST": "list.json"})
+		r.Interstitial = &core.Interstitial{AssetList: "list.json", Error: errors.New("404 Not Found")}
+		report := NewInterstitialInspector().Inspect(build(false, r), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "interstitial asset list is unreachable", report.Message)
+		assert.Equal(t, "list.json", report.Values["assetList"])
+	})
+
+	t.Run("unreachable asset", func(t *testing.T) {
+		broken := &core.InterstitialAsset{URI: "ad.m3u8", Error: errors.New("404 Not Found")}
+		report := NewInterstitialInspector().Inspect(build(false, interstitial("ad1", 4, nil, broken)), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "interstitial asset is unreachable", report.Message)
+		assert.Equal(t, "ad.m3u8", report.Values["asset"])
+	})
+
+	t.Run("asset list duration mismatch", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false,
+			interstitial("ad1", 4, map[string]string{"X-RESUME-OFFSET": "0"}, asset(float(15), 10)),
+		), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "DURATION of asset list mismatches asset playlist", report.Message)
+	})
+
+	t.Run("resume offset mismatch", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false,
+			interstitial("ad1", 4, map[string]string{"X-RESUME-OFFSET": "15"}, asset(nil, 10)),
+		), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "duration of interstitial assets mismatches X-RESUME-OFFSET", report.Message)
+		assert.Equal(t, 10.0, report.Values["assetsDuration"])
+	})
+
+	t.Run("duration mismatch", func(t *testing.T) {
+		r := interstitial("ad1", 4, nil, asset(nil, 10))
+		r.Duration = float(20)
+		report := NewInterstitialInspector().Inspect(build(false, r), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "duration of interstitial assets mismatches DURATION", report.Message)
+	})
+
+	t.Run("outside window", func(t *testing.T) {
+		report := NewInterstitialInspector().Inspect(build(false, interstitial("ad1", -10, nil)), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "interstitial is scheduled outside the playlist window", report.Message)
+
+		// live playlists can schedule interstitials in advance.
+		report = NewInterstitialInspector().Inspect(build(false, interstitial("ad1", 30, nil)), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+
+		report = NewInterstitialInspector().Inspect(build(true, interstitial("ad1", 30, nil)), nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "interstitial is scheduled outside the playlist window", report.Message)
+	})
+
+	t.Run("no program date time", func(t *testing.T) {
+		playlists := build(false, interstitial("ad1", 4, nil))
+		playlists.MediaPlaylists["media.m3u8"].Segments[1].ProgramDateTime = time.Time{}
+		report := NewInterstitialInspector().Inspect(playlists, nil)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "playlist with interstitials has no EXT-X-PROGRAM-DATE-TIME", report.Message)
+	})
+}
@@ -0,0 +1,215 @@
+package hls
+
+import (
+	"math"
+	"time"
+
+	"github.com/abema/antares/core"
+)
+
+type LowLatencyInspectorConfig struct {
+	// WarnLatePartRatio and ErrorLatePartRatio are thresholds of
+	// the elapsed time since the latest partial segment was published, as multiples of PART-TARGET.
+	WarnLatePartRatio  float64
+	ErrorLatePartRatio float64
+}
+
+func DefaultLowLatencyInspectorConfig() *LowLatencyInspectorConfig {
+	return &LowLatencyInspectorConfig{
+		WarnLatePartRatio:  2,
+		ErrorLatePartRatio: 3,
+	}
+}
+
+// NewLowLatencyInspector returns LowLatencyInspector.
+// It inspects partial segments, preload hints and blocking playlist reload of Low-Latency HLS.
+func NewLowLatencyInspector() core.HLSInspector {
+	return NewLowLatencyInspectorWithConfig(DefaultLowLatencyInspectorConfig())
+}
+
+func NewLowLatencyInspectorWithConfig(config *LowLatencyInspectorConfig) core.HLSInspector {
+	return &lowLatencyInspector{
+		config:   config,
+		partLogs: make(map[string]*partLog),
+	}
+}
+
+type partLog struct {
+	seqID     uint64
+	index     int
+	published time.Time
+}
+
+type lowLatencyInspector struct {
+	config   *LowLatencyInspectorConfig
+	partLogs map[string]*partLog
+}
+
+func (ins *lowLatencyInspector) Inspect(playlists *core.Playlists, _ core.SegmentStore) *core.Report {
+	var lowLatency bool
+	report := &core.Report{
+		Name:     "LowLatencyInspector",
+		Severity: core.Info,
+		Message:  "good",
+	}
+	for _, media := range playlists.MediaPlaylists {
+		if media.PartTarget == 0 && len(media.PartialSegments) == 0 {
+			continue
+		}
+		lowLatency = true
+		if rep := ins.inspectMediaPlaylist(media); rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+	if !lowLatency {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Info,
+			Message:  "skip non low-latency playlist",
+		}
+	}
+	return report
+}
+
+func (ins *lowLatencyInspector) inspectMediaPlaylist(media *core.MediaPlaylist) *core.Report {
+	values := core.Values{
+		"url":        media.URL,
+		"partTarget": media.PartTarget,
+	}
+	if media.PartTarget == 0 {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Error,
+			Message:  "EXT-X-PART-INF is omitted",
+			Values:   values,
+		}
+	}
+	for _, part := range media.PartialSegments {
+		if part.Duration > media.PartTarget+core.DurationEpsilon {
+			values["seqID"] = part.SeqID
+			values["partIndex"] = part.Index
+			values["partDuration"] = part.Duration
+			return &core.Report{
+				Name:     "LowLatencyInspector",
+				Severity: core.Error,
+				Message:  "partial segment duration exceeds PART-TARGET",
+				Values:   values,
+			}
+		}
+	}
+	if media.ServerControl == nil || !media.ServerControl.CanBlockReload {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Error,
+			Message:  "CAN-BLOCK-RELOAD=YES is required",
+			Values:   values,
+		}
+	}
+	values["partHoldBack"] = media.ServerControl.PartHoldBack
+	if media.ServerControl.PartHoldBack < media.PartTarget*2 {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Error,
+			Message:  "PART-HOLD-BACK must be at least twice PART-TARGET",
+			Values:   values,
+		}
+	}
+	if media.BlockingRequest != nil && !media.Closed && !media.SatisfiesBlockingRequest(media.BlockingRequest) {
+		values["msn"] = media.BlockingRequest.MSN
+		values["part"] = media.BlockingRequest.Part
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Error,
+			Message:  "blocking playlist reload responded without requested segment",
+			Values:   values,
+		}
+	}
+	if rep := ins.inspectMissingParts(media, values); rep != nil {
+		return rep
+	}
+	return ins.inspectPublication(media, values)
+}
+
+// inspectMissingParts checks that partial segments are listed for segments in the last three target durations
+// and that durations of partial segments sum up to the parent segment duration.
+func (ins *lowLatencyInspector) inspectMissingParts(media *core.MediaPlaylist, values core.Values) *core.Report {
+	partDurations := make(map[uint64]float64)
+	for _, part := range media.PartialSegments {
+		partDurations[part.SeqID] += part.Duration
+	}
+	var elapsed float64
+	for i := len(media.Segments) - 1; i >= 0; i-- {
+		segment := media.Segments[i]
+		partDuration, ok := partDurations[segment.SeqId]
+		if !ok {
+			if elapsed < media.TargetDuration*3 {
+				values["seqID"] = segment.SeqId
+				return &core.Report{
+					Name:     "LowLatencyInspector",
+					Severity: core.Error,
+					Message:  "partial segments are missing in the last three target durations",
+					Values:   values,
+				}
+			}
+			break
+		}
+		if math.Abs(partDuration-segment.Duration) > media.PartTarget/2 {
+			values["seqID"] = segment.SeqId
+			values["segmentDuration"] = segment.Duration
+			values["partsDuration"] = partDuration
+			return &core.Report{
+				Name:     "LowLatencyInspector",
+				Severity: core.Error,
+				Message:  "partial segments don't cover the parent segment",
+				Values:   values,
+			}
+		}
+		elapsed += segment.Duration
+	}
+	return nil
+}
+
+// inspectPublication checks that a new partial segment is published at least once per PART-TARGET.
+func (ins *lowLatencyInspector) inspectPublication(media *core.MediaPlaylist, values core.Values) *core.Report {
+	last := media.LastPartialSegment()
+	if last == nil || media.Closed {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Info,
+			Message:  "good",
+			Values:   values,
+		}
+	}
+	log := ins.partLogs[media.URL]
+	if log == nil || log.seqID != last.SeqID || log.index != last.Index {
+		log = &partLog{
+			seqID:     last.SeqID,
+			index:     last.Index,
+			published: media.Time,
+		}
+		ins.partLogs[media.URL] = log
+	}
+	elapsed := media.Time.Sub(log.published).Seconds()
+	values["elapsed"] = elapsed
+	if ins.config.ErrorLatePartRatio != 0 && elapsed > media.PartTarget*ins.config.ErrorLatePartRatio {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Error,
+			Message:  "partial segment is published late",
+			Values:   values,
+		}
+	} else if ins.config.WarnLatePartRatio != 0 && elapsed > media.PartTarget*ins.config.WarnLatePartRatio {
+		return &core.Report{
+			Name:     "LowLatencyInspector",
+			Severity: core.Warn,
+			Message:  "partial segment is published late",
+			Values:   values,
+		}
+	}
+	return &core.Report{
+		Name:     "LowLatencyInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   values,
+	}
+}
@@ -0,0 +1,111 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLowLatencyInspector(t *testing.T) {
+	build := func(tm time.Time, parts int) *core.MediaPlaylist {
+		media := &core.MediaPlaylist{
+			URL:  "https://foo/0.m3u8",
+			Time: tm,
+			MediaPlaylist: &m3u8.MediaPlaylist{
+				TargetDuration: 2,
+				Segments: []*m3u8.MediaSegment{
+					{SeqId: 10, Duration: 2.0},
+					{SeqId: 11, Duration: 2.0},
+					{SeqId: 12, Duration: 2.0},
+				},
+			},
+			ServerControl: &core.ServerControl{CanBlockReload: true, PartHoldBack: 1.5},
+			PartTarget:    0.5,
+		}
+		for seq := uint64(10); seq <= 12; seq++ {
+			for i := 0; i < 4; i++ {
+				media.PartialSegments = append(media.PartialSegments, &core.PartialSegment{SeqID: seq, Index: i, Duration: 0.5})
+			}
+		}
+		for i := 0; i < parts; i++ {
+			media.PartialSegments = append(media.PartialSegments, &core.PartialSegment{SeqID: 13, Index: i, Duration: 0.5})
+		}
+		return media
+	}
+	inspect := func(ins core.HLSInspector, media *core.MediaPlaylist) *core.Report {
+		return ins.Inspect(&core.Playlists{
+			MediaPlaylists: map[string]*core.MediaPlaylist{"0.m3u8": media},
+		}, nil)
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		ins := NewLowLatencyInspector()
+		report := inspect(ins, build(time.Unix(1000, 0), 1))
+		require.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build(time.Unix(1000, 500000000), 2))
+		require.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("late_part", func(t *testing.T) {
+		ins := NewLowLatencyInspector()
+		report := inspect(ins, build(time.Unix(1000, 0), 1))
+		require.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build(time.Unix(1001, 200000000), 1))
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "partial segment is published late", report.Message)
+		report = inspect(ins, build(time.Unix(1002, 0), 1))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "partial segment is published late", report.Message)
+	})
+
+	t.Run("part_target_violation", func(t *testing.T) {
+		media := build(time.Unix(1000, 0), 1)
+		media.LastPartialSegment().Duration = 0.6
+		report := inspect(NewLowLatencyInspector(), media)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "partial segment duration exceeds PART-TARGET", report.Message)
+	})
+
+	t.Run("missing_parts", func(t *testing.T) {
+		media := build(time.Unix(1000, 0), 1)
+		media.PartialSegments = media.PartialSegments[4:]
+		media.PartialSegments = append(media.PartialSegments[:2], media.PartialSegments[3:]...)
+		report := inspect(NewLowLatencyInspector(), media)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "partial segments don't cover the parent segment", report.Message)
+
+		media = build(time.Unix(1000, 0), 1)
+		media.PartialSegments = media.PartialSegments[8:]
+		report = inspect(NewLowLatencyInspector(), media)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "partial segments are missing in the last three target durations", report.Message)
+	})
+
+	t.Run("cannot_block_reload", func(t *testing.T) {
+		media := build(time.Unix(1000, 0), 1)
+		media.ServerControl.CanBlockReload = false
+		report := inspect(NewLowLatencyInspector(), media)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "CAN-BLOCK-RELOAD=YES is required", report.Message)
+	})
+
+	t.Run("blocking_request_not_satisfied", func(t *testing.T) {
+		media := build(time.Unix(1000, 0), 1)
+		media.BlockingRequest = &core.BlockingRequest{MSN: 13, Part: 1}
+		report := inspect(NewLowLatencyInspector(), media)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "blocking playlist reload responded without requested segment", report.Message)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		report := inspect(NewLowLatencyInspector(), &core.MediaPlaylist{
+			MediaPlaylist: &m3u8.MediaPlaylist{},
+		})
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "skip non low-latency playlist", report.Message)
+	})
+}
@@ -0,0 +1,310 @@
+package hls
+
+import (
+	"sort"
+	"time"
+
+	"github.com/abema/antares/core"
+)
+
+type ProgramDateTimeInspectorConfig struct {
+	// WarnLatency and ErrorLatency are the thresholds of the latency
+	// which is the difference between the download time and the end of the last segment.
+	// Zero disables the threshold.
+	WarnLatency  time.Duration
+	ErrorLatency time.Duration
+	// ClockTolerance is the allowable amount that the end of the last segment is ahead of the download time.
+	ClockTolerance time.Duration
+	// WarnDrift is the allowable difference between EXT-X-PROGRAM-DATE-TIME and the accumulated EXTINF durations.
+	WarnDrift time.Duration
+	// ErrorDrift is the threshold to regard the difference as a jump.
+	ErrorDrift time.Duration
+	// AlignmentTolerance is the allowable difference of EXT-X-PROGRAM-DATE-TIME between playlists.
+	AlignmentTolerance time.Duration
+}
+
+func DefaultProgramDateTimeInspectorConfig() *ProgramDateTimeInspectorConfig {
+	return &ProgramDateTimeInspectorConfig{
+		WarnLatency:        30 * time.Second,
+		ErrorLatency:       60 * time.Second,
+		ClockTolerance:     time.Second,
+		WarnDrift:          200 * time.Millisecond,
+		ErrorDrift:         time.Second,
+		AlignmentTolerance: 500 * time.Millisecond,
+	}
+}
+
+// NewProgramDateTimeInspector returns ProgramDateTimeInspector.
+// It inspects consistency of EXT-X-PROGRAM-DATE-TIME tags in each playlist and across playlists,
+// and the latency of the live edge.
+func NewProgramDateTimeInspector() core.HLSInspector {
+	return NewProgramDateTimeInspectorWithConfig(DefaultProgramDateTimeInspectorConfig())
+}
+
+func NewProgramDateTimeInspectorWithConfig(config *ProgramDateTimeInspectorConfig) core.HLSInspector {
+	return &programDateTimeInspector{
+		config: config,
+	}
+}
+
+type programDateTimeInspector struct {
+	config *ProgramDateTimeInspectorConfig
+}
+
+// segmentDate is the date range of a media segment estimated by EXT-X-PROGRAM-DATE-TIME tags.
+type segmentDate struct {
+	seqID uint64
+	start time.Time
+	end   time.Time
+}
+
+func (ins *programDateTimeInspector) Inspect(playlists *core.Playlists, _ core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+
+	keys := make([]string, 0, len(playlists.MediaPlaylists))
+	for key, media := range playlists.MediaPlaylists {
+		if !media.IFrameOnly && len(media.Segments) != 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	dates := make(map[string][]*segmentDate, len(keys))
+	var missing []string
+	for _, key := range keys {
+		media := playlists.MediaPlaylists[key]
+		if d := ins.segmentDates(media, setReport); len(d) != 0 {
+			dates[key] = d
+		} else {
+			missing = append(missing, media.URL)
+		}
+	}
+	if len(dates) == 0 {
+		return &core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Info,
+			Message:  "no EXT-X-PROGRAM-DATE-TIME",
+		}
+	}
+	if len(missing) != 0 {
+		setReport(&core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Warn,
+			Message:  "some playlists have no EXT-X-PROGRAM-DATE-TIME",
+			Values:   core.Values{"playlists": missing},
+		})
+	}
+	ins.inspectAlignment(playlists, keys, dates, setReport)
+	values := ins.inspectLatency(playlists, keys, dates, setReport)
+
+	if report != nil {
+		return report
+	}
+	return &core.Report{
+		Name:     "ProgramDateTimeInspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   values,
+	}
+}
+
+// segmentDates returns the dates of the segments, and reports drifts and jumps of EXT-X-PROGRAM-DATE-TIME tags.
+// The dates of the segments which precede the first EXT-X-PROGRAM-DATE-TIME tag are estimated backward
+// unless EXT-X-DISCONTINUITY tag exists between them.
+func (ins *programDateTimeInspector) segmentDates(media *core.MediaPlaylist, setReport func(*core.Report)) []*segmentDate {
+	dates := make([]*segmentDate, 0, len(media.Segments))
+	first := -1
+	var next time.Time
+	var known bool
+	for i, segment := range media.Segments {
+		if segment.Discontinuity {
+			known = false
+		}
+		if pdt := segment.ProgramDateTime; !pdt.IsZero() {
+			if known {
+				ins.inspectDrift(media, segment.SeqId, next, pdt, setReport)
+			}
+			next = pdt
+			known = true
+			if first < 0 {
+				first = i
+			}
+		}
+		if !known {
+			continue
+		}
+		end := next.Add(time.Duration(segment.Duration * float64(time.Second)))
+		dates = append(dates, &segmentDate{seqID: segment.SeqId, start: next, end: end})
+		next = end
+	}
+	if first <= 0 {
+		return dates
+	}
+	start := media.Segments[first].ProgramDateTime
+	for i := first - 1; i >= 0 && !media.Segments[i+1].Discontinuity; i-- {
+		end := start
+		start = end.Add(-time.Duration(media.Segments[i].Duration * float64(time.Second)))
+		dates = append([]*segmentDate{{seqID: media.Segments[i].SeqId, start: start, end: end}}, dates...)
+	}
+	return dates
+}
+
+func (ins *programDateTimeInspector) inspectDrift(media *core.MediaPlaylist, seqID uint64, expected, actual time.Time, setReport func(*core.Report)) {
+	drift := absDuration(actual.Sub(expected))
+	if drift <= ins.config.WarnDrift {
+		return
+	}
+	values := core.Values{
+		"playlist": media.URL,
+		"seqId":    seqID,
+		"expected": expected.UTC().Format(time.RFC3339Nano),
+		"actual":   actual.UTC().Format(time.RFC3339Nano),
+		"drift":    actual.Sub(expected),
+	}
+	if drift > ins.config.ErrorDrift {
+		setReport(&core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Error,
+			Message:  "EXT-X-PROGRAM-DATE-TIME jumps without EXT-X-DISCONTINUITY",
+			Values:   values,
+		})
+		return
+	}
+	setReport(&core.Report{
+		Name:     "ProgramDateTimeInspector",
+		Severity: core.Warn,
+		Message:  "EXT-X-PROGRAM-DATE-TIME drifts from EXTINF durations",
+		Values:   values,
+	})
+}
+
+// inspectAlignment compares dates of the segments with the same media sequence number between variants
+// in the same group, and dates of the live edges between all playlists.
+func (ins *programDateTimeInspector) inspectAlignment(playlists *core.Playlists, keys []string, dates map[string][]*segmentDate, setReport func(*core.Report)) {
+	type sequenceKey struct {
+		groupID string
+		seqID   uint64
+	}
+	type sequenceValue struct {
+		playlist string
+		start    time.Time
+	}
+	sequences := make(map[sequenceKey]sequenceValue)
+	var edgeMin, edgeMax *segmentDate
+	var edgeMinPlaylist, edgeMaxPlaylist string
+	for _, key := range keys {
+		d, ok := dates[key]
+		if !ok {
+			continue
+		}
+		media := playlists.MediaPlaylists[key]
+		var groupID string
+		if media.Alternative != nil {
+			groupID = media.Alternative.GroupId
+		}
+		for _, date := range d {
+			skey := sequenceKey{groupID: groupID, seqID: date.seqID}
+			sval, ok := sequences[skey]
+			if !ok {
+				sequences[skey] = sequenceValue{playlist: media.URL, start: date.start}
+				continue
+			}
+			if diff := absDuration(date.start.Sub(sval.start)); diff > ins.config.AlignmentTolerance {
+				setReport(&core.Report{
+					Name:     "ProgramDateTimeInspector",
+					Severity: core.Error,
+					Message:  "EXT-X-PROGRAM-DATE-TIME mismatches between variants",
+					Values: core.Values{
+						"seqId":     date.seqID,
+						"playlists": []string{sval.playlist, media.URL},
+						"diff":      diff,
+					},
+				})
+			}
+		}
+		edge := d[len(d)-1]
+		if edgeMin == nil || edge.end.Before(edgeMin.end) {
+			edgeMin, edgeMinPlaylist = edge, media.URL
+		}
+		if edgeMax == nil || edge.end.After(edgeMax.end) {
+			edgeMax, edgeMaxPlaylist = edge, media.URL
+		}
+	}
+
+	// playlists can have different segment durations, so the live edges can differ up to the target duration.
+	tolerance := time.Duration(playlists.MaxTargetDuration()*float64(time.Second)) + ins.config.AlignmentTolerance
+	if diff := edgeMax.end.Sub(edgeMin.end); diff > tolerance {
+		setReport(&core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Warn,
+			Message:  "live edges mismatch between playlists",
+			Values: core.Values{
+				"playlists": []string{edgeMinPlaylist, edgeMaxPlaylist},
+				"diff":      diff,
+			},
+		})
+	}
+}
+
+// inspectLatency inspects the difference between the download time and the end of the last segment.
+func (ins *programDateTimeInspector) inspectLatency(playlists *core.Playlists, keys []string, dates map[string][]*segmentDate, setReport func(*core.Report)) core.Values {
+	var values core.Values
+	var maxLatency time.Duration
+	for _, key := range keys {
+		d, ok := dates[key]
+		media := playlists.MediaPlaylists[key]
+		if !ok || media.Closed {
+			continue
+		}
+		end := d[len(d)-1].end
+		latency := media.Time.Sub(end)
+		playlistValues := core.Values{
+			"playlist":        media.URL,
+			"latency":         latency,
+			"programDateTime": end.UTC().Format(time.RFC3339Nano),
+			"downloadTime":    media.Time.UTC().Format(time.RFC3339Nano),
+		}
+		if latency < -ins.config.ClockTolerance {
+			setReport(&core.Report{
+				Name:     "ProgramDateTimeInspector",
+				Severity: core.Error,
+				Message:  "the last segment ends in the future",
+				Values:   playlistValues,
+			})
+		}
+		if values == nil || latency > maxLatency {
+			values = playlistValues
+			maxLatency = latency
+		}
+	}
+	if values == nil {
+		return nil
+	}
+	if ins.config.ErrorLatency != 0 && maxLatency > ins.config.ErrorLatency {
+		setReport(&core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Error,
+			Message:  "latency is too large",
+			Values:   values,
+		})
+	} else if ins.config.WarnLatency != 0 && maxLatency > ins.config.WarnLatency {
+		setReport(&core.Report{
+			Name:     "ProgramDateTimeInspector",
+			Severity: core.Warn,
+			Message:  "latency is too large",
+			Values:   values,
+		})
+	}
+	return values
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
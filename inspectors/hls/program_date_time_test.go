@@ -0,0 +1,142 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramDateTimeInspector(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	build := func(url, groupID string, first uint64, offset time.Duration, pdts map[uint64]time.Duration) *core.MediaPlaylist {
+		media := &core.MediaPlaylist{
+			URL:           url,
+			Time:          start.Add(30 * time.Second),
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 2},
+		}
+		if groupID != "" {
+			media.Alternative = &m3u8.Alternative{GroupId: groupID}
+		}
+		for i := uint64(0); i < 10; i++ {
+			media.Segments = append(media.Segments, &m3u8.MediaSegment{SeqId: first + i, Duration: 2.0})
+		}
+		media.Segments[1].ProgramDateTime = start.Add(offset + 2*time.Second)
+		for seqID, pdt := range pdts {
+			media.Segments[seqID-first].ProgramDateTime = start.Add(pdt)
+		}
+		return media
+	}
+	inspect := func(ins core.HLSInspector, medias ...*core.MediaPlaylist) *core.Report {
+		playlists := &core.Playlists{MediaPlaylists: make(map[string]*core.MediaPlaylist)}
+		for _, media := range medias {
+			playlists.MediaPlaylists[media.URL] = media
+		}
+		return ins.Inspect(playlists, nil)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		report := inspect(NewProgramDateTimeInspector(),
+			build("video1.m3u8", "", 0, 0, map[uint64]time.Duration{5: 10*time.Second + 100*time.Millisecond}),
+			build("video2.m3u8", "", 0, 0, nil),
+			build("audio.m3u8", "audio", 100, time.Second, nil),
+		)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 10*time.Second, report.Values["latency"])
+		assert.Equal(t, "2021-01-01T00:00:20Z", report.Values["programDateTime"])
+	})
+
+	t.Run("no program date time", func(t *testing.T) {
+		media := build("video.m3u8", "", 0, 0, nil)
+		media.Segments[1].ProgramDateTime = time.Time{}
+		report := inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "no EXT-X-PROGRAM-DATE-TIME", report.Message)
+
+		report = inspect(NewProgramDateTimeInspector(), media, build("video2.m3u8", "", 0, 0, nil))
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "some playlists have no EXT-X-PROGRAM-DATE-TIME", report.Message)
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		report := inspect(NewProgramDateTimeInspector(),
+			build("video.m3u8", "", 0, 0, map[uint64]time.Duration{5: 10*time.Second + 500*time.Millisecond}),
+		)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "EXT-X-PROGRAM-DATE-TIME drifts from EXTINF durations", report.Message)
+		assert.Equal(t, uint64(5), report.Values["seqId"])
+	})
+
+	t.Run("jump", func(t *testing.T) {
+		media := build("video.m3u8", "", 0, 0, map[uint64]time.Duration{5: 20 * time.Second})
+		report := inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-PROGRAM-DATE-TIME jumps without EXT-X-DISCONTINUITY", report.Message)
+
+		// jumps are allowed at discontinuities.
+		media.Segments[5].Discontinuity = true
+		media.Time = start.Add(40 * time.Second)
+		report = inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+	})
+
+	t.Run("variants mismatch", func(t *testing.T) {
+		report := inspect(NewProgramDateTimeInspector(),
+			build("video1.m3u8", "", 0, 0, nil),
+			build("video2.m3u8", "", 0, time.Second, nil),
+		)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-PROGRAM-DATE-TIME mismatches between variants", report.Message)
+		assert.Equal(t, uint64(0), report.Values["seqId"])
+	})
+
+	t.Run("live edges mismatch", func(t *testing.T) {
+		report := inspect(NewProgramDateTimeInspector(),
+			build("video.m3u8", "", 0, 0, nil),
+			build("audio.m3u8", "audio", 100, -5*time.Second, nil),
+		)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "live edges mismatch between playlists", report.Message)
+		assert.Equal(t, []string{"audio.m3u8", "video.m3u8"}, report.Values["playlists"])
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		media := build("video.m3u8", "", 0, 0, nil)
+		media.Time = start.Add(55 * time.Second)
+		report := inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "latency is too large", report.Message)
+		assert.Equal(t, 35*time.Second, report.Values["latency"])
+
+		media.Time = start.Add(90 * time.Second)
+		report = inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "latency is too large", report.Message)
+
+		media.Time = start.Add(10 * time.Second)
+		report = inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "the last segment ends in the future", report.Message)
+
+		// latency is not inspected for VOD.
+		media.Closed = true
+		report = inspect(NewProgramDateTimeInspector(), media)
+		require.NotNil(t, report)
+		assert.Equal(t, core.Info, report.Severity)
+	})
+}
@@ -0,0 +1,617 @@
+package hls
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/abema/antares/internal/scte35"
+	"github.com/grafov/m3u8"
+)
+
+type SCTE35InspectorConfig struct {
+	// Tolerance is the allowable difference between durations of breaks.
+	Tolerance time.Duration
+}
+
+func DefaultSCTE35InspectorConfig() *SCTE35InspectorConfig {
+	return &SCTE35InspectorConfig{
+		Tolerance: 500 * time.Millisecond,
+	}
+}
+
+// NewSCTE35Inspector returns SCTE35Inspector.
+// It inspects ad-marker tags such as EXT-X-DATERANGE with SCTE35 attributes,
+// EXT-X-CUE-OUT, EXT-X-CUE-OUT-CONT, EXT-X-CUE-IN, EXT-X-SCTE35 and EXT-OATCLS-SCTE35.
+// Breaks are tracked across playlist updates to detect unclosed breaks.
+func NewSCTE35Inspector() core.HLSInspector {
+	return NewSCTE35InspectorWithConfig(DefaultSCTE35InspectorConfig())
+}
+
+func NewSCTE35InspectorWithConfig(config *SCTE35InspectorConfig) core.HLSInspector {
+	return &scte35Inspector{
+		config:     config,
+		cueBreaks:  make(map[string]*cueBreak),
+		dateRanges: make(map[string]map[string]*core.DateRange),
+	}
+}
+
+type scte35Inspector struct {
+	config *SCTE35InspectorConfig
+	// cueBreaks holds the break which is open at the end of the previous playlist by playlist URL.
+	cueBreaks map[string]*cueBreak
+	// dateRanges holds EXT-X-DATERANGE tags of the previous playlist by playlist URL and ID.
+	dateRanges map[string]map[string]*core.DateRange
+}
+
+// cueBreak is a break signaled by EXT-X-CUE-OUT and EXT-X-CUE-IN tags.
+type cueBreak struct {
+	startSeqID uint64
+	// duration is nil when the duration is not signaled.
+	duration *float64
+	// elapsed is the total duration of segments in the break until lastSeqID.
+	elapsed   float64
+	lastSeqID uint64
+}
+
+func (ins *scte35Inspector) Inspect(playlists *core.Playlists, _ core.SegmentStore) *core.Report {
+	var report *core.Report
+	setReport := func(rep *core.Report) {
+		if report == nil || rep.Severity.WorseThan(report.Severity) {
+			report = rep
+		}
+	}
+
+	keys := make([]string, 0, len(playlists.MediaPlaylists))
+	for key, media := range playlists.MediaPlaylists {
+		if !media.IFrameOnly {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var tags int
+	for _, key := range keys {
+		media := playlists.MediaPlaylists[key]
+		tags += len(media.DateRanges) + len(media.CueTags)
+		ins.inspectCueTags(media, setReport)
+		ins.inspectDateRanges(media, setReport)
+	}
+	ins.inspectVariants(playlists, keys, setReport)
+
+	if report != nil {
+		return report
+	}
+	if tags == 0 {
+		return &core.Report{
+			Name:     "SCTE35Inspector",
+			Severity: core.Info,
+			Message:  "no ad-marker tags",
+		}
+	}
+	return &core.Report{
+		Name:     "SCTE35Inspector",
+		Severity: core.Info,
+		Message:  "good",
+		Values:   core.Values{"tags": tags},
+	}
+}
+
+// parseCueOutDuration parses the value of EXT-X-CUE-OUT tag, such as "30", "DURATION=30" and "Duration=30".
+func parseCueOutDuration(value string) (float64, bool) {
+	if !strings.Contains(value, "=") {
+		d, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return d, err == nil
+	}
+	for k, v := range m3u8.DecodeAttributeList(value) {
+		if strings.EqualFold(k, "DURATION") {
+			d, err := strconv.ParseFloat(v, 64)
+			return d, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseCueOutCont parses the value of EXT-X-CUE-OUT-CONT tag, such as "ElapsedTime=10,Duration=30" and "10/30".
+func parseCueOutCont(value string) (elapsed float64, hasElapsed bool, duration float64, hasDuration bool) {
+	if !strings.Contains(value, "=") {
+		s := strings.SplitN(value, "/", 2)
+		var err error
+		elapsed, err = strconv.ParseFloat(strings.TrimSpace(s[0]), 64)
+		hasElapsed = err == nil
+		if len(s) == 2 {
+			duration, err = strconv.ParseFloat(strings.TrimSpace(s[1]), 64)
+			hasDuration = err == nil
+		}
+		return
+	}
+	for k, v := range m3u8.DecodeAttributeList(value) {
+		var err error
+		switch strings.ToUpper(k) {
+		case "ELAPSEDTIME":
+			elapsed, err = strconv.ParseFloat(v, 64)
+			hasElapsed = err == nil
+		case "DURATION":
+			duration, err = strconv.ParseFloat(v, 64)
+			hasDuration = err == nil
+		}
+	}
+	return
+}
+
+// cueTagSCTE35 returns the SCTE-35 message of EXT-X-SCTE35, EXT-OATCLS-SCTE35 or EXT-X-CUE-OUT-CONT tag.
+func cueTagSCTE35(tag *core.CueTag) (string, bool) {
+	switch tag.Name {
+	case "EXT-OATCLS-SCTE35":
+		return tag.Value, true
+	case "EXT-X-SCTE35":
+		cue, ok := m3u8.DecodeAttributeList(tag.Value)["CUE"]
+		return cue, ok
+	case "EXT-X-CUE-OUT-CONT":
+		for k, v := range m3u8.DecodeAttributeList(tag.Value) {
+			if strings.EqualFold(k, "SCTE35") {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (ins *scte35Inspector) inspectCueTags(media *core.MediaPlaylist, setReport func(*core.Report)) {
+	tolerance := ins.config.Tolerance.Seconds()
+	tagMap := make(map[uint64][]*core.CueTag)
+	for _, tag := range media.CueTags {
+		tagMap[tag.SeqID] = append(tagMap[tag.SeqID], tag)
+		if message, ok := cueTagSCTE35(tag); ok {
+			if _, err := scte35.ParseBase64(message); err != nil {
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "failed to decode SCTE-35 message",
+					Values:   core.Values{"playlist": media.URL, "tag": tag.Name, "seqID": tag.SeqID, "error": err},
+				})
+			}
+		}
+	}
+
+	// the break which is open in the previous playlist is continued when its EXT-X-CUE-OUT tag is removed.
+	var open *cueBreak
+	if prev := ins.cueBreaks[media.URL]; prev != nil && len(media.Segments) != 0 && prev.startSeqID < media.Segments[0].SeqId {
+		open = prev
+	}
+	var seenTag bool
+	for _, segment := range media.Segments {
+		for _, tag := range tagMap[segment.SeqId] {
+			values := core.Values{"playlist": media.URL, "tag": tag.Name, "seqID": tag.SeqID}
+			switch tag.Name {
+			case "EXT-X-CUE-OUT":
+				if open != nil && (open.duration == nil || open.elapsed+tolerance < *open.duration) {
+					values["startSeqID"] = open.startSeqID
+					setReport(&core.Report{
+						Name:     "SCTE35Inspector",
+						Severity: core.Warn,
+						Message:  "EXT-X-CUE-OUT without EXT-X-CUE-IN",
+						Values:   values,
+					})
+				}
+				open = &cueBreak{startSeqID: segment.SeqId, lastSeqID: segment.SeqId - 1}
+				if d, ok := parseCueOutDuration(tag.Value); ok {
+					open.duration = &d
+				}
+			case "EXT-X-CUE-OUT-CONT":
+				elapsed, hasElapsed, duration, hasDuration := parseCueOutCont(tag.Value)
+				if open == nil {
+					// EXT-X-CUE-OUT tag has been removed from the playlist.
+					open = &cueBreak{startSeqID: segment.SeqId, elapsed: elapsed, lastSeqID: segment.SeqId - 1}
+					if hasDuration {
+						open.duration = &duration
+					}
+					break
+				}
+				if hasElapsed && math.Abs(elapsed-open.elapsed) > tolerance {
+					values["elapsedTime"] = elapsed
+					values["expected"] = open.elapsed
+					setReport(&core.Report{
+						Name:     "SCTE35Inspector",
+						Severity: core.Warn,
+						Message:  "ElapsedTime of EXT-X-CUE-OUT-CONT mismatches segment durations",
+						Values:   values,
+					})
+				}
+				if hasDuration && open.duration != nil && math.Abs(duration-*open.duration) > tolerance {
+					values["duration"] = duration
+					values["expected"] = *open.duration
+					setReport(&core.Report{
+						Name:     "SCTE35Inspector",
+						Severity: core.Warn,
+						Message:  "Duration of EXT-X-CUE-OUT-CONT mismatches EXT-X-CUE-OUT",
+						Values:   values,
+					})
+				}
+			case "EXT-X-CUE-IN":
+				if open == nil {
+					// EXT-X-CUE-OUT tag of the first break can be removed from the playlist.
+					if seenTag {
+						setReport(&core.Report{
+							Name:     "SCTE35Inspector",
+							Severity: core.Warn,
+							Message:  "EXT-X-CUE-IN without EXT-X-CUE-OUT",
+							Values:   values,
+						})
+					}
+					break
+				}
+				if open.duration != nil && math.Abs(open.elapsed-*open.duration) > tolerance {
+					values["startSeqID"] = open.startSeqID
+					values["breakLength"] = open.elapsed
+					values["duration"] = *open.duration
+					setReport(&core.Report{
+						Name:     "SCTE35Inspector",
+						Severity: core.Warn,
+						Message:  "break length mismatches duration of EXT-X-CUE-OUT",
+						Values:   values,
+					})
+				}
+				open = nil
+			}
+			seenTag = true
+		}
+		if open != nil && segment.SeqId > open.lastSeqID {
+			open.elapsed += segment.Duration
+			open.lastSeqID = segment.SeqId
+		}
+	}
+	if open != nil && open.duration != nil && open.elapsed > *open.duration+tolerance && !media.Closed {
+		setReport(&core.Report{
+			Name:     "SCTE35Inspector",
+			Severity: core.Error,
+			Message:  "unclosed break",
+			Values: core.Values{
+				"playlist":   media.URL,
+				"startSeqID": open.startSeqID,
+				"duration":   *open.duration,
+				"elapsed":    open.elapsed,
+			},
+		})
+	}
+	ins.cueBreaks[media.URL] = open
+}
+
+func (ins *scte35Inspector) inspectDateRanges(media *core.MediaPlaylist, setReport func(*core.Report)) {
+	tolerance := ins.config.Tolerance.Seconds()
+	prevDateRanges := ins.dateRanges[media.URL]
+	dateRanges := make(map[string]*core.DateRange, len(media.DateRanges))
+	for _, dateRange := range media.DateRanges {
+		values := func() core.Values {
+			return core.Values{"playlist": media.URL, "id": dateRange.ID}
+		}
+		if prev, ok := dateRanges[dateRange.ID]; ok {
+			if name, ok := changedAttribute(prev, dateRange); ok {
+				v := values()
+				v["attribute"] = name
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "EXT-X-DATERANGE tags with the same ID have different attribute values",
+					Values:   v,
+				})
+			}
+		} else if prev, ok := prevDateRanges[dateRange.ID]; ok {
+			if name, ok := changedAttribute(prev, dateRange); ok {
+				v := values()
+				v["attribute"] = name
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "EXT-X-DATERANGE attribute is changed from previous playlist",
+					Values:   v,
+				})
+			}
+		}
+		dateRanges[dateRange.ID] = dateRange
+
+		if dateRange.EndOnNext && dateRange.Class == "" {
+			setReport(&core.Report{
+				Name:     "SCTE35Inspector",
+				Severity: core.Error,
+				Message:  "EXT-X-DATERANGE with END-ON-NEXT has no CLASS",
+				Values:   values(),
+			})
+		}
+		if dateRange.EndDate != nil && dateRange.Duration != nil && !dateRange.StartDate.IsZero() &&
+			math.Abs(dateRange.EndDate.Sub(dateRange.StartDate).Seconds()-*dateRange.Duration) > tolerance {
+			v := values()
+			v["duration"] = *dateRange.Duration
+			v["endDate"] = dateRange.EndDate.Format(time.RFC3339Nano)
+			setReport(&core.Report{
+				Name:     "SCTE35Inspector",
+				Severity: core.Error,
+				Message:  "DURATION mismatches END-DATE",
+				Values:   v,
+			})
+		}
+		for _, attr := range []struct {
+			name  string
+			value string
+		}{
+			{name: "SCTE35-CMD", value: dateRange.SCTE35Cmd},
+			{name: "SCTE35-OUT", value: dateRange.SCTE35Out},
+			{name: "SCTE35-IN", value: dateRange.SCTE35In},
+		} {
+			if attr.value == "" {
+				continue
+			}
+			info, err := scte35.ParseHex(attr.value)
+			if err != nil {
+				v := values()
+				v["attribute"] = attr.name
+				v["error"] = err
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "failed to decode SCTE-35 message",
+					Values:   v,
+				})
+				continue
+			}
+			if attr.name != "SCTE35-OUT" {
+				continue
+			}
+			breakDuration, ok := spliceBreakDuration(info)
+			if !ok {
+				continue
+			}
+			duration := dateRange.Duration
+			if duration == nil {
+				duration = dateRange.PlannedDuration
+			}
+			if duration != nil && math.Abs(*duration-breakDuration) > tolerance {
+				v := values()
+				v["duration"] = *duration
+				v["breakDuration"] = breakDuration
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Warn,
+					Message:  "break duration of SCTE35-OUT mismatches EXT-X-DATERANGE duration",
+					Values:   v,
+				})
+			}
+		}
+	}
+	ins.dateRanges[media.URL] = dateRanges
+
+	// unclosed breaks
+	if end, ok := playlistEndDate(media); ok && !media.Closed {
+		for _, dateRange := range media.DateRanges {
+			if dateRange.SCTE35Out == "" || dateRange.EndOnNext || dateRange.PlannedDuration == nil || dateRange.StartDate.IsZero() {
+				continue
+			}
+			if dateRangeClosed(media, dateRange.ID) {
+				continue
+			}
+			plannedEnd := dateRange.StartDate.Add(time.Duration((*dateRange.PlannedDuration + tolerance) * float64(time.Second)))
+			if end.After(plannedEnd) {
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "unclosed break",
+					Values: core.Values{
+						"playlist":        media.URL,
+						"id":              dateRange.ID,
+						"startDate":       dateRange.StartDate.Format(time.RFC3339Nano),
+						"plannedDuration": *dateRange.PlannedDuration,
+					},
+				})
+			}
+		}
+	}
+
+	// overlapping date ranges with the same CLASS
+	classes := make(map[string][]*core.DateRange)
+	for _, dateRange := range dateRanges {
+		if dateRange.Class != "" && !dateRange.StartDate.IsZero() {
+			classes[dateRange.Class] = append(classes[dateRange.Class], dateRange)
+		}
+	}
+	classNames := make([]string, 0, len(classes))
+	for class := range classes {
+		classNames = append(classNames, class)
+	}
+	sort.Strings(classNames)
+	for _, class := range classNames {
+		ranges := classes[class]
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i].StartDate.Before(ranges[j].StartDate)
+		})
+		for i := 1; i < len(ranges); i++ {
+			end, ok := ranges[i-1].End()
+			if !ok || end.Sub(ranges[i].StartDate).Seconds() <= tolerance {
+				continue
+			}
+			setReport(&core.Report{
+				Name:     "SCTE35Inspector",
+				Severity: core.Error,
+				Message:  "EXT-X-DATERANGE tags with the same CLASS overlap",
+				Values: core.Values{
+					"playlist": media.URL,
+					"class":    class,
+					"id":       ranges[i].ID,
+					"previous": ranges[i-1].ID,
+				},
+			})
+		}
+	}
+}
+
+// inspectVariants inspects that ad-marker tags are consistent across media playlists.
+func (ins *scte35Inspector) inspectVariants(playlists *core.Playlists, keys []string, setReport func(*core.Report)) {
+	tolerance := ins.config.Tolerance.Seconds()
+	var base *core.MediaPlaylist
+	for _, key := range keys {
+		media := playlists.MediaPlaylists[key]
+		if base == nil {
+			base = media
+			continue
+		}
+		values := func() core.Values {
+			return core.Values{"playlist": media.URL, "basePlaylist": base.URL}
+		}
+		for _, dateRange := range media.DateRanges {
+			baseDateRange := findDateRange(base, dateRange.ID)
+			if baseDateRange == nil {
+				continue
+			}
+			if !dateRange.StartDate.Equal(baseDateRange.StartDate) ||
+				dateRange.SCTE35Out != baseDateRange.SCTE35Out ||
+				dateRange.SCTE35In != baseDateRange.SCTE35In ||
+				!equalFloatPtr(dateRange.Duration, baseDateRange.Duration, tolerance) ||
+				!equalFloatPtr(dateRange.PlannedDuration, baseDateRange.PlannedDuration, tolerance) {
+				v := values()
+				v["id"] = dateRange.ID
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "EXT-X-DATERANGE mismatches between variants",
+					Values:   v,
+				})
+				break
+			}
+		}
+
+		// EXT-X-CUE-OUT and EXT-X-CUE-IN tags are compared in the common range of media sequence numbers.
+		if len(media.Segments) == 0 || len(base.Segments) == 0 || (media.Alternative == nil) != (base.Alternative == nil) {
+			continue
+		}
+		first := maxUint64(media.Segments[0].SeqId, base.Segments[0].SeqId)
+		last := minUint64(media.Segments[len(media.Segments)-1].SeqId, base.Segments[len(base.Segments)-1].SeqId)
+		cues := cuePositions(media, first, last)
+		baseCues := cuePositions(base, first, last)
+		for seqID, name := range cues {
+			if baseCues[seqID] != name {
+				v := values()
+				v["seqID"] = seqID
+				v["tag"] = name
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "EXT-X-CUE-OUT/EXT-X-CUE-IN positions mismatch between variants",
+					Values:   v,
+				})
+				break
+			}
+		}
+		for seqID, name := range baseCues {
+			if _, ok := cues[seqID]; !ok {
+				v := values()
+				v["seqID"] = seqID
+				v["tag"] = name
+				setReport(&core.Report{
+					Name:     "SCTE35Inspector",
+					Severity: core.Error,
+					Message:  "EXT-X-CUE-OUT/EXT-X-CUE-IN positions mismatch between variants",
+					Values:   v,
+				})
+				break
+			}
+		}
+	}
+}
+
+// changedAttribute returns the name of the attribute which has different values in both tags.
+func changedAttribute(a, b *core.DateRange) (string, bool) {
+	names := make([]string, 0, len(a.Attributes))
+	for name := range a.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := b.Attributes[name]; ok && value != a.Attributes[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func spliceBreakDuration(info *scte35.SpliceInfo) (float64, bool) {
+	if info.SpliceInsert != nil && info.SpliceInsert.BreakDuration != nil {
+		return float64(*info.SpliceInsert.BreakDuration) / scte35.Timescale, true
+	}
+	for _, desc := range info.SegmentationDescriptors {
+		if desc.IsStart() && desc.Duration != nil {
+			return float64(*desc.Duration) / scte35.Timescale, true
+		}
+	}
+	return 0, false
+}
+
+// dateRangeClosed returns whether any EXT-X-DATERANGE tag with the ID has the end or SCTE35-IN attribute.
+func dateRangeClosed(media *core.MediaPlaylist, id string) bool {
+	for _, dateRange := range media.DateRanges {
+		if dateRange.ID != id {
+			continue
+		}
+		if _, ok := dateRange.End(); ok || dateRange.SCTE35In != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func findDateRange(media *core.MediaPlaylist, id string) *core.DateRange {
+	for _, dateRange := range media.DateRanges {
+		if dateRange.ID == id {
+			return dateRange
+		}
+	}
+	return nil
+}
+
+// playlistEndDate returns the end date of the last segment derived from EXT-X-PROGRAM-DATE-TIME.
+func playlistEndDate(media *core.MediaPlaylist) (time.Time, bool) {
+	var end time.Time
+	var ok bool
+	for _, segment := range media.Segments {
+		if !segment.ProgramDateTime.IsZero() {
+			end = segment.ProgramDateTime
+			ok = true
+		} else if !ok {
+			continue
+		}
+		end = end.Add(time.Duration(segment.Duration * float64(time.Second)))
+	}
+	return end, ok
+}
+
+// cuePositions returns names of EXT-X-CUE-OUT and EXT-X-CUE-IN tags by media sequence number.
+func cuePositions(media *core.MediaPlaylist, first, last uint64) map[uint64]string {
+	positions := make(map[uint64]string)
+	for _, tag := range media.CueTags {
+		if (tag.Name == "EXT-X-CUE-OUT" || tag.Name == "EXT-X-CUE-IN") && tag.SeqID >= first && tag.SeqID <= last {
+			positions[tag.SeqID] = tag.Name
+		}
+	}
+	return positions
+}
+
+func equalFloatPtr(a, b *float64, tolerance float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return math.Abs(*a-*b) <= tolerance
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
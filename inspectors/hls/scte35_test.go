@@ -0,0 +1,194 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abema/antares/core"
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSCTE35Inspector(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	float := func(f float64) *float64 { return &f }
+	build := func(url string, first, last uint64, cueTags []*core.CueTag, dateRanges ...*core.DateRange) *core.MediaPlaylist {
+		media := &core.MediaPlaylist{
+			URL:           url,
+			MediaPlaylist: &m3u8.MediaPlaylist{TargetDuration: 2},
+			CueTags:       cueTags,
+			DateRanges:    dateRanges,
+		}
+		for seq := first; seq <= last; seq++ {
+			media.Segments = append(media.Segments, &m3u8.MediaSegment{SeqId: seq, Duration: 2.0})
+		}
+		media.Segments[0].ProgramDateTime = start.Add(time.Duration(first*2) * time.Second)
+		return media
+	}
+	inspect := func(ins core.HLSInspector, medias ...*core.MediaPlaylist) *core.Report {
+		playlists := &core.Playlists{MediaPlaylists: make(map[string]*core.MediaPlaylist)}
+		for _, media := range medias {
+			playlists.MediaPlaylists[media.URL] = media
+		}
+		return ins.Inspect(playlists, nil)
+	}
+	cueOut := func(seqID uint64, value string) *core.CueTag {
+		return &core.CueTag{Name: "EXT-X-CUE-OUT", Value: value, SeqID: seqID}
+	}
+	cueOutCont := func(seqID uint64, value string) *core.CueTag {
+		return &core.CueTag{Name: "EXT-X-CUE-OUT-CONT", Value: value, SeqID: seqID}
+	}
+	cueIn := func(seqID uint64) *core.CueTag {
+		return &core.CueTag{Name: "EXT-X-CUE-IN", SeqID: seqID}
+	}
+	dateRange := func(id, class string, offset, duration float64, attrs map[string]string) *core.DateRange {
+		r := &core.DateRange{
+			ID:         id,
+			Class:      class,
+			StartDate:  start.Add(time.Duration(offset * float64(time.Second))),
+			Attributes: map[string]string{"ID": id},
+		}
+		if duration != 0 {
+			r.Duration = float(duration)
+		}
+		for k, v := range attrs {
+			r.Attributes[k] = v
+			switch k {
+			case "SCTE35-OUT":
+				r.SCTE35Out = v
+			case "PLANNED-DURATION":
+				r.PlannedDuration = float(60.293)
+			}
+		}
+		return r
+	}
+
+	t.Run("no tags", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, nil))
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "no ad-marker tags", report.Message)
+	})
+
+	t.Run("cue/ok", func(t *testing.T) {
+		ins := NewSCTE35Inspector()
+		tags := []*core.CueTag{cueOut(2, "6.0"), cueOutCont(3, "ElapsedTime=2.0,Duration=6.0"), cueOutCont(4, "4/6"), cueIn(5)}
+		report := inspect(ins, build("0.m3u8", 0, 9, tags), build("1.m3u8", 0, 9, tags))
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+		assert.Equal(t, 8, report.Values["tags"])
+	})
+
+	t.Run("cue/break length", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, []*core.CueTag{cueOut(2, "DURATION=6.0"), cueIn(6)}))
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "break length mismatches duration of EXT-X-CUE-OUT", report.Message)
+		assert.Equal(t, 8.0, report.Values["breakLength"])
+	})
+
+	t.Run("cue/elapsed time", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, []*core.CueTag{cueOut(2, "6.0"), cueOutCont(3, "ElapsedTime=4.0,Duration=6.0"), cueIn(5)}))
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "ElapsedTime of EXT-X-CUE-OUT-CONT mismatches segment durations", report.Message)
+	})
+
+	t.Run("cue/unclosed break across updates", func(t *testing.T) {
+		ins := NewSCTE35Inspector()
+		report := inspect(ins, build("0.m3u8", 0, 4, []*core.CueTag{cueOut(3, "6.0")}))
+		require.Equal(t, core.Info, report.Severity)
+		// EXT-X-CUE-OUT tag is removed from the playlist.
+		report = inspect(ins, build("0.m3u8", 4, 5, nil))
+		require.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build("0.m3u8", 5, 7, nil))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "unclosed break", report.Message)
+		assert.Equal(t, uint64(3), report.Values["startSeqID"])
+		assert.Equal(t, 10.0, report.Values["elapsed"])
+	})
+
+	t.Run("cue/variants", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(),
+			build("0.m3u8", 0, 9, []*core.CueTag{cueOut(2, "6.0"), cueIn(5)}),
+			build("1.m3u8", 1, 9, []*core.CueTag{cueOut(3, "6.0"), cueIn(6)}),
+		)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-CUE-OUT/EXT-X-CUE-IN positions mismatch between variants", report.Message)
+	})
+
+	t.Run("cue/invalid SCTE-35", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, []*core.CueTag{{Name: "EXT-OATCLS-SCTE35", Value: "!!!", SeqID: 2}}))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "failed to decode SCTE-35 message", report.Message)
+	})
+
+	t.Run("daterange/ok", func(t *testing.T) {
+		ins := NewSCTE35Inspector()
+		r := dateRange("ad1", "com.example.ad", 4, 60.293, map[string]string{
+			"SCTE35-OUT": "0xFC302F000000000000FFFFF014054800008F7FEFFE7369C02EFE0052CCF500000000000A0008435545490000013562DBA30A",
+		})
+		report := inspect(ins, build("0.m3u8", 0, 9, nil, r), build("1.m3u8", 0, 9, nil, r))
+		require.Equal(t, core.Info, report.Severity)
+		assert.Equal(t, "good", report.Message)
+	})
+
+	t.Run("daterange/break duration", func(t *testing.T) {
+		r := dateRange("ad1", "", 4, 30, map[string]string{
+			"SCTE35-OUT": "0xFC302F000000000000FFFFF014054800008F7FEFFE7369C02EFE0052CCF500000000000A0008435545490000013562DBA30A",
+		})
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, nil, r))
+		require.Equal(t, core.Warn, report.Severity)
+		assert.Equal(t, "break duration of SCTE35-OUT mismatches EXT-X-DATERANGE duration", report.Message)
+		assert.InDelta(t, 60.293, report.Values["breakDuration"], 0.001)
+	})
+
+	t.Run("daterange/unclosed break", func(t *testing.T) {
+		r := dateRange("ad1", "", 4, 0, map[string]string{
+			"SCTE35-OUT":       "0xFC302F000000000000FFFFF014054800008F7FEFFE7369C02EFE0052CCF500000000000A0008435545490000013562DBA30A",
+			"PLANNED-DURATION": "60.293",
+		})
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, nil, r))
+		require.Equal(t, core.Info, report.Severity)
+		report = inspect(NewSCTE35Inspector(), build("0.m3u8", 30, 39, nil, r))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "unclosed break", report.Message)
+	})
+
+	t.Run("daterange/overlap", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(), build("0.m3u8", 0, 9, nil,
+			dateRange("ad1", "com.example.ad", 0, 10, nil),
+			dateRange("ad2", "com.example.ad", 8, 10, nil),
+			dateRange("other", "com.example.other", 4, 10, nil),
+		))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-DATERANGE tags with the same CLASS overlap", report.Message)
+		assert.Equal(t, "ad2", report.Values["id"])
+	})
+
+	t.Run("daterange/changed attribute", func(t *testing.T) {
+		ins := NewSCTE35Inspector()
+		report := inspect(ins, build("0.m3u8", 0, 9, nil, dateRange("ad1", "", 0, 10, map[string]string{"X-AD-ID": "a"})))
+		require.Equal(t, core.Info, report.Severity)
+		report = inspect(ins, build("0.m3u8", 1, 10, nil, dateRange("ad1", "", 0, 10, map[string]string{"X-AD-ID": "b"})))
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-DATERANGE attribute is changed from previous playlist", report.Message)
+		assert.Equal(t, "X-AD-ID", report.Values["attribute"])
+	})
+
+	t.Run("daterange/variants", func(t *testing.T) {
+		report := inspect(NewSCTE35Inspector(),
+			build("0.m3u8", 0, 9, nil, dateRange("ad1", "", 0, 10, nil)),
+			build("1.m3u8", 0, 9, nil, dateRange("ad1", "", 2, 10, nil)),
+		)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-DATERANGE mismatches between variants", report.Message)
+
+		// values of the report are not modified by the following mismatches of cue positions.
+		report = inspect(NewSCTE35Inspector(),
+			build("0.m3u8", 0, 9, []*core.CueTag{cueOut(2, "6.0"), cueIn(5)}, dateRange("ad1", "", 0, 10, nil)),
+			build("1.m3u8", 0, 9, []*core.CueTag{cueOut(3, "6.0"), cueIn(6)}, dateRange("ad1", "", 2, 10, nil)),
+		)
+		require.Equal(t, core.Error, report.Severity)
+		assert.Equal(t, "EXT-X-DATERANGE mismatches between variants", report.Message)
+		assert.Equal(t, core.Values{"playlist": "1.m3u8", "basePlaylist": "0.m3u8", "id": "ad1"}, report.Values)
+	})
+}
@@ -54,6 +54,10 @@ func (ins *variantsSyncInspector) Inspect(playlists *core.Playlists, _ core.Segm
 	}
 	groupMap := make(map[string]GroupValue)
 	for _, media := range playlists.MediaPlaylists {
+		if media.IFrameOnly {
+			// I-frame only playlists are inspected by IFrameInspector.
+			continue
+		}
 		if len(media.Segments) == 0 {
 			return &core.Report{
 				Name:     "VariantsSyncInspector",
@@ -0,0 +1,498 @@
+package mp4
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TrackEncryption is encryption parameters of the track defined by the sinf box.
+type TrackEncryption struct {
+	TrackID uint32
+	// Scheme is scheme_type of the schm box. (cenc, cens, cbc1 or cbcs)
+	Scheme          string
+	IsProtected     bool
+	PerSampleIVSize int
+	KID             []byte
+	ConstantIV      []byte
+	CryptByteBlock  int
+	SkipByteBlock   int
+}
+
+// ReadTrackEncryptions reads encryption parameters of each track from the initialization segment.
+// Tracks which are not encrypted are not contained in the result.
+func ReadTrackEncryptions(init []byte) (map[uint32]*TrackEncryption, error) {
+	traks, err := FindBoxes(init, "moov", "trak")
+	if err != nil {
+		return nil, err
+	}
+	tracks := make(map[uint32]*TrackEncryption)
+	for _, trak := range traks {
+		tkhds, err := FindBoxes(trak.Payload, "tkhd")
+		if err != nil {
+			return nil, err
+		}
+		if len(tkhds) == 0 {
+			return nil, errors.New("tkhd box is not found")
+		}
+		trackID, err := readTrackID(tkhds[0])
+		if err != nil {
+			return nil, err
+		}
+		stsds, err := FindBoxes(trak.Payload, "mdia", "minf", "stbl", "stsd")
+		if err != nil {
+			return nil, err
+		}
+		if len(stsds) == 0 {
+			continue
+		}
+		_, _, payload, err := stsds[0].FullBox()
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < 4 {
+			return nil, errors.New("too short stsd box")
+		}
+		entries, err := ReadBoxes(payload[4:])
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			enc, err := readSampleEntryEncryption(entry)
+			if err != nil {
+				return nil, err
+			}
+			if enc != nil {
+				enc.TrackID = trackID
+				tracks[trackID] = enc
+				break
+			}
+		}
+	}
+	return tracks, nil
+}
+
+func readTrackID(tkhd *Box) (uint32, error) {
+	version, _, payload, err := tkhd.FullBox()
+	if err != nil {
+		return 0, err
+	}
+	offset := 8
+	if version == 1 {
+		offset = 16
+	}
+	if len(payload) < offset+4 {
+		return 0, errors.New("too short tkhd box")
+	}
+	return binary.BigEndian.Uint32(payload[offset:]), nil
+}
+
+// sampleEntryHeaderSizes is size of fields which precede child boxes in the sample entry.
+var sampleEntryHeaderSizes = map[string]int{
+	"encv": 78,
+	"enca": 28,
+}
+
+func readSampleEntryEncryption(entry *Box) (*TrackEncryption, error) {
+	headerSize, ok := sampleEntryHeaderSizes[entry.Type]
+	if !ok || len(entry.Payload) < headerSize {
+		return nil, nil
+	}
+	sinfs, err := FindBoxes(entry.Payload[headerSize:], "sinf")
+	if err != nil {
+		return nil, err
+	}
+	if len(sinfs) == 0 {
+		return nil, errors.New("sinf box is not found")
+	}
+	enc := &TrackEncryption{}
+	schms, err := FindBoxes(sinfs[0].Payload, "schm")
+	if err != nil {
+		return nil, err
+	}
+	if len(schms) != 0 {
+		_, _, payload, err := schms[0].FullBox()
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < 4 {
+			return nil, errors.New("too short schm box")
+		}
+		enc.Scheme = string(payload[:4])
+	}
+	tencs, err := FindBoxes(sinfs[0].Payload, "schi", "tenc")
+	if err != nil {
+		return nil, err
+	}
+	if len(tencs) == 0 {
+		return nil, errors.New("tenc box is not found")
+	}
+	version, _, payload, err := tencs[0].FullBox()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 20 {
+		return nil, errors.New("too short tenc box")
+	}
+	if version != 0 {
+		enc.CryptByteBlock = int(payload[1] >> 4)
+		enc.SkipByteBlock = int(payload[1] & 0x0f)
+	}
+	enc.IsProtected = payload[2] == 1
+	enc.PerSampleIVSize = int(payload[3])
+	enc.KID = payload[4:20]
+	if enc.IsProtected && enc.PerSampleIVSize == 0 {
+		if len(payload) < 21 || len(payload) < 21+int(payload[20]) {
+			return nil, errors.New("too short tenc box")
+		}
+		enc.ConstantIV = payload[21 : 21+int(payload[20])]
+	}
+	return enc, nil
+}
+
+type subsample struct {
+	clear     int
+	protected int
+}
+
+type sampleEncryption struct {
+	iv         []byte
+	subsamples []subsample
+}
+
+// DecryptFragments returns a copy of the segment whose samples are decrypted.
+// key returns the decryption key for the key ID.
+func DecryptFragments(segment []byte, tracks map[uint32]*TrackEncryption, key func(kid []byte) ([]byte, error)) ([]byte, error) {
+	boxes, err := ReadBoxes(segment)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(segment))
+	copy(out, segment)
+	for _, moof := range boxes {
+		if moof.Type != "moof" {
+			continue
+		}
+		trafs, err := FindBoxes(moof.Payload, "traf")
+		if err != nil {
+			return nil, err
+		}
+		for _, traf := range trafs {
+			if err := decryptTrackFragment(out, moof.Offset, traf, tracks, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+func decryptTrackFragment(
+	out []byte,
+	moofOffset int,
+	traf *Box,
+	tracks map[uint32]*TrackEncryption,
+	key func(kid []byte) ([]byte, error),
+) error {
+	children, err := ReadBoxes(traf.Payload)
+	if err != nil {
+		return err
+	}
+	var trackID uint32
+	var defaultSampleSize uint32
+	var sizes []uint32
+	var dataOffset int
+	var hasDataOffset bool
+	var senc *Box
+	for _, box := range children {
+		switch box.Type {
+		case "tfhd":
+			_, flags, payload, err := box.FullBox()
+			if err != nil {
+				return err
+			}
+			if len(payload) < 4 {
+				return errors.New("too short tfhd box")
+			}
+			trackID = binary.BigEndian.Uint32(payload)
+			offset := 4
+			if flags&tfhdBaseDataOffsetPresent != 0 {
+				offset += 8
+			}
+			if flags&tfhdSampleDescriptionIndexPresent != 0 {
+				offset += 4
+			}
+			if flags&tfhdDefaultSampleDurationPresent != 0 {
+				offset += 4
+			}
+			if flags&tfhdDefaultSampleSizePresent != 0 {
+				if len(payload) < offset+4 {
+					return errors.New("too short tfhd box")
+				}
+				defaultSampleSize = binary.BigEndian.Uint32(payload[offset:])
+			}
+		case "trun":
+			trunSizes, offset, ok, err := readTrun(box, defaultSampleSize)
+			if err != nil {
+				return err
+			}
+			if ok && !hasDataOffset {
+				dataOffset = offset
+				hasDataOffset = true
+			}
+			sizes = append(sizes, trunSizes...)
+		case "senc":
+			senc = box
+		}
+	}
+	enc := tracks[trackID]
+	if enc == nil || !enc.IsProtected {
+		return nil
+	}
+	if senc == nil {
+		return fmt.Errorf("senc box is not found: trackID=%d", trackID)
+	}
+	samples, err := readSenc(senc, enc.PerSampleIVSize)
+	if err != nil {
+		return err
+	}
+	if len(samples) != len(sizes) {
+		return fmt.Errorf("sample count mismatch: trun=%d senc=%d", len(sizes), len(samples))
+	}
+	k, err := key(enc.KID)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return err
+	}
+	pos := moofOffset + dataOffset
+	for i, size := range sizes {
+		if pos < 0 || pos+int(size) > len(out) {
+			return errors.New("sample data is out of range")
+		}
+		iv := samples[i].iv
+		if len(iv) == 0 {
+			iv = enc.ConstantIV
+		}
+		if err := decryptSample(out[pos:pos+int(size)], enc, block, iv, samples[i].subsamples); err != nil {
+			return err
+		}
+		pos += int(size)
+	}
+	return nil
+}
+
+// readTrun returns sample sizes and data_offset of the trun box.
+func readTrun(trun *Box, defaultSampleSize uint32) (sizes []uint32, dataOffset int, hasDataOffset bool, err error) {
+	_, flags, payload, err := trun.FullBox()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if len(payload) < 4 {
+		return nil, 0, false, errors.New("too short trun box")
+	}
+	count := int(binary.BigEndian.Uint32(payload))
+	offset := 4
+	if flags&trunDataOffsetPresent != 0 {
+		if len(payload) < offset+4 {
+			return nil, 0, false, errors.New("too short trun box")
+		}
+		dataOffset = int(int32(binary.BigEndian.Uint32(payload[offset:])))
+		hasDataOffset = true
+		offset += 4
+	}
+	if flags&trunFirstSampleFlagsPresent != 0 {
+		offset += 4
+	}
+	var entrySize int
+	for _, bit := range []uint32{trunSampleDurationPresent, trunSampleSizePresent, trunSampleFlagsPresent, trunSampleCompositionTimeOffsetPresent} {
+		if flags&bit != 0 {
+			entrySize += 4
+		}
+	}
+	if len(payload) < offset+entrySize*count {
+		return nil, 0, false, errors.New("too short trun box")
+	}
+	sizes = make([]uint32, count)
+	for i := range sizes {
+		sizes[i] = defaultSampleSize
+		if flags&trunSampleSizePresent != 0 {
+			sizeOffset := offset + entrySize*i
+			if flags&trunSampleDurationPresent != 0 {
+				sizeOffset += 4
+			}
+			sizes[i] = binary.BigEndian.Uint32(payload[sizeOffset:])
+		}
+	}
+	return sizes, dataOffset, hasDataOffset, nil
+}
+
+const sencUseSubsampleEncryption = 0x000002
+
+func readSenc(senc *Box, ivSize int) ([]*sampleEncryption, error) {
+	_, flags, payload, err := senc.FullBox()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 4 {
+		return nil, errors.New("too short senc box")
+	}
+	count := int(binary.BigEndian.Uint32(payload))
+	payload = payload[4:]
+	samples := make([]*sampleEncryption, 0, count)
+	for i := 0; i < count; i++ {
+		if len(payload) < ivSize {
+			return nil, errors.New("too short senc box")
+		}
+		sample := &sampleEncryption{iv: payload[:ivSize]}
+		payload = payload[ivSize:]
+		if flags&sencUseSubsampleEncryption != 0 {
+			if len(payload) < 2 {
+				return nil, errors.New("too short senc box")
+			}
+			n := int(binary.BigEndian.Uint16(payload))
+			payload = payload[2:]
+			if len(payload) < n*6 {
+				return nil, errors.New("too short senc box")
+			}
+			for j := 0; j < n; j++ {
+				sample.subsamples = append(sample.subsamples, subsample{
+					clear:     int(binary.BigEndian.Uint16(payload[j*6:])),
+					protected: int(binary.BigEndian.Uint32(payload[j*6+2:])),
+				})
+			}
+			payload = payload[n*6:]
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func decryptSample(sample []byte, enc *TrackEncryption, block cipher.Block, iv []byte, subsamples []subsample) error {
+	if len(subsamples) == 0 {
+		subsamples = []subsample{{protected: len(sample)}}
+	}
+	iv16 := make([]byte, aes.BlockSize)
+	copy(iv16, iv)
+	var ctr cipher.Stream
+	var cbc cipher.BlockMode
+	switch enc.Scheme {
+	case "cenc", "cens":
+		ctr = cipher.NewCTR(block, iv16)
+	case "cbc1":
+		cbc = cipher.NewCBCDecrypter(block, iv16)
+	case "cbcs":
+	default:
+		return fmt.Errorf("unsupported protection scheme: %s", enc.Scheme)
+	}
+	var pos int
+	for _, sub := range subsamples {
+		pos += sub.clear
+		if pos+sub.protected > len(sample) {
+			return errors.New("subsample is out of range")
+		}
+		protected := sample[pos : pos+sub.protected]
+		pos += sub.protected
+		switch enc.Scheme {
+		case "cenc":
+			ctr.XORKeyStream(protected, protected)
+		case "cens":
+			applyPattern(protected, enc.CryptByteBlock, enc.SkipByteBlock, func(blocks []byte) {
+				ctr.XORKeyStream(blocks, blocks)
+			})
+		case "cbc1":
+			blocks := protected[:len(protected)/aes.BlockSize*aes.BlockSize]
+			cbc.CryptBlocks(blocks, blocks)
+		case "cbcs":
+			// the constant IV is applied at the start of each subsample.
+			cbc = cipher.NewCBCDecrypter(block, iv16)
+			applyPattern(protected, enc.CryptByteBlock, enc.SkipByteBlock, func(blocks []byte) {
+				cbc.CryptBlocks(blocks, blocks)
+			})
+		}
+	}
+	return nil
+}
+
+// applyPattern calls crypt for encrypted blocks specified by the pattern.
+// Trailing partial block is always unencrypted.
+func applyPattern(data []byte, cryptByteBlock, skipByteBlock int, crypt func(blocks []byte)) {
+	end := len(data) / aes.BlockSize * aes.BlockSize
+	if cryptByteBlock == 0 && skipByteBlock == 0 {
+		if end != 0 {
+			crypt(data[:end])
+		}
+		return
+	}
+	for pos := 0; pos < end; pos += (cryptByteBlock + skipByteBlock) * aes.BlockSize {
+		n := cryptByteBlock * aes.BlockSize
+		if pos+n > end {
+			n = end - pos
+		}
+		crypt(data[pos : pos+n])
+	}
+}
+
+// PSSH is a protection system specific header box.
+type PSSH struct {
+	SystemID []byte
+	// KIDs is empty when the box version is 0.
+	KIDs [][]byte
+	Data []byte
+}
+
+// ParsePSSH parses the pssh box.
+func ParsePSSH(box *Box) (*PSSH, error) {
+	version, _, payload, err := box.FullBox()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 16 {
+		return nil, errors.New("too short pssh box")
+	}
+	pssh := &PSSH{SystemID: payload[:16]}
+	payload = payload[16:]
+	if version > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("too short pssh box")
+		}
+		count := int(binary.BigEndian.Uint32(payload))
+		payload = payload[4:]
+		if len(payload) < count*16 {
+			return nil, errors.New("too short pssh box")
+		}
+		for i := 0; i < count; i++ {
+			pssh.KIDs = append(pssh.KIDs, payload[i*16:(i+1)*16])
+		}
+		payload = payload[count*16:]
+	}
+	if len(payload) < 4 {
+		return nil, errors.New("too short pssh box")
+	}
+	size := int(binary.BigEndian.Uint32(payload))
+	if len(payload) < 4+size {
+		return nil, errors.New("too short pssh box")
+	}
+	pssh.Data = payload[4 : 4+size]
+	return pssh, nil
+}
+
+// ReadPSSHs reads pssh boxes from the initialization segment.
+func ReadPSSHs(init []byte) ([]*PSSH, error) {
+	boxes, err := FindBoxes(init, "moov", "pssh")
+	if err != nil {
+		return nil, err
+	}
+	psshs := make([]*PSSH, 0, len(boxes))
+	for _, box := range boxes {
+		pssh, err := ParsePSSH(box)
+		if err != nil {
+			return nil, err
+		}
+		psshs = append(psshs, pssh)
+	}
+	return psshs, nil
+}
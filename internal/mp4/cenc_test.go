@@ -0,0 +1,150 @@
+package mp4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"testing"
+
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initSegment(scheme string, tenc []byte) []byte {
+	return mp4test.Box("moov", mp4test.Box("trak",
+		mp4test.FullBox("tkhd", 0, 0, mp4test.U32(0, 0, 1, 0, 0)),
+		mp4test.Box("mdia", mp4test.Box("minf", mp4test.Box("stbl", mp4test.FullBox("stsd", 0, 0, mp4test.U32(1), mp4test.Box("encv",
+			make([]byte, 78),
+			mp4test.Box("sinf",
+				mp4test.Box("frma", []byte("avc1")),
+				mp4test.FullBox("schm", 0, 0, []byte(scheme), mp4test.U32(0x10000)),
+				mp4test.Box("schi", tenc),
+			),
+		))))),
+	))
+}
+
+func fragment(samples [][]byte, ivs [][]byte, subsamples [][2]int) []byte {
+	build := func(dataOffset uint32) []byte {
+		trun := mp4test.U32(uint32(len(samples)), dataOffset)
+		senc := mp4test.U32(uint32(len(samples)))
+		for i, sample := range samples {
+			trun = append(trun, mp4test.U32(uint32(len(sample)))...)
+			senc = append(senc, ivs[i]...)
+			senc = append(senc, 0, 1)
+			senc = append(senc, byte(subsamples[i][0]>>8), byte(subsamples[i][0]))
+			senc = append(senc, mp4test.U32(uint32(subsamples[i][1]))...)
+		}
+		return mp4test.Box("moof",
+			mp4test.FullBox("mfhd", 0, 0, mp4test.U32(1)),
+			mp4test.Box("traf",
+				mp4test.FullBox("tfhd", 0, 0x020000, mp4test.U32(1)),
+				mp4test.FullBox("trun", 0, 0x000201, trun),
+				mp4test.FullBox("senc", 0, 0x000002, senc),
+			),
+		)
+	}
+	moof := build(0)
+	moof = build(uint32(len(moof) + 8))
+	return append(moof, mp4test.Box("mdat", samples...)...)
+}
+
+func TestDecryptFragments(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	kid := []byte("fedcba9876543210")
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	plain := [][]byte{
+		bytes.Repeat([]byte{0x11}, 200),
+		bytes.Repeat([]byte{0x22}, 100),
+	}
+	subsamples := [][2]int{{8, 192}, {4, 96}}
+	keyFunc := func(k []byte) ([]byte, error) {
+		assert.Equal(t, kid, k)
+		return key, nil
+	}
+
+	t.Run("cenc", func(t *testing.T) {
+		ivs := [][]byte{[]byte("iv000001"), []byte("iv000002")}
+		encrypted := make([][]byte, len(plain))
+		for i := range plain {
+			encrypted[i] = append([]byte{}, plain[i]...)
+			iv := make([]byte, 16)
+			copy(iv, ivs[i])
+			protected := encrypted[i][subsamples[i][0]:]
+			cipher.NewCTR(block, iv).XORKeyStream(protected, protected)
+		}
+		tenc := mp4test.FullBox("tenc", 0, 0, []byte{0, 0, 1, 8}, kid)
+		tracks, err := ReadTrackEncryptions(initSegment("cenc", tenc))
+		require.NoError(t, err)
+		require.Contains(t, tracks, uint32(1))
+		assert.Equal(t, "cenc", tracks[1].Scheme)
+		assert.Equal(t, 8, tracks[1].PerSampleIVSize)
+
+		seg := fragment(encrypted, ivs, subsamples)
+		dec, err := DecryptFragments(seg, tracks, keyFunc)
+		require.NoError(t, err)
+		assert.Equal(t, fragment(plain, ivs, subsamples), dec)
+	})
+
+	t.Run("cbcs", func(t *testing.T) {
+		constantIV := []byte("constant-iv-0001")
+		encrypted := make([][]byte, len(plain))
+		for i := range plain {
+			encrypted[i] = append([]byte{}, plain[i]...)
+			cbc := cipher.NewCBCEncrypter(block, constantIV)
+			applyPattern(encrypted[i][subsamples[i][0]:], 1, 9, func(blocks []byte) {
+				cbc.CryptBlocks(blocks, blocks)
+			})
+		}
+		tenc := mp4test.FullBox("tenc", 1, 0, []byte{0, 0x19, 1, 0}, kid, []byte{16}, constantIV)
+		tracks, err := ReadTrackEncryptions(initSegment("cbcs", tenc))
+		require.NoError(t, err)
+		require.Contains(t, tracks, uint32(1))
+		assert.Equal(t, 1, tracks[1].CryptByteBlock)
+		assert.Equal(t, 9, tracks[1].SkipByteBlock)
+		assert.Equal(t, constantIV, tracks[1].ConstantIV)
+
+		ivs := [][]byte{{}, {}}
+		seg := fragment(encrypted, ivs, subsamples)
+		assert.NotEqual(t, fragment(plain, ivs, subsamples), seg)
+		dec, err := DecryptFragments(seg, tracks, keyFunc)
+		require.NoError(t, err)
+		assert.Equal(t, fragment(plain, ivs, subsamples), dec)
+	})
+
+	t.Run("sample_count_mismatch", func(t *testing.T) {
+		tenc := mp4test.FullBox("tenc", 0, 0, []byte{0, 0, 1, 8}, kid)
+		tracks, err := ReadTrackEncryptions(initSegment("cenc", tenc))
+		require.NoError(t, err)
+		seg := fragment(plain, [][]byte{[]byte("iv000001"), []byte("iv000002")}, subsamples)
+		// rewrite sample_count of senc
+		i := bytes.Index(seg, []byte("senc"))
+		binary.BigEndian.PutUint32(seg[i+8:], 1)
+		_, err = DecryptFragments(seg, tracks, keyFunc)
+		assert.Error(t, err)
+	})
+}
+
+func TestReadPSSHs(t *testing.T) {
+	systemID := []byte("0123456789abcdef")
+	kid := []byte("fedcba9876543210")
+	init := mp4test.Box("moov",
+		mp4test.FullBox("pssh", 0, 0, systemID, mp4test.U32(3), []byte("foo")),
+		mp4test.FullBox("pssh", 1, 0, systemID, mp4test.U32(1), kid, mp4test.U32(0)),
+	)
+	psshs, err := ReadPSSHs(init)
+	require.NoError(t, err)
+	require.Len(t, psshs, 2)
+	assert.Equal(t, systemID, psshs[0].SystemID)
+	assert.Empty(t, psshs[0].KIDs)
+	assert.Equal(t, []byte("foo"), psshs[0].Data)
+	assert.Equal(t, systemID, psshs[1].SystemID)
+	assert.Equal(t, [][]byte{kid}, psshs[1].KIDs)
+	assert.Empty(t, psshs[1].Data)
+
+	_, err = ReadPSSHs(mp4test.Box("moov", mp4test.FullBox("pssh", 1, 0, systemID, mp4test.U32(2), kid)))
+	require.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// EventMessage is the event message box (emsg).
+type EventMessage struct {
+	Version     uint8
+	SchemeIDURI string
+	Value       string
+	Timescale   uint32
+	// PresentationTimeDelta is the offset from the earliest presentation time of the segment.
+	// It is available only in version 0.
+	PresentationTimeDelta uint32
+	// PresentationTime is available only in version 1.
+	PresentationTime uint64
+	EventDuration    uint32
+	ID               uint32
+	MessageData      []byte
+}
+
+// ReadEventMessages parses top-level emsg boxes in the segment.
+func ReadEventMessages(segment []byte) ([]*EventMessage, error) {
+	boxes, err := FindBoxes(segment, "emsg")
+	if err != nil {
+		return nil, err
+	}
+	emsgs := make([]*EventMessage, 0, len(boxes))
+	for _, box := range boxes {
+		emsg, err := ParseEventMessage(box)
+		if err != nil {
+			return nil, err
+		}
+		emsgs = append(emsgs, emsg)
+	}
+	return emsgs, nil
+}
+
+// ParseEventMessage parses the emsg box.
+func ParseEventMessage(box *Box) (*EventMessage, error) {
+	version, _, payload, err := box.FullBox()
+	if err != nil {
+		return nil, err
+	}
+	emsg := &EventMessage{Version: version}
+	switch version {
+	case 0:
+		if emsg.SchemeIDURI, payload, err = readCString(payload); err != nil {
+			return nil, err
+		}
+		if emsg.Value, payload, err = readCString(payload); err != nil {
+			return nil, err
+		}
+		if len(payload) < 16 {
+			return nil, errors.New("too short emsg box")
+		}
+		emsg.Timescale = binary.BigEndian.Uint32(payload)
+		emsg.PresentationTimeDelta = binary.BigEndian.Uint32(payload[4:])
+		emsg.EventDuration = binary.BigEndian.Uint32(payload[8:])
+		emsg.ID = binary.BigEndian.Uint32(payload[12:])
+		payload = payload[16:]
+	case 1:
+		if len(payload) < 20 {
+			return nil, errors.New("too short emsg box")
+		}
+		emsg.Timescale = binary.BigEndian.Uint32(payload)
+		emsg.PresentationTime = binary.BigEndian.Uint64(payload[4:])
+		emsg.EventDuration = binary.BigEndian.Uint32(payload[12:])
+		emsg.ID = binary.BigEndian.Uint32(payload[16:])
+		payload = payload[20:]
+		if emsg.SchemeIDURI, payload, err = readCString(payload); err != nil {
+			return nil, err
+		}
+		if emsg.Value, payload, err = readCString(payload); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported emsg version")
+	}
+	emsg.MessageData = payload
+	return emsg, nil
+}
+
+// readCString reads a null-terminated string.
+func readCString(data []byte) (string, []byte, error) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", nil, errors.New("string is not terminated")
+	}
+	return string(data[:i]), data[i+1:], nil
+}
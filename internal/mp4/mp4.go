@@ -0,0 +1,193 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Box is an ISO base media file format box.
+type Box struct {
+	Type string
+	// Offset is the position of the box header in the parsed data.
+	Offset int
+	// Size is the size of the box including the header.
+	Size int
+	// Payload is the box content following the box header.
+	Payload []byte
+}
+
+// FullBox returns version, flags and payload of the full box.
+func (b *Box) FullBox() (version uint8, flags uint32, payload []byte, err error) {
+	if len(b.Payload) < 4 {
+		return 0, 0, nil, fmt.Errorf("too short full box: %s", b.Type)
+	}
+	header := binary.BigEndian.Uint32(b.Payload)
+	return uint8(header >> 24), header & 0x00ffffff, b.Payload[4:], nil
+}
+
+// ReadBoxes parses sequential boxes.
+func ReadBoxes(data []byte) ([]*Box, error) {
+	boxes := make([]*Box, 0)
+	var offset int
+	for len(data) != 0 {
+		if len(data) < 8 {
+			return nil, errors.New("too short box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("too short box header")
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid box size: type=%s size=%d", boxType, size)
+		}
+		boxes = append(boxes, &Box{
+			Type:    boxType,
+			Offset:  offset,
+			Size:    int(size),
+			Payload: data[headerSize:size],
+		})
+		data = data[size:]
+		offset += int(size)
+	}
+	return boxes, nil
+}
+
+// FindBoxes returns boxes which match the path of box types.
+// For example, FindBoxes(data, "moof", "traf", "trun") returns all trun boxes in the fragments.
+func FindBoxes(data []byte, path ...string) ([]*Box, error) {
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]*Box, 0)
+	for _, box := range boxes {
+		if len(path) == 0 || box.Type != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			found = append(found, box)
+			continue
+		}
+		payload := box.Payload
+		if fullBoxContainers[box.Type] {
+			if _, _, payload, err = box.FullBox(); err != nil {
+				return nil, err
+			}
+		}
+		children, err := FindBoxes(payload, path[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, children...)
+	}
+	return found, nil
+}
+
+// fullBoxContainers is a set of full boxes which contain child boxes.
+var fullBoxContainers = map[string]bool{
+	"meta": true,
+}
+
+const (
+	tfhdBaseDataOffsetPresent              = 0x000001
+	tfhdSampleDescriptionIndexPresent      = 0x000002
+	tfhdDefaultSampleDurationPresent       = 0x000008
+	tfhdDefaultSampleSizePresent           = 0x000010
+	tfhdDefaultSampleFlagsPresent          = 0x000020
+	trunDataOffsetPresent                  = 0x000001
+	trunFirstSampleFlagsPresent            = 0x000004
+	trunSampleDurationPresent              = 0x000100
+	trunSampleSizePresent                  = 0x000200
+	trunSampleFlagsPresent                 = 0x000400
+	trunSampleCompositionTimeOffsetPresent = 0x000800
+	sampleIsNonSyncSample                  = 0x00010000
+)
+
+// FirstSampleIsSync returns whether the first sample of the first movie fragment is a sync sample.
+// ok is false when the sample flags are not specified in the movie fragment.
+func FirstSampleIsSync(data []byte) (sync bool, ok bool, err error) {
+	trafs, err := FindBoxes(data, "moof", "traf")
+	if err != nil {
+		return false, false, err
+	}
+	if len(trafs) == 0 {
+		return false, false, errors.New("movie fragment is not found")
+	}
+	children, err := ReadBoxes(trafs[0].Payload)
+	if err != nil {
+		return false, false, err
+	}
+	var defaultFlags *uint32
+	for _, box := range children {
+		switch box.Type {
+		case "tfhd":
+			_, flags, payload, err := box.FullBox()
+			if err != nil {
+				return false, false, err
+			}
+			if flags&tfhdDefaultSampleFlagsPresent == 0 {
+				continue
+			}
+			offset := 4 // track_ID
+			if flags&tfhdBaseDataOffsetPresent != 0 {
+				offset += 8
+			}
+			for _, bit := range []uint32{
+				tfhdSampleDescriptionIndexPresent,
+				tfhdDefaultSampleDurationPresent,
+				tfhdDefaultSampleSizePresent,
+			} {
+				if flags&bit != 0 {
+					offset += 4
+				}
+			}
+			if len(payload) < offset+4 {
+				return false, false, errors.New("too short tfhd box")
+			}
+			f := binary.BigEndian.Uint32(payload[offset:])
+			defaultFlags = &f
+		case "trun":
+			_, flags, payload, err := box.FullBox()
+			if err != nil {
+				return false, false, err
+			}
+			offset := 4 // sample_count
+			if flags&trunDataOffsetPresent != 0 {
+				offset += 4
+			}
+			if flags&trunFirstSampleFlagsPresent != 0 {
+				if len(payload) < offset+4 {
+					return false, false, errors.New("too short trun box")
+				}
+				return binary.BigEndian.Uint32(payload[offset:])&sampleIsNonSyncSample == 0, true, nil
+			}
+			if flags&trunSampleFlagsPresent != 0 {
+				if flags&trunSampleDurationPresent != 0 {
+					offset += 4
+				}
+				if flags&trunSampleSizePresent != 0 {
+					offset += 4
+				}
+				if len(payload) < offset+4 {
+					return false, false, errors.New("too short trun box")
+				}
+				return binary.BigEndian.Uint32(payload[offset:])&sampleIsNonSyncSample == 0, true, nil
+			}
+			if defaultFlags != nil {
+				return *defaultFlags&sampleIsNonSyncSample == 0, true, nil
+			}
+			return false, false, nil
+		}
+	}
+	return false, false, nil
+}
@@ -0,0 +1,128 @@
+package mp4
+
+import (
+	"testing"
+
+	"github.com/abema/antares/internal/mp4/mp4test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBoxes(t *testing.T) {
+	data := append(mp4test.Box("styp", []byte("msdh")), mp4test.Box("moof",
+		mp4test.Box("mfhd", mp4test.U32(0, 1)),
+		mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0, 1)), mp4test.Box("trun", mp4test.U32(0, 0))),
+		mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0, 2))),
+	)...)
+	boxes, err := FindBoxes(data, "moof", "traf", "tfhd")
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+	_, _, payload, err := boxes[1].FullBox()
+	require.NoError(t, err)
+	assert.Equal(t, mp4test.U32(2), payload)
+
+	_, err = FindBoxes(data[:len(data)-1], "moof")
+	assert.Error(t, err)
+}
+
+func TestFirstSampleIsSync(t *testing.T) {
+	testCases := []struct {
+		name string
+		traf []byte
+		sync bool
+		ok   bool
+	}{
+		{
+			name: "first_sample_flags",
+			traf: mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0, 1)), mp4test.Box("trun", mp4test.U32(0x000005, 1, 100, 0x02000000))),
+			sync: true,
+			ok:   true,
+		},
+		{
+			name: "sample_flags",
+			traf: mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0, 1)), mp4test.Box("trun", mp4test.U32(0x000701, 1, 100, 3000, 5000, 0x01010000))),
+			sync: false,
+			ok:   true,
+		},
+		{
+			name: "default_sample_flags",
+			traf: mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0x000028, 1, 3000, 0x01010000)), mp4test.Box("trun", mp4test.U32(0x000001, 1, 100))),
+			sync: false,
+			ok:   true,
+		},
+		{
+			name: "unknown",
+			traf: mp4test.Box("traf", mp4test.Box("tfhd", mp4test.U32(0, 1)), mp4test.Box("trun", mp4test.U32(0x000001, 1, 100))),
+			ok:   false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sync, ok, err := FirstSampleIsSync(mp4test.Box("moof", tc.traf))
+			require.NoError(t, err)
+			assert.Equal(t, tc.sync, sync)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+
+	_, _, err := FirstSampleIsSync(mp4test.Box("mdat"))
+	assert.Error(t, err)
+}
+
+func TestReadSegmentIndex(t *testing.T) {
+	data := append(mp4test.Box("styp", []byte("iso6")), mp4test.Box("sidx",
+		mp4test.U32(1<<24, 1, 90000, 0, 0x12345678, 0, 100, 2),
+		mp4test.U32(1000, 180000, 0x90000000),
+		mp4test.U32(0x80000000|2000, 90000, 0),
+	)...)
+	sidx, end, err := ReadSegmentIndex(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), end)
+	assert.Equal(t, uint32(90000), sidx.Timescale)
+	assert.Equal(t, uint64(0x12345678), sidx.EarliestPresentationTime)
+	assert.Equal(t, uint64(100), sidx.FirstOffset)
+	require.Len(t, sidx.References, 2)
+	assert.Equal(t, &SegmentReference{ReferencedSize: 1000, SubsegmentDuration: 180000, StartsWithSAP: true}, sidx.References[0])
+	assert.Equal(t, &SegmentReference{ReferenceType: true, ReferencedSize: 2000, SubsegmentDuration: 90000}, sidx.References[1])
+
+	_, _, err = ReadSegmentIndex(mp4test.Box("styp"))
+	require.Error(t, err)
+}
+
+func TestReadEventMessages(t *testing.T) {
+	var data []byte
+	for _, b := range [][]byte{
+		mp4test.Box("styp", []byte("msdh")),
+		mp4test.Box("emsg", mp4test.U32(0), []byte("urn:scte:scte35:2013:bin\x00"), []byte("1\x00"), mp4test.U32(90000, 180000, 2700000, 10), []byte{0xfc}),
+		mp4test.Box("emsg", mp4test.U32(1<<24, 1000, 0, 5000, 3000, 11), []byte("urn:example\x00\x00"), []byte("data")),
+		mp4test.Box("moof"),
+		mp4test.Box("mdat"),
+	} {
+		data = append(data, b...)
+	}
+	emsgs, err := ReadEventMessages(data)
+	require.NoError(t, err)
+	require.Len(t, emsgs, 2)
+	assert.Equal(t, &EventMessage{
+		Version:               0,
+		SchemeIDURI:           "urn:scte:scte35:2013:bin",
+		Value:                 "1",
+		Timescale:             90000,
+		PresentationTimeDelta: 180000,
+		EventDuration:         2700000,
+		ID:                    10,
+		MessageData:           []byte{0xfc},
+	}, emsgs[0])
+	assert.Equal(t, &EventMessage{
+		Version:          1,
+		SchemeIDURI:      "urn:example",
+		Timescale:        1000,
+		PresentationTime: 5000,
+		EventDuration:    3000,
+		ID:               11,
+		MessageData:      []byte("data"),
+	}, emsgs[1])
+
+	_, err = ReadEventMessages(mp4test.Box("emsg", mp4test.U32(0), []byte("urn:example")))
+	require.Error(t, err)
+}
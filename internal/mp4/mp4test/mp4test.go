@@ -0,0 +1,42 @@
+// Package mp4test provides utilities to build ISO BMFF boxes for tests.
+package mp4test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Box returns a box which has the type and the concatenated payloads.
+func Box(boxType string, payloads ...[]byte) []byte {
+	var payload []byte
+	for _, p := range payloads {
+		payload = append(payload, p...)
+	}
+	data := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(data, uint32(8+len(payload)))
+	copy(data[4:], boxType)
+	return append(data, payload...)
+}
+
+// FullBox returns a box which has the version, the flags and the concatenated payloads.
+func FullBox(boxType string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	return Box(boxType, append([][]byte{U32(uint32(version)<<24 | flags)}, payloads...)...)
+}
+
+// U32 returns the big-endian representation of the values.
+func U32(values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], v)
+	}
+	return data
+}
+
+// MustDecodeHex decodes the hex string, and panics if it is invalid.
+func MustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,80 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// SegmentIndex is the segment index box (sidx).
+type SegmentIndex struct {
+	Timescale                uint32
+	EarliestPresentationTime uint64
+	// FirstOffset is the distance from the end of the sidx box to the first referenced byte.
+	FirstOffset uint64
+	References  []*SegmentReference
+}
+
+// SegmentReference is a reference entry of the sidx box.
+type SegmentReference struct {
+	// ReferenceType is true when the reference points to another sidx box.
+	ReferenceType      bool
+	ReferencedSize     uint32
+	SubsegmentDuration uint32
+	StartsWithSAP      bool
+}
+
+// ReadSegmentIndex parses the first sidx box in data.
+// end is the position of the end of the sidx box in data.
+func ReadSegmentIndex(data []byte) (sidx *SegmentIndex, end int, err error) {
+	boxes, err := FindBoxes(data, "sidx")
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(boxes) == 0 {
+		return nil, 0, errors.New("sidx box is not found")
+	}
+	version, _, payload, err := boxes[0].FullBox()
+	if err != nil {
+		return nil, 0, err
+	}
+	sidx = &SegmentIndex{}
+	if len(payload) < 8 {
+		return nil, 0, errors.New("too short sidx box")
+	}
+	sidx.Timescale = binary.BigEndian.Uint32(payload[4:])
+	payload = payload[8:]
+	if version == 0 {
+		if len(payload) < 8 {
+			return nil, 0, errors.New("too short sidx box")
+		}
+		sidx.EarliestPresentationTime = uint64(binary.BigEndian.Uint32(payload))
+		sidx.FirstOffset = uint64(binary.BigEndian.Uint32(payload[4:]))
+		payload = payload[8:]
+	} else {
+		if len(payload) < 16 {
+			return nil, 0, errors.New("too short sidx box")
+		}
+		sidx.EarliestPresentationTime = binary.BigEndian.Uint64(payload)
+		sidx.FirstOffset = binary.BigEndian.Uint64(payload[8:])
+		payload = payload[16:]
+	}
+	if len(payload) < 4 {
+		return nil, 0, errors.New("too short sidx box")
+	}
+	count := int(binary.BigEndian.Uint16(payload[2:]))
+	payload = payload[4:]
+	if len(payload) < count*12 {
+		return nil, 0, errors.New("too short sidx box")
+	}
+	sidx.References = make([]*SegmentReference, count)
+	for i := range sidx.References {
+		entry := payload[i*12:]
+		sidx.References[i] = &SegmentReference{
+			ReferenceType:      entry[0]&0x80 != 0,
+			ReferencedSize:     binary.BigEndian.Uint32(entry) & 0x7fffffff,
+			SubsegmentDuration: binary.BigEndian.Uint32(entry[4:]),
+			StartsWithSAP:      entry[8]&0x80 != 0,
+		}
+	}
+	return sidx, boxes[0].Offset + boxes[0].Size, nil
+}
@@ -0,0 +1,306 @@
+package scte35
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	TableID = 0xfc
+
+	CommandSpliceNull           = 0x00
+	CommandSpliceSchedule       = 0x04
+	CommandSpliceInsert         = 0x05
+	CommandTimeSignal           = 0x06
+	CommandBandwidthReservation = 0x07
+	CommandPrivate              = 0xff
+
+	descriptorTagSegmentation = 0x02
+	cueIdentifier             = 0x43554549 // "CUEI"
+)
+
+// Timescale is the timescale of PTS and durations in splice_info_section.
+const Timescale = 90000
+
+// SpliceInfo is splice_info_section.
+type SpliceInfo struct {
+	PTSAdjustment uint64
+	Tier          uint16
+	CommandType   uint8
+	// SpliceInsert is set when the command is splice_insert.
+	SpliceInsert *SpliceInsert
+	// TimeSignal is set when the command is time_signal.
+	TimeSignal              *SpliceTime
+	SegmentationDescriptors []*SegmentationDescriptor
+}
+
+// SpliceTime is splice_time. PTSTime is nil when time_specified_flag is 0.
+type SpliceTime struct {
+	PTSTime *uint64
+}
+
+// SpliceInsert is splice_insert command.
+type SpliceInsert struct {
+	EventID         uint32
+	Cancel          bool
+	OutOfNetwork    bool
+	ProgramSplice   bool
+	SpliceImmediate bool
+	// SpliceTime is the splice time of the program or the first component.
+	SpliceTime *SpliceTime
+	// BreakDuration is nil when duration_flag is 0.
+	BreakDuration   *uint64
+	AutoReturn      bool
+	UniqueProgramID uint16
+	AvailNum        uint8
+	AvailsExpected  uint8
+}
+
+// SegmentationDescriptor is segmentation_descriptor.
+type SegmentationDescriptor struct {
+	EventID uint32
+	Cancel  bool
+	// Duration is nil when segmentation_duration_flag is 0.
+	Duration         *uint64
+	UPIDType         uint8
+	UPID             []byte
+	TypeID           uint8
+	SegmentNum       uint8
+	SegmentsExpected uint8
+}
+
+// IsStart returns whether the segmentation type starts a break, an advertisement, a placement opportunity or an ad block.
+func (d *SegmentationDescriptor) IsStart() bool {
+	return isAdSegmentationType(d.TypeID) && d.TypeID%2 == 0
+}
+
+// IsEnd returns whether the segmentation type ends a break, an advertisement, a placement opportunity or an ad block.
+func (d *SegmentationDescriptor) IsEnd() bool {
+	return isAdSegmentationType(d.TypeID) && d.TypeID%2 == 1
+}
+
+// StartTypeID returns segmentation_type_id of the start which is paired with the type.
+func (d *SegmentationDescriptor) StartTypeID() uint8 {
+	return d.TypeID &^ 1
+}
+
+// isAdSegmentationType returns whether the type is Break, Advertisement, Placement Opportunity,
+// Overlay Placement Opportunity or Ad Block.
+// Promos (0x3C-0x3F), Unscheduled Events (0x40, 0x41) and Alternate Content Opportunities (0x42, 0x43) are excluded.
+func isAdSegmentationType(typeID uint8) bool {
+	return typeID == 0x22 || typeID == 0x23 || // Break
+		(typeID >= 0x30 && typeID <= 0x3B) || // Advertisement, Placement Opportunity, Overlay Placement Opportunity
+		(typeID >= 0x44 && typeID <= 0x47) // Ad Block
+}
+
+// Parse parses splice_info_section.
+// Encrypted sections and CRC are not verified.
+func Parse(data []byte) (*SpliceInfo, error) {
+	if len(data) < 14 {
+		return nil, errors.New("too short splice_info_section")
+	}
+	if data[0] != TableID {
+		return nil, fmt.Errorf("invalid table_id: 0x%02x", data[0])
+	}
+	sectionLength := int(binary.BigEndian.Uint16(data[1:]) & 0x0fff)
+	if 3+sectionLength > len(data) {
+		return nil, errors.New("invalid section_length")
+	}
+	data = data[:3+sectionLength]
+	if data[4]&0x80 != 0 {
+		return nil, errors.New("encrypted splice_info_section is not supported")
+	}
+	info := &SpliceInfo{
+		PTSAdjustment: uint64(data[4]&0x01)<<32 | uint64(binary.BigEndian.Uint32(data[5:])),
+		Tier:          binary.BigEndian.Uint16(data[10:]) >> 4,
+		CommandType:   data[13],
+	}
+	commandLength := int(binary.BigEndian.Uint16(data[11:]) & 0x0fff)
+	command := data[14:]
+	if commandLength != 0x0fff {
+		if commandLength > len(command) {
+			return nil, errors.New("invalid splice_command_length")
+		}
+		command = command[:commandLength]
+	}
+	var n int
+	var err error
+	switch info.CommandType {
+	case CommandSpliceInsert:
+		info.SpliceInsert, n, err = parseSpliceInsert(command)
+	case CommandTimeSignal:
+		info.TimeSignal, n, err = parseSpliceTime(command)
+	case CommandSpliceNull, CommandBandwidthReservation:
+	default:
+		if commandLength == 0x0fff {
+			return nil, fmt.Errorf("unknown length of splice command: 0x%02x", info.CommandType)
+		}
+		n = commandLength
+	}
+	if err != nil {
+		return nil, err
+	}
+	if commandLength != 0x0fff {
+		n = commandLength
+	}
+	rest := data[14+n:]
+	if len(rest) < 2 {
+		return nil, errors.New("too short splice_info_section")
+	}
+	loopLength := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if loopLength > len(rest) {
+		return nil, errors.New("invalid descriptor_loop_length")
+	}
+	for descriptors := rest[:loopLength]; len(descriptors) != 0; {
+		if len(descriptors) < 2 || 2+int(descriptors[1]) > len(descriptors) {
+			return nil, errors.New("invalid splice_descriptor")
+		}
+		tag, body := descriptors[0], descriptors[2:2+int(descriptors[1])]
+		descriptors = descriptors[2+int(descriptors[1]):]
+		if tag != descriptorTagSegmentation || len(body) < 4 || binary.BigEndian.Uint32(body) != cueIdentifier {
+			continue
+		}
+		desc, err := parseSegmentationDescriptor(body[4:])
+		if err != nil {
+			return nil, err
+		}
+		info.SegmentationDescriptors = append(info.SegmentationDescriptors, desc)
+	}
+	return info, nil
+}
+
+func parseSpliceTime(data []byte) (*SpliceTime, int, error) {
+	if len(data) < 1 {
+		return nil, 0, errors.New("too short splice_time")
+	}
+	if data[0]&0x80 == 0 {
+		return &SpliceTime{}, 1, nil
+	}
+	if len(data) < 5 {
+		return nil, 0, errors.New("too short splice_time")
+	}
+	pts := read33(data)
+	return &SpliceTime{PTSTime: &pts}, 5, nil
+}
+
+func parseSpliceInsert(data []byte) (*SpliceInsert, int, error) {
+	if len(data) < 5 {
+		return nil, 0, errors.New("too short splice_insert")
+	}
+	insert := &SpliceInsert{
+		EventID: binary.BigEndian.Uint32(data),
+		Cancel:  data[4]&0x80 != 0,
+	}
+	n := 5
+	if insert.Cancel {
+		return insert, n, nil
+	}
+	if len(data) < n+1 {
+		return nil, 0, errors.New("too short splice_insert")
+	}
+	flags := data[n]
+	n++
+	insert.OutOfNetwork = flags&0x80 != 0
+	insert.ProgramSplice = flags&0x40 != 0
+	durationFlag := flags&0x20 != 0
+	insert.SpliceImmediate = flags&0x10 != 0
+	if insert.ProgramSplice && !insert.SpliceImmediate {
+		spliceTime, m, err := parseSpliceTime(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		insert.SpliceTime = spliceTime
+		n += m
+	}
+	if !insert.ProgramSplice {
+		if len(data) < n+1 {
+			return nil, 0, errors.New("too short splice_insert")
+		}
+		count := int(data[n])
+		n++
+		for i := 0; i < count; i++ {
+			if len(data) < n+1 {
+				return nil, 0, errors.New("too short splice_insert")
+			}
+			n++ // component_tag
+			if insert.SpliceImmediate {
+				continue
+			}
+			spliceTime, m, err := parseSpliceTime(data[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			if insert.SpliceTime == nil {
+				insert.SpliceTime = spliceTime
+			}
+			n += m
+		}
+	}
+	if durationFlag {
+		if len(data) < n+5 {
+			return nil, 0, errors.New("too short break_duration")
+		}
+		insert.AutoReturn = data[n]&0x80 != 0
+		duration := read33(data[n:])
+		insert.BreakDuration = &duration
+		n += 5
+	}
+	if len(data) < n+4 {
+		return nil, 0, errors.New("too short splice_insert")
+	}
+	insert.UniqueProgramID = binary.BigEndian.Uint16(data[n:])
+	insert.AvailNum = data[n+2]
+	insert.AvailsExpected = data[n+3]
+	return insert, n + 4, nil
+}
+
+func parseSegmentationDescriptor(data []byte) (*SegmentationDescriptor, error) {
+	if len(data) < 5 {
+		return nil, errors.New("too short segmentation_descriptor")
+	}
+	desc := &SegmentationDescriptor{
+		EventID: binary.BigEndian.Uint32(data),
+		Cancel:  data[4]&0x80 != 0,
+	}
+	if desc.Cancel {
+		return desc, nil
+	}
+	data = data[5:]
+	if len(data) < 1 {
+		return nil, errors.New("too short segmentation_descriptor")
+	}
+	programSegmentation := data[0]&0x80 != 0
+	durationFlag := data[0]&0x40 != 0
+	data = data[1:]
+	if !programSegmentation {
+		if len(data) < 1 || len(data) < 1+6*int(data[0]) {
+			return nil, errors.New("too short segmentation_descriptor")
+		}
+		data = data[1+6*int(data[0]):]
+	}
+	if durationFlag {
+		if len(data) < 5 {
+			return nil, errors.New("too short segmentation_duration")
+		}
+		duration := uint64(data[0])<<32 | uint64(binary.BigEndian.Uint32(data[1:]))
+		desc.Duration = &duration
+		data = data[5:]
+	}
+	if len(data) < 2 || len(data) < 2+int(data[1])+3 {
+		return nil, errors.New("too short segmentation_descriptor")
+	}
+	desc.UPIDType = data[0]
+	desc.UPID = data[2 : 2+int(data[1])]
+	data = data[2+int(data[1]):]
+	desc.TypeID = data[0]
+	desc.SegmentNum = data[1]
+	desc.SegmentsExpected = data[2]
+	return desc, nil
+}
+
+// read33 reads the 33-bit value which is preceded by 7 bits.
+func read33(data []byte) uint64 {
+	return uint64(data[0]&0x01)<<32 | uint64(binary.BigEndian.Uint32(data[1:]))
+}
@@ -0,0 +1,149 @@
+package scte35
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("splice_insert", func(t *testing.T) {
+		// splice_insert: out_of_network, pts_time=0x07369c02e, auto_return, break_duration=0x00052ccf5 (60.293s)
+		data, err := base64.StdEncoding.DecodeString("/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=")
+		require.NoError(t, err)
+		info, err := Parse(data)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(CommandSpliceInsert), info.CommandType)
+		require.NotNil(t, info.SpliceInsert)
+		insert := info.SpliceInsert
+		assert.Equal(t, uint32(0x4800008f), insert.EventID)
+		assert.False(t, insert.Cancel)
+		assert.True(t, insert.OutOfNetwork)
+		assert.True(t, insert.ProgramSplice)
+		assert.False(t, insert.SpliceImmediate)
+		require.NotNil(t, insert.SpliceTime)
+		require.NotNil(t, insert.SpliceTime.PTSTime)
+		assert.Equal(t, uint64(0x07369c02e), *insert.SpliceTime.PTSTime)
+		require.NotNil(t, insert.BreakDuration)
+		assert.Equal(t, uint64(0x00052ccf5), *insert.BreakDuration)
+		assert.True(t, insert.AutoReturn)
+		assert.Empty(t, info.SegmentationDescriptors)
+	})
+
+	t.Run("time_signal", func(t *testing.T) {
+		// time_signal with segmentation_descriptor: Provider Placement Opportunity Start, duration=0x0001a599b0
+		data, err := base64.StdEncoding.DecodeString("/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg==")
+		require.NoError(t, err)
+		info, err := Parse(data)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(CommandTimeSignal), info.CommandType)
+		require.NotNil(t, info.TimeSignal)
+		require.NotNil(t, info.TimeSignal.PTSTime)
+		assert.Equal(t, uint64(0x072bd0050), *info.TimeSignal.PTSTime)
+		require.Len(t, info.SegmentationDescriptors, 1)
+		desc := info.SegmentationDescriptors[0]
+		assert.Equal(t, uint32(0x4800008e), desc.EventID)
+		assert.False(t, desc.Cancel)
+		require.NotNil(t, desc.Duration)
+		assert.Equal(t, uint64(0x0001a599b0), *desc.Duration)
+		assert.Equal(t, uint8(0x08), desc.UPIDType)
+		assert.Equal(t, uint8(0x34), desc.TypeID)
+		assert.True(t, desc.IsStart())
+		assert.False(t, desc.IsEnd())
+	})
+
+	t.Run("invalid table_id", func(t *testing.T) {
+		_, err := Parse(make([]byte, 20))
+		require.Error(t, err)
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		_, err := Parse([]byte{0xfc, 0x30, 0x11})
+		require.Error(t, err)
+	})
+}
+
+func TestParseXML(t *testing.T) {
+	t.Run("SpliceInfoSection", func(t *testing.T) {
+		info, err := ParseXML([]byte(`<scte35:SpliceInfoSection xmlns:scte35="http://www.scte.org/schemas/35/2016" ptsAdjustment="0" tier="4095">` +
+			`<scte35:SpliceInsert spliceEventId="100" spliceEventCancelIndicator="false" outOfNetworkIndicator="true" uniqueProgramId="1" availNum="0" availsExpected="0" spliceImmediateFlag="false">` +
+			`<scte35:Program><scte35:SpliceTime ptsTime="900000"/></scte35:Program>` +
+			`<scte35:BreakDuration autoReturn="true" duration="2700000"/>` +
+			`</scte35:SpliceInsert>` +
+			`</scte35:SpliceInfoSection>`))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(CommandSpliceInsert), info.CommandType)
+		require.NotNil(t, info.SpliceInsert)
+		assert.Equal(t, uint32(100), info.SpliceInsert.EventID)
+		assert.True(t, info.SpliceInsert.OutOfNetwork)
+		require.NotNil(t, info.SpliceInsert.SpliceTime)
+		assert.Equal(t, uint64(900000), *info.SpliceInsert.SpliceTime.PTSTime)
+		require.NotNil(t, info.SpliceInsert.BreakDuration)
+		assert.Equal(t, uint64(2700000), *info.SpliceInsert.BreakDuration)
+		assert.True(t, info.SpliceInsert.AutoReturn)
+	})
+
+	t.Run("Signal/Binary", func(t *testing.T) {
+		info, err := ParseXML([]byte(`<scte35:Signal xmlns:scte35="http://www.scte.org/schemas/35/2016">` +
+			`<scte35:Binary>/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=</scte35:Binary>` +
+			`</scte35:Signal>`))
+		require.NoError(t, err)
+		require.NotNil(t, info.SpliceInsert)
+		assert.Equal(t, uint32(0x4800008f), info.SpliceInsert.EventID)
+	})
+
+	t.Run("SegmentationDescriptor", func(t *testing.T) {
+		info, err := ParseXML([]byte(`<SpliceInfoSection>` +
+			`<TimeSignal><SpliceTime ptsTime="1800000"/></TimeSignal>` +
+			`<SegmentationDescriptor segmentationEventId="7" segmentationTypeId="35"/>` +
+			`</SpliceInfoSection>`))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(CommandTimeSignal), info.CommandType)
+		require.Len(t, info.SegmentationDescriptors, 1)
+		assert.True(t, info.SegmentationDescriptors[0].IsEnd())
+	})
+
+	t.Run("unexpected element", func(t *testing.T) {
+		_, err := ParseXML([]byte(`<Foo/>`))
+		require.Error(t, err)
+	})
+}
+
+func TestParseHex(t *testing.T) {
+	info, err := ParseHex("0xFC3034000000000000FFFFF00506FE72BD0050001E021C435545494800008E7FCF0001A599B00808000000002CA0A18A3402009AC9D17E")
+	require.NoError(t, err)
+	require.NotNil(t, info.TimeSignal)
+	require.Len(t, info.SegmentationDescriptors, 1)
+	assert.Equal(t, uint8(0x34), info.SegmentationDescriptors[0].TypeID)
+
+	_, err = ParseHex("0xZZ")
+	require.Error(t, err)
+}
+
+func TestSegmentationDescriptor(t *testing.T) {
+	for _, tc := range []struct {
+		typeID uint8
+		start  bool
+		end    bool
+	}{
+		{typeID: 0x22, start: true},
+		{typeID: 0x23, end: true},
+		{typeID: 0x30, start: true},
+		{typeID: 0x37, end: true},
+		{typeID: 0x3A, start: true},
+		{typeID: 0x3C},
+		{typeID: 0x3F},
+		{typeID: 0x40},
+		{typeID: 0x41},
+		{typeID: 0x42},
+		{typeID: 0x44, start: true},
+		{typeID: 0x47, end: true},
+	} {
+		desc := &SegmentationDescriptor{TypeID: tc.typeID}
+		assert.Equal(t, tc.start, desc.IsStart(), "0x%02x", tc.typeID)
+		assert.Equal(t, tc.end, desc.IsEnd(), "0x%02x", tc.typeID)
+	}
+	assert.Equal(t, uint8(0x34), (&SegmentationDescriptor{TypeID: 0x35}).StartTypeID())
+}
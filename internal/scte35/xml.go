@@ -0,0 +1,157 @@
+package scte35
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+type xmlSpliceTime struct {
+	PTSTime *uint64 `xml:"ptsTime,attr"`
+}
+
+type xmlSpliceInfoSection struct {
+	PTSAdjustment uint64  `xml:"ptsAdjustment,attr"`
+	Tier          *uint16 `xml:"tier,attr"`
+	SpliceInsert  *struct {
+		EventID         uint32 `xml:"spliceEventId,attr"`
+		Cancel          bool   `xml:"spliceEventCancelIndicator,attr"`
+		OutOfNetwork    bool   `xml:"outOfNetworkIndicator,attr"`
+		SpliceImmediate bool   `xml:"spliceImmediateFlag,attr"`
+		UniqueProgramID uint16 `xml:"uniqueProgramId,attr"`
+		AvailNum        uint8  `xml:"availNum,attr"`
+		AvailsExpected  uint8  `xml:"availsExpected,attr"`
+		Program         *struct {
+			SpliceTime *xmlSpliceTime `xml:"SpliceTime"`
+		} `xml:"Program"`
+		Components []struct {
+			SpliceTime *xmlSpliceTime `xml:"SpliceTime"`
+		} `xml:"Component"`
+		BreakDuration *struct {
+			AutoReturn bool   `xml:"autoReturn,attr"`
+			Duration   uint64 `xml:"duration,attr"`
+		} `xml:"BreakDuration"`
+	} `xml:"SpliceInsert"`
+	TimeSignal *struct {
+		SpliceTime *xmlSpliceTime `xml:"SpliceTime"`
+	} `xml:"TimeSignal"`
+	SegmentationDescriptors []struct {
+		EventID          uint32  `xml:"segmentationEventId,attr"`
+		Cancel           bool    `xml:"segmentationEventCancelIndicator,attr"`
+		Duration         *uint64 `xml:"segmentationDuration,attr"`
+		TypeID           uint8   `xml:"segmentationTypeId,attr"`
+		SegmentNum       uint8   `xml:"segmentNum,attr"`
+		SegmentsExpected uint8   `xml:"segmentsExpected,attr"`
+	} `xml:"SegmentationDescriptor"`
+}
+
+// ParseXML parses SpliceInfoSection element or Signal element of SCTE 35 XML schema.
+// Signal element is expected to have Binary element or SpliceInfoSection element.
+func ParseXML(data []byte) (*SpliceInfo, error) {
+	var root struct {
+		XMLName xml.Name
+		Binary  *string               `xml:"Binary"`
+		Section *xmlSpliceInfoSection `xml:"SpliceInfoSection"`
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	switch root.XMLName.Local {
+	case "SpliceInfoSection":
+		var section xmlSpliceInfoSection
+		if err := xml.Unmarshal(data, &section); err != nil {
+			return nil, err
+		}
+		return section.spliceInfo(), nil
+	case "Signal":
+		if root.Binary != nil {
+			return ParseBase64(*root.Binary)
+		}
+		if root.Section != nil {
+			return root.Section.spliceInfo(), nil
+		}
+		return nil, errors.New("Signal element has neither Binary nor SpliceInfoSection")
+	case "Binary":
+		var binary string
+		if err := xml.Unmarshal(data, &binary); err != nil {
+			return nil, err
+		}
+		return ParseBase64(binary)
+	}
+	return nil, errors.New("unexpected element: " + root.XMLName.Local)
+}
+
+// ParseBase64 parses base64-encoded splice_info_section.
+func ParseBase64(s string) (*SpliceInfo, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// ParseHex parses hexadecimal splice_info_section which is used by EXT-X-DATERANGE tag.
+func ParseHex(s string) (*SpliceInfo, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func (s *xmlSpliceInfoSection) spliceInfo() *SpliceInfo {
+	info := &SpliceInfo{
+		PTSAdjustment: s.PTSAdjustment,
+		Tier:          0xfff,
+		CommandType:   CommandSpliceNull,
+	}
+	if s.Tier != nil {
+		info.Tier = *s.Tier
+	}
+	if insert := s.SpliceInsert; insert != nil {
+		info.CommandType = CommandSpliceInsert
+		info.SpliceInsert = &SpliceInsert{
+			EventID:         insert.EventID,
+			Cancel:          insert.Cancel,
+			OutOfNetwork:    insert.OutOfNetwork,
+			ProgramSplice:   insert.Program != nil,
+			SpliceImmediate: insert.SpliceImmediate,
+			UniqueProgramID: insert.UniqueProgramID,
+			AvailNum:        insert.AvailNum,
+			AvailsExpected:  insert.AvailsExpected,
+		}
+		if insert.Program != nil && insert.Program.SpliceTime != nil {
+			info.SpliceInsert.SpliceTime = &SpliceTime{PTSTime: insert.Program.SpliceTime.PTSTime}
+		} else if len(insert.Components) != 0 && insert.Components[0].SpliceTime != nil {
+			info.SpliceInsert.SpliceTime = &SpliceTime{PTSTime: insert.Components[0].SpliceTime.PTSTime}
+		}
+		if insert.BreakDuration != nil {
+			duration := insert.BreakDuration.Duration
+			info.SpliceInsert.BreakDuration = &duration
+			info.SpliceInsert.AutoReturn = insert.BreakDuration.AutoReturn
+		}
+	} else if s.TimeSignal != nil {
+		info.CommandType = CommandTimeSignal
+		info.TimeSignal = &SpliceTime{}
+		if s.TimeSignal.SpliceTime != nil {
+			info.TimeSignal.PTSTime = s.TimeSignal.SpliceTime.PTSTime
+		}
+	}
+	for _, d := range s.SegmentationDescriptors {
+		info.SegmentationDescriptors = append(info.SegmentationDescriptors, &SegmentationDescriptor{
+			EventID:          d.EventID,
+			Cancel:           d.Cancel,
+			Duration:         d.Duration,
+			TypeID:           d.TypeID,
+			SegmentNum:       d.SegmentNum,
+			SegmentsExpected: d.SegmentsExpected,
+		})
+	}
+	return info
+}
@@ -0,0 +1,30 @@
+// Package segmenttest provides a core.SegmentStore implementation for tests.
+package segmenttest
+
+import "github.com/abema/antares/core"
+
+// SegmentStore is a core.SegmentStore which holds segments in memory.
+// Keys are generated by core.SegmentKey, so that segments which share the URL are distinguished by the byte range.
+type SegmentStore map[string][]byte
+
+// Exists returns whether the segment exists.
+func (s SegmentStore) Exists(url string) bool {
+	return s.ExistsRange(url, nil)
+}
+
+// Load returns the segment.
+func (s SegmentStore) Load(url string) ([]byte, bool) {
+	return s.LoadRange(url, nil)
+}
+
+// ExistsRange returns whether the segment specified by the byte range exists.
+func (s SegmentStore) ExistsRange(url string, byteRange *core.ByteRange) bool {
+	_, ok := s[core.SegmentKey(url, byteRange)]
+	return ok
+}
+
+// LoadRange returns the segment specified by the byte range.
+func (s SegmentStore) LoadRange(url string, byteRange *core.ByteRange) ([]byte, bool) {
+	data, ok := s[core.SegmentKey(url, byteRange)]
+	return data, ok
+}
@@ -0,0 +1,124 @@
+package ts
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+)
+
+// Packet is an MPEG-2 transport stream packet.
+type Packet struct {
+	PID                       uint16
+	PayloadUnitStartIndicator bool
+	RandomAccessIndicator     bool
+	Payload                   []byte
+}
+
+// ReadPackets parses sequential transport stream packets.
+func ReadPackets(data []byte) ([]*Packet, error) {
+	if len(data)%PacketSize != 0 {
+		return nil, fmt.Errorf("data size is not a multiple of packet size: %d", len(data))
+	}
+	packets := make([]*Packet, 0, len(data)/PacketSize)
+	for ; len(data) != 0; data = data[PacketSize:] {
+		if data[0] != SyncByte {
+			return nil, errors.New("sync byte is not found")
+		}
+		packet := &Packet{
+			PID:                       uint16(data[1]&0x1f)<<8 | uint16(data[2]),
+			PayloadUnitStartIndicator: data[1]&0x40 != 0,
+		}
+		adaptationFieldControl := (data[3] >> 4) & 0x3
+		payload := data[4:PacketSize]
+		if adaptationFieldControl&0x2 != 0 {
+			length := int(payload[0])
+			if length+1 > len(payload) {
+				return nil, errors.New("invalid adaptation field length")
+			}
+			if length > 0 {
+				packet.RandomAccessIndicator = payload[1]&0x40 != 0
+			}
+			payload = payload[length+1:]
+		}
+		if adaptationFieldControl&0x1 != 0 {
+			packet.Payload = payload
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
+
+// FirstVideoPES returns the first complete or partial PES packet of video stream in the packets.
+// randomAccess is random_access_indicator of the packet which starts the PES packet.
+func FirstVideoPES(packets []*Packet) (pes []byte, randomAccess bool, ok bool) {
+	var pid uint16
+	for _, packet := range packets {
+		if !ok {
+			if !packet.PayloadUnitStartIndicator || !isVideoPESHeader(packet.Payload) {
+				continue
+			}
+			ok = true
+			pid = packet.PID
+			randomAccess = packet.RandomAccessIndicator
+			pes = append(pes, packet.Payload...)
+			continue
+		}
+		if packet.PID != pid {
+			continue
+		}
+		if packet.PayloadUnitStartIndicator {
+			break
+		}
+		pes = append(pes, packet.Payload...)
+	}
+	return pes, randomAccess, ok
+}
+
+func isVideoPESHeader(payload []byte) bool {
+	return len(payload) >= 4 &&
+		payload[0] == 0x00 && payload[1] == 0x00 && payload[2] == 0x01 &&
+		payload[3]&0xf0 == 0xe0
+}
+
+// PESPayload returns the elementary stream data of the PES packet.
+func PESPayload(pes []byte) ([]byte, error) {
+	if len(pes) < 9 {
+		return nil, errors.New("too short PES header")
+	}
+	headerLength := 9 + int(pes[8])
+	if headerLength > len(pes) {
+		return nil, errors.New("invalid PES header length")
+	}
+	return pes[headerLength:], nil
+}
+
+// NALUnitHeaders returns a list of the first byte of NAL units in the Annex B byte stream.
+func NALUnitHeaders(es []byte) []byte {
+	headers := make([]byte, 0)
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] == 0x00 && es[i+1] == 0x00 && es[i+2] == 0x01 {
+			headers = append(headers, es[i+3])
+			i += 3
+		}
+	}
+	return headers
+}
+
+// ContainsIDR returns whether the elementary stream contains an IDR picture of H.264
+// or an IRAP picture of H.265.
+func ContainsIDR(es []byte, hevc bool) bool {
+	for _, header := range NALUnitHeaders(es) {
+		if hevc {
+			if t := (header >> 1) & 0x3f; t >= 16 && t <= 21 {
+				return true
+			}
+		} else if header&0x1f == 5 {
+			return true
+		}
+	}
+	return false
+}
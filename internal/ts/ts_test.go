@@ -0,0 +1,68 @@
+package ts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func packet(pid uint16, pusi bool, randomAccess bool, payload []byte) []byte {
+	data := make([]byte, PacketSize)
+	data[0] = SyncByte
+	data[1] = byte(pid>>8) & 0x1f
+	if pusi {
+		data[1] |= 0x40
+	}
+	data[2] = byte(pid)
+	// adaptation field is used as stuffing
+	data[3] = 0x30
+	afLength := PacketSize - 5 - len(payload)
+	data[4] = byte(afLength)
+	if afLength > 0 {
+		data[5] = 0x00
+		if randomAccess {
+			data[5] = 0x40
+		}
+		for i := 6; i < 5+afLength; i++ {
+			data[i] = 0xff
+		}
+	}
+	copy(data[5+afLength:], payload)
+	return data
+}
+
+func pes(es []byte) []byte {
+	return append([]byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01}, es...)
+}
+
+func TestFirstVideoPES(t *testing.T) {
+	var data []byte
+	data = append(data, packet(0, true, false, []byte{0x00, 0x00, 0xb0})...)
+	data = append(data, packet(0x100, true, true, pes([]byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0}))...)
+	data = append(data, packet(0x101, true, false, []byte{0x00, 0x00, 0x01, 0xc0})...)
+	data = append(data, packet(0x100, false, false, []byte{0x00, 0x00, 0x01, 0x65, 0x88})...)
+	data = append(data, packet(0x100, true, false, pes([]byte{0x00, 0x00, 0x01, 0x41}))...)
+	packets, err := ReadPackets(data)
+	require.NoError(t, err)
+	require.Len(t, packets, 5)
+	p, randomAccess, ok := FirstVideoPES(packets)
+	require.True(t, ok)
+	assert.True(t, randomAccess)
+	es, err := PESPayload(p)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x09, 0x65}, NALUnitHeaders(es))
+	assert.True(t, ContainsIDR(es, false))
+
+	_, err = ReadPackets(data[1:])
+	assert.Error(t, err)
+}
+
+func TestContainsIDR(t *testing.T) {
+	assert.True(t, ContainsIDR([]byte{0x00, 0x00, 0x01, 0x65}, false))
+	assert.False(t, ContainsIDR([]byte{0x00, 0x00, 0x01, 0x41}, false))
+	// IDR_W_RADL
+	assert.True(t, ContainsIDR([]byte{0x00, 0x00, 0x01, 0x26, 0x01}, true))
+	// TRAIL_R
+	assert.False(t, ContainsIDR([]byte{0x00, 0x00, 0x01, 0x02, 0x01}, true))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
@@ -29,6 +30,7 @@ var opts struct {
 		PlaylistType string
 		Endlist      bool
 		NoEndlist    bool
+		Decrypt      bool
 	}
 	DASH struct {
 		MandatoryMimeTypes string
@@ -41,6 +43,8 @@ var opts struct {
 		MinVideoBandwidth  int64
 		MaxAudioBandwidth  int64
 		MinAudioBandwidth  int64
+		Keys               string
+		ProbeAllBaseURLs   bool
 	}
 	Export struct {
 		Enable bool
@@ -73,6 +77,7 @@ func main() {
 	flagSet.StringVar(&opts.HLS.PlaylistType, "hls.playlistType", "", "PLAYLIST-TYPE tag status (omitted|event|vod)")
 	flagSet.BoolVar(&opts.HLS.Endlist, "hls.endlist", false, "If true, playlist must have ENDLIST tag.")
 	flagSet.BoolVar(&opts.HLS.NoEndlist, "hls.noEndlist", false, "If true, playlist must have no ENDLIST tag.")
+	flagSet.BoolVar(&opts.HLS.Decrypt, "hls.decrypt", false, "If true, segments are decrypted with keys downloaded from EXT-X-KEY URI.")
 	flagSet.StringVar(&opts.DASH.MandatoryMimeTypes, "dash.mandatoryMimeTypes", "", "comma-separated list of mandatory mimeType attribute values.")
 	flagSet.StringVar(&opts.DASH.ValidMimeTypes, "dash.validMimeTypes", "", "comma-separated list of valid mimeType attribute values.")
 	flagSet.StringVar(&opts.DASH.MPDType, "dash.mpdType", "", "expected MPD@type attribute value. (static|dynamic)")
@@ -83,6 +88,8 @@ func main() {
 	flagSet.Int64Var(&opts.DASH.MinVideoBandwidth, "dash.minVideoBandwidth", 0, "minimum value of video bandwidth.")
 	flagSet.Int64Var(&opts.DASH.MaxAudioBandwidth, "dash.maxAudioBandwidth", 0, "maximum value of audio bandwidth.")
 	flagSet.Int64Var(&opts.DASH.MinAudioBandwidth, "dash.minAudioBandwidth", 0, "minimum value of audio bandwidth.")
+	flagSet.StringVar(&opts.DASH.Keys, "dash.keys", "", "comma-separated list of KID:key pairs in hexadecimal to decrypt segments. (ex: \"0123...cdef:0123...cdef\")")
+	flagSet.BoolVar(&opts.DASH.ProbeAllBaseURLs, "dash.probeAllBaseURLs", false, "download segments from all BaseURLs instead of the one which has the highest priority.")
 	flagSet.BoolVar(&opts.Export.Meta, "export.meta", false, "Export metadatas with raw files.")
 	flagSet.StringVar(&opts.Export.Dir, "export.dir", defaultExportDir, "an export directory")
 	flagSet.BoolVar(&opts.Segment.Disable, "segment.disable", false, "Disable segment download.")
@@ -119,6 +126,9 @@ func main() {
 	switch streamType {
 	case core.StreamTypeHLS:
 		config.HLS = hlsConfig()
+		if opts.HLS.Decrypt {
+			config.KeyProvider = core.HTTPKeyProvider()
+		}
 	case core.StreamTypeDASH:
 		config.DASH = dashConfig()
 	}
@@ -181,6 +191,12 @@ func hlsConfig() *core.HLSConfig {
 	inspectors := []core.HLSInspector{
 		hls.NewSpeedInspector(),
 		hls.NewVariantsSyncInspector(),
+		hls.NewLowLatencyInspector(),
+		hls.NewIFrameInspector(),
+		hls.NewSCTE35Inspector(),
+		hls.NewInterstitialInspector(),
+		hls.NewProgramDateTimeInspector(),
+		hls.NewConformanceInspector(),
 	}
 	if opts.HLS.PlaylistType != "" || opts.HLS.NoEndlist {
 		config := new(hls.PlaylistTypeInspectorConfig)
@@ -212,6 +228,13 @@ func dashConfig() *core.DASHConfig {
 	inspectors := []core.DASHInspector{
 		dash.NewSpeedInspector(),
 		dash.NewPresentationDelayInspector(),
+		dash.NewContentProtectionInspector(),
+		dash.NewSegmentTimelineInspector(),
+		dash.NewSegmentTemplateInspector(),
+		dash.NewAvailabilityWindowInspector(),
+		dash.NewRepresentationsSyncInspector(),
+		dash.NewPeriodTransitionInspector(),
+		dash.NewSCTE35Inspector(),
 	}
 	if opts.DASH.MandatoryMimeTypes != "" || opts.DASH.ValidMimeTypes != "" {
 		inspectors = append(inspectors, dash.NewAdaptationSetInspector(&dash.AdaptationSetInspectorConfig{
@@ -235,9 +258,30 @@ func dashConfig() *core.DASHConfig {
 			ErrorMinAudioBandwidth: opts.DASH.MinAudioBandwidth,
 		}))
 	}
-	return &core.DASHConfig{
-		Inspectors: inspectors,
+	config := &core.DASHConfig{
+		Inspectors:       inspectors,
+		ProbeAllBaseURLs: opts.DASH.ProbeAllBaseURLs,
+	}
+	if opts.DASH.Keys != "" {
+		config.Keys = buildKeys(opts.DASH.Keys)
+	}
+	return config
+}
+
+func buildKeys(pairs string) map[string][]byte {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(pairs, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			invalidArguments("invalid key format: %s", pair)
+		}
+		key, err := hex.DecodeString(kv[1])
+		if err != nil || len(key) != 16 {
+			invalidArguments("invalid key format: %s", pair)
+		}
+		keys[kv[0]] = key
 	}
+	return keys
 }
 
 func buildAspectRatios(ars string) []dash.AspectRatio {